@@ -3,6 +3,7 @@
 package HexDiff
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"os"
 	"time"
@@ -41,6 +42,26 @@ var (
 	ErrPatchValidation  = fmt.Errorf("patch validation failed")
 	ErrInvalidArgument  = fmt.Errorf("invalid argument")
 	ErrInvalidConfig    = fmt.Errorf("invalid configuration")
+
+	// Signature errors, matched with errors.Is
+	ErrPatchUnsigned    = patch.ErrPatchUnsigned
+	ErrSignatureInvalid = patch.ErrSignatureInvalid
+	ErrUntrustedKey     = patch.ErrUntrustedKey
+
+	// Encryption errors, matched with errors.Is
+	ErrDecryptionKeyMissing = patch.ErrDecryptionKeyMissing
+	ErrDecryptionFailed     = patch.ErrDecryptionFailed
+
+	// ErrPatchChainMismatch is returned by Squash when the second patch does not start
+	// from the target of the first patch
+	ErrPatchChainMismatch = patch.ErrPatchChainMismatch
+
+	// ErrNotInPlace is returned by ApplyInPlace for patches generated without InPlace
+	ErrNotInPlace = patch.ErrNotInPlace
+
+	// ErrNotReversible is returned by ApplyDirReverse and Reverse for directory patches
+	// generated without Reversible when no old directory is given
+	ErrNotReversible = patch.ErrNotReversible
 )
 
 // CompressionType represents the compression algorithm
@@ -51,6 +72,7 @@ const (
 	CompressionGzip                        // Gzip compression
 	CompressionLZ4                         // LZ4 compression
 	CompressionZstd                        // Zstandard compression
+	CompressionAuto                        // Pick a compression algorithm per data chunk
 )
 
 // String returns the compression type as a string
@@ -64,6 +86,8 @@ func (c CompressionType) String() string {
 		return "lz4"
 	case CompressionZstd:
 		return "zstd"
+	case CompressionAuto:
+		return "auto"
 	default:
 		return "unknown"
 	}
@@ -152,10 +176,44 @@ type Config struct {
 	MaxMemory int64
 	// Compression is the compression type (default: CompressionGzip)
 	Compression CompressionType
+	// CompressionLevel is the compression level, 1-11; 0 uses the algorithm's default (default: 0)
+	CompressionLevel int
+	// Dictionary is a trained zstd dictionary used with CompressionZstd or CompressionAuto (default: none)
+	Dictionary []byte
+	// Enhanced writes single-file patches in the enhanced HXDF format with embedded metadata (default: false)
+	Enhanced bool
+	// Description is stored in the metadata of enhanced patches
+	Description string
+	// SigningKey signs every generated patch with Ed25519 (default: none)
+	SigningKey ed25519.PrivateKey
+	// Signer is the signer name recorded in patch signatures
+	Signer string
+	// TrustedKeys, when set, makes Apply refuse patches that are unsigned,
+	// tampered with or signed by another key (default: none)
+	TrustedKeys []ed25519.PublicKey
+	// Encryption encrypts every generated single-file patch with AES-256-GCM (default: none)
+	Encryption *EncryptionKey
 	// Verify enables verification after patch application (default: true)
 	Verify bool
 	// Backup creates backup before applying patch (default: false)
 	Backup bool
+	// InPlace generates single-file patches that ApplyInPlace can apply directly
+	// on the old file without a second copy (default: false)
+	InPlace bool
+	// Reversible embeds reverse entries in directory patches so that ApplyDirReverse
+	// can restore the old directory from the new one (default: false)
+	Reversible bool
+	// Resume makes Apply checkpoint its progress so that an interrupted
+	// single-file apply continues where it stopped when run again (default: false)
+	Resume bool
+	// Algorithm is the diff algorithm name (default: AlgorithmRollingHash)
+	Algorithm string
+	// AllowExternalSymlinks lets directory patches create symlinks with absolute targets
+	// or targets outside the target directory, which are refused by default (default: false)
+	AllowExternalSymlinks bool
+	// ContentDefinedChunking splits files with FastCDC instead of fixed-size blocks,
+	// so that inserted data only affects nearby chunks; requires AlgorithmRollingHash (default: false)
+	ContentDefinedChunking bool
 }
 
 // DefaultConfig returns the default configuration
@@ -169,6 +227,7 @@ func DefaultConfig() *Config {
 		Compression:  CompressionGzip,
 		Verify:       true,
 		Backup:       false,
+		Algorithm:    AlgorithmRollingHash,
 	}
 }
 
@@ -192,18 +251,46 @@ func (c *Config) Validate() error {
 			Err: fmt.Errorf("max memory must be at least 1MB"),
 		}
 	}
+	if c.Compression < CompressionNone || c.Compression > CompressionAuto {
+		return &Error{
+			Op:  "validate config",
+			Err: fmt.Errorf("unsupported compression type: %d", c.Compression),
+		}
+	}
+	if c.CompressionLevel < 0 || c.CompressionLevel > int(compression.LevelMax) {
+		return &Error{
+			Op:  "validate config",
+			Err: fmt.Errorf("compression level must be between 0 and %d", compression.LevelMax),
+		}
+	}
+	if _, err := diff.NewAlgorithm(c.DiffConfig()); err != nil {
+		return &Error{
+			Op:  "validate config",
+			Err: fmt.Errorf("%w: %s", err, c.Algorithm),
+		}
+	}
+	if c.ContentDefinedChunking && c.Algorithm != "" && c.Algorithm != AlgorithmRollingHash {
+		return &Error{
+			Op:  "validate config",
+			Err: fmt.Errorf("content-defined chunking requires %s", AlgorithmRollingHash),
+		}
+	}
 	return nil
 }
 
 // DiffConfig converts Config to diff.DiffConfig
 func (c *Config) DiffConfig() *diff.DiffConfig {
-	return &diff.DiffConfig{
-		BlockSize:    c.BlockSize,
-		WindowSize:   c.WindowSize,
-		EnableCRC32:  c.EnableCRC32,
-		EnableSHA256: c.EnableSHA256,
-		MaxMemory:    c.MaxMemory,
+	config := diff.DefaultDiffConfig()
+	config.BlockSize = c.BlockSize
+	config.WindowSize = c.WindowSize
+	config.EnableCRC32 = c.EnableCRC32
+	config.EnableSHA256 = c.EnableSHA256
+	config.MaxMemory = c.MaxMemory
+	config.Algorithm = c.Algorithm
+	if c.ContentDefinedChunking {
+		config.Chunking = diff.ChunkingContentDefined
 	}
+	return config
 }
 
 // CompressionConfig converts CompressionType to compression config
@@ -213,6 +300,10 @@ func (c *CompressionType) CompressionConfig() compression.CompressionConfig {
 		return compression.CompressionConfig{Type: compression.CompressionGzip}
 	case CompressionLZ4:
 		return compression.CompressionConfig{Type: compression.CompressionLZ4}
+	case CompressionZstd:
+		return compression.CompressionConfig{Type: compression.CompressionZstd}
+	case CompressionAuto:
+		return compression.CompressionConfig{Type: compression.CompressionAuto}
 	default:
 		return compression.CompressionConfig{Type: compression.CompressionNone}
 	}
@@ -277,6 +368,158 @@ func WithCompression(ct CompressionType) Option {
 	}
 }
 
+// WithCompressionLevel sets the compression level (1-11, 0 for the algorithm's default)
+func WithCompressionLevel(level int) Option {
+	return func(h *HexDiff) error {
+		if level < 0 || level > int(compression.LevelMax) {
+			return &Error{
+				Op:  "option",
+				Err: fmt.Errorf("compression level must be between 0 and %d", compression.LevelMax),
+			}
+		}
+		h.config.CompressionLevel = level
+		return nil
+	}
+}
+
+// WithDictionary sets the zstd dictionary used to compress patches.
+// The dictionary ID is stored in the patch header; the same dictionary must be
+// registered with RegisterDictionary before applying the patch.
+func WithDictionary(dict []byte) Option {
+	return func(h *HexDiff) error {
+		if _, err := compression.ZstdDictionaryID(dict); err != nil {
+			return &Error{
+				Op:  "option",
+				Err: err,
+			}
+		}
+		h.config.Dictionary = dict
+		return nil
+	}
+}
+
+// WithEnhancedFormat writes single-file patches in the enhanced HXDF format.
+// The patch carries metadata (file names, algorithm, creator, description) returned by GetPatchInfo;
+// apply and validate detect the format automatically.
+func WithEnhancedFormat(description string) Option {
+	return func(h *HexDiff) error {
+		h.config.Enhanced = true
+		h.config.Description = description
+		return nil
+	}
+}
+
+// WithSigningKey signs every generated patch with the given Ed25519 key.
+// The signature covers the patch header and a SHA-256 of the whole patch.
+func WithSigningKey(key ed25519.PrivateKey, signer string) Option {
+	return func(h *HexDiff) error {
+		if len(key) != ed25519.PrivateKeySize {
+			return &Error{
+				Op:  "option",
+				Err: fmt.Errorf("invalid ed25519 private key size: %d", len(key)),
+			}
+		}
+		h.config.SigningKey = key
+		h.config.Signer = signer
+		return nil
+	}
+}
+
+// WithTrustedKeys makes Apply verify patch signatures before applying.
+// Unsigned patches, tampered patches and patches signed by other keys are refused.
+func WithTrustedKeys(keys ...ed25519.PublicKey) Option {
+	return func(h *HexDiff) error {
+		for _, key := range keys {
+			if len(key) != ed25519.PublicKeySize {
+				return &Error{
+					Op:  "option",
+					Err: fmt.Errorf("invalid ed25519 public key size: %d", len(key)),
+				}
+			}
+		}
+		h.config.TrustedKeys = keys
+		return nil
+	}
+}
+
+// WithEncryption encrypts generated single-file patches with AES-256-GCM.
+// The operations and data are encrypted after compression; the header stays readable,
+// so GetPatchInfo works without the key. Applying requires a key registered with RegisterDecryptionKey.
+// Directory patches cannot be encrypted.
+func WithEncryption(key *EncryptionKey) Option {
+	return func(h *HexDiff) error {
+		if key == nil {
+			return &Error{
+				Op:  "option",
+				Err: fmt.Errorf("nil encryption key"),
+			}
+		}
+		h.config.Encryption = key
+		return nil
+	}
+}
+
+// LoadEncryptionKey parses an encryption key: "pass:<passphrase>", "env:<variable>"
+// or the path of a key file (X25519 PEM public or private key, or a 32-byte raw key)
+func LoadEncryptionKey(spec string) (*EncryptionKey, error) {
+	key, err := patch.ParseEncryptionKey(spec)
+	if err != nil {
+		return nil, &Error{
+			Op:  "load encryption key",
+			Err: err,
+		}
+	}
+	return key, nil
+}
+
+// RegisterDecryptionKey registers a key for reading encrypted patches.
+// Registered keys are tried in order; X25519 recipients need the private key.
+func RegisterDecryptionKey(key *EncryptionKey) {
+	patch.RegisterDecryptionKey(key)
+}
+
+// SignPatch signs an existing patch file, replacing any previous signature
+func SignPatch(patchFile string, key ed25519.PrivateKey, signer string) error {
+	if _, err := patch.SignPatch(patchFile, key, signer); err != nil {
+		return &Error{
+			Op:  "sign patch",
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// VerifyPatch verifies that a patch is signed by one of the trusted keys
+// and returns its signature information
+func VerifyPatch(patchFile string, trusted ...ed25519.PublicKey) (*SignatureInfo, error) {
+	signature, err := patch.VerifyPatch(patchFile, trusted)
+	if err != nil {
+		return nil, &Error{
+			Op:  "verify patch",
+			Err: err,
+		}
+	}
+	return &SignatureInfo{
+		Signer:   signature.Signer,
+		KeyID:    signature.KeyID(),
+		SignedAt: time.Unix(signature.SignedAt, 0),
+		Verified: true,
+	}, nil
+}
+
+// RegisterDictionary registers a zstd dictionary for applying patches and returns its ID.
+// Dictionaries shipped with the program can be embedded with go:embed and registered at startup.
+func RegisterDictionary(dict []byte) (uint32, error) {
+	id, err := compression.RegisterZstdDictionary(dict)
+	if err != nil {
+		return 0, &Error{
+			Op:  "register dictionary",
+			Err: err,
+		}
+	}
+	return id, nil
+}
+
 // WithChecksum enables or disables checksum verification
 func WithChecksum(enableCRC32, enableSHA256 bool) Option {
 	return func(h *HexDiff) error {
@@ -300,6 +543,22 @@ func WithMaxMemory(bytes int64) Option {
 	}
 }
 
+// WithAlgorithm sets the diff algorithm (AlgorithmRollingHash or AlgorithmSuffixArray)
+func WithAlgorithm(name string) Option {
+	return func(h *HexDiff) error {
+		config := h.config.DiffConfig()
+		config.Algorithm = name
+		if _, err := diff.NewAlgorithm(config); err != nil {
+			return &Error{
+				Op:  "option",
+				Err: fmt.Errorf("%w: %s", err, name),
+			}
+		}
+		h.config.Algorithm = name
+		return nil
+	}
+}
+
 // WithProgress sets the progress callback function
 func WithProgress(pf ProgressFunc) Option {
 	return func(h *HexDiff) error {
@@ -328,6 +587,46 @@ func WithBackup(backup bool) Option {
 	}
 }
 
+// WithInPlace enables or disables generation of in-place single-file patches
+func WithInPlace(inPlace bool) Option {
+	return func(h *HexDiff) error {
+		h.config.InPlace = inPlace
+		return nil
+	}
+}
+
+// WithAllowExternalSymlinks allows or refuses directory patches creating symlinks that point outside the target directory
+func WithAllowExternalSymlinks(allow bool) Option {
+	return func(h *HexDiff) error {
+		h.config.AllowExternalSymlinks = allow
+		return nil
+	}
+}
+
+// WithContentDefinedChunking enables or disables FastCDC content-defined chunking
+func WithContentDefinedChunking(enabled bool) Option {
+	return func(h *HexDiff) error {
+		h.config.ContentDefinedChunking = enabled
+		return nil
+	}
+}
+
+// WithReversible enables or disables embedding reverse entries in directory patches
+func WithReversible(reversible bool) Option {
+	return func(h *HexDiff) error {
+		h.config.Reversible = reversible
+		return nil
+	}
+}
+
+// WithResume enables or disables resumable single-file patch application
+func WithResume(resume bool) Option {
+	return func(h *HexDiff) error {
+		h.config.Resume = resume
+		return nil
+	}
+}
+
 // WithConfig sets a complete configuration
 func WithConfig(cfg *Config) Option {
 	return func(h *HexDiff) error {
@@ -359,6 +658,37 @@ func (h *HexDiff) init() error {
 		}
 	}
 
+	if err := engine.SetCompression(h.config.Compression.String(), h.config.CompressionLevel); err != nil {
+		return &Error{
+			Op:  "initialize engine",
+			Err: err,
+		}
+	}
+	if _, err := engine.UseDictionary(h.config.Dictionary); err != nil {
+		return &Error{
+			Op:  "initialize engine",
+			Err: err,
+		}
+	}
+	format := "standard"
+	if h.config.Enhanced {
+		format = "enhanced"
+	}
+	if err := engine.SetPatchFormat(format, h.config.Description); err != nil {
+		return &Error{
+			Op:  "initialize engine",
+			Err: err,
+		}
+	}
+	engine.SetSigningKey(h.config.SigningKey, h.config.Signer)
+	engine.SetTrustedKeys(h.config.TrustedKeys)
+	engine.SetEncryptionKey(h.config.Encryption)
+	engine.SetResume(h.config.Resume)
+	engine.SetInPlace(h.config.InPlace)
+	engine.SetChunking(h.config.ContentDefinedChunking)
+	engine.SetReversible(h.config.Reversible)
+	engine.SetAllowExternalSymlinks(h.config.AllowExternalSymlinks)
+
 	h.engine = engine
 	h.initialized = true
 	return nil
@@ -432,6 +762,13 @@ func ApplyWithProgress(patchFile, targetFile, outputFile string, progress Progre
 	return h.ApplyTo(patchFile, targetFile, outputFile)
 }
 
+// ApplyInPlace applies an in-place patch directly on targetFile. An interrupted
+// apply is rolled back to its last committed batch and continued on the next call.
+// Simple API: hexdiff.ApplyInPlace("patch.patch", "firmware.img")
+func ApplyInPlace(patchFile, targetFile string) error {
+	return New().ApplyInPlaceTo(patchFile, targetFile)
+}
+
 // ApplyDir applies a directory patch
 func ApplyDir(patchFile, targetDir string) error {
 	return ApplyDirWithOptions(patchFile, targetDir, nil)
@@ -449,6 +786,35 @@ func ApplyDirWithOptions(patchFile, targetDir string, opts []Option) error {
 	return h.ApplyDirTo(patchFile, targetDir)
 }
 
+// ApplyDirWithFilter applies only the entries of a directory patch whose paths match one of
+// the only patterns (all entries when empty) and none of the exclude patterns.
+// Simple API: hexdiff.ApplyDirWithFilter("app.patch", "./app", []string{"lib/*"}, []string{"*.log"})
+func ApplyDirWithFilter(patchFile, targetDir string, only, exclude []string) error {
+	return New().ApplyDirWithFilterTo(patchFile, targetDir, only, exclude)
+}
+
+// Squash composes a v1→v2 patch and a v2→v3 patch into a single v1→v3 patch.
+// Both patches must be single-file patches or both directory patches.
+// Simple API: hexdiff.Squash("1to2.patch", "2to3.patch", "1to3.patch")
+func Squash(firstPatch, secondPatch, outputFile string) error {
+	return New().SquashTo(firstPatch, secondPatch, outputFile)
+}
+
+// Reverse generates the new→old patch from an old→new patch and the old file or
+// directory, without diffing the two versions again. source may be empty for
+// directory patches generated with Reversible.
+// Simple API: hexdiff.Reverse("update.patch", "old.bin", "rollback.patch")
+func Reverse(patchFile, source, outputFile string) error {
+	return New().ReverseTo(patchFile, source, outputFile)
+}
+
+// ApplyDirReverse restores the old directory from the new one using the reverse
+// entries embedded in a directory patch generated with Reversible.
+// Simple API: hexdiff.ApplyDirReverse("app.patch", "./app")
+func ApplyDirReverse(patchFile, targetDir string) error {
+	return New().ApplyDirReverseTo(patchFile, targetDir)
+}
+
 // Validate validates a patch file
 // Simple API: hexdiff.Validate("patch.patch")
 func Validate(patchFile string) (*ValidationResult, error) {
@@ -492,7 +858,7 @@ func (h *HexDiff) DiffTo(oldFile, newFile, outputFile string) error {
 
 	progressAdapter := &cliProgressAdapter{progress: h.progress}
 	compress := h.config.Compression != CompressionNone
-	return h.engine.GeneratePatch(oldFile, newFile, outputFile, "", compress, progressAdapter)
+	return h.engine.GeneratePatch(oldFile, newFile, outputFile, "", h.config.Algorithm, compress, progressAdapter)
 }
 
 // DiffDirTo generates a directory patch (chainable API)
@@ -501,6 +867,13 @@ func (h *HexDiff) DiffDirTo(oldDir, newDir, outputFile string) error {
 		return err
 	}
 
+	if h.config.Encryption != nil {
+		return &Error{
+			Op:  "generate dir diff",
+			Err: fmt.Errorf("directory patches cannot be encrypted"),
+		}
+	}
+
 	progressAdapter := &cliProgressAdapter{progress: h.progress}
 	compress := h.config.Compression != CompressionNone
 
@@ -516,21 +889,33 @@ func (h *HexDiff) DiffDirTo(oldDir, newDir, outputFile string) error {
 		}
 	}
 
-	result, err := dirEngine.GenerateDirDiff(oldDir, newDir, progressAdapter)
+	serializer := patch.NewDirPatchSerializer(h.config.Compression.CompressionConfig().Type)
+	serializer.SetLevel(patch.CompressionLevel(h.config.CompressionLevel))
+	sink, err := serializer.NewSink(outputFile, "", "")
 	if err != nil {
 		return &Error{
-			Op:  "generate dir diff",
+			Op:  "create dir patch",
 			Err: err,
 		}
 	}
 
-	dirPatchSerializer := patch.NewDirPatchSerializer(patch.CompressionNone)
-	oldBase := ""
-	newBase := ""
-	err = dirPatchSerializer.SerializeDirPatch(result, oldBase, newBase, outputFile)
+	_, err = dirEngine.GenerateDirDiffTo(oldDir, newDir, progressAdapter, sink)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && h.config.Reversible {
+		err = serializer.AddReverse(outputFile, oldDir)
+	}
 	if err != nil {
+		os.Remove(outputFile)
 		return &Error{
-			Op:  "serialize dir patch",
+			Op:  "generate dir diff",
+			Err: err,
+		}
+	}
+	if err := h.engine.SignOutput(outputFile); err != nil {
+		return &Error{
+			Op:  "sign dir patch",
 			Err: err,
 		}
 	}
@@ -548,6 +933,22 @@ func (h *HexDiff) ApplyTo(patchFile, targetFile, outputFile string) error {
 	return h.engine.ApplyPatch(patchFile, targetFile, outputFile, h.config.Verify, progressAdapter)
 }
 
+// ApplyInPlaceTo applies an in-place patch directly on targetFile (chainable API)
+func (h *HexDiff) ApplyInPlaceTo(patchFile, targetFile string) error {
+	if err := h.init(); err != nil {
+		return err
+	}
+
+	progressAdapter := &cliProgressAdapter{progress: h.progress}
+	if err := h.engine.ApplyPatchInPlace(patchFile, targetFile, progressAdapter); err != nil {
+		return &Error{
+			Op:  "apply patch in place",
+			Err: err,
+		}
+	}
+	return nil
+}
+
 // ApplyDirTo applies a directory patch (chainable API)
 func (h *HexDiff) ApplyDirTo(patchFile, targetDir string) error {
 	if err := h.init(); err != nil {
@@ -565,6 +966,89 @@ func (h *HexDiff) ApplyDirTo(patchFile, targetDir string) error {
 	return nil
 }
 
+// ApplyDirWithFilterTo applies the matching entries of a directory patch (chainable API)
+func (h *HexDiff) ApplyDirWithFilterTo(patchFile, targetDir string, only, exclude []string) error {
+	if err := h.init(); err != nil {
+		return err
+	}
+
+	progressAdapter := &cliProgressAdapter{progress: h.progress}
+	_, err := h.engine.ApplyDirPatchWithFilter(patchFile, targetDir, only, exclude, progressAdapter)
+	if err != nil {
+		return &Error{
+			Op:  "apply dir patch",
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// SquashTo composes two consecutive patches into outputFile (chainable API)
+func (h *HexDiff) SquashTo(firstPatch, secondPatch, outputFile string) error {
+	if err := h.init(); err != nil {
+		return err
+	}
+
+	progressAdapter := &cliProgressAdapter{progress: h.progress}
+	compress := h.config.Compression != CompressionNone
+	if _, err := h.engine.SquashPatches(firstPatch, secondPatch, outputFile, compress, progressAdapter); err != nil {
+		return &Error{
+			Op:  "squash patches",
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// ApplyDirReverseTo applies the reverse entries of a directory patch (chainable API)
+func (h *HexDiff) ApplyDirReverseTo(patchFile, targetDir string) error {
+	if err := h.init(); err != nil {
+		return err
+	}
+
+	progressAdapter := &cliProgressAdapter{progress: h.progress}
+	_, err := h.engine.ApplyDirPatchReverse(patchFile, targetDir, nil, nil, progressAdapter)
+	if err != nil {
+		return &Error{
+			Op:  "apply dir patch in reverse",
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// ReverseTo generates the reverse of patchFile into outputFile (chainable API)
+func (h *HexDiff) ReverseTo(patchFile, source, outputFile string) error {
+	if err := h.init(); err != nil {
+		return err
+	}
+
+	progressAdapter := &cliProgressAdapter{progress: h.progress}
+	compress := h.config.Compression != CompressionNone
+	if _, err := h.engine.ReversePatch(patchFile, source, outputFile, compress, progressAdapter); err != nil {
+		return &Error{
+			Op:  "reverse patch",
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// RollbackDir rolls back an interrupted directory patch on targetDir
+func (h *HexDiff) RollbackDir(targetDir string) error {
+	if err := h.init(); err != nil {
+		return err
+	}
+
+	if err := h.engine.RollbackDirPatch(targetDir); err != nil {
+		return &Error{
+			Op:  "rollback dir patch",
+			Err: err,
+		}
+	}
+	return nil
+}
+
 // ValidatePatch validates a patch file (chainable API)
 func (h *HexDiff) ValidatePatch(patchFile string) (*ValidationResult, error) {
 	if err := h.init(); err != nil {
@@ -612,6 +1096,10 @@ func (h *HexDiff) GetInfo(patchFile string) (*PatchInfo, error) {
 		PatchSize:      info.PatchSize,
 		CreatedAt:      info.CreatedAt,
 		Metadata:       info.Metadata,
+		DictID:         info.DictID,
+		Enhanced:       info.Enhanced,
+		Signature:      newSignatureInfo(info.Signature),
+		Encryption:     newEncryptionInfo(info.Encryption),
 	}, nil
 }
 
@@ -637,15 +1125,44 @@ func (h *HexDiff) GetDirInfo(patchFile string) (*DirPatchInfo, error) {
 		AddedFiles:       info.AddedFiles,
 		DeletedFiles:     info.DeletedFiles,
 		ModifiedFiles:    info.ModifiedFiles,
+		RenamedFiles:     info.RenamedFiles,
+		CopiedFiles:      info.CopiedFiles,
 		UnchangedFiles:   info.UnchangedFiles,
+		SourceFiles:      info.SourceFiles,
 		PatchSize:        info.PatchSize,
 		CreatedAt:        info.CreatedAt,
 		AddedFileList:    info.AddedFileList,
 		DeletedFileList:  info.DeletedFileList,
 		ModifiedFileList: info.ModifiedFileList,
+		RenamedFileList:  info.RenamedFileList,
+		CopiedFileList:   info.CopiedFileList,
+		Signature:        newSignatureInfo(info.Signature),
 	}, nil
 }
 
+// newSignatureInfo converts the engine's signature information
+func newSignatureInfo(info *cli.SignatureInfo) *SignatureInfo {
+	if info == nil {
+		return nil
+	}
+	return &SignatureInfo{
+		Signer:   info.Signer,
+		KeyID:    info.KeyID,
+		SignedAt: info.SignedAt,
+		Verified: info.Verified,
+	}
+}
+
+func newEncryptionInfo(info *cli.EncryptionInfo) *EncryptionInfo {
+	if info == nil {
+		return nil
+	}
+	return &EncryptionInfo{
+		Kind:  info.Kind,
+		KeyID: info.KeyID,
+	}
+}
+
 // SetProgress sets the progress callback (chainable API)
 func (h *HexDiff) SetProgress(pf ProgressFunc) error {
 	if pf == nil {
@@ -684,6 +1201,24 @@ type PatchInfo struct {
 	PatchSize      int64
 	CreatedAt      time.Time
 	Metadata       map[string]string
+	DictID         uint32          // zstd dictionary ID, 0 if no dictionary was used
+	Enhanced       bool            // enhanced HXDF patch; Metadata holds its embedded metadata
+	Signature      *SignatureInfo  // nil if the patch is not signed
+	Encryption     *EncryptionInfo // nil if the patch is not encrypted
+}
+
+// EncryptionInfo represents the encryption of a patch file
+type EncryptionInfo struct {
+	Kind  string // Passphrase, RawKey or X25519
+	KeyID string // first 8 bytes of the SHA-256 of the raw key or recipient public key, empty for passphrases
+}
+
+// SignatureInfo represents the signature of a patch file
+type SignatureInfo struct {
+	Signer   string
+	KeyID    string // first 8 bytes of the SHA-256 of the public key, hex encoded
+	SignedAt time.Time
+	Verified bool // the signature was checked against trusted keys
 }
 
 // DirPatchInfo represents information about a directory patch file
@@ -695,12 +1230,18 @@ type DirPatchInfo struct {
 	AddedFiles       int
 	DeletedFiles     int
 	ModifiedFiles    int
+	RenamedFiles     int
+	CopiedFiles      int
 	UnchangedFiles   int
+	SourceFiles      int
 	PatchSize        int64
 	CreatedAt        time.Time
 	AddedFileList    []string
 	DeletedFileList  []string
 	ModifiedFileList []string
+	RenamedFileList  []string       // 格式为 "原路径 -> 新路径"
+	CopiedFileList   []string       // 格式为 "原路径 -> 新路径"
+	Signature        *SignatureInfo // nil if the patch is not signed
 }
 
 // ============================================================================
@@ -727,6 +1268,8 @@ type (
 type (
 	// PatchHeader represents a patch file header
 	PatchHeader = patch.PatchHeader
+	// EncryptionKey is a key for encrypting or decrypting patches
+	EncryptionKey = patch.EncryptionKey
 )
 
 // Constants
@@ -744,4 +1287,11 @@ const (
 	OpInsert = diff.OpInsert
 	// OpDelete is the delete operation type
 	OpDelete = diff.OpDelete
+	// OpAdd is the add operation type (source bytes plus stored differences)
+	OpAdd = diff.OpAdd
+
+	// AlgorithmRollingHash is the block-matching rolling hash diff algorithm
+	AlgorithmRollingHash = diff.AlgorithmRollingHash
+	// AlgorithmSuffixArray is the bsdiff-style suffix array diff algorithm
+	AlgorithmSuffixArray = diff.AlgorithmSuffixArray
 )
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"encoding/binary"
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/Sky-ey/HexDiff/pkg/patch"
@@ -23,46 +24,91 @@ func main() {
 	}
 	defer file.Close()
 
-	// 读取文件头
-	headerData := make([]byte, patch.HeaderSize)
-	if _, err := file.Read(headerData); err != nil {
+	// 增强补丁先显示外层信息，再从其中的补丁开始读取
+	if enhanced, err := patch.IsEnhancedPatch(patchFile); err == nil && enhanced {
+		enhancedPatch, err := patch.ReadEnhancedPatch(patchFile)
+		if err != nil {
+			fmt.Printf("读取增强补丁失败: %v\n", err)
+			return
+		}
+		fmt.Printf("增强补丁 (HXDF):\n")
+		fmt.Printf("  压缩级别: %d\n", enhancedPatch.Header.CompressionLevel)
+		fmt.Printf("  数据偏移: %d\n", enhancedPatch.Header.DataOffset)
+		fmt.Printf("  元数据: %d 字节 @ %d\n", enhancedPatch.Header.MetadataSize, enhancedPatch.Header.MetadataOffset)
+		fmt.Printf("  创建者: %s\n", enhancedPatch.Metadata.CreatedBy)
+		if enhancedPatch.Metadata.Description != "" {
+			fmt.Printf("  描述: %s\n", enhancedPatch.Metadata.Description)
+		}
+		fmt.Printf("\n")
+		if _, err := file.Seek(int64(enhancedPatch.Header.DataOffset), io.SeekStart); err != nil {
+			fmt.Printf("定位补丁数据失败: %v\n", err)
+			return
+		}
+	}
+
+	// 读取文件头（自动识别32位和64位版本）
+	reader := bufio.NewReader(file)
+	header, err := patch.ReadPatchHeader(reader)
+	if err != nil {
 		fmt.Printf("读取文件头失败: %v\n", err)
 		return
 	}
 
-	magic := binary.LittleEndian.Uint32(headerData[0:4])
-	version := binary.LittleEndian.Uint16(headerData[4:6])
-	compression := headerData[6]
-	sourceSize := binary.LittleEndian.Uint64(headerData[16:24])
-	targetSize := binary.LittleEndian.Uint64(headerData[24:32])
-	operationCount := binary.LittleEndian.Uint32(headerData[96:100])
+	magic := header.Magic
+	version := header.Version
+	compression := header.Compression
+	sourceSize := header.SourceSize
+	targetSize := header.TargetSize
+	operationCount := header.OperationCount
 
 	fmt.Printf("补丁文件信息:\n")
 	fmt.Printf("  魔数: 0x%x\n", magic)
 	fmt.Printf("  版本: %d\n", version)
-	fmt.Printf("  压缩类型: %d\n", compression)
+	fmt.Printf("  压缩类型: %s\n", compression)
 	fmt.Printf("  源文件大小: %d\n", sourceSize)
 	fmt.Printf("  目标文件大小: %d\n", targetSize)
 	fmt.Printf("  操作数量: %d\n", operationCount)
+	if header.Flags&patch.FlagCompactOps != 0 {
+		fmt.Printf("  操作编码: 紧凑\n")
+	}
+	if header.Flags&patch.FlagCompressedOps != 0 {
+		fmt.Printf("  操作列表: 已压缩\n")
+	}
+	if header.Flags&patch.FlagChunkedData != 0 {
+		fmt.Printf("  数据区: 分块压缩\n")
+	}
+	if header.Flags&patch.FlagZstdDict != 0 {
+		fmt.Printf("  字典ID: %08x\n", header.DictID)
+	}
+	if header.Flags&patch.FlagEncrypted != 0 {
+		fmt.Printf("  加密: %s %s\n", header.Encryption.Kind, header.Encryption.KeyIDString())
+	}
 	fmt.Printf("\n")
 
+	// 加密的补丁没有密钥时只能显示文件头
+	payload, err := patch.OpenPayload(reader, header)
+	if err != nil {
+		fmt.Printf("读取操作列表失败: %v\n", err)
+		return
+	}
+
 	// 读取操作列表
 	fmt.Println("操作列表:")
 	fmt.Println("序号 | 类型   | 偏移量       | 大小    | 源偏移量     | 数据偏移")
 	fmt.Println("-----|--------|--------------|---------|--------------|---------")
 
-	for i := uint32(0); i < operationCount; i++ {
-		opData := make([]byte, patch.OperationSize)
-		if _, err := file.Read(opData); err != nil {
-			fmt.Printf("读取操作 %d 失败: %v\n", i, err)
-			return
-		}
+	operations, err := patch.ReadOperations(payload, header)
+	if err != nil {
+		fmt.Printf("读取操作列表失败: %v\n", err)
+		return
+	}
 
-		opType := opData[0]
-		size := binary.LittleEndian.Uint32(opData[2:6])
-		offset := binary.LittleEndian.Uint64(opData[6:14])
-		srcOffset := binary.LittleEndian.Uint64(opData[14:22])
-		dataOffset := binary.LittleEndian.Uint32(opData[22:26])
+	for i, op := range operations {
+		opType := op.Type
+		size := op.Size
+		offset := op.Offset
+		srcOffset := op.SrcOffset
+		dataOffset := op.DataOffset
 
 		typeStr := "???"
 		switch opType {
@@ -72,6 +118,8 @@ func main() {
 			typeStr = "INSERT"
 		case 2:
 			typeStr = "DELETE"
+		case 3:
+			typeStr = "ADD"
 		}
 
 		fmt.Printf("%4d | %-6s | 0x%012x | %-7d | 0x%012x | 0x%08x\n",
@@ -24,11 +24,10 @@ func main() {
 	// 创建 CLI 应用程序
 	app := cli.NewApp(AppName, AppVersion, AppDescription, engine)
 
-	// 创建错误处理器
-	errorHandler := cli.NewErrorHandler(app.GetLogger(), false)
-
 	// 运行应用程序
 	if err := app.Run(os.Args); err != nil {
+		// 创建错误处理器，使用全局参数和配置生效后的日志器
+		errorHandler := cli.NewErrorHandler(app.GetLogger(), false)
 		exitCode := errorHandler.Handle(err)
 		os.Exit(exitCode)
 	}
@@ -3,7 +3,15 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"io"
+	"math/rand"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,18 +25,47 @@ type App struct {
 	progress    *ProgressManager
 	engine      Engine
 	registry    *CommandRegistry
+	stdout      io.Writer // JSON 结果的输出流
+	result      any       // 命令结果，JSON 模式下输出
 }
 
 // Engine 引擎接口（需要在其他包中实现）
 type Engine interface {
 	GenerateSignature(inputFile, outputFile string, blockSize int, progress ProgressReporter) error
-	GeneratePatch(oldFile, newFile, outputFile, signature string, compress bool, progress ProgressReporter) error
+	GeneratePatch(oldFile, newFile, outputFile, signature, algorithm string, compress bool, progress ProgressReporter) error
 	GenerateDirDiff(oldDir, newDir, outputFile string, recursive, ignoreHidden bool, ignorePatterns string, compress bool, progress ProgressReporter) (any, error)
 	ApplyPatch(patchFile, targetFile, outputFile string, verify bool, progress ProgressReporter) error
+	ApplyPatchInPlace(patchFile, targetFile string, progress ProgressReporter) error
 	ApplyDirPatch(patchFile, targetDir string, verify bool, progress ProgressReporter) (any, error)
+	ApplyDirPatchWithFilter(patchFile, targetDir string, only, exclude []string, progress ProgressReporter) (any, error)
+	ApplyDirPatchReverse(patchFile, targetDir string, only, exclude []string, progress ProgressReporter) (any, error)
+	RollbackDirPatch(targetDir string) error
 	ValidatePatch(patchFile string, progress ProgressReporter) (*ValidationResult, error)
 	GetPatchInfo(patchFile string) (*PatchInfo, error)
 	GetDirPatchInfo(patchFile string) (*DirPatchInfo, error)
+	SyncSend(conn io.ReadWriter, newFile string, compress bool, progress ProgressReporter) (*SyncResult, error)
+	SyncReceive(conn io.ReadWriter, oldFile, outputFile string, blockSize int, progress ProgressReporter) (*SyncResult, error)
+	ExportPatch(patchFile, sourceFile, outputFile, format string, progress ProgressReporter) error
+	ImportPatch(inputFile, sourceFile, outputFile, format string, compress bool, progress ProgressReporter) error
+	SquashPatches(firstPatch, secondPatch, outputFile string, compress bool, progress ProgressReporter) (*SquashResult, error)
+	ReversePatch(patchFile, source, outputFile string, compress bool, progress ProgressReporter) (*ReverseResult, error)
+	SetCompression(name string, level int) error
+	SetDictionary(path string) (uint32, error)
+	SetPatchFormat(format, description string) error
+	SetResume(enabled bool)
+	SetInPlace(enabled bool)
+	SetChunking(contentDefined bool)
+	SetReversible(enabled bool)
+	SetAllowExternalSymlinks(allow bool)
+	GenerateSigningKey(keyFile, pubFile string) (string, error)
+	SignPatch(patchFile, keyFile, signer string) (*SignatureInfo, error)
+	VerifyPatch(patchFile string, pubKeyFiles []string) (*SignatureInfo, error)
+	LoadTrustedKeys(paths []string) error
+	SetEncryption(spec string) (*EncryptionInfo, error)
+	LoadDecryptionKeys(specs []string) error
+	LoadDictionary(path string) (uint32, error)
+	TrainDictionary(samples []string, outputFile string, maxSize int, id uint32, progress ProgressReporter) (*DictInfo, error)
+	GetDictionaryInfo(path string) (*DictInfo, error)
 }
 
 // NewApp 创建新的应用程序实例
@@ -38,6 +75,7 @@ func NewApp(name, version, description string, engine Engine) *App {
 		version:     version,
 		description: description,
 		engine:      engine,
+		stdout:      os.Stdout,
 	}
 
 	// 初始化组件
@@ -58,6 +96,14 @@ func (app *App) registerDefaultCommands() {
 	app.registry.Register(NewDiffCommand(app))
 	app.registry.Register(NewDirDiffCommand(app))
 	app.registry.Register(NewApplyCommand(app))
+	app.registry.Register(NewSyncCommand(app))
+	app.registry.Register(NewExportCommand(app))
+	app.registry.Register(NewImportCommand(app))
+	app.registry.Register(NewSquashCommand(app))
+	app.registry.Register(NewReverseCommand(app))
+	app.registry.Register(NewDictCommand(app))
+	app.registry.Register(NewSignCommand(app))
+	app.registry.Register(NewVerifyCommand(app))
 	app.registry.Register(NewValidateCommand(app))
 	app.registry.Register(NewInfoCommand(app))
 	app.registry.Register(NewHelpCommand(app))
@@ -67,29 +113,35 @@ func (app *App) registerDefaultCommands() {
 }
 
 // Run 运行应用程序
-func (app *App) Run(args []string) error {
+func (app *App) Run(args []string) (err error) {
 	// 解析全局参数
-	if err := app.parseGlobalFlags(args); err != nil {
+	args, err = app.parseGlobalFlags(args)
+	if err != nil {
 		return err
 	}
 
 	// 如果没有参数，显示帮助
-	if len(args) <= 1 {
+	if len(args) == 0 {
 		return app.showHelp()
 	}
 
 	// 获取命令名称
-	cmdName := args[1]
-	cmdArgs := args[2:]
+	cmdName := args[0]
+	cmdArgs := args[1:]
 
 	// 处理特殊命令
 	switch cmdName {
 	case "help", "-h", "--help":
 		return app.showHelp()
-	case "version", "-v", "--version":
-		return app.showVersion()
+	case "-v", "--version":
+		cmdName = "version"
 	}
 
+	// JSON 模式下命令结束后输出结果或错误
+	defer func() {
+		app.writeOutput(cmdName, err)
+	}()
+
 	// 查找并执行命令
 	cmd, exists := app.registry.Get(cmdName)
 	if !exists {
@@ -120,7 +172,7 @@ func (app *App) Run(args []string) error {
 	app.logger.Debug("执行命令: %s", cmdName)
 	startTime := time.Now()
 
-	err := cmd.Execute(fs.Args())
+	err = cmd.Execute(fs.Args())
 
 	duration := time.Since(startTime)
 	if err != nil {
@@ -132,59 +184,101 @@ func (app *App) Run(args []string) error {
 	return nil
 }
 
-// parseGlobalFlags 解析全局标志
-func (app *App) parseGlobalFlags(args []string) error {
+// setCompression 设置生成补丁时使用的压缩算法和级别，name为空或level小于0时使用配置中的值
+func (app *App) setCompression(name string, level int) error {
+	if name == "" {
+		name = app.config.DefaultCompression
+	}
+	if level < 0 {
+		level = app.config.CompressionLevel
+	}
+	if err := app.engine.SetCompression(name, level); err != nil {
+		return ErrInvalidArgumentf("压缩设置无效: %v", err)
+	}
+	return nil
+}
+
+// parseGlobalFlags 解析全局标志并加载配置，返回命令名称及其后的参数
+func (app *App) parseGlobalFlags(args []string) ([]string, error) {
 	// 创建全局标志集
 	fs := flag.NewFlagSet("global", flag.ContinueOnError)
 	fs.Usage = func() {} // 禁用默认用法输出
 
 	var (
 		configFile = fs.String("config", "", "配置文件路径")
-		logLevel   = fs.String("log-level", "info", "日志级别 (debug, info, warn, error)")
-		logFile    = fs.String("log-file", "", "日志文件路径")
 		noProgress = fs.Bool("no-progress", false, "禁用进度显示")
-		quiet      = fs.Bool("quiet", false, "静默模式")
-		verbose    = fs.Bool("verbose", false, "详细模式")
+		jsonOutput = fs.Bool("json", false, "以 JSON 格式输出命令结果，等同于 --output-format json")
 	)
+	fs.String("output-format", OutputFormatText, "命令结果的输出格式 (text, json)")
+	fs.String("log-level", "info", "日志级别 (debug, info, warn, error)")
+	fs.String("log-file", "", "日志文件路径")
+	fs.Bool("quiet", false, "静默模式")
+	fs.Bool("verbose", false, "详细模式")
 
 	// 解析全局参数
-	if err := fs.Parse(args[1:]); err != nil {
-		if err == flag.ErrHelp {
-			return nil
-		}
-		// 忽略未知参数，让具体命令处理
+	rest := args[1:]
+	if err := fs.Parse(rest); err == nil {
+		rest = fs.Args()
 	}
+	// 解析失败时忽略未知参数，让具体命令处理
 
-	// 加载配置文件
-	if *configFile != "" {
-		if err := app.config.LoadFromFile(*configFile); err != nil {
-			return fmt.Errorf("加载配置文件失败: %w", err)
+	// 依次合并系统、用户、项目配置文件、--config 指定的文件和环境变量
+	config, loadErr := LoadConfig(*configFile)
+	if loadErr != nil {
+		if *configFile != "" {
+			return nil, fmt.Errorf("加载配置文件失败: %w", loadErr)
 		}
+		config = NewConfig()
+	}
+	app.config = config
+
+	// 应用命令行参数覆盖，全局标志名与配置键名一一对应
+	fs.Visit(func(f *flag.Flag) {
+		key, value := strings.ReplaceAll(f.Name, "-", "_"), f.Value.String()
+		switch f.Name {
+		case "config":
+			return
+		case "no-progress":
+			key, value = "show_progress", strconv.FormatBool(!*noProgress)
+		case "json":
+			key, value = "output_format", OutputFormatText
+			if *jsonOutput {
+				value = OutputFormatJSON
+			}
+		}
+		if err := app.config.Set(key, value); err == nil {
+			app.config.SetOrigin(key, OriginFlag+":--"+f.Name)
+		}
+	})
+	if err := app.config.Validate(); err != nil {
+		return nil, ErrInvalidArgumentf("配置无效: %v", err)
 	}
 
-	// 应用命令行参数覆盖
-	if *logLevel != "info" {
-		app.config.LogLevel = *logLevel
+	// 重新初始化日志器和进度管理器
+	logLevel, showProgress := app.config.LogLevel, app.config.ShowProgress
+	if app.config.Quiet {
+		logLevel, showProgress = "error", false
 	}
-	if *logFile != "" {
-		app.config.LogFile = *logFile
+	if app.config.Verbose {
+		logLevel = "debug"
 	}
-	if *noProgress {
-		app.config.ShowProgress = false
+	app.logger = NewLogger(logLevel, app.config.LogFile)
+	app.progress = NewProgressManager(showProgress)
+	if app.JSONOutput() {
+		// 标准输出只用于 JSON 结果
+		app.logger.SetOutput(os.Stderr)
+		app.progress.SetOutput(os.Stderr)
 	}
-	if *quiet {
-		app.config.LogLevel = "error"
-		app.config.ShowProgress = false
+
+	// 配置警告在日志器就绪后输出，遵循 --quiet 和 JSON 输出模式
+	if loadErr != nil {
+		app.logger.Warn("加载配置失败，使用默认配置: %v", loadErr)
 	}
-	if *verbose {
-		app.config.LogLevel = "debug"
+	for _, warning := range app.config.Warnings() {
+		app.logger.Warn("%s", warning)
 	}
 
-	// 重新初始化日志器和进度管理器
-	app.logger = NewLogger(app.config.LogLevel, app.config.LogFile)
-	app.progress = NewProgressManager(app.config.ShowProgress)
-
-	return nil
+	return rest, nil
 }
 
 // showHelp 显示帮助信息
@@ -202,6 +296,8 @@ func (app *App) showHelp() error {
 	fmt.Printf("  --no-progress       禁用进度显示\n")
 	fmt.Printf("  --quiet             静默模式\n")
 	fmt.Printf("  --verbose           详细模式\n")
+	fmt.Printf("  --output-format <f> 命令结果的输出格式 (text, json)\n")
+	fmt.Printf("  --json              等同于 --output-format json\n")
 	fmt.Printf("  --help              显示帮助信息\n")
 	fmt.Printf("  --version           显示版本信息\n\n")
 
@@ -218,6 +314,10 @@ func (app *App) showHelp() error {
 
 // showVersion 显示版本信息
 func (app *App) showVersion() error {
+	if app.JSONOutput() {
+		app.SetResult(VersionResult{Name: app.name, Version: app.version})
+		return nil
+	}
 	fmt.Printf("%s version %s\n", app.name, app.version)
 	return nil
 }
@@ -343,6 +443,7 @@ func (c *VersionCommand) Execute(args []string) error {
 type BenchmarkCommand struct {
 	app     *App
 	testDir string
+	sizes   string
 	cleanup bool
 	verbose bool
 }
@@ -352,6 +453,7 @@ func NewBenchmarkCommand(app *App) *BenchmarkCommand {
 	return &BenchmarkCommand{
 		app:     app,
 		testDir: "./benchmark_test",
+		sizes:   "1,16",
 		cleanup: true,
 	}
 }
@@ -370,29 +472,135 @@ func (c *BenchmarkCommand) Usage() string {
 
 func (c *BenchmarkCommand) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.testDir, "test-dir", "./benchmark_test", "测试目录")
+	fs.StringVar(&c.sizes, "sizes", "1,16", "测试文件大小（MB，逗号分隔）")
 	fs.BoolVar(&c.cleanup, "cleanup", true, "测试后清理文件")
 	fs.BoolVar(&c.verbose, "v", false, "详细输出")
 	fs.BoolVar(&c.verbose, "verbose", false, "详细输出")
 }
 
+// Execute 对每种大小生成一对相近的文件，分别测量生成补丁和应用补丁的耗时
 func (c *BenchmarkCommand) Execute(args []string) error {
+	var sizes []int64
+	for _, field := range strings.Split(c.sizes, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		mb, err := strconv.Atoi(field)
+		if err != nil || mb <= 0 {
+			return ErrInvalidArgumentf("无效的文件大小: %s", field)
+		}
+		if !slices.Contains(sizes, int64(mb)<<20) {
+			sizes = append(sizes, int64(mb)<<20)
+		}
+	}
+	if len(sizes) == 0 {
+		return ErrInvalidArgumentf("需要至少一个文件大小: --sizes <MB>[,<MB>...]")
+	}
+	if err := c.app.setCompression("", -1); err != nil {
+		return err
+	}
+
+	// 只清理本次运行创建的目录和文件，按创建的相反顺序删除
+	var created []string
+	for dir := filepath.Clean(c.testDir); filepath.Dir(dir) != dir; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			break
+		}
+		created = append(created, dir)
+	}
+	slices.Reverse(created)
+	if err := os.MkdirAll(c.testDir, 0755); err != nil {
+		return WrapError(ErrFileCreate, "创建测试目录失败", err)
+	}
+	if c.cleanup {
+		defer func() {
+			for i := len(created) - 1; i >= 0; i-- {
+				os.Remove(created[i])
+			}
+		}()
+	}
+
 	c.app.logger.Info("开始性能基准测试...")
 	c.app.logger.Info("测试目录: %s", c.testDir)
 
-	// 这里应该调用性能测试模块
-	// 由于性能测试模块在不同的包中，这里只是示例
-	c.app.logger.Info("性能测试功能需要集成性能测试模块")
-	c.app.logger.Info("请参考 pkg/performance/benchmark.go 中的实现")
+	report := &BenchmarkReport{TestDir: c.testDir, Results: []BenchmarkResult{}}
+	c.app.SetResult(report)
+	for _, size := range sizes {
+		name := fmt.Sprintf("%dMB", size>>20)
+		oldFile := filepath.Join(c.testDir, "old_"+name+".bin")
+		newFile := filepath.Join(c.testDir, "new_"+name+".bin")
+		patchFile := filepath.Join(c.testDir, name+".patch")
+		outputFile := filepath.Join(c.testDir, "output_"+name+".bin")
+		created = append(created, oldFile, newFile, patchFile, outputFile)
+		if err := createBenchmarkFiles(oldFile, newFile, size); err != nil {
+			return WrapError(ErrFileCreate, "创建测试文件失败", err)
+		}
+
+		start := time.Now()
+		if err := c.app.engine.GeneratePatch(oldFile, newFile, patchFile, "", "", true, &NoOpProgress{}); err != nil {
+			return WrapError(ErrPatchGeneration, "生成补丁失败", err)
+		}
+		c.addResult(report, "diff", size, fileSize(patchFile), time.Since(start))
+
+		start = time.Now()
+		if err := c.app.engine.ApplyPatch(patchFile, oldFile, outputFile, true, &NoOpProgress{}); err != nil {
+			return WrapError(ErrPatchApplication, "应用补丁失败", err)
+		}
+		c.addResult(report, "apply", size, fileSize(patchFile), time.Since(start))
+	}
 
+	c.app.logger.Success("性能基准测试完成")
 	return nil
 }
 
+// addResult 记录并显示一项测试结果
+func (c *BenchmarkCommand) addResult(report *BenchmarkReport, name string, size, patchSize int64, duration time.Duration) {
+	result := BenchmarkResult{
+		Name:       name,
+		FileSize:   size,
+		PatchSize:  patchSize,
+		DurationMs: float64(duration.Microseconds()) / 1000,
+	}
+	if duration > 0 {
+		result.Throughput = float64(size) / (1 << 20) / duration.Seconds()
+	}
+	report.Results = append(report.Results, result)
+
+	c.app.logger.Info("%-5s %s: %v (%.2f MB/s)", name, formatFileSize(size), duration.Round(time.Millisecond), result.Throughput)
+	if c.verbose {
+		c.app.logger.Info("  补丁大小: %s", formatFileSize(patchSize))
+	}
+}
+
+// createBenchmarkFiles 生成随机内容的旧文件，新文件每64KB改写一小段并在中间插入一段数据
+func createBenchmarkFiles(oldFile, newFile string, size int64) error {
+	rng := rand.New(rand.NewSource(size))
+	oldData := make([]byte, size)
+	rng.Read(oldData)
+
+	newData := slices.Clone(oldData)
+	for offset := 0; offset+128 <= len(newData); offset += 64 * 1024 {
+		rng.Read(newData[offset : offset+128])
+	}
+	inserted := make([]byte, 4096)
+	rng.Read(inserted)
+	newData = slices.Insert(newData, len(newData)/2, inserted...)
+
+	if err := os.WriteFile(oldFile, oldData, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(newFile, newData, 0644)
+}
+
 // ConfigCommand 配置管理命令
 type ConfigCommand struct {
-	app    *App
-	action string
-	key    string
-	value  string
+	app        *App
+	system     bool
+	user       bool
+	project    bool
+	file       string
+	showOrigin bool
 }
 
 // NewConfigCommand 创建配置管理命令
@@ -411,16 +619,20 @@ func (c *ConfigCommand) Description() string {
 }
 
 func (c *ConfigCommand) Usage() string {
-	return "hexdiff config <action> [key] [value]"
+	return "hexdiff config [options] <init|get|set|unset|list|edit> [key] [value]"
 }
 
 func (c *ConfigCommand) SetFlags(fs *flag.FlagSet) {
-	// 配置命令通过位置参数处理
+	fs.BoolVar(&c.system, "system", false, "使用系统配置文件 "+GetSystemConfigPath())
+	fs.BoolVar(&c.user, "user", false, "使用用户配置文件 "+GetConfigPath()+" (set、unset、edit 的默认值)")
+	fs.BoolVar(&c.project, "project", false, "使用项目配置文件 "+ProjectConfigName)
+	fs.StringVar(&c.file, "file", "", "使用指定的配置文件")
+	fs.BoolVar(&c.showOrigin, "show-origin", false, "显示配置项的来源")
 }
 
 func (c *ConfigCommand) Execute(args []string) error {
 	if len(args) < 1 {
-		return ErrInvalidArgumentf("缺少操作参数 (init, get, set, list)")
+		return ErrInvalidArgumentf("缺少操作参数 (init, get, set, unset, list, edit)")
 	}
 
 	action := args[0]
@@ -438,13 +650,80 @@ func (c *ConfigCommand) Execute(args []string) error {
 			return ErrInvalidArgumentf("缺少配置键名或值")
 		}
 		return c.setConfig(args[1], args[2])
+	case "unset":
+		if len(args) < 2 {
+			return ErrInvalidArgumentf("缺少配置键名")
+		}
+		return c.unsetConfig(args[1])
 	case "list":
 		return c.listConfig()
+	case "edit":
+		return c.editConfig()
 	default:
 		return ErrInvalidArgumentf("未知操作: %s", action)
 	}
 }
 
+// scopeFile 返回 --system、--user、--project 或 --file 选择的配置文件，未选择时返回空
+func (c *ConfigCommand) scopeFile() (string, error) {
+	var files []string
+	if c.system {
+		files = append(files, GetSystemConfigPath())
+	}
+	if c.user {
+		files = append(files, GetConfigPath())
+	}
+	if c.project {
+		path := FindProjectConfig()
+		if path == "" {
+			path = ProjectConfigName
+		}
+		files = append(files, path)
+	}
+	if c.file != "" {
+		files = append(files, c.file)
+	}
+	if len(files) > 1 {
+		return "", ErrInvalidArgumentf("--system、--user、--project 和 --file 只能指定一个")
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	return files[0], nil
+}
+
+// targetFile 返回要修改的配置文件，默认为用户配置文件
+func (c *ConfigCommand) targetFile() (string, error) {
+	path, err := c.scopeFile()
+	if err != nil || path != "" {
+		return path, err
+	}
+	return GetConfigPath(), nil
+}
+
+// scopeConfig 返回要查看的配置，指定配置文件时只包含该文件中的配置项
+func (c *ConfigCommand) scopeConfig() (*Config, []string, error) {
+	path, err := c.scopeFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	if path == "" {
+		return c.app.config, ConfigKeys(), nil
+	}
+
+	config := NewConfig()
+	if err := config.mergeFile(path, OriginFile); err != nil {
+		return nil, nil, WrapError(ErrConfigInvalid, "读取配置文件失败", err)
+	}
+	var keys []string
+	for _, key := range ConfigKeys() {
+		if config.Origin(key) != OriginDefault {
+			keys = append(keys, key)
+		}
+	}
+	return config, keys, nil
+}
+
 func (c *ConfigCommand) initConfig() error {
 	configPath := GetConfigPath()
 
@@ -452,29 +731,136 @@ func (c *ConfigCommand) initConfig() error {
 		return WrapError(ErrConfigInvalid, "创建配置文件失败", err)
 	}
 
+	c.app.SetResult(map[string]string{"file": configPath})
 	c.app.logger.Success("配置文件已创建: %s", configPath)
 	return nil
 }
 
 func (c *ConfigCommand) getConfig(key string) error {
-	// 这里应该实现获取配置值的逻辑
-	c.app.logger.Info("获取配置: %s", key)
+	config, keys, err := c.scopeConfig()
+	if err != nil {
+		return err
+	}
+	value, err := config.Get(key)
+	if err != nil {
+		return ErrInvalidArgumentf("%v", err)
+	}
+	if !slices.Contains(keys, key) {
+		return NewCLIError(ErrConfigNotFound, fmt.Sprintf("配置文件中没有设置 %s", key))
+	}
+
+	if c.app.JSONOutput() {
+		c.app.SetResult(configEntry(config, key))
+		return nil
+	}
+	if c.showOrigin {
+		fmt.Printf("%s\t%s\n", config.Origin(key), value)
+	} else {
+		fmt.Println(value)
+	}
 	return nil
 }
 
 func (c *ConfigCommand) setConfig(key, value string) error {
-	// 这里应该实现设置配置值的逻辑
-	c.app.logger.Info("设置配置: %s = %s", key, value)
+	path, err := c.targetFile()
+	if err != nil {
+		return err
+	}
+	if err := SetConfigValue(path, key, value); err != nil {
+		return WrapError(ErrConfigInvalid, "设置配置失败", err)
+	}
+
+	config := NewConfig()
+	config.Set(key, value)
+	typed, _ := config.Value(key)
+	c.app.SetResult(&ConfigEntry{Key: key, Value: typed, File: path})
+	c.app.logger.Success("已设置 %s = %s (%s)", key, value, path)
+	return nil
+}
+
+func (c *ConfigCommand) unsetConfig(key string) error {
+	path, err := c.targetFile()
+	if err != nil {
+		return err
+	}
+	found, err := UnsetConfigValue(path, key)
+	if err != nil {
+		return WrapError(ErrConfigInvalid, "删除配置失败", err)
+	}
+	if !found {
+		return NewCLIError(ErrConfigNotFound, fmt.Sprintf("%s 中没有设置 %s", path, key))
+	}
+
+	c.app.SetResult(&ConfigEntry{Key: key, File: path})
+	c.app.logger.Success("已删除 %s (%s)", key, path)
 	return nil
 }
 
 func (c *ConfigCommand) listConfig() error {
-	// 这里应该实现列出所有配置的逻辑
-	c.app.logger.Info("当前配置:")
-	c.app.logger.Info("  日志级别: %s", c.app.config.LogLevel)
-	c.app.logger.Info("  显示进度: %t", c.app.config.ShowProgress)
-	c.app.logger.Info("  块大小: %d", c.app.config.BlockSize)
-	c.app.logger.Info("  最大内存: %d MB", c.app.config.MaxMemory)
-	c.app.logger.Info("  工作协程数: %d", c.app.config.WorkerCount)
+	config, keys, err := c.scopeConfig()
+	if err != nil {
+		return err
+	}
+
+	if c.app.JSONOutput() {
+		entries := make([]ConfigEntry, 0, len(keys))
+		for _, key := range keys {
+			entries = append(entries, configEntry(config, key))
+		}
+		c.app.SetResult(entries)
+		return nil
+	}
+
+	for _, key := range keys {
+		value, _ := config.Get(key)
+		if c.showOrigin {
+			fmt.Printf("%s\t%s=%s\n", config.Origin(key), key, value)
+		} else {
+			fmt.Printf("%s=%s\n", key, value)
+		}
+	}
+	return nil
+}
+
+// editConfig 使用 $VISUAL 或 $EDITOR 编辑配置文件，保存后检查配置是否有效
+func (c *ConfigCommand) editConfig() error {
+	path, err := c.targetFile()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := WriteConfigFile(path, nil); err != nil {
+			return WrapError(ErrFileCreate, "创建配置文件失败", err)
+		}
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return WrapError(ErrUnknown, "运行编辑器失败", err)
+	}
+
+	if err := ValidateConfigFile(path); err != nil {
+		return WrapError(ErrConfigInvalid, "配置文件无效，请重新编辑", err)
+	}
+	c.app.SetResult(map[string]string{"file": path})
+	c.app.logger.Success("配置文件已保存: %s", path)
 	return nil
 }
+
+// configEntry 返回配置项的值和来源
+func configEntry(config *Config, key string) ConfigEntry {
+	value, _ := config.Value(key)
+	return ConfigEntry{Key: key, Value: value, Origin: config.Origin(key)}
+}
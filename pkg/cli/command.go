@@ -1,12 +1,23 @@
 package cli
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Sky-ey/HexDiff/pkg/compression"
+	"github.com/Sky-ey/HexDiff/pkg/diff"
 	"github.com/Sky-ey/HexDiff/pkg/patch"
 )
 
@@ -58,6 +69,7 @@ type SignatureCommand struct {
 	app        *App
 	outputFile string
 	blockSize  int
+	cdc        bool
 	verbose    bool
 }
 
@@ -84,8 +96,9 @@ func (c *SignatureCommand) Usage() string {
 func (c *SignatureCommand) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.outputFile, "o", "", "输出签名文件路径")
 	fs.StringVar(&c.outputFile, "output", "", "输出签名文件路径")
-	fs.IntVar(&c.blockSize, "b", 4096, "块大小")
-	fs.IntVar(&c.blockSize, "block-size", 4096, "块大小")
+	fs.IntVar(&c.blockSize, "b", c.app.config.BlockSize, "块大小 (配置项 block_size)")
+	fs.IntVar(&c.blockSize, "block-size", c.app.config.BlockSize, "块大小 (配置项 block_size)")
+	fs.BoolVar(&c.cdc, "cdc", false, "使用基于内容的分块 (FastCDC)，插入数据只影响附近的分块，忽略块大小")
 	fs.BoolVar(&c.verbose, "v", false, "详细输出")
 	fs.BoolVar(&c.verbose, "verbose", false, "详细输出")
 }
@@ -112,7 +125,12 @@ func (c *SignatureCommand) Execute(args []string) error {
 	c.app.logger.Info("开始生成文件签名...")
 	c.app.logger.Info("输入文件: %s", inputFile)
 	c.app.logger.Info("输出文件: %s", outputFile)
-	c.app.logger.Info("块大小: %d", c.blockSize)
+	if c.cdc {
+		c.app.logger.Info("分块方式: FastCDC")
+	} else {
+		c.app.logger.Info("块大小: %d", c.blockSize)
+	}
+	c.app.engine.SetChunking(c.cdc)
 
 	// 创建进度条
 	progress := c.app.progress.NewTask("生成签名", 100)
@@ -123,6 +141,7 @@ func (c *SignatureCommand) Execute(args []string) error {
 		return WrapError(ErrPatchGeneration, "生成签名失败", err)
 	}
 
+	c.app.SetResult(&FileResult{Input: inputFile, OutputFile: outputFile, OutputSize: fileSize(outputFile)})
 	c.app.logger.Success("签名生成完成: %s", outputFile)
 	return nil
 }
@@ -148,14 +167,24 @@ type DiffCommand struct {
 	app        *App
 	outputFile string
 	signature  string
+	algorithm  string
 	verbose    bool
 	compress   bool
+	codec      string
+	level      int
+	dict       string
+	format     string
+	desc       string
+	encrypt    string
+	inPlace    bool
+	cdc        bool
 }
 
 // NewDiffCommand 创建差异检测命令
 func NewDiffCommand(app *App) *DiffCommand {
 	return &DiffCommand{
-		app: app,
+		app:   app,
+		level: -1,
 	}
 }
 
@@ -168,7 +197,7 @@ func (c *DiffCommand) Description() string {
 }
 
 func (c *DiffCommand) Usage() string {
-	return "hexdiff diff [options] <old-file> <new-file>"
+	return "hexdiff diff [options] <old-file> <new-file>\n       hexdiff diff [options] -s <signature-file> <new-file>"
 }
 
 func (c *DiffCommand) SetFlags(fs *flag.FlagSet) {
@@ -176,22 +205,58 @@ func (c *DiffCommand) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.outputFile, "output", "", "输出补丁文件路径")
 	fs.StringVar(&c.signature, "s", "", "使用现有签名文件")
 	fs.StringVar(&c.signature, "signature", "", "使用现有签名文件")
+	fs.StringVar(&c.algorithm, "a", "", "差异算法 ("+strings.Join(diff.Algorithms(), ", ")+")")
+	fs.StringVar(&c.algorithm, "algorithm", "", "差异算法 ("+strings.Join(diff.Algorithms(), ", ")+")")
 	fs.BoolVar(&c.verbose, "v", false, "详细输出")
 	fs.BoolVar(&c.verbose, "verbose", false, "详细输出")
 	fs.BoolVar(&c.compress, "c", true, "压缩补丁文件")
 	fs.BoolVar(&c.compress, "compress", true, "压缩补丁文件")
+	setCompressionFlags(fs, &c.codec, &c.level)
+	fs.StringVar(&c.dict, "dict", "", "使用Zstd字典压缩（未指定 --compression 时使用 zstd）")
+	fs.StringVar(&c.format, "format", "standard", "补丁格式 (standard, enhanced)，enhanced 附带元数据")
+	fs.StringVar(&c.desc, "description", "", "增强格式补丁的描述信息")
+	fs.StringVar(&c.encrypt, "encrypt", "", "加密补丁：pass:<口令>、env:<环境变量> 或密钥文件（X25519公钥或32字节密钥）")
+	fs.BoolVar(&c.inPlace, "in-place", false, "生成可以直接在旧文件上应用的原地补丁（apply --in-place）")
+	fs.BoolVar(&c.cdc, "cdc", false, "使用基于内容的分块 (FastCDC)，插入数据只影响附近的分块")
 }
 
 func (c *DiffCommand) Execute(args []string) error {
-	if len(args) < 2 {
+	// 使用签名文件时旧文件可以不在本机，只需要新文件
+	var oldFile, newFile string
+	switch {
+	case c.signature != "" && len(args) == 1:
+		newFile = args[0]
+	case len(args) >= 2:
+		oldFile = args[0]
+		newFile = args[1]
+	case c.signature != "":
+		return ErrInvalidArgumentf("需要新文件参数: -s <signature-file> <new-file>")
+	default:
 		return ErrInvalidArgumentf("需要两个文件参数: <old-file> <new-file>")
 	}
 
-	oldFile := args[0]
-	newFile := args[1]
+	// 签名文件只包含块哈希，只能使用滚动哈希算法
+	if c.signature != "" && c.algorithm != "" && c.algorithm != diff.AlgorithmRollingHash {
+		return ErrInvalidArgumentf("使用签名文件时只支持 %s 算法", diff.AlgorithmRollingHash)
+	}
+	// 使用签名文件时分块方式由签名决定
+	if c.signature != "" && c.cdc {
+		return ErrInvalidArgumentf("--cdc 不能与签名文件同时使用，分块方式由签名文件决定")
+	}
+	if c.cdc && c.algorithm != "" && c.algorithm != diff.AlgorithmRollingHash {
+		return ErrInvalidArgumentf("--cdc 只支持 %s 算法", diff.AlgorithmRollingHash)
+	}
+	// 打破操作之间的依赖环需要读取旧文件
+	if c.signature != "" && c.inPlace {
+		return ErrInvalidArgumentf("--in-place 需要旧文件，不能与签名文件同时使用")
+	}
 
 	// 验证输入文件
-	if err := c.validateInputFile(oldFile); err != nil {
+	if c.signature != "" {
+		if err := c.validateInputFile(c.signature); err != nil {
+			return WrapError(ErrFileRead, "签名文件错误", err)
+		}
+	} else if err := c.validateInputFile(oldFile); err != nil {
 		return WrapError(ErrFileRead, "旧文件错误", err)
 	}
 	if err := c.validateInputFile(newFile); err != nil {
@@ -201,30 +266,77 @@ func (c *DiffCommand) Execute(args []string) error {
 	// 确定输出文件
 	outputFile := c.outputFile
 	if outputFile == "" {
+		oldName := oldFile
+		if oldName == "" {
+			oldName = strings.TrimSuffix(c.signature, ".sig")
+		}
 		outputFile = fmt.Sprintf("%s_to_%s.patch",
-			filepath.Base(oldFile), filepath.Base(newFile))
+			filepath.Base(oldName), filepath.Base(newFile))
 	}
 
 	// 显示操作信息
 	c.app.logger.Info("开始生成补丁...")
-	c.app.logger.Info("旧文件: %s", oldFile)
+	if oldFile != "" {
+		c.app.logger.Info("旧文件: %s", oldFile)
+	}
 	c.app.logger.Info("新文件: %s", newFile)
 	c.app.logger.Info("补丁文件: %s", outputFile)
 	if c.signature != "" {
 		c.app.logger.Info("使用签名文件: %s", c.signature)
 	}
+	if c.algorithm != "" {
+		c.app.logger.Info("差异算法: %s", c.algorithm)
+	}
+
+	codec := c.codec
+	if c.dict != "" {
+		if codec == "" {
+			codec = "zstd"
+		} else if !strings.EqualFold(codec, "zstd") && !strings.EqualFold(codec, "auto") {
+			return ErrInvalidArgumentf("字典只能用于 zstd 或 auto 压缩")
+		}
+		id, err := c.app.engine.SetDictionary(c.dict)
+		if err != nil {
+			return WrapError(ErrFileRead, "加载字典失败", err)
+		}
+		c.app.logger.Info("使用字典: %s (%08x)", c.dict, id)
+	}
+	if err := c.app.setCompression(codec, c.level); err != nil {
+		return err
+	}
+	if err := c.app.engine.SetPatchFormat(c.format, c.desc); err != nil {
+		return WrapError(ErrInvalidArgument, "补丁格式错误", err)
+	}
+	encryption, err := c.app.engine.SetEncryption(c.encrypt)
+	if err != nil {
+		return WrapError(ErrInvalidArgument, "加载加密密钥失败", err)
+	}
+	if encryption != nil {
+		c.app.logger.Info("加密: %s", formatEncryption(encryption))
+	}
+	c.app.engine.SetInPlace(c.inPlace)
+	c.app.engine.SetChunking(c.cdc)
 
 	// 创建进度条
 	progress := c.app.progress.NewTask("生成补丁", 100)
 	defer progress.Finish()
 
 	// 执行差异检测
-	if err := c.app.engine.GeneratePatch(oldFile, newFile, outputFile, c.signature, c.compress, progress); err != nil {
+	if err := c.app.engine.GeneratePatch(oldFile, newFile, outputFile, c.signature, c.algorithm, c.compress, progress); err != nil {
 		return WrapError(ErrPatchGeneration, "生成补丁失败", err)
 	}
 
 	// 显示补丁信息
-	if err := c.showPatchInfo(outputFile); err != nil {
+	result := &DiffResult{
+		OldFile:    oldFile,
+		Signature:  c.signature,
+		NewFile:    newFile,
+		PatchFile:  outputFile,
+		InPlace:    c.inPlace,
+		Encryption: encryption,
+	}
+	c.app.SetResult(result)
+	if err := c.showPatchInfo(result); err != nil {
 		c.app.logger.Warning("无法显示补丁信息: %v", err)
 	}
 
@@ -248,11 +360,13 @@ func (c *DiffCommand) validateInputFile(path string) error {
 	return nil
 }
 
-func (c *DiffCommand) showPatchInfo(patchFile string) error {
+func (c *DiffCommand) showPatchInfo(result *DiffResult) error {
+	patchFile := result.PatchFile
 	info, err := os.Stat(patchFile)
 	if err != nil {
 		return err
 	}
+	result.PatchSize = info.Size()
 
 	c.app.logger.Info("补丁文件大小: %s", formatFileSize(info.Size()))
 
@@ -272,6 +386,16 @@ type ApplyCommand struct {
 	backup     bool
 	verify     bool
 	verbose    bool
+	rollback   bool
+	only       string
+	exclude    string
+	dicts      string
+	pubkeys    string
+	decryptKey string
+	resume     bool
+	inPlace    bool
+	reverse    bool
+	extLinks   bool
 }
 
 // NewApplyCommand 创建应用补丁命令
@@ -298,13 +422,30 @@ func (c *ApplyCommand) Usage() string {
 func (c *ApplyCommand) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.outputFile, "o", "", "输出文件路径")
 	fs.StringVar(&c.outputFile, "output", "", "输出文件路径")
-	fs.BoolVar(&c.backup, "backup", true, "创建备份文件")
-	fs.BoolVar(&c.verify, "verify", true, "验证补丁应用结果")
+	fs.BoolVar(&c.backup, "backup", c.app.config.EnableBackup, "创建备份文件 (配置项 enable_backup)")
+	fs.BoolVar(&c.verify, "verify", c.app.config.EnableIntegrity, "验证补丁应用结果 (配置项 enable_integrity)")
 	fs.BoolVar(&c.verbose, "v", false, "详细输出")
 	fs.BoolVar(&c.verbose, "verbose", false, "详细输出")
+	fs.BoolVar(&c.rollback, "rollback", false, "回滚目标目录上未完成的目录补丁")
+	fs.StringVar(&c.only, "only", "", "只应用目录补丁中匹配的路径（逗号分隔的模式）")
+	fs.StringVar(&c.exclude, "exclude", "", "不应用目录补丁中匹配的路径（逗号分隔的模式）")
+	fs.StringVar(&c.dicts, "dict", "", "加载补丁使用的Zstd字典（逗号分隔的路径）")
+	fs.StringVar(&c.pubkeys, "pubkey", "", "只应用由这些公钥签名的补丁（逗号分隔的PEM公钥路径）")
+	fs.BoolVar(&c.resume, "resume", false, "定期保存检查点，中断后使用相同参数再次应用时从检查点继续（单文件补丁）")
+	fs.BoolVar(&c.inPlace, "in-place", false, "直接改写目标文件，不创建副本和备份（需要 diff --in-place 生成的补丁）")
+	fs.BoolVar(&c.reverse, "reverse", false, "将新目录恢复为旧目录（需要 dir-diff --reversible 生成的目录补丁）")
+	fs.BoolVar(&c.extLinks, "allow-external-symlinks", false, "允许目录补丁创建绝对路径或指向目标目录之外的符号链接")
+	setDecryptKeyFlag(fs, &c.decryptKey)
 }
 
 func (c *ApplyCommand) Execute(args []string) error {
+	if c.rollback {
+		if len(args) < 1 {
+			return ErrInvalidArgumentf("需要一个参数: <target-dir>")
+		}
+		return c.rollbackDirectoryPatch(args[0])
+	}
+
 	if len(args) < 2 {
 		return ErrInvalidArgumentf("需要两个参数: <patch-file> <target-file>")
 	}
@@ -317,6 +458,23 @@ func (c *ApplyCommand) Execute(args []string) error {
 		return WrapError(ErrFileRead, "补丁文件错误", err)
 	}
 
+	for _, path := range splitIgnorePatterns(c.dicts) {
+		id, err := c.app.engine.LoadDictionary(path)
+		if err != nil {
+			return WrapError(ErrFileRead, "加载字典失败", err)
+		}
+		c.app.logger.Debug("已加载字典: %s (%08x)", path, id)
+	}
+
+	if pubkeys := splitIgnorePatterns(c.pubkeys); len(pubkeys) > 0 {
+		if err := c.app.engine.LoadTrustedKeys(pubkeys); err != nil {
+			return WrapError(ErrFileRead, "加载公钥失败", err)
+		}
+	}
+	if err := c.app.engine.LoadDecryptionKeys(splitIgnorePatterns(c.decryptKey)); err != nil {
+		return WrapError(ErrFileRead, "加载解密密钥失败", err)
+	}
+
 	// 检查是否是目录补丁
 	isDirPatch, err := c.isDirectoryPatch(patchFile)
 	if err != nil {
@@ -324,8 +482,25 @@ func (c *ApplyCommand) Execute(args []string) error {
 	}
 
 	if isDirPatch {
+		if c.inPlace {
+			return ErrInvalidArgumentf("--in-place 只能用于单文件补丁")
+		}
+		c.app.engine.SetAllowExternalSymlinks(c.extLinks)
 		return c.applyDirectoryPatch(patchFile, targetFile)
 	}
+	if c.only != "" || c.exclude != "" {
+		return ErrInvalidArgumentf("--only 和 --exclude 只能用于目录补丁")
+	}
+	if c.reverse {
+		return ErrInvalidArgumentf("--reverse 只能用于目录补丁，单文件补丁请使用 reverse 命令生成反向补丁")
+	}
+	if c.inPlace {
+		if c.outputFile != "" || c.resume {
+			return ErrInvalidArgumentf("--in-place 不能与 --output 或 --resume 同时使用")
+		}
+		return c.applyInPlace(patchFile, targetFile)
+	}
+	c.app.engine.SetResume(c.resume)
 
 	// 应用单文件补丁
 	return c.applySingleFilePatch(patchFile, targetFile)
@@ -340,23 +515,79 @@ func (c *ApplyCommand) isDirectoryPatch(patchFile string) (bool, error) {
 }
 
 func (c *ApplyCommand) applyDirectoryPatch(patchFile, targetDir string) error {
-	c.app.logger.Info("检测到目录补丁，正在应用...")
+	if c.reverse {
+		c.app.logger.Info("检测到目录补丁，正在反向应用...")
+	} else {
+		c.app.logger.Info("检测到目录补丁，正在应用...")
+	}
 	c.app.logger.Info("补丁文件: %s", patchFile)
 	c.app.logger.Info("目标目录: %s", targetDir)
+	if c.only != "" {
+		c.app.logger.Info("只应用: %s", c.only)
+	}
+	if c.exclude != "" {
+		c.app.logger.Info("排除: %s", c.exclude)
+	}
 
 	progress := c.app.progress.NewTask("应用目录补丁", 0)
 	defer progress.Finish()
 
-	result, err := c.app.engine.ApplyDirPatch(patchFile, targetDir, true, progress)
+	only := splitIgnorePatterns(c.only)
+	exclude := splitIgnorePatterns(c.exclude)
+	apply := c.app.engine.ApplyDirPatchWithFilter
+	if c.reverse {
+		apply = c.app.engine.ApplyDirPatchReverse
+	}
+	result, err := apply(patchFile, targetDir, only, exclude, progress)
+	if errors.Is(err, patch.ErrNotReversible) {
+		return WrapError(ErrPatchIncompatible, "补丁不能反向应用，请使用 dir-diff --reversible 生成补丁或使用 reverse 命令", err)
+	}
 	if err != nil {
 		return WrapError(ErrPatchApplication, "应用目录补丁失败", err)
 	}
 
-	_ = result
+	applyResult := &ApplyResult{
+		PatchFile:   patchFile,
+		Target:      targetDir,
+		OutputFile:  targetDir,
+		IsDirectory: true,
+		Reverse:     c.reverse,
+	}
+	if dirPatch, ok := result.(*diff.DirPatch); ok {
+		for _, f := range dirPatch.Files {
+			switch f.Status {
+			case diff.StatusRenamed:
+				c.app.logger.Info("重命名: %s -> %s", f.OldPath, f.RelativePath)
+			case diff.StatusCopied:
+				c.app.logger.Info("复制: %s -> %s", f.OldPath, f.RelativePath)
+			}
+			if f.Status != diff.StatusUnchanged {
+				applyResult.Files = append(applyResult.Files, FileChange{Path: f.RelativePath, OldPath: f.OldPath, Status: f.Status.String()})
+			}
+		}
+	}
+	c.app.SetResult(applyResult)
 	c.app.logger.Success("目录补丁应用完成: %s", targetDir)
 	return nil
 }
 
+func (c *ApplyCommand) rollbackDirectoryPatch(targetDir string) error {
+	result := &ApplyResult{Target: targetDir, OutputFile: targetDir, IsDirectory: true}
+	c.app.SetResult(result)
+	if !patch.HasPendingTransaction(targetDir) {
+		c.app.logger.Info("目标目录没有未完成的目录补丁: %s", targetDir)
+		return nil
+	}
+
+	if err := c.app.engine.RollbackDirPatch(targetDir); err != nil {
+		return WrapError(ErrPatchApplication, "回滚目录补丁失败", err)
+	}
+	result.RolledBack = true
+
+	c.app.logger.Success("目录补丁已回滚: %s", targetDir)
+	return nil
+}
+
 func (c *ApplyCommand) applySingleFilePatch(patchFile, targetFile string) error {
 	outputFile := c.outputFile
 	if outputFile == "" {
@@ -373,6 +604,13 @@ func (c *ApplyCommand) applySingleFilePatch(patchFile, targetFile string) error
 	c.app.logger.Info("补丁文件: %s", patchFile)
 	c.app.logger.Info("目标文件: %s", targetFile)
 	c.app.logger.Info("输出文件: %s", outputFile)
+	if patch.HasCheckpoint(outputFile) {
+		if c.resume {
+			c.app.logger.Info("发现未完成的应用，将校验已写入的部分并从检查点继续")
+		} else {
+			c.app.logger.Warning("发现未完成的应用，使用 --resume 可以从检查点继续")
+		}
+	}
 
 	// 创建备份
 	var backupFile string
@@ -400,13 +638,48 @@ func (c *ApplyCommand) applySingleFilePatch(patchFile, targetFile string) error
 	c.app.logger.Success("补丁应用完成: %s", outputFile)
 
 	// 显示结果信息
-	if err := c.showResultInfo(outputFile); err != nil {
+	result := &ApplyResult{PatchFile: patchFile, Target: targetFile, OutputFile: outputFile, BackupFile: backupFile}
+	c.app.SetResult(result)
+	if err := c.showResultInfo(result); err != nil {
 		c.app.logger.Warning("无法显示结果信息: %v", err)
 	}
 
 	return nil
 }
 
+func (c *ApplyCommand) applyInPlace(patchFile, targetFile string) error {
+	if err := c.validateInputFile(targetFile); err != nil {
+		return WrapError(ErrFileRead, "目标文件错误", err)
+	}
+
+	c.app.logger.Info("开始原地应用补丁...")
+	c.app.logger.Info("补丁文件: %s", patchFile)
+	c.app.logger.Info("目标文件: %s", targetFile)
+	if patch.HasInPlaceJournal(targetFile) {
+		c.app.logger.Info("发现未完成的原地应用，将撤销未提交的写入并继续")
+	}
+
+	progress := c.app.progress.NewTask("原地应用补丁", 100)
+	defer progress.Finish()
+
+	if err := c.app.engine.ApplyPatchInPlace(patchFile, targetFile, progress); err != nil {
+		if errors.Is(err, patch.ErrNotInPlace) {
+			return WrapError(ErrPatchApplication, "补丁不支持原地应用，请使用 diff --in-place 重新生成", err)
+		}
+		return WrapError(ErrPatchApplication, "原地应用补丁失败", err)
+	}
+
+	c.app.SetResult(&ApplyResult{
+		PatchFile:  patchFile,
+		Target:     targetFile,
+		OutputFile: targetFile,
+		OutputSize: fileSize(targetFile),
+		InPlace:    true,
+	})
+	c.app.logger.Success("补丁应用完成: %s", targetFile)
+	return nil
+}
+
 func (c *ApplyCommand) validateInputFile(path string) error {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -440,11 +713,13 @@ func (c *ApplyCommand) createBackup(source, backup string) error {
 	return err
 }
 
-func (c *ApplyCommand) showResultInfo(outputFile string) error {
+func (c *ApplyCommand) showResultInfo(result *ApplyResult) error {
+	outputFile := result.OutputFile
 	info, err := os.Stat(outputFile)
 	if err != nil {
 		return err
 	}
+	result.OutputSize = info.Size()
 
 	c.app.logger.Info("输出文件大小: %s", formatFileSize(info.Size()))
 
@@ -458,8 +733,9 @@ func (c *ApplyCommand) showResultInfo(outputFile string) error {
 
 // ValidateCommand 验证命令
 type ValidateCommand struct {
-	app     *App
-	verbose bool
+	app        *App
+	verbose    bool
+	decryptKey string
 }
 
 // NewValidateCommand 创建验证命令
@@ -484,6 +760,7 @@ func (c *ValidateCommand) Usage() string {
 func (c *ValidateCommand) SetFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&c.verbose, "v", false, "详细输出")
 	fs.BoolVar(&c.verbose, "verbose", false, "详细输出")
+	setDecryptKeyFlag(fs, &c.decryptKey)
 }
 
 func (c *ValidateCommand) Execute(args []string) error {
@@ -498,6 +775,10 @@ func (c *ValidateCommand) Execute(args []string) error {
 		return err
 	}
 
+	if err := c.app.engine.LoadDecryptionKeys(splitIgnorePatterns(c.decryptKey)); err != nil {
+		return WrapError(ErrFileRead, "加载解密密钥失败", err)
+	}
+
 	c.app.logger.Info("开始验证补丁文件...")
 	c.app.logger.Info("补丁文件: %s", patchFile)
 
@@ -512,6 +793,7 @@ func (c *ValidateCommand) Execute(args []string) error {
 	}
 
 	// 显示验证结果
+	c.app.SetResult(&ValidateResult{PatchFile: patchFile, ValidationResult: result})
 	c.showValidationResult(result)
 
 	if result.Valid {
@@ -615,6 +897,7 @@ func (c *InfoCommand) Execute(args []string) error {
 	}
 
 	// 显示信息
+	c.app.SetResult(&InfoResult{PatchFile: patchFile, Patch: info})
 	c.showPatchInfo(info)
 
 	return nil
@@ -625,6 +908,7 @@ func (c *InfoCommand) showDirPatchInfo(patchFile string) error {
 	if err != nil {
 		return WrapError(ErrFileRead, "读取目录补丁信息失败", err)
 	}
+	c.app.SetResult(&InfoResult{PatchFile: patchFile, IsDirectory: true, DirPatch: info})
 
 	c.app.logger.Info("目录补丁信息:")
 	c.app.logger.Info("  版本: %d", info.Version)
@@ -634,14 +918,23 @@ func (c *InfoCommand) showDirPatchInfo(patchFile string) error {
 	c.app.logger.Info("  新增文件: %d", info.AddedFiles)
 	c.app.logger.Info("  删除文件: %d", info.DeletedFiles)
 	c.app.logger.Info("  修改文件: %d", info.ModifiedFiles)
+	c.app.logger.Info("  重命名文件: %d", info.RenamedFiles)
+	c.app.logger.Info("  复制文件: %d", info.CopiedFiles)
 	c.app.logger.Info("  未改变文件: %d", info.UnchangedFiles)
+	if info.SourceFiles > 0 {
+		c.app.logger.Info("  跨文件源: %d 个旧文件", info.SourceFiles)
+	}
+	if info.Reversible {
+		c.app.logger.Info("  反向应用: 支持")
+	}
 	c.app.logger.Info("  补丁大小: %s", formatFileSize(info.PatchSize))
+	c.showSignature(info.Signature)
 
 	if c.verbose {
 		c.app.logger.Info("  创建时间: %s", info.CreatedAt.Format("2006-01-02 15:04:05"))
 	}
 
-	if c.verbose || info.AddedFiles > 0 || info.ModifiedFiles > 0 || info.DeletedFiles > 0 {
+	if c.verbose || info.AddedFiles > 0 || info.ModifiedFiles > 0 || info.DeletedFiles > 0 || info.RenamedFiles > 0 || info.CopiedFiles > 0 {
 		c.app.logger.Info("")
 		if len(info.AddedFileList) > 0 {
 			c.app.logger.Info("  新增文件列表:")
@@ -655,6 +948,18 @@ func (c *InfoCommand) showDirPatchInfo(patchFile string) error {
 				c.app.logger.Info("    M %s", f)
 			}
 		}
+		if len(info.RenamedFileList) > 0 {
+			c.app.logger.Info("  重命名文件列表:")
+			for _, f := range info.RenamedFileList {
+				c.app.logger.Info("    R %s", f)
+			}
+		}
+		if len(info.CopiedFileList) > 0 {
+			c.app.logger.Info("  复制文件列表:")
+			for _, f := range info.CopiedFileList {
+				c.app.logger.Info("    C %s", f)
+			}
+		}
 		if len(info.DeletedFileList) > 0 {
 			c.app.logger.Info("  删除文件列表:")
 			for _, f := range info.DeletedFileList {
@@ -682,21 +987,57 @@ func (c *InfoCommand) validateInputFile(path string) error {
 	return nil
 }
 
+// showSignature 显示签名信息，签名是否可信需要使用 verify 命令验证
+func (c *InfoCommand) showSignature(signature *SignatureInfo) {
+	if signature == nil {
+		return
+	}
+	if signature.Signer != "" {
+		c.app.logger.Info("  签名: %s (公钥 %s，未验证)", signature.Signer, signature.KeyID)
+	} else {
+		c.app.logger.Info("  签名: 公钥 %s (未验证)", signature.KeyID)
+	}
+	if c.verbose {
+		c.app.logger.Info("  签名时间: %s", signature.SignedAt.Format("2006-01-02 15:04:05"))
+	}
+}
+
 func (c *InfoCommand) showPatchInfo(info *PatchInfo) {
 	c.app.logger.Info("补丁文件信息:")
 	c.app.logger.Info("  版本: %d", info.Version)
+	if info.Enhanced {
+		c.app.logger.Info("  格式: 增强 (HXDF)")
+		if description := info.Metadata["description"]; description != "" {
+			c.app.logger.Info("  描述: %s", description)
+		}
+	}
 	c.app.logger.Info("  压缩: %s", getCompressionString(info.Compression))
+	if info.DictID != 0 {
+		c.app.logger.Info("  字典: %08x", info.DictID)
+	}
+	if info.Encryption != nil {
+		c.app.logger.Info("  加密: %s", formatEncryption(info.Encryption))
+	}
+	if info.InPlace {
+		c.app.logger.Info("  原地应用: 支持")
+	}
 	c.app.logger.Info("  源文件校验和: %x", info.SourceChecksum)
 	c.app.logger.Info("  目标文件校验和: %x", info.TargetChecksum)
 	c.app.logger.Info("  操作数量: %d", info.OperationCount)
 	c.app.logger.Info("  补丁大小: %s", formatFileSize(info.PatchSize))
+	c.showSignature(info.Signature)
 
 	if c.verbose {
 		c.app.logger.Info("  创建时间: %s", info.CreatedAt.Format("2006-01-02 15:04:05"))
-		if info.Metadata != nil {
+		if len(info.Metadata) > 0 {
 			c.app.logger.Info("  元数据:")
-			for key, value := range info.Metadata {
-				c.app.logger.Info("    %s: %s", key, value)
+			keys := make([]string, 0, len(info.Metadata))
+			for key := range info.Metadata {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				c.app.logger.Info("    %s: %s", key, info.Metadata[key])
 			}
 		}
 	}
@@ -716,6 +1057,19 @@ func formatFileSize(size int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
+// formatEncryption 返回加密方式的说明
+func formatEncryption(encryption *EncryptionInfo) string {
+	if encryption.KeyID == "" {
+		return "AES-256-GCM, " + encryption.Kind
+	}
+	return fmt.Sprintf("AES-256-GCM, %s (密钥 %s)", encryption.Kind, encryption.KeyID)
+}
+
+// setDecryptKeyFlag 注册加载解密密钥的参数
+func setDecryptKeyFlag(fs *flag.FlagSet, spec *string) {
+	fs.StringVar(spec, "decrypt-key", "", "解密补丁的密钥：pass:<口令>、env:<环境变量> 或密钥文件（X25519私钥或32字节密钥），逗号分隔")
+}
+
 func getStatusString(valid bool) string {
 	if valid {
 		return "✅ 通过"
@@ -723,6 +1077,12 @@ func getStatusString(valid bool) string {
 	return "❌ 失败"
 }
 
+// setCompressionFlags 注册选择压缩算法和级别的参数，未指定时使用配置文件中的默认值
+func setCompressionFlags(fs *flag.FlagSet, codec *string, level *int) {
+	fs.StringVar(codec, "compression", "", "压缩算法 (none, gzip, lz4, zstd, auto)，默认使用配置中的算法")
+	fs.IntVar(level, "level", -1, "压缩级别 (1-11)，默认使用配置中的级别")
+}
+
 func getCompressionString(compression CompressionType) string {
 	switch compression {
 	case CompressionNone:
@@ -731,6 +1091,10 @@ func getCompressionString(compression CompressionType) string {
 		return "Gzip"
 	case CompressionLZ4:
 		return "LZ4"
+	case CompressionZstd:
+		return "Zstd"
+	case CompressionAuto:
+		return "自动（按数据块选择）"
 	default:
 		return "未知"
 	}
@@ -738,22 +1102,49 @@ func getCompressionString(compression CompressionType) string {
 
 // 类型定义（这些应该在其他包中定义，这里为了编译通过临时定义）
 type ValidationResult struct {
-	Valid         bool
-	ValidFormat   bool
-	ValidChecksum bool
-	ValidData     bool
-	Errors        []string
+	Valid         bool     `json:"valid"`
+	ValidFormat   bool     `json:"valid_format"`
+	ValidChecksum bool     `json:"valid_checksum"`
+	ValidData     bool     `json:"valid_data"`
+	Errors        []string `json:"errors"`
 }
 
 type PatchInfo struct {
-	Version        uint16
-	Compression    CompressionType
-	SourceChecksum []byte
-	TargetChecksum []byte
-	OperationCount int
-	PatchSize      int64
-	CreatedAt      time.Time
-	Metadata       map[string]string
+	Version        uint16            `json:"version"`
+	Compression    CompressionType   `json:"compression"`
+	SourceChecksum HexBytes          `json:"source_checksum"`
+	TargetChecksum HexBytes          `json:"target_checksum"`
+	OperationCount int               `json:"operation_count"`
+	PatchSize      int64             `json:"patch_size"`
+	CreatedAt      time.Time         `json:"created_at"`
+	Metadata       map[string]string `json:"metadata"`
+	DictID         uint32            `json:"dict_id"`  // Zstd字典ID，未使用字典时为0
+	Enhanced       bool              `json:"enhanced"` // 增强格式(HXDF)补丁，Metadata中包含其元数据
+	InPlace        bool              `json:"in_place"` // 可以直接在源文件上应用的原地补丁
+	Signature      *SignatureInfo    `json:"signature"`
+	Encryption     *EncryptionInfo   `json:"encryption"` // 未加密时为nil
+}
+
+// EncryptionInfo 补丁加密信息
+type EncryptionInfo struct {
+	Kind  string `json:"kind"`   // 密钥类型：Passphrase、RawKey 或 X25519
+	KeyID string `json:"key_id"` // 原始密钥或接收方公钥的短标识，口令加密时为空
+}
+
+// SignatureInfo 补丁签名信息
+type SignatureInfo struct {
+	Signer   string    `json:"signer"`
+	KeyID    string    `json:"key_id"` // 签名公钥的短标识
+	SignedAt time.Time `json:"signed_at"`
+	Verified bool      `json:"verified"` // 签名已使用受信任的公钥验证
+}
+
+// DictInfo Zstd字典信息
+type DictInfo struct {
+	ID          uint32 `json:"id"`
+	Size        int64  `json:"size"`
+	Samples     int    `json:"samples"`      // 训练使用的样本数量
+	SampleBytes int64  `json:"sample_bytes"` // 训练使用的样本总大小
 }
 
 type CompressionType int
@@ -762,23 +1153,62 @@ const (
 	CompressionNone CompressionType = iota
 	CompressionGzip
 	CompressionLZ4
+	CompressionZstd
+	CompressionAuto
 )
 
 // DirPatchInfo 目录补丁信息
 type DirPatchInfo struct {
-	Version          uint16
-	OldDir           string
-	NewDir           string
-	FileCount        int
-	AddedFiles       int
-	DeletedFiles     int
-	ModifiedFiles    int
-	UnchangedFiles   int
-	PatchSize        int64
-	CreatedAt        time.Time
-	AddedFileList    []string
-	DeletedFileList  []string
-	ModifiedFileList []string
+	Version          uint16         `json:"version"`
+	OldDir           string         `json:"old_dir"`
+	NewDir           string         `json:"new_dir"`
+	FileCount        int            `json:"file_count"`
+	AddedFiles       int            `json:"added_files"`
+	DeletedFiles     int            `json:"deleted_files"`
+	ModifiedFiles    int            `json:"modified_files"`
+	RenamedFiles     int            `json:"renamed_files"`
+	CopiedFiles      int            `json:"copied_files"`
+	UnchangedFiles   int            `json:"unchanged_files"`
+	SourceFiles      int            `json:"source_files"`
+	Reversible       bool           `json:"reversible"` // 是否嵌入了反向条目
+	PatchSize        int64          `json:"patch_size"`
+	CreatedAt        time.Time      `json:"created_at"`
+	AddedFileList    []string       `json:"added_file_list"`
+	DeletedFileList  []string       `json:"deleted_file_list"`
+	ModifiedFileList []string       `json:"modified_file_list"`
+	RenamedFileList  []string       `json:"renamed_file_list"` // 格式为 "原路径 -> 新路径"
+	CopiedFileList   []string       `json:"copied_file_list"`  // 格式为 "原路径 -> 新路径"
+	Signature        *SignatureInfo `json:"signature"`
+}
+
+// SyncResult 远程同步结果
+type SyncResult struct {
+	SignatureBytes int64 `json:"signature_bytes"`
+	PatchBytes     int64 `json:"patch_bytes"`
+	SourceSize     int64 `json:"source_size"`
+	TargetSize     int64 `json:"target_size"`
+}
+
+// SquashResult 补丁合并结果
+type SquashResult struct {
+	PatchFile   string `json:"patch_file"`
+	IsDirectory bool   `json:"is_directory"`
+	Operations  int    `json:"operations"`  // 单文件补丁的操作数
+	Files       int    `json:"files"`       // 目录补丁的条目数
+	SourceSize  int64  `json:"source_size"` // 单文件补丁的源文件大小
+	TargetSize  int64  `json:"target_size"` // 单文件补丁的目标文件大小
+	PatchSize   int64  `json:"patch_size"`
+}
+
+// ReverseResult 反向补丁生成结果
+type ReverseResult struct {
+	PatchFile   string `json:"patch_file"`
+	IsDirectory bool   `json:"is_directory"`
+	Operations  int    `json:"operations"`  // 单文件补丁的操作数
+	Files       int    `json:"files"`       // 目录补丁的条目数
+	SourceSize  int64  `json:"source_size"` // 单文件补丁的源文件大小，即原补丁的目标文件大小
+	TargetSize  int64  `json:"target_size"` // 单文件补丁的目标文件大小，即原补丁的源文件大小
+	PatchSize   int64  `json:"patch_size"`
 }
 
 // DirDiffCommand 目录差异检测命令
@@ -789,6 +1219,9 @@ type DirDiffCommand struct {
 	ignoreHidden bool
 	ignore       string
 	compress     bool
+	codec        string
+	level        int
+	reversible   bool
 	verbose      bool
 }
 
@@ -798,6 +1231,7 @@ func NewDirDiffCommand(app *App) *DirDiffCommand {
 		app:       app,
 		recursive: true,
 		compress:  true,
+		level:     -1,
 	}
 }
 
@@ -822,6 +1256,8 @@ func (c *DirDiffCommand) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.ignore, "ignore", "", "忽略的文件模式（逗号分隔）")
 	fs.BoolVar(&c.compress, "c", true, "压缩补丁文件")
 	fs.BoolVar(&c.compress, "compress", true, "压缩补丁文件")
+	setCompressionFlags(fs, &c.codec, &c.level)
+	fs.BoolVar(&c.reversible, "reversible", false, "嵌入反向条目，补丁可以用 apply --reverse 反向应用")
 	fs.BoolVar(&c.verbose, "v", false, "详细输出")
 	fs.BoolVar(&c.verbose, "verbose", false, "详细输出")
 }
@@ -852,15 +1288,20 @@ func (c *DirDiffCommand) Execute(args []string) error {
 	c.app.logger.Info("新目录: %s", newDir)
 	c.app.logger.Info("输出文件: %s", outputFile)
 
+	if err := c.app.setCompression(c.codec, c.level); err != nil {
+		return err
+	}
+
+	c.app.engine.SetReversible(c.reversible)
+
 	progress := c.app.progress.NewTask("生成目录补丁", 0)
 	defer progress.Finish()
 
-	result, err := c.app.engine.GenerateDirDiff(oldDir, newDir, outputFile, c.recursive, !c.ignoreHidden, c.ignore, c.compress, progress)
-	if err != nil {
+	if _, err := c.app.engine.GenerateDirDiff(oldDir, newDir, outputFile, c.recursive, !c.ignoreHidden, c.ignore, c.compress, progress); err != nil {
 		return WrapError(ErrPatchGeneration, "生成目录补丁失败", err)
 	}
 
-	c.showDirDiffResult(result)
+	c.showDirDiffResult(&DirDiffResult{OldDir: oldDir, NewDir: newDir, PatchFile: outputFile})
 
 	c.app.logger.Success("目录补丁生成完成: %s", outputFile)
 	return nil
@@ -882,6 +1323,868 @@ func (c *DirDiffCommand) validateDirectory(path string) error {
 	return nil
 }
 
-func (c *DirDiffCommand) showDirDiffResult(result any) {
+// showDirDiffResult 从生成的补丁读取并显示目录差异统计
+func (c *DirDiffCommand) showDirDiffResult(result *DirDiffResult) {
+	c.app.SetResult(result)
+	info, err := c.app.engine.GetDirPatchInfo(result.PatchFile)
+	if err != nil {
+		c.app.logger.Warning("无法读取目录补丁信息: %v", err)
+		return
+	}
+	result.DirPatch = info
+
 	c.app.logger.Info("目录差异统计:")
+	c.app.logger.Info("  总文件数: %d", info.FileCount)
+	c.app.logger.Info("  新增: %d, 删除: %d, 修改: %d, 重命名: %d, 复制: %d, 未改变: %d",
+		info.AddedFiles, info.DeletedFiles, info.ModifiedFiles, info.RenamedFiles, info.CopiedFiles, info.UnchangedFiles)
+	if info.SourceFiles > 0 {
+		c.app.logger.Info("  跨文件源: %d 个旧文件", info.SourceFiles)
+	}
+	c.app.logger.Info("  补丁大小: %s", formatFileSize(info.PatchSize))
+
+	if c.verbose {
+		for _, list := range [][]string{info.AddedFileList, info.ModifiedFileList, info.RenamedFileList, info.CopiedFileList, info.DeletedFileList} {
+			for _, f := range list {
+				c.app.logger.Info("    %s", f)
+			}
+		}
+	}
+}
+
+// SyncCommand 远程同步命令
+type SyncCommand struct {
+	app        *App
+	outputFile string
+	listen     string
+	connect    string
+	execCmd    string
+	blockSize  int
+	cdc        bool
+	compress   bool
+	verbose    bool
+}
+
+// NewSyncCommand 创建远程同步命令
+func NewSyncCommand(app *App) *SyncCommand {
+	return &SyncCommand{
+		app:      app,
+		compress: true,
+	}
+}
+
+func (c *SyncCommand) Name() string {
+	return "sync"
+}
+
+func (c *SyncCommand) Description() string {
+	return "与远端同步文件，只传输签名和差异数据"
+}
+
+func (c *SyncCommand) Usage() string {
+	return "hexdiff sync [options] send <new-file>\n       hexdiff sync [options] receive <old-file>"
+}
+
+func (c *SyncCommand) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.outputFile, "o", "", "接收方输出文件路径")
+	fs.StringVar(&c.outputFile, "output", "", "接收方输出文件路径")
+	fs.StringVar(&c.listen, "listen", "", "监听地址，等待对端连接 (host:port 或 unix:<path>)")
+	fs.StringVar(&c.connect, "connect", "", "连接到对端地址 (host:port 或 unix:<path>)")
+	fs.StringVar(&c.execCmd, "exec", "", "执行命令并通过其标准输入输出通信 (如 \"ssh host hexdiff sync send file\")")
+	fs.IntVar(&c.blockSize, "b", 0, "接收方签名块大小")
+	fs.IntVar(&c.blockSize, "block-size", 0, "接收方签名块大小")
+	fs.BoolVar(&c.cdc, "cdc", false, "接收方签名使用基于内容的分块 (FastCDC)，插入数据只影响附近的分块")
+	fs.BoolVar(&c.compress, "c", true, "压缩传输的补丁")
+	fs.BoolVar(&c.compress, "compress", true, "压缩传输的补丁")
+	fs.BoolVar(&c.verbose, "v", false, "详细输出")
+	fs.BoolVar(&c.verbose, "verbose", false, "详细输出")
+}
+
+func (c *SyncCommand) Execute(args []string) error {
+	if len(args) < 2 {
+		return ErrInvalidArgumentf("需要两个参数: send <new-file> 或 receive <old-file>")
+	}
+
+	mode := args[0]
+	file := args[1]
+	if mode != "send" && mode != "receive" {
+		return ErrInvalidArgumentf("未知的同步模式: %s (应为 send 或 receive)", mode)
+	}
+
+	if err := c.validateInputFile(file); err != nil {
+		return WrapError(ErrFileRead, "输入文件错误", err)
+	}
+
+	// 先建立连接：使用标准输入输出时日志需要改为输出到stderr
+	conn, closeConn, err := c.openConnection()
+	if err != nil {
+		return WrapError(ErrIOError, "建立同步连接失败", err)
+	}
+	defer closeConn()
+
+	if err := c.app.setCompression("", -1); err != nil {
+		return err
+	}
+
+	c.app.engine.SetChunking(c.cdc)
+
+	progress := c.app.progress.NewTask("同步文件", 100)
+	defer progress.Finish()
+
+	var result *SyncResult
+	if mode == "send" {
+		c.app.logger.Info("开始发送: %s", file)
+		result, err = c.app.engine.SyncSend(conn, file, c.compress, progress)
+		if err != nil {
+			return WrapError(ErrPatchGeneration, "发送差异失败", err)
+		}
+	} else {
+		outputFile := c.outputFile
+		if outputFile == "" {
+			outputFile = file + ".new"
+		}
+		c.app.logger.Info("开始接收: %s", file)
+		c.app.logger.Info("输出文件: %s", outputFile)
+		result, err = c.app.engine.SyncReceive(conn, file, outputFile, c.blockSize, progress)
+		if err != nil {
+			return WrapError(ErrPatchApplication, "接收差异失败", err)
+		}
+	}
+
+	if err := closeConn(); err != nil {
+		c.app.logger.Warning("关闭同步连接失败: %v", err)
+	}
+
+	c.app.SetResult(result)
+	c.showSyncResult(result)
+	c.app.logger.Success("同步完成")
+	return nil
+}
+
+// openConnection 根据选项建立连接，返回连接及其关闭函数
+func (c *SyncCommand) openConnection() (io.ReadWriter, func() error, error) {
+	selected := 0
+	for _, opt := range []string{c.listen, c.connect, c.execCmd} {
+		if opt != "" {
+			selected++
+		}
+	}
+	if selected > 1 {
+		return nil, nil, ErrInvalidArgumentf("--listen、--connect 和 --exec 只能指定一个")
+	}
+
+	switch {
+	case c.listen != "":
+		network, address := parseSyncAddress(c.listen)
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer listener.Close()
+
+		c.app.logger.Info("等待连接: %s", c.listen)
+		conn, err := listener.Accept()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.app.logger.Info("已连接: %s", conn.RemoteAddr())
+		return conn, onceCloser(conn.Close), nil
+
+	case c.connect != "":
+		network, address := parseSyncAddress(c.connect)
+		conn, err := net.Dial(network, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, onceCloser(conn.Close), nil
+
+	case c.execCmd != "":
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", c.execCmd)
+		} else {
+			cmd = exec.Command("sh", "-c", c.execCmd)
+		}
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		conn := struct {
+			io.Reader
+			io.Writer
+		}{stdout, stdin}
+		return conn, onceCloser(func() error {
+			stdin.Close()
+			return cmd.Wait()
+		}), nil
+
+	default:
+		// 标准输出用于传输协议数据
+		c.app.logger.SetOutput(os.Stderr)
+		c.app.progress.SetOutput(os.Stderr)
+		c.app.stdout = os.Stderr
+		conn := struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}
+		return conn, func() error { return nil }, nil
+	}
+}
+
+func (c *SyncCommand) validateInputFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrFileNotFoundf("文件不存在: %s", path)
+		}
+		return WrapError(ErrFileRead, "无法访问文件", err)
+	}
+
+	if info.IsDir() {
+		return ErrInvalidArgumentf("路径是目录，需要文件: %s", path)
+	}
+
+	return nil
+}
+
+func (c *SyncCommand) showSyncResult(result *SyncResult) {
+	c.app.logger.Info("签名大小: %s", formatFileSize(result.SignatureBytes))
+	c.app.logger.Info("补丁大小: %s", formatFileSize(result.PatchBytes))
+
+	if c.verbose {
+		c.app.logger.Info("旧文件大小: %s", formatFileSize(result.SourceSize))
+		c.app.logger.Info("新文件大小: %s", formatFileSize(result.TargetSize))
+		if result.TargetSize > 0 {
+			transferred := result.SignatureBytes + result.PatchBytes
+			c.app.logger.Info("传输比例: %.2f%%", float64(transferred)/float64(result.TargetSize)*100)
+		}
+	}
+}
+
+// parseSyncAddress 解析同步地址，unix:前缀表示unix套接字
+func parseSyncAddress(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", strings.TrimPrefix(addr, "tcp:")
+}
+
+// onceCloser 保证关闭函数只执行一次
+func onceCloser(closeFn func() error) func() error {
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() { err = closeFn() })
+		return err
+	}
+}
+
+// ExportCommand 导出补丁命令
+type ExportCommand struct {
+	app        *App
+	outputFile string
+	format     string
+	decryptKey string
+}
+
+// NewExportCommand 创建导出补丁命令
+func NewExportCommand(app *App) *ExportCommand {
+	return &ExportCommand{
+		app:    app,
+		format: "vcdiff",
+	}
+}
+
+func (c *ExportCommand) Name() string {
+	return "export"
+}
+
+func (c *ExportCommand) Description() string {
+	return "将补丁导出为其他差异格式 (VCDIFF)"
+}
+
+func (c *ExportCommand) Usage() string {
+	return "hexdiff export [options] <patch-file> [old-file]"
+}
+
+func (c *ExportCommand) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.outputFile, "o", "", "输出文件路径")
+	fs.StringVar(&c.outputFile, "output", "", "输出文件路径")
+	fs.StringVar(&c.format, "format", "vcdiff", "导出格式 (vcdiff)")
+	setDecryptKeyFlag(fs, &c.decryptKey)
+}
+
+func (c *ExportCommand) Execute(args []string) error {
+	if len(args) < 1 {
+		return ErrInvalidArgumentf("需要补丁文件参数: <patch-file> [old-file]")
+	}
+	if c.format != "vcdiff" {
+		return ErrInvalidArgumentf("不支持的格式: %s", c.format)
+	}
+
+	patchFile := args[0]
+	if err := validateRegularFile(patchFile); err != nil {
+		return WrapError(ErrFileRead, "补丁文件错误", err)
+	}
+	if err := c.app.engine.LoadDecryptionKeys(splitIgnorePatterns(c.decryptKey)); err != nil {
+		return WrapError(ErrFileRead, "加载解密密钥失败", err)
+	}
+
+	// 提供旧文件时为每个窗口写入校验和
+	var oldFile string
+	if len(args) >= 2 {
+		oldFile = args[1]
+		if err := validateRegularFile(oldFile); err != nil {
+			return WrapError(ErrFileRead, "旧文件错误", err)
+		}
+	}
+
+	outputFile := c.outputFile
+	if outputFile == "" {
+		outputFile = strings.TrimSuffix(patchFile, ".patch") + "." + c.format
+	}
+
+	c.app.logger.Info("开始导出补丁...")
+	c.app.logger.Info("补丁文件: %s", patchFile)
+	c.app.logger.Info("输出文件: %s", outputFile)
+
+	progress := c.app.progress.NewTask("导出补丁", 100)
+	defer progress.Finish()
+
+	if err := c.app.engine.ExportPatch(patchFile, oldFile, outputFile, c.format, progress); err != nil {
+		return WrapError(ErrPatchIncompatible, "导出补丁失败", err)
+	}
+
+	c.app.SetResult(&FileResult{Input: patchFile, OutputFile: outputFile, OutputSize: fileSize(outputFile)})
+	c.app.logger.Success("导出完成: %s", outputFile)
+	return nil
+}
+
+// ImportCommand 导入补丁命令
+type ImportCommand struct {
+	app        *App
+	outputFile string
+	format     string
+	compress   bool
+}
+
+// NewImportCommand 创建导入补丁命令
+func NewImportCommand(app *App) *ImportCommand {
+	return &ImportCommand{
+		app:      app,
+		format:   "vcdiff",
+		compress: true,
+	}
+}
+
+func (c *ImportCommand) Name() string {
+	return "import"
+}
+
+func (c *ImportCommand) Description() string {
+	return "将其他差异格式 (VCDIFF) 导入为补丁"
+}
+
+func (c *ImportCommand) Usage() string {
+	return "hexdiff import [options] <input-file> <old-file>"
+}
+
+func (c *ImportCommand) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.outputFile, "o", "", "输出补丁文件路径")
+	fs.StringVar(&c.outputFile, "output", "", "输出补丁文件路径")
+	fs.StringVar(&c.format, "format", "vcdiff", "输入格式 (vcdiff)")
+	fs.BoolVar(&c.compress, "c", true, "压缩补丁文件")
+	fs.BoolVar(&c.compress, "compress", true, "压缩补丁文件")
+}
+
+func (c *ImportCommand) Execute(args []string) error {
+	// 旧文件用于计算源文件校验和以及目标文件校验和
+	if len(args) < 2 {
+		return ErrInvalidArgumentf("需要两个文件参数: <input-file> <old-file>")
+	}
+	if c.format != "vcdiff" {
+		return ErrInvalidArgumentf("不支持的格式: %s", c.format)
+	}
+
+	inputFile := args[0]
+	oldFile := args[1]
+	if err := validateRegularFile(inputFile); err != nil {
+		return WrapError(ErrFileRead, "输入文件错误", err)
+	}
+	if err := validateRegularFile(oldFile); err != nil {
+		return WrapError(ErrFileRead, "旧文件错误", err)
+	}
+
+	outputFile := c.outputFile
+	if outputFile == "" {
+		outputFile = strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".patch"
+	}
+
+	c.app.logger.Info("开始导入补丁...")
+	c.app.logger.Info("输入文件: %s", inputFile)
+	c.app.logger.Info("补丁文件: %s", outputFile)
+
+	if err := c.app.setCompression("", -1); err != nil {
+		return err
+	}
+
+	progress := c.app.progress.NewTask("导入补丁", 100)
+	defer progress.Finish()
+
+	if err := c.app.engine.ImportPatch(inputFile, oldFile, outputFile, c.format, c.compress, progress); err != nil {
+		return WrapError(ErrPatchIncompatible, "导入补丁失败", err)
+	}
+
+	c.app.SetResult(&FileResult{Input: inputFile, OutputFile: outputFile, OutputSize: fileSize(outputFile)})
+	c.app.logger.Success("导入完成: %s", outputFile)
+	return nil
+}
+
+// SquashCommand 补丁合并命令
+type SquashCommand struct {
+	app        *App
+	outputFile string
+	compress   bool
+	codec      string
+	level      int
+	decryptKey string
+}
+
+// NewSquashCommand 创建补丁合并命令
+func NewSquashCommand(app *App) *SquashCommand {
+	return &SquashCommand{
+		app:      app,
+		compress: true,
+		level:    -1,
+	}
+}
+
+func (c *SquashCommand) Name() string {
+	return "squash"
+}
+
+func (c *SquashCommand) Description() string {
+	return "将两个连续的补丁合并为一个补丁"
+}
+
+func (c *SquashCommand) Usage() string {
+	return "hexdiff squash [options] <first-patch> <second-patch>"
+}
+
+func (c *SquashCommand) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.outputFile, "o", "", "输出补丁文件路径")
+	fs.StringVar(&c.outputFile, "output", "", "输出补丁文件路径")
+	fs.BoolVar(&c.compress, "c", true, "压缩补丁文件")
+	fs.BoolVar(&c.compress, "compress", true, "压缩补丁文件")
+	setCompressionFlags(fs, &c.codec, &c.level)
+	setDecryptKeyFlag(fs, &c.decryptKey)
+}
+
+func (c *SquashCommand) Execute(args []string) error {
+	// 第一个补丁从旧版本生成中间版本，第二个补丁从中间版本生成新版本
+	if len(args) < 2 {
+		return ErrInvalidArgumentf("需要两个补丁文件参数: <first-patch> <second-patch>")
+	}
+
+	firstPatch := args[0]
+	secondPatch := args[1]
+	for _, path := range []string{firstPatch, secondPatch} {
+		if err := validateRegularFile(path); err != nil {
+			return WrapError(ErrFileRead, "补丁文件错误", err)
+		}
+	}
+	if err := c.app.engine.LoadDecryptionKeys(splitIgnorePatterns(c.decryptKey)); err != nil {
+		return WrapError(ErrFileRead, "加载解密密钥失败", err)
+	}
+
+	outputFile := c.outputFile
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("%s+%s.patch",
+			strings.TrimSuffix(filepath.Base(firstPatch), ".patch"), strings.TrimSuffix(filepath.Base(secondPatch), ".patch"))
+	}
+
+	c.app.logger.Info("开始合并补丁...")
+	c.app.logger.Info("第一个补丁: %s", firstPatch)
+	c.app.logger.Info("第二个补丁: %s", secondPatch)
+	c.app.logger.Info("输出文件: %s", outputFile)
+
+	if err := c.app.setCompression(c.codec, c.level); err != nil {
+		return err
+	}
+
+	progress := c.app.progress.NewTask("合并补丁", 100)
+	defer progress.Finish()
+
+	result, err := c.app.engine.SquashPatches(firstPatch, secondPatch, outputFile, c.compress, progress)
+	if err != nil {
+		return WrapError(ErrPatchIncompatible, "合并补丁失败", err)
+	}
+	c.app.SetResult(result)
+
+	if result.IsDirectory {
+		c.app.logger.Info("条目数: %d", result.Files)
+	} else {
+		c.app.logger.Info("源文件大小: %s", formatFileSize(result.SourceSize))
+		c.app.logger.Info("目标文件大小: %s", formatFileSize(result.TargetSize))
+		c.app.logger.Info("操作数: %d", result.Operations)
+	}
+	c.app.logger.Info("补丁大小: %s", formatFileSize(result.PatchSize))
+	c.app.logger.Success("合并完成: %s", outputFile)
+	return nil
+}
+
+// ReverseCommand 反向补丁生成命令
+type ReverseCommand struct {
+	app        *App
+	outputFile string
+	compress   bool
+	codec      string
+	level      int
+	decryptKey string
+}
+
+// NewReverseCommand 创建反向补丁生成命令
+func NewReverseCommand(app *App) *ReverseCommand {
+	return &ReverseCommand{
+		app:      app,
+		compress: true,
+		level:    -1,
+	}
+}
+
+func (c *ReverseCommand) Name() string {
+	return "reverse"
+}
+
+func (c *ReverseCommand) Description() string {
+	return "由补丁和旧文件生成从新版本回到旧版本的反向补丁"
+}
+
+func (c *ReverseCommand) Usage() string {
+	return "hexdiff reverse [options] <patch-file> [old-file|old-dir]"
+}
+
+func (c *ReverseCommand) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.outputFile, "o", "", "输出补丁文件路径")
+	fs.StringVar(&c.outputFile, "output", "", "输出补丁文件路径")
+	fs.BoolVar(&c.compress, "c", true, "压缩补丁文件")
+	fs.BoolVar(&c.compress, "compress", true, "压缩补丁文件")
+	setCompressionFlags(fs, &c.codec, &c.level)
+	setDecryptKeyFlag(fs, &c.decryptKey)
+}
+
+func (c *ReverseCommand) Execute(args []string) error {
+	// 嵌入了反向条目的目录补丁不需要旧目录
+	if len(args) < 1 {
+		return ErrInvalidArgumentf("需要补丁文件参数: <patch-file> [old-file|old-dir]")
+	}
+
+	patchFile := args[0]
+	if err := validateRegularFile(patchFile); err != nil {
+		return WrapError(ErrFileRead, "补丁文件错误", err)
+	}
+	var source string
+	if len(args) > 1 {
+		source = args[1]
+		if _, err := os.Stat(source); err != nil {
+			return WrapError(ErrFileRead, "旧文件错误", err)
+		}
+	}
+	if err := c.app.engine.LoadDecryptionKeys(splitIgnorePatterns(c.decryptKey)); err != nil {
+		return WrapError(ErrFileRead, "加载解密密钥失败", err)
+	}
+
+	outputFile := c.outputFile
+	if outputFile == "" {
+		outputFile = strings.TrimSuffix(filepath.Base(patchFile), ".patch") + ".reverse.patch"
+	}
+
+	c.app.logger.Info("开始生成反向补丁...")
+	c.app.logger.Info("补丁文件: %s", patchFile)
+	if source != "" {
+		c.app.logger.Info("旧版本: %s", source)
+	}
+	c.app.logger.Info("输出文件: %s", outputFile)
+
+	if err := c.app.setCompression(c.codec, c.level); err != nil {
+		return err
+	}
+
+	progress := c.app.progress.NewTask("生成反向补丁", 100)
+	defer progress.Finish()
+
+	result, err := c.app.engine.ReversePatch(patchFile, source, outputFile, c.compress, progress)
+	if err != nil {
+		return WrapError(ErrPatchGeneration, "生成反向补丁失败", err)
+	}
+	c.app.SetResult(result)
+
+	if result.IsDirectory {
+		c.app.logger.Info("条目数: %d", result.Files)
+	} else {
+		c.app.logger.Info("源文件大小: %s", formatFileSize(result.SourceSize))
+		c.app.logger.Info("目标文件大小: %s", formatFileSize(result.TargetSize))
+		c.app.logger.Info("操作数: %d", result.Operations)
+	}
+	c.app.logger.Info("补丁大小: %s", formatFileSize(result.PatchSize))
+	c.app.logger.Success("反向补丁生成完成: %s", outputFile)
+	return nil
+}
+
+// DictCommand Zstd字典命令
+type DictCommand struct {
+	app        *App
+	outputFile string
+	size       int
+	id         uint
+}
+
+// NewDictCommand 创建字典命令
+func NewDictCommand(app *App) *DictCommand {
+	return &DictCommand{app: app}
+}
+
+func (c *DictCommand) Name() string {
+	return "dict"
+}
+
+func (c *DictCommand) Description() string {
+	return "训练和查看用于压缩小补丁的Zstd字典"
+}
+
+func (c *DictCommand) Usage() string {
+	return "hexdiff dict [options] train <sample-file>...\n       hexdiff dict info <dict-file>"
+}
+
+func (c *DictCommand) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.outputFile, "o", "", "输出字典文件路径")
+	fs.StringVar(&c.outputFile, "output", "", "输出字典文件路径")
+	fs.IntVar(&c.size, "size", compression.DefaultZstdDictSize, "字典最大大小（字节）")
+	fs.UintVar(&c.id, "id", 0, "字典ID（0表示随机生成）")
+}
+
+func (c *DictCommand) Execute(args []string) error {
+	if len(args) < 2 {
+		return ErrInvalidArgumentf("需要两个参数: train <sample-file>... 或 info <dict-file>")
+	}
+
+	switch args[0] {
+	case "train":
+		return c.train(args[1:])
+	case "info":
+		return c.info(args[1])
+	default:
+		return ErrInvalidArgumentf("未知的字典操作: %s (应为 train 或 info)", args[0])
+	}
+}
+
+func (c *DictCommand) train(samples []string) error {
+	if c.outputFile == "" {
+		return ErrInvalidArgumentf("需要指定输出字典文件: -o <dict-file>")
+	}
+	if c.size <= 0 {
+		return ErrInvalidArgumentf("无效的字典大小: %d", c.size)
+	}
+	if c.id > math.MaxUint32 {
+		return ErrInvalidArgumentf("无效的字典ID: %d", c.id)
+	}
+	for _, sample := range samples {
+		if err := validateRegularFile(sample); err != nil {
+			return WrapError(ErrFileRead, "样本文件错误", err)
+		}
+	}
+
+	c.app.logger.Info("开始训练字典...")
+	c.app.logger.Info("样本文件: %d 个", len(samples))
+	c.app.logger.Info("字典文件: %s", c.outputFile)
+
+	progress := c.app.progress.NewTask("训练字典", int64(len(samples)))
+	defer progress.Finish()
+
+	info, err := c.app.engine.TrainDictionary(samples, c.outputFile, c.size, uint32(c.id), progress)
+	if err != nil {
+		return WrapError(ErrPatchGeneration, "训练字典失败", err)
+	}
+
+	c.app.logger.Info("样本数量: %d (%s)", info.Samples, formatFileSize(info.SampleBytes))
+	c.showDictInfo(info)
+	c.app.logger.Success("字典训练完成: %s", c.outputFile)
+	return nil
+}
+
+func (c *DictCommand) info(dictFile string) error {
+	if err := validateRegularFile(dictFile); err != nil {
+		return WrapError(ErrFileRead, "字典文件错误", err)
+	}
+	info, err := c.app.engine.GetDictionaryInfo(dictFile)
+	if err != nil {
+		return WrapError(ErrFileRead, "读取字典失败", err)
+	}
+	c.showDictInfo(info)
+	return nil
+}
+
+func (c *DictCommand) showDictInfo(info *DictInfo) {
+	c.app.SetResult(info)
+	c.app.logger.Info("字典ID: %08x", info.ID)
+	c.app.logger.Info("字典大小: %s", formatFileSize(info.Size))
+}
+
+// validateRegularFile 检查路径存在且不是目录
+func validateRegularFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrFileNotFoundf("文件不存在: %s", path)
+		}
+		return WrapError(ErrFileRead, "无法访问文件", err)
+	}
+
+	if info.IsDir() {
+		return ErrInvalidArgumentf("路径是目录，需要文件: %s", path)
+	}
+
+	return nil
+}
+
+// SignCommand 补丁签名命令
+type SignCommand struct {
+	app     *App
+	keyFile string
+	signer  string
+	genkey  string
+}
+
+// NewSignCommand 创建补丁签名命令
+func NewSignCommand(app *App) *SignCommand {
+	return &SignCommand{app: app}
+}
+
+func (c *SignCommand) Name() string {
+	return "sign"
+}
+
+func (c *SignCommand) Description() string {
+	return "使用Ed25519私钥签名补丁文件"
+}
+
+func (c *SignCommand) Usage() string {
+	return "hexdiff sign [options] --key <private-key> <patch-file>...\n       hexdiff sign --genkey <name>"
+}
+
+func (c *SignCommand) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.keyFile, "k", "", "PEM格式的Ed25519私钥")
+	fs.StringVar(&c.keyFile, "key", "", "PEM格式的Ed25519私钥")
+	fs.StringVar(&c.signer, "signer", "", "记录在签名中的签名者名称")
+	fs.StringVar(&c.genkey, "genkey", "", "生成密钥对 <name>.key 和 <name>.pub")
+}
+
+func (c *SignCommand) Execute(args []string) error {
+	if c.genkey != "" {
+		return c.generateKey(c.genkey)
+	}
+
+	if len(args) < 1 {
+		return ErrInvalidArgumentf("缺少补丁文件参数")
+	}
+	if c.keyFile == "" {
+		return ErrInvalidArgumentf("需要指定私钥: --key <private-key>")
+	}
+
+	var signed []SignedPatch
+	c.app.SetResult(&signed)
+	for _, patchFile := range args {
+		if err := validateRegularFile(patchFile); err != nil {
+			return WrapError(ErrFileRead, "补丁文件错误", err)
+		}
+		info, err := c.app.engine.SignPatch(patchFile, c.keyFile, c.signer)
+		if err != nil {
+			return WrapError(ErrPatchGeneration, "签名补丁失败", err)
+		}
+		signed = append(signed, SignedPatch{PatchFile: patchFile, Signature: info})
+		c.app.logger.Success("已签名: %s (公钥 %s)", patchFile, info.KeyID)
+	}
+	return nil
+}
+
+func (c *SignCommand) generateKey(name string) error {
+	keyFile := name + ".key"
+	pubFile := name + ".pub"
+	for _, path := range []string{keyFile, pubFile} {
+		if _, err := os.Stat(path); err == nil {
+			return ErrInvalidArgumentf("文件已存在: %s", path)
+		}
+	}
+
+	keyID, err := c.app.engine.GenerateSigningKey(keyFile, pubFile)
+	if err != nil {
+		return WrapError(ErrFileWrite, "生成密钥失败", err)
+	}
+
+	c.app.SetResult(&KeyPairResult{PrivateKey: keyFile, PublicKey: pubFile, KeyID: keyID})
+	c.app.logger.Info("私钥: %s", keyFile)
+	c.app.logger.Info("公钥: %s", pubFile)
+	c.app.logger.Success("密钥对已生成 (公钥 %s)", keyID)
+	return nil
+}
+
+// VerifyCommand 补丁签名验证命令
+type VerifyCommand struct {
+	app     *App
+	pubkeys string
+}
+
+// NewVerifyCommand 创建补丁签名验证命令
+func NewVerifyCommand(app *App) *VerifyCommand {
+	return &VerifyCommand{app: app}
+}
+
+func (c *VerifyCommand) Name() string {
+	return "verify"
+}
+
+func (c *VerifyCommand) Description() string {
+	return "使用受信任的公钥验证补丁签名"
+}
+
+func (c *VerifyCommand) Usage() string {
+	return "hexdiff verify --pubkey <public-key>[,<public-key>...] <patch-file>..."
+}
+
+func (c *VerifyCommand) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.pubkeys, "pubkey", "", "受信任的PEM格式公钥（逗号分隔的路径）")
+}
+
+func (c *VerifyCommand) Execute(args []string) error {
+	if len(args) < 1 {
+		return ErrInvalidArgumentf("缺少补丁文件参数")
+	}
+	pubkeys := splitIgnorePatterns(c.pubkeys)
+	if len(pubkeys) == 0 {
+		return ErrInvalidArgumentf("需要指定公钥: --pubkey <public-key>")
+	}
+
+	var verified []SignedPatch
+	c.app.SetResult(&verified)
+	for _, patchFile := range args {
+		if err := validateRegularFile(patchFile); err != nil {
+			return WrapError(ErrFileRead, "补丁文件错误", err)
+		}
+		info, err := c.app.engine.VerifyPatch(patchFile, pubkeys)
+		if err != nil {
+			return WrapError(ErrPatchValidation, "签名验证失败: "+patchFile, err)
+		}
+		verified = append(verified, SignedPatch{PatchFile: patchFile, Signature: info})
+		c.app.logger.Info("签名者: %s", info.Signer)
+		c.app.logger.Info("签名时间: %s", info.SignedAt.Format("2006-01-02 15:04:05"))
+		c.app.logger.Success("签名有效: %s (公钥 %s)", patchFile, info.KeyID)
+	}
+	return nil
 }
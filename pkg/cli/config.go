@@ -1,12 +1,38 @@
 package cli
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// 配置来源，按优先级从低到高排列
+const (
+	OriginDefault = "default" // 内置默认值
+	OriginSystem  = "system"  // 系统配置文件
+	OriginUser    = "user"    // 用户配置文件
+	OriginProject = "project" // 项目配置文件
+	OriginFile    = "file"    // --config 指定的配置文件
+	OriginEnv     = "env"     // 环境变量
+	OriginFlag    = "flag"    // 命令行参数
+)
+
+// ProjectConfigName 项目配置文件名，从当前目录向上查找
+const ProjectConfigName = ".hexdiff.json"
+
+// EnvPrefix 环境变量前缀，配置项 block_size 对应 HEXDIFF_BLOCK_SIZE
+const EnvPrefix = "HEXDIFF_"
+
+// SystemConfigEnv 指定系统配置文件路径的环境变量，不是配置项
+const SystemConfigEnv = "HEXDIFF_SYSTEM_CONFIG"
+
 // Config 应用程序配置
 type Config struct {
 	// 日志配置
@@ -35,6 +61,9 @@ type Config struct {
 	OutputFormat string `json:"output_format"` // 输出格式 (text, json)
 	Quiet        bool   `json:"quiet"`         // 静默模式
 	Verbose      bool   `json:"verbose"`       // 详细模式
+
+	origins  map[string]string // 配置项来源
+	warnings []string          // 加载配置时产生的警告
 }
 
 // NewConfig 创建默认配置
@@ -129,15 +158,15 @@ func (c *Config) Validate() error {
 
 	// 验证压缩算法
 	validCompressions := map[string]bool{
-		"none": true, "gzip": true, "lz4": true,
+		"none": true, "gzip": true, "lz4": true, "zstd": true, "auto": true,
 	}
 	if !validCompressions[c.DefaultCompression] {
 		return fmt.Errorf("无效的压缩算法: %s", c.DefaultCompression)
 	}
 
 	// 验证压缩级别
-	if c.CompressionLevel < 0 || c.CompressionLevel > 9 {
-		return fmt.Errorf("压缩级别必须在0-9之间: %d", c.CompressionLevel)
+	if c.CompressionLevel < 0 || c.CompressionLevel > 11 {
+		return fmt.Errorf("压缩级别必须在0-11之间: %d", c.CompressionLevel)
 	}
 
 	// 验证输出格式
@@ -151,6 +180,322 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// ConfigKeys 返回所有配置项的键名
+func ConfigKeys() []string {
+	t := reflect.TypeFor[Config]()
+	keys := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		if key := configKey(t.Field(i)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// configKey 返回字段的配置键名，未导出的字段返回空
+func configKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
+
+// field 按键名查找配置字段
+func (c *Config) field(key string) (reflect.Value, error) {
+	v := reflect.ValueOf(c).Elem()
+	for i := range v.NumField() {
+		if configKey(v.Type().Field(i)) == key {
+			return v.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("未知的配置项: %s", key)
+}
+
+// Value 获取配置项的值，类型与配置字段相同
+func (c *Config) Value(key string) (any, error) {
+	field, err := c.field(key)
+	if err != nil {
+		return nil, err
+	}
+	return field.Interface(), nil
+}
+
+// Get 获取配置项的值
+func (c *Config) Get(key string) (string, error) {
+	value, err := c.Value(key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Set 按配置项的类型解析并设置值，不做范围检查
+func (c *Config) Set(key, value string) error {
+	field, err := c.field(key)
+	if err != nil {
+		return err
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s 需要布尔值: %s", key, value)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s 需要整数: %s", key, value)
+		}
+		field.SetInt(int64(n))
+	}
+	return nil
+}
+
+// Origin 返回配置项的来源，如 "user:/home/me/.hexdiff/config.json"
+func (c *Config) Origin(key string) string {
+	if origin, ok := c.origins[key]; ok {
+		return origin
+	}
+	return OriginDefault
+}
+
+// SetOrigin 记录配置项的来源
+func (c *Config) SetOrigin(key, origin string) {
+	if c.origins == nil {
+		c.origins = make(map[string]string)
+	}
+	c.origins[key] = origin
+}
+
+// Warnings 返回加载配置时产生的警告，如被忽略的未知配置项
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+// mergeFile 合并配置文件中出现的配置项，文件不存在时跳过，未知配置项记为警告
+func (c *Config) mergeFile(filename, origin string) error {
+	values, err := ReadConfigFile(filename)
+	if err != nil || values == nil {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		raw := values[key]
+		field, err := c.field(key)
+		if err != nil {
+			c.warnings = append(c.warnings, fmt.Sprintf("%s: 忽略未知的配置项 %s", filename, key))
+			continue
+		}
+		if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: 配置项 %s 类型错误: %w", filename, key, err)
+		}
+		c.SetOrigin(key, origin+":"+filename)
+	}
+	return nil
+}
+
+// mergeEnv 合并 HEXDIFF_ 前缀的环境变量
+func (c *Config) mergeEnv() error {
+	for _, key := range ConfigKeys() {
+		name := EnvPrefix + strings.ToUpper(key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("环境变量 %s: %w", name, err)
+		}
+		c.SetOrigin(key, OriginEnv+":"+name)
+	}
+	return nil
+}
+
+// ConfigLayer 配置文件层
+type ConfigLayer struct {
+	Origin string
+	Path   string
+}
+
+// ConfigLayers 返回按优先级从低到高排列的配置文件层，configFile 为 --config 指定的文件
+func ConfigLayers(configFile string) []ConfigLayer {
+	layers := []ConfigLayer{
+		{OriginSystem, GetSystemConfigPath()},
+		{OriginUser, GetConfigPath()},
+	}
+	if path := FindProjectConfig(); path != "" {
+		layers = append(layers, ConfigLayer{OriginProject, path})
+	}
+	if configFile != "" {
+		layers = append(layers, ConfigLayer{OriginFile, configFile})
+	}
+	return layers
+}
+
+// LoadConfig 依次合并默认值、系统配置、用户配置、项目配置、--config 指定的文件和环境变量
+func LoadConfig(configFile string) (*Config, error) {
+	config := NewConfig()
+	for _, layer := range ConfigLayers(configFile) {
+		if layer.Origin == OriginFile {
+			if _, err := os.Stat(layer.Path); err != nil {
+				return nil, fmt.Errorf("读取配置文件失败: %w", err)
+			}
+		}
+		if err := config.mergeFile(layer.Path, layer.Origin); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.mergeEnv(); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ReadConfigFile 读取配置文件中的配置项，文件不存在时返回 nil
+func ReadConfigFile(filename string) (map[string]json.RawMessage, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	values := make(map[string]json.RawMessage)
+	if len(bytes.TrimSpace(data)) == 0 {
+		return values, nil
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %s: %w", filename, err)
+	}
+	return values, nil
+}
+
+// WriteConfigFile 写入配置项，只保存出现的键，其余配置项由其他层决定
+func WriteConfigFile(filename string, values map[string]json.RawMessage) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("创建配置目录失败: %w", err)
+	}
+	if values == nil {
+		values = make(map[string]json.RawMessage)
+	}
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化配置失败: %w", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	return nil
+}
+
+// SetConfigValue 在配置文件中设置一个配置项，值按类型检查并与默认值合并后验证
+func SetConfigValue(filename, key, value string) error {
+	values, err := ReadConfigFile(filename)
+	if err != nil {
+		return err
+	}
+	if values == nil {
+		values = make(map[string]json.RawMessage)
+	}
+
+	config := NewConfig()
+	if err := config.Set(key, value); err != nil {
+		return err
+	}
+	field, _ := config.field(key)
+	raw, err := json.Marshal(field.Interface())
+	if err != nil {
+		return fmt.Errorf("序列化配置失败: %w", err)
+	}
+	values[key] = raw
+	if err := validateConfigValues(values); err != nil {
+		return err
+	}
+	return WriteConfigFile(filename, values)
+}
+
+// UnsetConfigValue 从配置文件中删除一个配置项，返回配置项是否存在
+func UnsetConfigValue(filename, key string) (bool, error) {
+	if _, err := NewConfig().field(key); err != nil {
+		return false, err
+	}
+	values, err := ReadConfigFile(filename)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := values[key]; !ok {
+		return false, nil
+	}
+	delete(values, key)
+	return true, WriteConfigFile(filename, values)
+}
+
+// ValidateConfigFile 检查配置文件能否解析，与默认值合并后是否有效
+func ValidateConfigFile(filename string) error {
+	values, err := ReadConfigFile(filename)
+	if err != nil {
+		return err
+	}
+	return validateConfigValues(values)
+}
+
+// validateConfigValues 将配置项合并到默认配置后验证
+func validateConfigValues(values map[string]json.RawMessage) error {
+	config := NewConfig()
+	for key, raw := range values {
+		field, err := config.field(key)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, field.Addr().Interface()); err != nil {
+			return fmt.Errorf("配置项 %s 类型错误: %w", key, err)
+		}
+	}
+	return config.Validate()
+}
+
+// GetSystemConfigPath 获取系统配置文件路径，可由环境变量 HEXDIFF_SYSTEM_CONFIG 指定
+func GetSystemConfigPath() string {
+	if path := os.Getenv(SystemConfigEnv); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			return filepath.Join(dir, "hexdiff", "config.json")
+		}
+		return filepath.Join(`C:\ProgramData`, "hexdiff", "config.json")
+	}
+	return "/etc/hexdiff/config.json"
+}
+
+// FindProjectConfig 从当前目录向上查找项目配置文件，找不到时返回空
+func FindProjectConfig() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, ProjectConfigName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
 // GetConfigPath 获取默认配置文件路径
 func GetConfigPath() string {
 	homeDir, err := os.UserHomeDir()
@@ -160,19 +505,14 @@ func GetConfigPath() string {
 	return filepath.Join(homeDir, ".hexdiff", "config.json")
 }
 
-// LoadDefaultConfig 加载默认配置
+// LoadDefaultConfig 加载各层配置，失败时使用默认配置
 func LoadDefaultConfig() *Config {
-	config := NewConfig()
-
-	// 尝试从默认位置加载配置
-	configPath := GetConfigPath()
-	if _, err := os.Stat(configPath); err == nil {
-		if err := config.LoadFromFile(configPath); err != nil {
-			// 配置文件存在但加载失败，使用默认配置
-			fmt.Fprintf(os.Stderr, "警告: 加载配置文件失败，使用默认配置: %v\n", err)
-		}
+	config, err := LoadConfig("")
+	if err != nil {
+		// 配置文件存在但加载失败，使用默认配置
+		fmt.Fprintf(os.Stderr, "警告: 加载配置失败，使用默认配置: %v\n", err)
+		return NewConfig()
 	}
-
 	return config
 }
 
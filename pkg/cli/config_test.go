@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	systemFile := filepath.Join(dir, "etc", "config.json")
+	writeConfig(t, systemFile, `{"block_size": 1024, "log_level": "debug", "worker_count": 2, "cache_size": 10}`)
+	t.Setenv(SystemConfigEnv, systemFile)
+
+	home := filepath.Join(dir, "home")
+	t.Setenv("HOME", home)
+	userFile := filepath.Join(home, ".hexdiff", "config.json")
+	writeConfig(t, userFile, `{"block_size": 2048, "log_level": "warn", "worker_count": 3}`)
+
+	projectFile := filepath.Join(dir, "project", ProjectConfigName)
+	writeConfig(t, projectFile, `{"block_size": 4096, "worker_count": 5}`)
+	workDir := filepath.Join(dir, "project", "src")
+	os.MkdirAll(workDir, 0755)
+	t.Chdir(workDir)
+
+	t.Setenv("HEXDIFF_BLOCK_SIZE", "8192")
+
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	tests := []struct {
+		key    string
+		value  string
+		origin string
+	}{
+		{"block_size", "8192", OriginEnv + ":HEXDIFF_BLOCK_SIZE"},
+		{"worker_count", "5", OriginProject + ":" + projectFile},
+		{"log_level", "warn", OriginUser + ":" + userFile},
+		{"cache_size", "10", OriginSystem + ":" + systemFile},
+		{"max_memory", strconv.Itoa(NewConfig().MaxMemory), OriginDefault},
+	}
+	for _, tt := range tests {
+		value, err := config.Get(tt.key)
+		if err != nil || value != tt.value {
+			t.Errorf("Value(%s) = %v, %v, want %v", tt.key, value, err, tt.value)
+		}
+		if got := config.Origin(tt.key); got != tt.origin {
+			t.Errorf("Origin(%s) = %s, want %s", tt.key, got, tt.origin)
+		}
+	}
+
+	// --config 指定的文件优先于项目配置，但低于环境变量
+	extraFile := filepath.Join(dir, "extra.json")
+	writeConfig(t, extraFile, `{"block_size": 16384, "worker_count": 7}`)
+	config, err = LoadConfig(extraFile)
+	if err != nil {
+		t.Fatalf("LoadConfig(%s) error = %v", extraFile, err)
+	}
+	if config.WorkerCount != 7 || config.Origin("worker_count") != OriginFile+":"+extraFile {
+		t.Errorf("worker_count = %d from %s, want 7 from --config", config.WorkerCount, config.Origin("worker_count"))
+	}
+	if config.BlockSize != 8192 {
+		t.Errorf("block_size = %d, want 8192 from environment", config.BlockSize)
+	}
+	if _, err := LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadConfig() should fail when --config does not exist")
+	}
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(SystemConfigEnv, filepath.Join(dir, "missing.json"))
+	t.Setenv("HOME", dir)
+	t.Chdir(dir)
+
+	// 未知配置项不影响其他配置项加载，只产生警告
+	configFile := filepath.Join(dir, "extra.json")
+	writeConfig(t, configFile, `{"block_size": 2048, "blok_size": 1024}`)
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.BlockSize != 2048 {
+		t.Errorf("block_size = %d, want 2048", config.BlockSize)
+	}
+	warnings := config.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "blok_size") {
+		t.Errorf("Warnings() = %q, want one warning for blok_size", warnings)
+	}
+}
+
+func TestConfigSetTypeErrors(t *testing.T) {
+	config := NewConfig()
+	for _, tt := range []struct{ key, value string }{
+		{"quiet", "maybe"},
+		{"enable_backup", "2"},
+		{"block_size", "4k"},
+		{"compression_level", "1.5"},
+		{"no_such_key", "1"},
+	} {
+		if err := config.Set(tt.key, tt.value); err == nil {
+			t.Errorf("Set(%s, %q) succeeded", tt.key, tt.value)
+		}
+	}
+	if config.Quiet || config.BlockSize != NewConfig().BlockSize {
+		t.Error("failed Set() modified the config")
+	}
+
+	if err := config.Set("quiet", "true"); err != nil || !config.Quiet {
+		t.Errorf("Set(quiet, true) = %v, Quiet = %v", err, config.Quiet)
+	}
+	if err := config.Set("block_size", "4096"); err != nil || config.BlockSize != 4096 {
+		t.Errorf("Set(block_size, 4096) = %v, BlockSize = %d", err, config.BlockSize)
+	}
+}
+
+func TestSetAndUnsetConfigValue(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+
+	// 类型正确但验证失败的值不写入文件
+	for _, tt := range []struct{ key, value string }{
+		{"block_size", "0"},
+		{"compression_level", "12"},
+		{"log_level", "trace"},
+	} {
+		if err := SetConfigValue(filename, tt.key, tt.value); err == nil {
+			t.Errorf("SetConfigValue(%s, %s) succeeded", tt.key, tt.value)
+		}
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("config file written after rejected values: %v", err)
+	}
+
+	if err := SetConfigValue(filename, "block_size", "4096"); err != nil {
+		t.Fatalf("SetConfigValue() error = %v", err)
+	}
+	values, err := ReadConfigFile(filename)
+	if err != nil || string(values["block_size"]) != "4096" || len(values) != 1 {
+		t.Fatalf("ReadConfigFile() = %s, %v", values, err)
+	}
+
+	if found, err := UnsetConfigValue(filename, "log_level"); err != nil || found {
+		t.Errorf("UnsetConfigValue(log_level) = %v, %v, want false", found, err)
+	}
+	if found, err := UnsetConfigValue(filename, "block_size"); err != nil || !found {
+		t.Errorf("UnsetConfigValue(block_size) = %v, %v, want true", found, err)
+	}
+	if found, err := UnsetConfigValue(filename, "block_size"); err != nil || found {
+		t.Errorf("UnsetConfigValue(block_size) again = %v, %v, want false", found, err)
+	}
+	if _, err := UnsetConfigValue(filename, "no_such_key"); err == nil {
+		t.Error("UnsetConfigValue() should reject an unknown key")
+	}
+}
@@ -1,26 +1,44 @@
 package cli
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/Sky-ey/HexDiff/pkg/compression"
 	"github.com/Sky-ey/HexDiff/pkg/diff"
 	"github.com/Sky-ey/HexDiff/pkg/integrity"
+	"github.com/Sky-ey/HexDiff/pkg/metadata"
 	"github.com/Sky-ey/HexDiff/pkg/patch"
+	"github.com/Sky-ey/HexDiff/pkg/vcdiff"
 )
 
 // EngineAdapter CLI引擎适配器
 type EngineAdapter struct {
-	diffEngine         *diff.Engine
-	dirDiffEngine      *diff.DirEngine
-	patchGenerator     *patch.Generator
-	patchApplier       *patch.Applier
-	dirPatchSerializer *patch.DirPatchSerializer
-	validator          *patch.Validator
-	integrityChecker   *integrity.IntegrityChecker
+	diffEngine       *diff.Engine
+	dirDiffEngine    *diff.DirEngine
+	patchApplier     *patch.Applier
+	compression      patch.CompressionType
+	compressionLevel patch.CompressionLevel
+	dictionary       []byte
+	enhanced         bool   // 生成增强格式(HXDF)补丁
+	description      string // 增强补丁元数据中的描述
+	signingKey       ed25519.PrivateKey
+	signer           string
+	trustedKeys      []ed25519.PublicKey  // 非空时只应用由这些公钥签名的补丁
+	encryption       *patch.EncryptionKey // 非空时加密生成的单文件补丁
+	inPlace          bool                 // 生成可以直接在源文件上应用的原地补丁
+	contentDefined   bool                 // 签名和差异使用基于内容的分块
+	reversible       bool                 // 在目录补丁中嵌入反向条目
+	dirApplier       *patch.DirApplier
+	validator        *patch.Validator
+	integrityChecker *integrity.IntegrityChecker
 }
 
 // NewEngineAdapter 创建引擎适配器
@@ -37,15 +55,9 @@ func NewEngineAdapter() (*EngineAdapter, error) {
 		return nil, fmt.Errorf("创建目录差异检测引擎失败: %w", err)
 	}
 
-	// 创建补丁生成器
-	patchGenerator := patch.NewGenerator(diffEngine, patch.CompressionGzip)
-
 	// 创建补丁应用器
 	patchApplier := patch.NewApplier(nil)
 
-	// 创建目录补丁序列化器
-	dirPatchSerializer := patch.NewDirPatchSerializer(patch.CompressionNone)
-
 	// 创建验证器
 	validator := patch.NewValidator()
 
@@ -53,38 +65,372 @@ func NewEngineAdapter() (*EngineAdapter, error) {
 	integrityChecker := integrity.NewIntegrityChecker(integrity.DefaultCheckerConfig())
 
 	return &EngineAdapter{
-		diffEngine:         diffEngine,
-		dirDiffEngine:      dirDiffEngine,
-		patchGenerator:     patchGenerator,
-		patchApplier:       patchApplier,
-		dirPatchSerializer: dirPatchSerializer,
-		validator:          validator,
-		integrityChecker:   integrityChecker,
+		diffEngine:       diffEngine,
+		dirDiffEngine:    dirDiffEngine,
+		patchApplier:     patchApplier,
+		compression:      patch.CompressionGzip,
+		dirApplier:       patch.NewDirApplier(patchApplier),
+		validator:        validator,
+		integrityChecker: integrityChecker,
 	}, nil
 }
 
+// SetCompression 设置生成补丁时使用的压缩算法和级别，level为0时使用算法的默认级别
+func (ea *EngineAdapter) SetCompression(name string, level int) error {
+	compression, err := patch.ParseCompressionType(name)
+	if err != nil {
+		return err
+	}
+	if level < 0 || level > 11 {
+		return fmt.Errorf("无效的压缩级别: %d", level)
+	}
+	ea.compression = compression
+	ea.compressionLevel = patch.CompressionLevel(level)
+	return nil
+}
+
+// SetPatchFormat 设置单文件补丁的格式：standard 或 enhanced（HXDF，包含元数据）
+func (ea *EngineAdapter) SetPatchFormat(format, description string) error {
+	switch strings.ToLower(format) {
+	case "", "standard", "hexd":
+		ea.enhanced = false
+	case "enhanced", "hxdf":
+		ea.enhanced = true
+	default:
+		return fmt.Errorf("无效的补丁格式: %s", format)
+	}
+	ea.description = description
+	return nil
+}
+
+// SetResume 设置应用单文件补丁时是否写入检查点，中断后再次应用时从检查点继续
+func (ea *EngineAdapter) SetResume(enabled bool) {
+	ea.patchApplier.SetResumable(enabled)
+}
+
+// SetInPlace 设置是否生成可以直接在源文件上应用的原地补丁
+func (ea *EngineAdapter) SetInPlace(enabled bool) {
+	ea.inPlace = enabled
+}
+
+// SetChunking 设置生成签名和单文件补丁时是否使用基于内容的分块 (FastCDC)
+func (ea *EngineAdapter) SetChunking(contentDefined bool) {
+	ea.contentDefined = contentDefined
+}
+
+// diffConfig 按块大小和分块方式创建差异检测配置，blockSize为0时使用默认块大小
+func (ea *EngineAdapter) diffConfig(blockSize int) *diff.DiffConfig {
+	config := diff.DefaultDiffConfig()
+	if blockSize > 0 {
+		config.BlockSize = blockSize
+		config.WindowSize = min(config.WindowSize, blockSize)
+	}
+	if ea.contentDefined {
+		config.Chunking = diff.ChunkingContentDefined
+	}
+	return config
+}
+
+// SetReversible 设置生成目录补丁时是否嵌入反向条目，使补丁可以反向应用
+func (ea *EngineAdapter) SetReversible(enabled bool) {
+	ea.reversible = enabled
+}
+
+// SetAllowExternalSymlinks 设置应用目录补丁时是否允许创建指向目标目录之外的符号链接
+func (ea *EngineAdapter) SetAllowExternalSymlinks(allow bool) {
+	ea.dirApplier.SetAllowExternalSymlinks(allow)
+}
+
+// compressionFor 返回生成补丁使用的压缩类型，compress为false时不压缩
+func (ea *EngineAdapter) compressionFor(compress bool) patch.CompressionType {
+	if !compress {
+		return patch.CompressionNone
+	}
+	return ea.compression
+}
+
+// SetDictionary 加载Zstd字典，之后生成的Zstd补丁使用该字典压缩，path为空时不使用字典
+func (ea *EngineAdapter) SetDictionary(path string) (uint32, error) {
+	if path == "" {
+		ea.dictionary = nil
+		return 0, nil
+	}
+	dict, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("read dictionary: %w", err)
+	}
+	return ea.UseDictionary(dict)
+}
+
+// UseDictionary 注册Zstd字典并在之后生成Zstd补丁时使用，dict为空时不使用字典
+func (ea *EngineAdapter) UseDictionary(dict []byte) (uint32, error) {
+	if len(dict) == 0 {
+		ea.dictionary = nil
+		return 0, nil
+	}
+	id, err := compression.RegisterZstdDictionary(dict)
+	if err != nil {
+		return 0, err
+	}
+	ea.dictionary = dict
+	return id, nil
+}
+
+// LoadDictionary 注册Zstd字典，应用使用该字典压缩的补丁前需要加载
+func (ea *EngineAdapter) LoadDictionary(path string) (uint32, error) {
+	return compression.LoadZstdDictionary(path)
+}
+
+// TrainDictionary 从样本文件训练Zstd字典，样本为补丁文件时使用补丁中会被压缩的内容
+func (ea *EngineAdapter) TrainDictionary(sampleFiles []string, outputFile string, maxSize int, id uint32, progress ProgressReporter) (*DictInfo, error) {
+	progress.SetMessage("正在读取样本...")
+	progress.SetTotal(int64(len(sampleFiles)) + 1)
+
+	var samples [][]byte
+	var sampleBytes int64
+	for i, path := range sampleFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取样本失败: %w", err)
+		}
+		fileSamples, err := patch.DictionarySamples(data)
+		if err != nil {
+			return nil, fmt.Errorf("解析样本 %s 失败: %w", path, err)
+		}
+		for _, sample := range fileSamples {
+			samples = append(samples, sample)
+			sampleBytes += int64(len(sample))
+		}
+		progress.SetCurrent(int64(i + 1))
+	}
+
+	progress.SetMessage("正在训练字典...")
+	dict, err := compression.TrainZstdDictionary(samples, maxSize, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(outputFile, dict, 0644); err != nil {
+		return nil, fmt.Errorf("写入字典失败: %w", err)
+	}
+
+	info, err := ea.GetDictionaryInfo(outputFile)
+	if err != nil {
+		return nil, err
+	}
+	info.Samples = len(samples)
+	info.SampleBytes = sampleBytes
+
+	progress.SetCurrent(int64(len(sampleFiles)) + 1)
+	progress.SetMessage("字典训练完成")
+	return info, nil
+}
+
+// GetDictionaryInfo 获取Zstd字典信息
+func (ea *EngineAdapter) GetDictionaryInfo(path string) (*DictInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	id, err := compression.ZstdDictionaryID(data)
+	if err != nil {
+		return nil, err
+	}
+	return &DictInfo{ID: id, Size: int64(len(data))}, nil
+}
+
+// SetSigningKey 设置签名私钥，之后生成的补丁都会签名，key为nil时不签名
+func (ea *EngineAdapter) SetSigningKey(key ed25519.PrivateKey, signer string) {
+	ea.signingKey = key
+	ea.signer = signer
+}
+
+// SetTrustedKeys 设置受信任的公钥，非空时应用补丁前验证签名，拒绝未签名或签名无效的补丁
+func (ea *EngineAdapter) SetTrustedKeys(keys []ed25519.PublicKey) {
+	ea.trustedKeys = keys
+}
+
+// SetEncryption 设置加密生成的补丁使用的密钥，spec为空时不加密
+//
+// spec 可以是 pass:<口令>、env:<环境变量> 或密钥文件（X25519公钥/私钥或32字节原始密钥）。
+func (ea *EngineAdapter) SetEncryption(spec string) (*EncryptionInfo, error) {
+	if spec == "" {
+		ea.encryption = nil
+		return nil, nil
+	}
+	key, err := patch.ParseEncryptionKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	ea.SetEncryptionKey(key)
+	return &EncryptionInfo{Kind: key.Kind().String(), KeyID: key.KeyID()}, nil
+}
+
+// SetEncryptionKey 设置加密生成的补丁使用的密钥，为nil时不加密
+func (ea *EngineAdapter) SetEncryptionKey(key *patch.EncryptionKey) {
+	ea.encryption = key
+}
+
+// LoadDecryptionKeys 注册解密密钥，应用或读取加密补丁前需要加载
+func (ea *EngineAdapter) LoadDecryptionKeys(specs []string) error {
+	for _, spec := range specs {
+		key, err := patch.ParseEncryptionKey(spec)
+		if err != nil {
+			return err
+		}
+		patch.RegisterDecryptionKey(key)
+	}
+	return nil
+}
+
+// LoadTrustedKeys 从PEM文件加载受信任的公钥
+func (ea *EngineAdapter) LoadTrustedKeys(paths []string) error {
+	keys, err := loadPublicKeys(paths)
+	if err != nil {
+		return err
+	}
+	ea.trustedKeys = keys
+	return nil
+}
+
+// loadPublicKeys 读取PEM格式的Ed25519公钥
+func loadPublicKeys(paths []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read public key: %w", err)
+		}
+		key, err := patch.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// GenerateSigningKey 生成Ed25519密钥对，返回公钥标识
+func (ea *EngineAdapter) GenerateSigningKey(keyFile, pubFile string) (string, error) {
+	key, err := patch.GenerateSigningKey(keyFile, pubFile)
+	if err != nil {
+		return "", err
+	}
+	return patch.PublicKeyID(key), nil
+}
+
+// SignPatch 使用PEM格式的私钥签名补丁文件
+func (ea *EngineAdapter) SignPatch(patchFile, keyFile, signer string) (*SignatureInfo, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read private key: %w", err)
+	}
+	key, err := patch.ParseSigningKey(data)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := patch.SignPatch(patchFile, key, signer)
+	if err != nil {
+		return nil, err
+	}
+	return newSignatureInfo(signature, true), nil
+}
+
+// VerifyPatch 使用PEM格式的公钥验证补丁签名
+func (ea *EngineAdapter) VerifyPatch(patchFile string, pubKeyFiles []string) (*SignatureInfo, error) {
+	keys, err := loadPublicKeys(pubKeyFiles)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := patch.VerifyPatch(patchFile, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newSignatureInfo(signature, true), nil
+}
+
+// SignOutput 使用设置的私钥签名生成的补丁，未设置私钥时不做任何操作
+func (ea *EngineAdapter) SignOutput(outputFile string) error {
+	if ea.signingKey == nil {
+		return nil
+	}
+	if _, err := patch.SignPatch(outputFile, ea.signingKey, ea.signer); err != nil {
+		return fmt.Errorf("签名补丁失败: %w", err)
+	}
+	return nil
+}
+
+// verifyInput 设置了受信任公钥时验证补丁签名
+func (ea *EngineAdapter) verifyInput(patchFile string) error {
+	if len(ea.trustedKeys) == 0 {
+		return nil
+	}
+	if _, err := patch.VerifyPatch(patchFile, ea.trustedKeys); err != nil {
+		return fmt.Errorf("补丁签名验证失败: %w", err)
+	}
+	return nil
+}
+
+// readSignatureInfo 读取补丁的签名信息，未签名时返回nil
+func readSignatureInfo(patchFile string) (*SignatureInfo, error) {
+	signature, err := patch.ReadPatchSignature(patchFile)
+	if errors.Is(err, patch.ErrPatchUnsigned) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSignatureInfo(signature, false), nil
+}
+
+func newSignatureInfo(signature *patch.PatchSignature, verified bool) *SignatureInfo {
+	return &SignatureInfo{
+		Signer:   signature.Signer,
+		KeyID:    signature.KeyID(),
+		SignedAt: time.Unix(signature.SignedAt, 0),
+		Verified: verified,
+	}
+}
+
+// newGenerator 按当前压缩设置创建补丁生成器
+func (ea *EngineAdapter) newGenerator(engine *diff.Engine, compress bool) (*patch.Generator, error) {
+	generator := patch.NewGenerator(engine, ea.compressionFor(compress))
+	generator.SetCompressionLevel(ea.compressionLevel)
+	generator.SetCompressOperations(true)
+	generator.SetEncryption(ea.encryption)
+	generator.SetInPlace(ea.inPlace)
+	if err := generator.SetDictionary(ea.dictionary); err != nil {
+		return nil, err
+	}
+	return generator, nil
+}
+
 // GenerateSignature 生成文件签名
 func (ea *EngineAdapter) GenerateSignature(inputFile, outputFile string, blockSize int, progress ProgressReporter) error {
 	// 设置进度
 	progress.SetMessage("正在生成文件签名...")
 	progress.SetCurrent(10)
 
+	// 按指定块大小创建引擎
+	engine, err := diff.NewEngine(ea.diffConfig(blockSize))
+	if err != nil {
+		return err
+	}
+
 	// 生成签名
-	signature, err := ea.diffEngine.GenerateSignature(inputFile)
+	signature, err := engine.GenerateSignature(inputFile)
 	if err != nil {
 		return err
 	}
 
 	progress.SetCurrent(50)
 
-	// 保存签名到文件（这里需要实现签名序列化）
-	// 暂时只返回成功
+	// 保存签名到文件
 	progress.SetMessage("保存签名文件...")
 	progress.SetCurrent(90)
 
-	// 模拟保存过程
-	_ = signature
-	_ = outputFile
+	if err := diff.SaveSignature(signature, outputFile); err != nil {
+		return err
+	}
 
 	progress.SetCurrent(100)
 	progress.SetMessage("签名生成完成")
@@ -93,10 +439,25 @@ func (ea *EngineAdapter) GenerateSignature(inputFile, outputFile string, blockSi
 }
 
 // GeneratePatch 生成补丁
-func (ea *EngineAdapter) GeneratePatch(oldFile, newFile, outputFile, signature string, compress bool, progress ProgressReporter) error {
+func (ea *EngineAdapter) GeneratePatch(oldFile, newFile, outputFile, signature, algorithm string, compress bool, progress ProgressReporter) error {
 	progress.SetMessage("正在分析文件差异...")
 	progress.SetCurrent(10)
 
+	// 使用现有签名文件时无需访问旧文件
+	if signature != "" {
+		generator, err := ea.newGenerator(ea.diffEngine, compress)
+		if err != nil {
+			return err
+		}
+		err = ea.writePatch(outputFile, "", newFile, diff.AlgorithmRollingHash, func(patchFile string) error {
+			return ea.generatePatchFromSignature(generator, signature, newFile, patchFile, progress)
+		})
+		if err != nil {
+			return err
+		}
+		return ea.SignOutput(outputFile)
+	}
+
 	// 检查文件是否存在
 	if _, err := os.Stat(oldFile); os.IsNotExist(err) {
 		return fmt.Errorf("旧文件不存在: %s", oldFile)
@@ -105,14 +466,96 @@ func (ea *EngineAdapter) GeneratePatch(oldFile, newFile, outputFile, signature s
 		return fmt.Errorf("新文件不存在: %s", newFile)
 	}
 
+	// 基于内容的分块只用于滚动哈希算法的签名
+	if ea.contentDefined && algorithm != "" && algorithm != diff.AlgorithmRollingHash {
+		return fmt.Errorf("基于内容的分块只支持 %s 算法", diff.AlgorithmRollingHash)
+	}
+
+	// 指定其他差异算法或分块方式时使用对应配置的生成器
+	engine := ea.diffEngine
+	if algorithm != "" || ea.contentDefined {
+		config := ea.diffConfig(0)
+		config.Algorithm = algorithm
+		var err error
+		if engine, err = diff.NewEngine(config); err != nil {
+			return fmt.Errorf("差异算法 %s: %w", algorithm, err)
+		}
+	}
+	generator, err := ea.newGenerator(engine, compress)
+	if err != nil {
+		return err
+	}
+
 	progress.SetCurrent(30)
 	progress.SetMessage("生成补丁文件...")
 
 	// 生成补丁
-	_, err := ea.patchGenerator.GeneratePatch(oldFile, newFile, outputFile)
+	if algorithm == "" {
+		algorithm = diff.AlgorithmRollingHash
+	}
+	err = ea.writePatch(outputFile, oldFile, newFile, algorithm, func(patchFile string) error {
+		_, err := generator.GeneratePatch(oldFile, newFile, patchFile)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	if err := ea.SignOutput(outputFile); err != nil {
+		return err
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("补丁生成完成")
+
+	return nil
+}
+
+// writePatch 调用generate生成补丁，需要增强格式时先生成到临时文件，再附加元数据封装为HXDF
+func (ea *EngineAdapter) writePatch(outputFile, oldFile, newFile, algorithm string, generate func(patchFile string) error) error {
+	if !ea.enhanced {
+		return generate(outputFile)
+	}
+
+	tempFile := outputFile + ".hexd.tmp"
+	defer os.Remove(tempFile)
+
+	start := time.Now()
+	if err := generate(tempFile); err != nil {
+		return err
+	}
+
+	meta := metadata.NewMetadataManager("").CreateMetadata(outputFile)
+	meta.Description = ea.description
+	if oldFile != "" {
+		meta.SourceFile.Name = filepath.Base(oldFile)
+		meta.SourceFile.Path = oldFile
+	}
+	meta.TargetFile.Name = filepath.Base(newFile)
+	meta.TargetFile.Path = newFile
+	meta.PatchInfo.Algorithm = algorithm
+	meta.Performance.GenerationTime = time.Since(start).Milliseconds()
+
+	return patch.WrapEnhancedPatch(tempFile, outputFile, ea.compressionLevel, meta)
+}
+
+// generatePatchFromSignature 基于签名文件生成补丁
+func (ea *EngineAdapter) generatePatchFromSignature(generator *patch.Generator, signatureFile, newFile, outputFile string, progress ProgressReporter) error {
+	if _, err := os.Stat(newFile); os.IsNotExist(err) {
+		return fmt.Errorf("新文件不存在: %s", newFile)
+	}
+
+	progress.SetMessage("读取签名文件...")
+	signature, err := diff.LoadSignature(signatureFile)
+	if err != nil {
+		return err
+	}
+
+	progress.SetCurrent(30)
+	progress.SetMessage("生成补丁文件...")
+
+	if _, err := generator.GeneratePatchFromSignature(signature, newFile, outputFile); err != nil {
+		return err
+	}
 
 	progress.SetCurrent(100)
 	progress.SetMessage("补丁生成完成")
@@ -132,6 +575,9 @@ func (ea *EngineAdapter) ApplyPatch(patchFile, targetFile, outputFile string, ve
 	if _, err := os.Stat(targetFile); os.IsNotExist(err) {
 		return fmt.Errorf("目标文件不存在: %s", targetFile)
 	}
+	if err := ea.verifyInput(patchFile); err != nil {
+		return err
+	}
 
 	progress.SetCurrent(30)
 	progress.SetMessage("应用补丁...")
@@ -157,6 +603,34 @@ func (ea *EngineAdapter) ApplyPatch(patchFile, targetFile, outputFile string, ve
 	return nil
 }
 
+// ApplyPatchInPlace 直接在目标文件上应用原地补丁，中断后再次应用会从日志继续
+func (ea *EngineAdapter) ApplyPatchInPlace(patchFile, targetFile string, progress ProgressReporter) error {
+	progress.SetMessage("正在读取补丁文件...")
+	progress.SetCurrent(10)
+
+	if _, err := os.Stat(patchFile); os.IsNotExist(err) {
+		return fmt.Errorf("补丁文件不存在: %s", patchFile)
+	}
+	if _, err := os.Stat(targetFile); os.IsNotExist(err) {
+		return fmt.Errorf("目标文件不存在: %s", targetFile)
+	}
+	if err := ea.verifyInput(patchFile); err != nil {
+		return err
+	}
+
+	progress.SetCurrent(30)
+	progress.SetMessage("原地应用补丁...")
+
+	if _, err := ea.patchApplier.ApplyInPlace(targetFile, patchFile); err != nil {
+		return err
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("补丁应用完成")
+
+	return nil
+}
+
 // ValidatePatch 验证补丁
 func (ea *EngineAdapter) ValidatePatch(patchFile string, progress ProgressReporter) (*ValidationResult, error) {
 	progress.SetMessage("正在验证补丁文件...")
@@ -211,26 +685,86 @@ func (ea *EngineAdapter) GetPatchInfo(patchFile string) (*PatchInfo, error) {
 		CreatedAt:      time.Unix(header.Timestamp, 0),
 		Metadata:       make(map[string]string),
 	}
+	if header.Flags&patch.FlagChunkedData != 0 {
+		info.Compression = CompressionAuto
+	}
+	if header.Flags&patch.FlagZstdDict != 0 {
+		info.DictID = header.DictID
+	}
+	info.InPlace = header.Flags&patch.FlagInPlace != 0
+	if header.Flags&patch.FlagEncrypted != 0 {
+		info.Encryption = &EncryptionInfo{
+			Kind:  header.Encryption.Kind.String(),
+			KeyID: header.Encryption.KeyIDString(),
+		}
+	}
+
+	// 增强补丁附带元数据
+	enhanced, err := patch.IsEnhancedPatch(patchFile)
+	if err != nil {
+		return nil, err
+	}
+	if info.Signature, err = readSignatureInfo(patchFile); err != nil {
+		return nil, err
+	}
+	if enhanced {
+		enhancedPatch, err := patch.ReadEnhancedPatch(patchFile)
+		if err != nil {
+			return nil, err
+		}
+		info.Enhanced = true
+		info.Metadata = metadataFields(enhancedPatch.Metadata)
+	}
 
 	return info, nil
 }
 
-// GetDirPatchInfo 获取目录补丁信息
-func (ea *EngineAdapter) GetDirPatchInfo(patchFile string) (*DirPatchInfo, error) {
-	header, err := patch.GetDirPatchInfo(patchFile)
-	if err != nil {
-		return nil, err
+// metadataFields 将增强补丁的元数据展开为键值对
+func metadataFields(meta *metadata.PatchMetadata) map[string]string {
+	fields := map[string]string{
+		"version":    meta.Version,
+		"created_at": meta.CreatedAt.Format(time.RFC3339),
+		"created_by": meta.CreatedBy,
+	}
+	if meta.Description != "" {
+		fields["description"] = meta.Description
 	}
+	if meta.SourceFile.Name != "" {
+		fields["source_file"] = meta.SourceFile.Name
+	}
+	if meta.TargetFile.Name != "" {
+		fields["target_file"] = meta.TargetFile.Name
+	}
+	if meta.PatchInfo.Algorithm != "" {
+		fields["algorithm"] = meta.PatchInfo.Algorithm
+	}
+	if meta.PatchInfo.CompressionType != "" {
+		fields["compression"] = meta.PatchInfo.CompressionType
+	}
+	if meta.Performance.GenerationTime > 0 {
+		fields["generation_time_ms"] = fmt.Sprint(meta.Performance.GenerationTime)
+	}
+	for key, value := range meta.CustomAttributes {
+		fields[key] = fmt.Sprint(value)
+	}
+	return fields
+}
 
+// GetDirPatchInfo 获取目录补丁信息
+func (ea *EngineAdapter) GetDirPatchInfo(patchFile string) (*DirPatchInfo, error) {
 	stat, err := os.Stat(patchFile)
 	if err != nil {
 		return nil, err
 	}
 
-	dirPatch, err := ea.dirPatchSerializer.DeserializeDirPatch(patchFile)
+	// 只读取条目信息，不读取条目数据
+	reader, err := patch.OpenDirPatch(patchFile)
 	if err != nil {
 		return nil, err
 	}
+	defer reader.Close()
+	header := reader.Header()
+	dirPatch := reader.Patch()
 
 	addedCount := 0
 	deletedCount := 0
@@ -240,18 +774,24 @@ func (ea *EngineAdapter) GetDirPatchInfo(patchFile string) (*DirPatchInfo, error
 	var addedFiles []string
 	var deletedFiles []string
 	var modifiedFiles []string
+	var renamedFiles []string
+	var copiedFiles []string
 
 	for _, f := range dirPatch.Files {
 		switch f.Status {
+		case diff.StatusRenamed:
+			renamedFiles = append(renamedFiles, f.OldPath+" -> "+f.RelativePath)
+		case diff.StatusCopied:
+			copiedFiles = append(copiedFiles, f.OldPath+" -> "+f.RelativePath)
 		case diff.StatusAdded:
 			addedCount++
-			addedFiles = append(addedFiles, f.RelativePath)
+			addedFiles = append(addedFiles, displayPath(f))
 		case diff.StatusDeleted:
 			deletedCount++
-			deletedFiles = append(deletedFiles, f.RelativePath)
+			deletedFiles = append(deletedFiles, displayPath(f))
 		case diff.StatusModified:
 			modifiedCount++
-			modifiedFiles = append(modifiedFiles, f.RelativePath)
+			modifiedFiles = append(modifiedFiles, displayPath(f))
 		case diff.StatusUnchanged:
 			unchangedCount++
 		}
@@ -265,17 +805,358 @@ func (ea *EngineAdapter) GetDirPatchInfo(patchFile string) (*DirPatchInfo, error
 		AddedFiles:       addedCount,
 		DeletedFiles:     deletedCount,
 		ModifiedFiles:    modifiedCount,
+		RenamedFiles:     len(renamedFiles),
+		CopiedFiles:      len(copiedFiles),
 		UnchangedFiles:   unchangedCount,
+		SourceFiles:      len(dirPatch.Sources),
+		Reversible:       header.Flags&patch.DirPatchFlagReverse != 0,
 		PatchSize:        stat.Size(),
 		CreatedAt:        time.Unix(header.Timestamp, 0),
 		AddedFileList:    addedFiles,
 		DeletedFileList:  deletedFiles,
 		ModifiedFileList: modifiedFiles,
+		RenamedFileList:  renamedFiles,
+		CopiedFileList:   copiedFiles,
+	}
+	if info.Signature, err = readSignatureInfo(patchFile); err != nil {
+		return nil, err
 	}
 
 	return info, nil
 }
 
+// displayPath 返回条目的显示路径，与ls -F相同，目录以/结尾，符号链接以@结尾
+func displayPath(f *diff.DirPatchFile) string {
+	switch f.Kind {
+	case diff.KindDir:
+		return f.RelativePath + "/"
+	case diff.KindSymlink:
+		return f.RelativePath + "@"
+	default:
+		return f.RelativePath
+	}
+}
+
+// SyncSend 作为发送方通过连接同步新文件
+func (ea *EngineAdapter) SyncSend(conn io.ReadWriter, newFile string, compress bool, progress ProgressReporter) (*SyncResult, error) {
+	if _, err := os.Stat(newFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("新文件不存在: %s", newFile)
+	}
+
+	progress.SetMessage("等待接收方签名...")
+	progress.SetCurrent(10)
+
+	sender := patch.NewRemoteSender(ea.diffEngine, ea.compressionFor(compress))
+	sender.SetCompressionLevel(ea.compressionLevel)
+	result, err := sender.Send(conn, newFile)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("同步完成")
+
+	return newSyncResult(result), nil
+}
+
+// SyncReceive 作为接收方通过连接同步旧文件
+func (ea *EngineAdapter) SyncReceive(conn io.ReadWriter, oldFile, outputFile string, blockSize int, progress ProgressReporter) (*SyncResult, error) {
+	if _, err := os.Stat(oldFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("旧文件不存在: %s", oldFile)
+	}
+
+	// 签名的块大小和分块方式由接收方决定
+	engine, err := diff.NewEngine(ea.diffConfig(blockSize))
+	if err != nil {
+		return nil, err
+	}
+
+	progress.SetMessage("正在生成并发送签名...")
+	progress.SetCurrent(10)
+
+	receiver := patch.NewRemoteReceiver(engine, ea.patchApplier)
+	result, err := receiver.Receive(conn, oldFile, outputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("同步完成")
+
+	return newSyncResult(result), nil
+}
+
+func newSyncResult(result *patch.RemoteResult) *SyncResult {
+	return &SyncResult{
+		SignatureBytes: result.SignatureBytes,
+		PatchBytes:     result.PatchBytes,
+		SourceSize:     result.SourceSize,
+		TargetSize:     result.TargetSize,
+	}
+}
+
+// ExportPatch 将补丁文件导出为其他差异格式，sourceFile为空时不写入窗口校验和
+func (ea *EngineAdapter) ExportPatch(patchFile, sourceFile, outputFile, format string, progress ProgressReporter) error {
+	if format != "vcdiff" {
+		return fmt.Errorf("不支持的格式: %s", format)
+	}
+
+	progress.SetMessage("正在读取补丁文件...")
+	progress.SetCurrent(10)
+
+	patchData, err := patch.NewSerializer(patch.CompressionNone).DeserializePatch(patchFile)
+	if err != nil {
+		return err
+	}
+
+	encoder := vcdiff.NewEncoder(nil)
+	if sourceFile != "" {
+		source, err := os.Open(sourceFile)
+		if err != nil {
+			return fmt.Errorf("打开源文件失败: %w", err)
+		}
+		defer source.Close()
+		encoder.Source = source
+	}
+
+	progress.SetCurrent(30)
+	progress.SetMessage("正在编码 VCDIFF...")
+
+	output, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("创建输出文件失败: %w", err)
+	}
+	defer output.Close()
+
+	if err := encoder.EncodePatch(output, patchData); err != nil {
+		return err
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("导出完成")
+
+	return output.Close()
+}
+
+// ImportPatch 将其他差异格式导入为补丁文件
+func (ea *EngineAdapter) ImportPatch(inputFile, sourceFile, outputFile, format string, compress bool, progress ProgressReporter) error {
+	if format != "vcdiff" {
+		return fmt.Errorf("不支持的格式: %s", format)
+	}
+
+	source, err := os.Open(sourceFile)
+	if err != nil {
+		return fmt.Errorf("打开源文件失败: %w", err)
+	}
+	defer source.Close()
+
+	progress.SetMessage("正在计算源文件校验和...")
+	progress.SetCurrent(10)
+
+	hasher := sha256.New()
+	sourceSize, err := io.Copy(hasher, source)
+	if err != nil {
+		return fmt.Errorf("读取源文件失败: %w", err)
+	}
+	var sourceChecksum [32]byte
+	copy(sourceChecksum[:], hasher.Sum(nil))
+
+	input, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("打开输入文件失败: %w", err)
+	}
+	defer input.Close()
+
+	progress.SetCurrent(30)
+	progress.SetMessage("正在解码 VCDIFF...")
+
+	delta, err := vcdiff.NewDecoder(source).Decode(input)
+	if err != nil {
+		return err
+	}
+	if delta.SourceSize > sourceSize {
+		return fmt.Errorf("VCDIFF 引用的数据超出源文件范围: %d > %d", delta.SourceSize, sourceSize)
+	}
+	delta.SourceSize = sourceSize
+
+	progress.SetCurrent(80)
+	progress.SetMessage("写入补丁文件...")
+
+	serializer := patch.NewSerializer(ea.compressionFor(compress))
+	serializer.SetLevel(ea.compressionLevel)
+	serializer.SetCompressOperations(true)
+	serializer.SetEncryption(ea.encryption)
+	if err := serializer.SetDictionary(ea.dictionary); err != nil {
+		return err
+	}
+	if err := serializer.SerializeDelta(delta, sourceChecksum, outputFile); err != nil {
+		return err
+	}
+	if err := ea.SignOutput(outputFile); err != nil {
+		return err
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("导入完成")
+
+	return nil
+}
+
+// SquashPatches 将旧版本→中间版本与中间版本→新版本的两个补丁合并为一个，支持单文件补丁和目录补丁
+func (ea *EngineAdapter) SquashPatches(firstPatch, secondPatch, outputFile string, compress bool, progress ProgressReporter) (*SquashResult, error) {
+	firstDir, err := patch.IsDirPatch(firstPatch)
+	if err != nil {
+		return nil, err
+	}
+	secondDir, err := patch.IsDirPatch(secondPatch)
+	if err != nil {
+		return nil, err
+	}
+	if firstDir != secondDir {
+		return nil, fmt.Errorf("不能合并目录补丁与单文件补丁")
+	}
+	for _, patchFile := range []string{firstPatch, secondPatch} {
+		if err := ea.verifyInput(patchFile); err != nil {
+			return nil, err
+		}
+	}
+
+	progress.SetMessage("正在合并补丁...")
+	progress.SetCurrent(10)
+
+	result := &SquashResult{PatchFile: outputFile, IsDirectory: firstDir}
+	if firstDir {
+		if ea.encryption != nil {
+			return nil, fmt.Errorf("目录补丁不支持加密")
+		}
+		serializer := patch.NewDirPatchSerializer(ea.compressionFor(compress))
+		serializer.SetLevel(ea.compressionLevel)
+		dirPatch, err := serializer.ComposeDirPatches(firstPatch, secondPatch, outputFile)
+		if err != nil {
+			return nil, err
+		}
+		result.Files = len(dirPatch.Files)
+	} else {
+		reader := patch.NewSerializer(patch.CompressionNone)
+		first, err := reader.DeserializePatch(firstPatch)
+		if err != nil {
+			return nil, fmt.Errorf("读取第一个补丁失败: %w", err)
+		}
+		second, err := reader.DeserializePatch(secondPatch)
+		if err != nil {
+			return nil, fmt.Errorf("读取第二个补丁失败: %w", err)
+		}
+
+		progress.SetCurrent(50)
+		composed, err := patch.Compose(first, second)
+		if err != nil {
+			return nil, err
+		}
+
+		progress.SetMessage("写入补丁文件...")
+		progress.SetCurrent(80)
+		serializer := patch.NewSerializer(ea.compressionFor(compress))
+		serializer.SetLevel(ea.compressionLevel)
+		serializer.SetCompressOperations(true)
+		serializer.SetEncryption(ea.encryption)
+		if err := serializer.SetDictionary(ea.dictionary); err != nil {
+			return nil, err
+		}
+		if err := serializer.SerializePatch(composed, outputFile); err != nil {
+			return nil, err
+		}
+		result.Operations = len(composed.Operations)
+		result.SourceSize = composed.Header.SourceSize
+		result.TargetSize = composed.Header.TargetSize
+	}
+
+	if err := ea.SignOutput(outputFile); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(outputFile); err == nil {
+		result.PatchSize = info.Size()
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("合并完成")
+	return result, nil
+}
+
+// ReversePatch 由旧版本→新版本的补丁生成新版本→旧版本的补丁，source为旧文件或旧目录。
+// 嵌入了反向条目的目录补丁不需要旧目录，source可以为空
+func (ea *EngineAdapter) ReversePatch(patchFile, source, outputFile string, compress bool, progress ProgressReporter) (*ReverseResult, error) {
+	isDir, err := patch.IsDirPatch(patchFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := ea.verifyInput(patchFile); err != nil {
+		return nil, err
+	}
+
+	progress.SetMessage("正在生成反向补丁...")
+	progress.SetCurrent(10)
+
+	result := &ReverseResult{PatchFile: outputFile, IsDirectory: isDir}
+	if isDir {
+		if ea.encryption != nil {
+			return nil, fmt.Errorf("目录补丁不支持加密")
+		}
+		serializer := patch.NewDirPatchSerializer(ea.compressionFor(compress))
+		serializer.SetLevel(ea.compressionLevel)
+		dirPatch, err := serializer.InvertDirPatch(patchFile, source, outputFile)
+		if err != nil {
+			return nil, err
+		}
+		result.Files = len(dirPatch.Files)
+	} else {
+		if source == "" {
+			return nil, fmt.Errorf("单文件补丁需要旧文件")
+		}
+		forward, err := patch.NewSerializer(patch.CompressionNone).DeserializePatch(patchFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取补丁失败: %w", err)
+		}
+		oldFile, err := os.Open(source)
+		if err != nil {
+			return nil, fmt.Errorf("打开旧文件失败: %w", err)
+		}
+		defer oldFile.Close()
+
+		progress.SetCurrent(50)
+		inverse, err := patch.Invert(forward, oldFile)
+		if err != nil {
+			return nil, err
+		}
+
+		progress.SetMessage("写入补丁文件...")
+		progress.SetCurrent(80)
+		serializer := patch.NewSerializer(ea.compressionFor(compress))
+		serializer.SetLevel(ea.compressionLevel)
+		serializer.SetCompressOperations(true)
+		serializer.SetEncryption(ea.encryption)
+		if err := serializer.SetDictionary(ea.dictionary); err != nil {
+			return nil, err
+		}
+		if err := serializer.SerializePatch(inverse, outputFile); err != nil {
+			return nil, err
+		}
+		result.Operations = len(inverse.Operations)
+		result.SourceSize = inverse.Header.SourceSize
+		result.TargetSize = inverse.Header.TargetSize
+	}
+
+	if err := ea.SignOutput(outputFile); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(outputFile); err == nil {
+		result.PatchSize = info.Size()
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("反向补丁生成完成")
+	return result, nil
+}
+
 // GenerateDirDiff 生成目录补丁
 func (ea *EngineAdapter) GenerateDirDiff(oldDir, newDir, outputFile string, recursive, ignoreHidden bool, ignorePatterns string, compress bool, progress ProgressReporter) (any, error) {
 	progress.SetMessage("正在分析目录差异...")
@@ -289,24 +1170,39 @@ func (ea *EngineAdapter) GenerateDirDiff(oldDir, newDir, outputFile string, recu
 	}
 	dirConfig.Compress = compress
 
+	// 目录补丁中新增文件的内容不经过单文件补丁，无法整体加密
+	if ea.encryption != nil {
+		return nil, fmt.Errorf("目录补丁不支持加密")
+	}
+
 	ea.dirDiffEngine, _ = diff.NewDirEngine(nil, dirConfig)
 
-	wrapper := &diffProgressWrapper{progress}
-	result, err := ea.dirDiffEngine.GenerateDirDiff(oldDir, newDir, wrapper)
+	// 每个条目生成后立即写入补丁文件，不在内存中保留差异数据
+	serializer := patch.NewDirPatchSerializer(ea.compressionFor(compress))
+	serializer.SetLevel(ea.compressionLevel)
+	sink, err := serializer.NewSink(outputFile, filepath.Base(oldDir), filepath.Base(newDir))
 	if err != nil {
 		return nil, err
 	}
 
-	totalBytes := result.TotalBytesToProcess()
-
-	progress.SetMessage("正在序列化补丁...")
-
-	oldBase := filepath.Base(oldDir)
-	newBase := filepath.Base(newDir)
-	err = ea.dirPatchSerializer.SerializeDirPatch(result, oldBase, newBase, outputFile)
+	wrapper := &diffProgressWrapper{progress}
+	result, err := ea.dirDiffEngine.GenerateDirDiffTo(oldDir, newDir, wrapper, sink)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && ea.reversible {
+		progress.SetMessage("正在嵌入反向条目...")
+		err = serializer.AddReverse(outputFile, oldDir)
+	}
 	if err != nil {
+		os.Remove(outputFile)
 		return nil, err
 	}
+	if err := ea.SignOutput(outputFile); err != nil {
+		return nil, err
+	}
+
+	totalBytes := result.TotalBytesToProcess()
 
 	if totalBytes > 0 {
 		progress.SetTotal(totalBytes)
@@ -351,95 +1247,60 @@ func splitIgnorePatterns(patterns string) []string {
 	return result
 }
 
-// ApplyDirPatch 应用目录补丁
+// ApplyDirPatch 以事务方式应用目录补丁
 func (ea *EngineAdapter) ApplyDirPatch(patchFile, targetDir string, verify bool, progress ProgressReporter) (any, error) {
-	progress.SetMessage("正在读取目录补丁...")
-	progress.SetCurrent(10)
+	return ea.ApplyDirPatchWithFilter(patchFile, targetDir, nil, nil, progress)
+}
 
-	dirPatch, err := ea.dirPatchSerializer.DeserializeDirPatch(patchFile)
+// ApplyDirPatchWithFilter 以事务方式只应用路径匹配only且不匹配exclude的条目
+func (ea *EngineAdapter) ApplyDirPatchWithFilter(patchFile, targetDir string, only, exclude []string, progress ProgressReporter) (any, error) {
+	filter, err := patch.NewDirPatchFilter(only, exclude)
 	if err != nil {
 		return nil, err
 	}
+	if err := ea.verifyInput(patchFile); err != nil {
+		return nil, err
+	}
 
+	progress.SetTotal(100)
 	progress.SetMessage("正在应用目录补丁...")
-	progress.SetCurrent(10)
 
-	var totalBytes int64
-
-	for _, filePatch := range dirPatch.Files {
-		switch filePatch.Status {
-		case diff.StatusAdded, diff.StatusModified:
-			if filePatch.IsFullContent {
-				totalBytes += filePatch.Size
-			} else if len(filePatch.Delta) > 0 {
-				totalBytes += filePatch.DeltaSize
-			}
-		}
+	dirPatch, err := ea.dirApplier.ApplyFiltered(patchFile, targetDir, filter, &diffProgressWrapper{cliProgress: progress})
+	if err != nil {
+		return nil, err
 	}
 
-	if totalBytes > 0 {
-		progress.SetTotal(10 + 30 + totalBytes)
-	}
-
-	var processedBytes int64
-
-	for _, filePatch := range dirPatch.Files {
-		var fileBytes int64
-
-		targetPath := filepath.Join(targetDir, filePatch.RelativePath)
-
-		switch filePatch.Status {
-		case diff.StatusAdded, diff.StatusModified:
-			dir := filepath.Dir(targetPath)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return nil, fmt.Errorf("创建目录失败: %w", err)
-			}
-
-			if filePatch.IsFullContent {
-				if err := os.WriteFile(targetPath, filePatch.Delta, os.FileMode(filePatch.Mode)); err != nil {
-					return nil, fmt.Errorf("写入文件失败: %w", err)
-				}
-				fileBytes = filePatch.Size
-			} else if len(filePatch.Delta) > 0 {
-				if filePatch.Status == diff.StatusAdded {
-					if err := os.WriteFile(targetPath, filePatch.Delta, os.FileMode(filePatch.Mode)); err != nil {
-						return nil, fmt.Errorf("写入文件失败: %w", err)
-					}
-					fileBytes = filePatch.Size
-				} else {
-					if _, err := os.Stat(targetPath); err == nil {
-						if err := ea.patchApplier.ApplyDelta(targetPath, filePatch.Delta, targetPath+".tmp"); err != nil {
-							return nil, fmt.Errorf("应用二进制差异补丁失败 %s: %w", filePatch.RelativePath, err)
-						}
-						if err := os.Rename(targetPath+".tmp", targetPath); err != nil {
-							return nil, fmt.Errorf("重命名文件失败 %s: %w", filePatch.RelativePath, err)
-						}
-						fileBytes = filePatch.DeltaSize
-					} else {
-						return nil, fmt.Errorf("源文件不存在: %s", filePatch.RelativePath)
-					}
-				}
-			}
-
-			os.Chtimes(targetPath, filePatch.GetMTime(), filePatch.GetMTime())
+	progress.SetCurrent(100)
+	progress.SetMessage("目录补丁应用完成")
 
-		case diff.StatusDeleted:
-			if _, err := os.Stat(targetPath); err == nil {
-				if err := os.Remove(targetPath); err != nil {
-					return nil, fmt.Errorf("删除文件失败: %w", err)
-				}
-			}
-		}
+	return dirPatch, nil
+}
 
-		if fileBytes > 0 {
-			processedBytes += fileBytes
-			if totalBytes > 0 {
-				progress.SetCurrent(30 + processedBytes)
-			}
-		}
+// ApplyDirPatchReverse 以事务方式将目录补丁中嵌入的反向补丁应用到新目录，只应用路径匹配only且不匹配exclude的条目
+func (ea *EngineAdapter) ApplyDirPatchReverse(patchFile, targetDir string, only, exclude []string, progress ProgressReporter) (any, error) {
+	filter, err := patch.NewDirPatchFilter(only, exclude)
+	if err != nil {
+		return nil, err
+	}
+	if err := ea.verifyInput(patchFile); err != nil {
+		return nil, err
 	}
 
-	progress.SetMessage("目录补丁应用完成")
+	progress.SetTotal(100)
+	progress.SetMessage("正在反向应用目录补丁...")
+
+	dirPatch, err := ea.dirApplier.ApplyReverse(patchFile, targetDir, filter, &diffProgressWrapper{cliProgress: progress})
+	if err != nil {
+		return nil, err
+	}
+
+	progress.SetCurrent(100)
+	progress.SetMessage("目录补丁反向应用完成")
 
 	return dirPatch, nil
 }
+
+// RollbackDirPatch 回滚目标目录上未完成的目录补丁
+func (ea *EngineAdapter) RollbackDirPatch(targetDir string) error {
+	return ea.dirApplier.Rollback(targetDir)
+}
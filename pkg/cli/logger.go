@@ -75,6 +75,14 @@ func (l *Logger) setOutputFile(filename string) error {
 	return nil
 }
 
+// SetOutput 设置输出流（已指定日志文件时不生效）
+func (l *Logger) SetOutput(output io.Writer) {
+	if l.file != nil {
+		return
+	}
+	l.output = output
+}
+
 // Close 关闭日志器
 func (l *Logger) Close() error {
 	if l.file != nil {
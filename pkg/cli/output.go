@@ -0,0 +1,219 @@
+package cli
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// 输出格式
+const (
+	OutputFormatText = "text"
+	OutputFormatJSON = "json"
+)
+
+// OutputVersion JSON 输出的格式版本，已有字段的含义改变时递增，新增字段不改变版本
+const OutputVersion = 1
+
+// CommandOutput JSON 模式下每次运行命令向标准输出写入的文档
+type CommandOutput struct {
+	Version int          `json:"version"`
+	Command string       `json:"command"`
+	Success bool         `json:"success"`
+	Result  any          `json:"result,omitempty"` // 命令结果，失败时可能包含部分结果
+	Error   *ErrorOutput `json:"error,omitempty"`
+}
+
+// ErrorOutput JSON 输出中的错误信息
+type ErrorOutput struct {
+	Code    string `json:"code"` // CLIError.Code 的名称，如 PATCH_APPLICATION
+	Message string `json:"message"`
+	Cause   string `json:"cause,omitempty"` // 原始错误
+}
+
+// newErrorOutput 将命令返回的错误转换为 JSON 输出，非 CLIError 的错误代码为 UNKNOWN
+func newErrorOutput(err error) *ErrorOutput {
+	var cliErr *CLIError
+	if !errors.As(err, &cliErr) {
+		return &ErrorOutput{Code: ErrUnknown.String(), Message: err.Error()}
+	}
+	output := &ErrorOutput{Code: cliErr.Code.String(), Message: cliErr.Message}
+	if cliErr.Cause != nil {
+		output.Cause = cliErr.Cause.Error()
+	}
+	return output
+}
+
+// JSONOutput 返回是否以 JSON 格式输出命令结果
+func (app *App) JSONOutput() bool {
+	return app.config.OutputFormat == OutputFormatJSON
+}
+
+// SetResult 记录命令结果，JSON 模式下在命令结束后输出
+func (app *App) SetResult(result any) {
+	app.result = result
+}
+
+// writeOutput 在 JSON 模式下输出命令结果或错误
+func (app *App) writeOutput(command string, err error) {
+	if !app.JSONOutput() {
+		return
+	}
+
+	output := CommandOutput{
+		Version: OutputVersion,
+		Command: command,
+		Success: err == nil,
+		Result:  app.result,
+	}
+	if err != nil {
+		output.Error = newErrorOutput(err)
+	}
+
+	encoder := json.NewEncoder(app.stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(output); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 输出 JSON 结果失败: %v\n", err)
+	}
+}
+
+// HexBytes 在 JSON 中以十六进制字符串表示的字节序列
+type HexBytes []byte
+
+// MarshalText 实现 encoding.TextMarshaler
+func (b HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+// MarshalText 实现 encoding.TextMarshaler，JSON 中使用算法名称
+func (c CompressionType) MarshalText() ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return []byte("none"), nil
+	case CompressionGzip:
+		return []byte("gzip"), nil
+	case CompressionLZ4:
+		return []byte("lz4"), nil
+	case CompressionZstd:
+		return []byte("zstd"), nil
+	case CompressionAuto:
+		return []byte("auto"), nil
+	default:
+		return fmt.Appendf(nil, "unknown(%d)", int(c)), nil
+	}
+}
+
+// VersionResult version 命令结果
+type VersionResult struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// FileResult signature、export、import 命令结果
+type FileResult struct {
+	Input      string `json:"input"`
+	OutputFile string `json:"output_file"`
+	OutputSize int64  `json:"output_size"`
+}
+
+// DiffResult diff 命令结果
+type DiffResult struct {
+	OldFile    string          `json:"old_file,omitempty"`  // 使用签名文件时为空
+	Signature  string          `json:"signature,omitempty"` // 签名文件路径
+	NewFile    string          `json:"new_file"`
+	PatchFile  string          `json:"patch_file"`
+	PatchSize  int64           `json:"patch_size"`
+	InPlace    bool            `json:"in_place"`
+	Encryption *EncryptionInfo `json:"encryption,omitempty"`
+}
+
+// DirDiffResult dir-diff 命令结果
+type DirDiffResult struct {
+	OldDir    string        `json:"old_dir"`
+	NewDir    string        `json:"new_dir"`
+	PatchFile string        `json:"patch_file"`
+	DirPatch  *DirPatchInfo `json:"dir_patch"`
+}
+
+// ApplyResult apply 命令结果
+type ApplyResult struct {
+	PatchFile   string       `json:"patch_file,omitempty"` // 回滚时为空
+	Target      string       `json:"target"`
+	OutputFile  string       `json:"output_file"` // 原地应用和目录补丁时与 target 相同
+	OutputSize  int64        `json:"output_size,omitempty"`
+	BackupFile  string       `json:"backup_file,omitempty"`
+	IsDirectory bool         `json:"is_directory"`
+	InPlace     bool         `json:"in_place"`
+	Reverse     bool         `json:"reverse"`
+	RolledBack  bool         `json:"rolled_back"`     // --rollback 撤销了未完成的目录补丁
+	Files       []FileChange `json:"files,omitempty"` // 目录补丁中发生变化的条目
+}
+
+// FileChange 目录补丁中的条目
+type FileChange struct {
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"` // 重命名和复制的原路径
+	Status  string `json:"status"`             // added、deleted、modified、renamed 或 copied
+}
+
+// ValidateResult validate 命令结果
+type ValidateResult struct {
+	PatchFile string `json:"patch_file"`
+	*ValidationResult
+}
+
+// InfoResult info 命令结果，Patch 和 DirPatch 只有一个不为空
+type InfoResult struct {
+	PatchFile   string        `json:"patch_file"`
+	IsDirectory bool          `json:"is_directory"`
+	Patch       *PatchInfo    `json:"patch,omitempty"`
+	DirPatch    *DirPatchInfo `json:"dir_patch,omitempty"`
+}
+
+// SignedPatch sign 和 verify 命令中一个补丁的签名
+type SignedPatch struct {
+	PatchFile string         `json:"patch_file"`
+	Signature *SignatureInfo `json:"signature"`
+}
+
+// KeyPairResult sign --genkey 命令结果
+type KeyPairResult struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+	KeyID      string `json:"key_id"`
+}
+
+// ConfigEntry config 命令输出的配置项
+type ConfigEntry struct {
+	Key    string `json:"key"`
+	Value  any    `json:"value"`            // unset 时为 null
+	Origin string `json:"origin,omitempty"` // 查看配置时的来源
+	File   string `json:"file,omitempty"`   // 修改的配置文件
+}
+
+// BenchmarkResult benchmark 命令中一项测试的结果
+type BenchmarkResult struct {
+	Name       string  `json:"name"` // diff 或 apply
+	FileSize   int64   `json:"file_size"`
+	PatchSize  int64   `json:"patch_size"`
+	DurationMs float64 `json:"duration_ms"`
+	Throughput float64 `json:"throughput_mb_s"`
+}
+
+// BenchmarkReport benchmark 命令结果
+type BenchmarkReport struct {
+	TestDir string            `json:"test_dir"`
+	Results []BenchmarkResult `json:"results"`
+}
+
+// fileSize 返回文件大小，无法访问时返回0
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
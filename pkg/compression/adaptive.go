@@ -0,0 +1,34 @@
+package compression
+
+import "math"
+
+// IncompressibleEntropy 熵不低于该值（比特/字节）的数据视为已压缩或随机数据
+const IncompressibleEntropy = 7.9
+
+// Entropy 按字节频率估计数据的香农熵，单位为比特/字节
+func Entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	total := float64(len(data))
+	var entropy float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / total
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// IsIncompressible 根据熵判断数据是否不值得压缩，如JPEG、zip等已压缩的内容
+func IsIncompressible(data []byte) bool {
+	return Entropy(data) >= IncompressibleEntropy
+}
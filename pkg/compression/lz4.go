@@ -39,8 +39,8 @@ func (lc *LZ4Compressor) Compress(data []byte) ([]byte, error) {
 
 // CompressStream 流式压缩
 func (lc *LZ4Compressor) CompressStream(src io.Reader, dst io.Writer) error {
+	// 不能用defer再次关闭，重复Close会再写入一个帧结束标记
 	writer := lz4.NewWriter(dst)
-	defer writer.Close()
 
 	buffer := make([]byte, lc.config.BlockSize)
 	for {
@@ -61,6 +61,7 @@ func (cm *CompressionManager) RegisterZstd(config *CompressionConfig) {
 		EnableChecksum:  true,
 		EnableDict:      config.EnableDict,
 		DictSize:        config.DictSize,
+		Dict:            config.Dict,
 		ConcurrentLevel: 1,
 	}
 
@@ -71,6 +72,9 @@ func (cm *CompressionManager) RegisterZstd(config *CompressionConfig) {
 		MaxWindowSize:   1 << 27,           // 128MB
 		ConcurrentLevel: 1,
 	}
+	if config.EnableDict && len(config.Dict) > 0 {
+		decompressConfig.Dicts = [][]byte{config.Dict}
+	}
 	cm.decompressors[CompressionZstd] = NewZstdDecompressor(decompressConfig)
 }
 
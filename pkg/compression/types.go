@@ -13,6 +13,9 @@ const (
 	CompressionGzip                        // Gzip压缩
 	CompressionLZ4                         // LZ4压缩
 	CompressionZstd                        // Zstandard压缩
+
+	// CompressionAuto 按数据块自动选择压缩算法，只用于选择，不对应具体的压缩器
+	CompressionAuto CompressionType = 0xFF
 )
 
 // String 返回压缩类型的字符串表示
@@ -26,6 +29,8 @@ func (ct CompressionType) String() string {
 		return "LZ4"
 	case CompressionZstd:
 		return "Zstd"
+	case CompressionAuto:
+		return "Auto"
 	default:
 		return fmt.Sprintf("Unknown(%d)", ct)
 	}
@@ -49,6 +54,7 @@ type CompressionConfig struct {
 	BlockSize    int              // 块大小
 	EnableDict   bool             // 是否启用字典压缩
 	DictSize     int              // 字典大小
+	Dict         []byte           // Zstd字典，EnableDict为true时使用
 	EnableStream bool             // 是否启用流式压缩
 }
 
@@ -19,6 +19,7 @@ type ZstdConfig struct {
 	EnableChecksum  bool             `json:"enable_checksum"`  // 启用校验和
 	EnableDict      bool             `json:"enable_dict"`      // 启用字典
 	DictSize        int              `json:"dict_size"`        // 字典大小
+	Dict            []byte           `json:"-"`                // 压缩使用的字典，EnableDict为true时生效
 	ConcurrentLevel int              `json:"concurrent_level"` // 并发级别
 }
 
@@ -78,6 +79,11 @@ func (zc *ZstdCompressor) Compress(data []byte) ([]byte, error) {
 		options = append(options, zstd.WithEncoderConcurrency(zc.config.ConcurrentLevel))
 	}
 
+	// 使用字典
+	if zc.config.EnableDict && len(zc.config.Dict) > 0 {
+		options = append(options, zstd.WithEncoderDict(zc.config.Dict))
+	}
+
 	// 创建编码器
 	encoder, err := zstd.NewWriter(nil, options...)
 	if err != nil {
@@ -125,6 +131,11 @@ func (zc *ZstdCompressor) CompressStream(reader io.Reader, writer io.Writer) err
 		options = append(options, zstd.WithEncoderConcurrency(zc.config.ConcurrentLevel))
 	}
 
+	// 使用字典
+	if zc.config.EnableDict && len(zc.config.Dict) > 0 {
+		options = append(options, zstd.WithEncoderDict(zc.config.Dict))
+	}
+
 	// 创建编码器
 	encoder, err := zstd.NewWriter(writer, options...)
 	if err != nil {
@@ -172,9 +183,10 @@ type ZstdDecompressor struct {
 
 // ZstdDecompressConfig Zstd解压配置
 type ZstdDecompressConfig struct {
-	MaxMemory       int64 `json:"max_memory"`       // 最大内存使用
-	MaxWindowSize   int   `json:"max_window_size"`  // 最大窗口大小
-	ConcurrentLevel int   `json:"concurrent_level"` // 并发级别
+	MaxMemory       int64    `json:"max_memory"`       // 最大内存使用
+	MaxWindowSize   int      `json:"max_window_size"`  // 最大窗口大小
+	ConcurrentLevel int      `json:"concurrent_level"` // 并发级别
+	Dicts           [][]byte `json:"-"`                // 可用的字典，已注册的字典总是可用
 }
 
 // NewZstdDecompressor 创建Zstd解压器
@@ -219,6 +231,11 @@ func (zd *ZstdDecompressor) Decompress(data []byte) ([]byte, error) {
 		options = append(options, zstd.WithDecoderConcurrency(zd.config.ConcurrentLevel))
 	}
 
+	// 按帧头中的字典ID选择字典
+	if dicts := append(ZstdDictionaries(), zd.config.Dicts...); len(dicts) > 0 {
+		options = append(options, zstd.WithDecoderDicts(dicts...))
+	}
+
 	// 创建解码器
 	decoder, err := zstd.NewReader(nil, options...)
 	if err != nil {
@@ -255,6 +272,11 @@ func (zd *ZstdDecompressor) DecompressStream(reader io.Reader, writer io.Writer)
 		options = append(options, zstd.WithDecoderConcurrency(zd.config.ConcurrentLevel))
 	}
 
+	// 按帧头中的字典ID选择字典
+	if dicts := append(ZstdDictionaries(), zd.config.Dicts...); len(dicts) > 0 {
+		options = append(options, zstd.WithDecoderDicts(dicts...))
+	}
+
 	// 创建解码器
 	decoder, err := zstd.NewReader(reader, options...)
 	if err != nil {
@@ -288,7 +310,11 @@ func (zd *ZstdDecompressor) ValidateData(data []byte) error {
 	}
 
 	// 尝试解压一小部分数据来验证格式
-	decoder, err := zstd.NewReader(bytes.NewReader(data))
+	var options []zstd.DOption
+	if dicts := append(ZstdDictionaries(), zd.config.Dicts...); len(dicts) > 0 {
+		options = append(options, zstd.WithDecoderDicts(dicts...))
+	}
+	decoder, err := zstd.NewReader(bytes.NewReader(data), options...)
 	if err != nil {
 		return NewCompressionError(CompressionZstd, "无效的zstd数据格式", err)
 	}
@@ -0,0 +1,127 @@
+package compression
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultZstdDictSize 训练字典的默认大小，与zstd命令行工具相同
+const DefaultZstdDictSize = 110 * 1024
+
+// zstdDictionaries 已注册的Zstd字典，按字典ID索引，解压时自动使用
+var zstdDictionaries = struct {
+	sync.RWMutex
+	dicts map[uint32][]byte
+}{dicts: make(map[uint32][]byte)}
+
+// TrainZstdDictionary 从样本中训练Zstd字典
+//
+// 样本应为相似的小块数据，例如同一批补丁的插入数据。maxSize为0时使用DefaultZstdDictSize，
+// id为0时随机生成字典ID。
+func TrainZstdDictionary(samples [][]byte, maxSize int, id uint32) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultZstdDictSize
+	}
+	if len(samples) == 0 {
+		return nil, NewCompressionError(CompressionZstd, "训练字典需要至少一个样本", nil)
+	}
+
+	trained, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxSize,
+		HashBytes:   6,
+		ZstdDictID:  id,
+	})
+	if err != nil {
+		return nil, NewCompressionError(CompressionZstd, "训练字典失败", err)
+	}
+	return trained, nil
+}
+
+// ZstdDictionaryID 解析字典并返回其ID
+func ZstdDictionaryID(data []byte) (uint32, error) {
+	d, err := zstd.InspectDictionary(data)
+	if err != nil {
+		return 0, NewCompressionError(CompressionZstd, "无效的zstd字典", err)
+	}
+	if d.ID() == 0 {
+		return 0, NewCompressionError(CompressionZstd, "zstd字典缺少ID", nil)
+	}
+	return d.ID(), nil
+}
+
+// RegisterZstdDictionary 注册字典，之后解压引用该字典ID的数据时自动使用，可用于注册嵌入程序中的字典
+func RegisterZstdDictionary(data []byte) (uint32, error) {
+	id, err := ZstdDictionaryID(data)
+	if err != nil {
+		return 0, err
+	}
+
+	zstdDictionaries.Lock()
+	defer zstdDictionaries.Unlock()
+	zstdDictionaries.dicts[id] = data
+	return id, nil
+}
+
+// LoadZstdDictionary 从文件读取并注册字典
+func LoadZstdDictionary(path string) (uint32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("read dictionary: %w", err)
+	}
+	id, err := RegisterZstdDictionary(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return id, nil
+}
+
+// LookupZstdDictionary 按ID查找已注册的字典
+func LookupZstdDictionary(id uint32) ([]byte, bool) {
+	zstdDictionaries.RLock()
+	defer zstdDictionaries.RUnlock()
+	data, ok := zstdDictionaries.dicts[id]
+	return data, ok
+}
+
+// ZstdDictionaries 返回所有已注册的字典，按ID排序
+func ZstdDictionaries() [][]byte {
+	zstdDictionaries.RLock()
+	defer zstdDictionaries.RUnlock()
+
+	ids := make([]uint32, 0, len(zstdDictionaries.dicts))
+	for id := range zstdDictionaries.dicts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	dicts := make([][]byte, len(ids))
+	for i, id := range ids {
+		dicts[i] = zstdDictionaries.dicts[id]
+	}
+	return dicts
+}
+
+// TrainDictionary 从样本训练字典并用于之后的压缩，字典大小由DictSize决定
+func (zc *ZstdCompressor) TrainDictionary(samples [][]byte, id uint32) ([]byte, error) {
+	trained, err := TrainZstdDictionary(samples, zc.config.DictSize, id)
+	if err != nil {
+		return nil, err
+	}
+	zc.config.EnableDict = true
+	zc.config.Dict = trained
+	return trained, nil
+}
+
+// AddDictionary 添加解压时可用的字典
+func (zd *ZstdDecompressor) AddDictionary(data []byte) error {
+	if _, err := ZstdDictionaryID(data); err != nil {
+		return err
+	}
+	zd.config.Dicts = append(zd.config.Dicts, data)
+	return nil
+}
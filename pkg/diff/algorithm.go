@@ -0,0 +1,88 @@
+package diff
+
+import (
+	"sort"
+	"sync"
+)
+
+// 内置差异算法名称
+const (
+	AlgorithmRollingHash = "rolling" // 滚动哈希块匹配（默认）
+	AlgorithmSuffixArray = "bsdiff"  // 后缀数组近似匹配，适合可执行文件
+)
+
+// Algorithm 差异算法接口
+type Algorithm interface {
+	// Name 返回算法名称
+	Name() string
+	// Diff 生成旧文件到新文件的差异
+	Diff(oldFilePath, newFilePath string) (*Delta, error)
+}
+
+// AlgorithmFactory 根据配置创建差异算法
+type AlgorithmFactory func(config *DiffConfig) Algorithm
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[string]AlgorithmFactory{
+		AlgorithmRollingHash: newRollingHashAlgorithm,
+		AlgorithmSuffixArray: newSuffixArrayAlgorithm,
+	}
+)
+
+// RegisterAlgorithm 注册差异算法，同名算法会被替换
+func RegisterAlgorithm(name string, factory AlgorithmFactory) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+	algorithms[name] = factory
+}
+
+// Algorithms 返回已注册的算法名称
+func Algorithms() []string {
+	algorithmsMu.RLock()
+	defer algorithmsMu.RUnlock()
+
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewAlgorithm 创建配置中选择的差异算法，未指定时使用滚动哈希
+func NewAlgorithm(config *DiffConfig) (Algorithm, error) {
+	name := config.Algorithm
+	if name == "" {
+		name = AlgorithmRollingHash
+	}
+
+	algorithmsMu.RLock()
+	factory, exists := algorithms[name]
+	algorithmsMu.RUnlock()
+	if !exists {
+		return nil, ErrUnknownAlgorithm
+	}
+	return factory(config), nil
+}
+
+// rollingHashAlgorithm 基于旧文件签名的滚动哈希块匹配
+type rollingHashAlgorithm struct {
+	engine *Engine
+}
+
+func newRollingHashAlgorithm(config *DiffConfig) Algorithm {
+	return &rollingHashAlgorithm{engine: &Engine{config: config}}
+}
+
+func (a *rollingHashAlgorithm) Name() string {
+	return AlgorithmRollingHash
+}
+
+func (a *rollingHashAlgorithm) Diff(oldFilePath, newFilePath string) (*Delta, error) {
+	signature, err := a.engine.GenerateSignature(oldFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return a.engine.GenerateDeltaFromSignature(signature, newFilePath)
+}
@@ -0,0 +1,103 @@
+package diff
+
+import (
+	"crypto/sha256"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	hexhash "github.com/Sky-ey/HexDiff/pkg/hash"
+)
+
+// generateChunkSignature 使用基于内容的分块为文件生成签名
+func (e *Engine) generateChunkSignature(file *os.File, fileSize int64) (*Signature, error) {
+	chunkerConfig := e.config.ChunkerConfig()
+	signature := NewSignature(chunkerConfig.AvgSize, fileSize)
+	signature.Chunker = chunkerConfig
+
+	var fileHasher hash.Hash
+	if e.config.EnableSHA256 {
+		fileHasher = sha256.New()
+	}
+
+	chunker := hexhash.NewChunker(file, chunkerConfig)
+	var offset int64
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, NewDiffError("read file", file.Name(), err)
+		}
+
+		if fileHasher != nil {
+			fileHasher.Write(chunk)
+		}
+
+		var checksum uint32
+		if e.config.EnableCRC32 {
+			checksum = crc32.ChecksumIEEE(chunk)
+		}
+
+		signature.AddBlock(Block{
+			Offset:   offset,
+			Size:     len(chunk),
+			Hash:     hexhash.FastHash(chunk),
+			Checksum: checksum,
+		})
+		offset += int64(len(chunk))
+	}
+
+	if fileHasher != nil {
+		copy(signature.Checksum[:], fileHasher.Sum(nil))
+	}
+
+	return signature, nil
+}
+
+// generateDeltaWithChunks 使用与签名相同的参数对新文件分块，按分块内容匹配旧文件
+func (e *Engine) generateDeltaWithChunks(newFile *os.File, signature *Signature, delta *Delta) error {
+	var fileHasher hash.Hash
+	if e.config.EnableSHA256 {
+		fileHasher = sha256.New()
+	}
+
+	var unmatchedStart int64
+	var unmatchedData []byte
+
+	chunker := hexhash.NewChunker(newFile, signature.Chunker)
+	var offset int64
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return NewDiffError("read new file", "", err)
+		}
+
+		if fileHasher != nil {
+			fileHasher.Write(chunk)
+		}
+
+		if block := signature.FindBlock(hexhash.FastHash(chunk), chunk); block != nil {
+			e.flushInsert(delta, unmatchedStart, unmatchedData)
+			unmatchedData = unmatchedData[:0]
+			delta.AddOperation(Operation{
+				Type:      OpCopy,
+				Offset:    offset,
+				Size:      block.Size,
+				SrcOffset: block.Offset,
+			})
+		} else {
+			e.appendUnmatchedBytes(chunk, offset, &unmatchedStart, &unmatchedData)
+		}
+		offset += int64(len(chunk))
+	}
+
+	e.flushInsert(delta, unmatchedStart, unmatchedData)
+	e.setDeltaChecksum(delta, fileHasher)
+	return nil
+}
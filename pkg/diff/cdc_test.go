@@ -0,0 +1,130 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	hexhash "github.com/Sky-ey/HexDiff/pkg/hash"
+)
+
+func newChunkingConfig() *DiffConfig {
+	config := DefaultDiffConfig()
+	config.Chunking = ChunkingContentDefined
+	config.MinChunkSize = 256
+	config.AvgChunkSize = 1024
+	config.MaxChunkSize = 4096
+	return config
+}
+
+func TestContentDefinedChunkingAfterUnalignedInsert(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := filepath.Join(tmpDir, "old.bin")
+	newPath := filepath.Join(tmpDir, "new.bin")
+	sigPath := filepath.Join(tmpDir, "old.sig")
+
+	oldData := make([]byte, 512*1024)
+	rand.New(rand.NewSource(3)).Read(oldData)
+	insertData := []byte("inserted at an unaligned position")
+	newData := append(append(append([]byte(nil), oldData[:100003]...), insertData...), oldData[100003:]...)
+
+	if err := os.WriteFile(oldPath, oldData, 0644); err != nil {
+		t.Fatalf("write old file: %v", err)
+	}
+	if err := os.WriteFile(newPath, newData, 0644); err != nil {
+		t.Fatalf("write new file: %v", err)
+	}
+
+	config := newChunkingConfig()
+	engine, err := NewEngine(config)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	signature, err := engine.GenerateSignature(oldPath)
+	if err != nil {
+		t.Fatalf("GenerateSignature() error = %v", err)
+	}
+	for _, block := range signature.OrderedBlocks() {
+		if block.Size > config.MaxChunkSize {
+			t.Fatalf("chunk size %d exceeds max %d", block.Size, config.MaxChunkSize)
+		}
+	}
+
+	// 签名文件保存分块参数，生成差异时无需相同的配置
+	if err := SaveSignature(signature, sigPath); err != nil {
+		t.Fatalf("SaveSignature() error = %v", err)
+	}
+	loaded, err := LoadSignature(sigPath)
+	if err != nil {
+		t.Fatalf("LoadSignature() error = %v", err)
+	}
+	if loaded.Chunker == nil || *loaded.Chunker != *config.ChunkerConfig() {
+		t.Fatalf("Chunker = %+v, want %+v", loaded.Chunker, config.ChunkerConfig())
+	}
+	if loaded.BlockCount() != signature.BlockCount() {
+		t.Fatalf("block count = %d, want %d", loaded.BlockCount(), signature.BlockCount())
+	}
+
+	defaultEngine, err := NewEngine(nil)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	delta, err := defaultEngine.GenerateDeltaFromSignature(loaded, newPath)
+	if err != nil {
+		t.Fatalf("GenerateDeltaFromSignature() error = %v", err)
+	}
+	assertDeltaRebuildsTarget(t, oldData, newData, delta)
+
+	// 插入只影响其所在的分块
+	_, insertBytes := countOperationBytes(delta)
+	if insertBytes > len(insertData)+2*config.MaxChunkSize {
+		t.Errorf("insert bytes = %d, want at most %d", insertBytes, len(insertData)+2*config.MaxChunkSize)
+	}
+}
+
+func TestChunkerBoundariesAreContentDefined(t *testing.T) {
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(5)).Read(data)
+	config := &hexhash.ChunkerConfig{MinSize: 128, AvgSize: 512, MaxSize: 2048}
+
+	boundaries := func(data []byte) map[int]bool {
+		result := make(map[int]bool)
+		chunker := hexhash.NewChunker(bytes.NewReader(data), config)
+		offset := 0
+		for {
+			chunk, err := chunker.Next()
+			if err != nil {
+				break
+			}
+			offset += len(chunk)
+			result[offset] = true
+		}
+		return result
+	}
+
+	// 在开头插入数据后，后续的分块边界整体平移
+	shift := 7
+	original := boundaries(data)
+	shifted := boundaries(append(make([]byte, shift), data...))
+	common := 0
+	for offset := range original {
+		if shifted[offset+shift] {
+			common++
+		}
+	}
+	if common < len(original)*9/10 {
+		t.Errorf("only %d of %d boundaries survived the insert", common, len(original))
+	}
+}
+
+func TestDiffConfigRejectsInvalidChunkSizes(t *testing.T) {
+	config := newChunkingConfig()
+	config.MinChunkSize = 8192
+	if _, err := NewEngine(config); !errors.Is(err, hexhash.ErrInvalidChunkSize) {
+		t.Errorf("NewEngine() error = %v, want ErrInvalidChunkSize", err)
+	}
+}
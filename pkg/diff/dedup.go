@@ -0,0 +1,131 @@
+package diff
+
+import (
+	"sort"
+)
+
+// sourceIndex 跨文件去重使用的源文件索引
+//
+// 所有旧文件按相对路径排序后视为一个拼接的源，签名中块的偏移量为其在拼接中的位置。
+// 每个文件单独分块，块不会跨越文件边界。
+type sourceIndex struct {
+	files     []*FileEntry
+	offsets   []int64 // 每个文件在拼接中的起始偏移量
+	signature *Signature
+}
+
+// newSourceIndex 为旧目录中的所有文件建立块索引
+func newSourceIndex(engine *Engine, oldDir string, config *DirDiffConfig) (*sourceIndex, error) {
+	entries, err := WalkDirectory(oldDir, config)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &sourceIndex{}
+	for _, entry := range entries {
+		if entry.Kind() == KindFile && entry.Size > 0 {
+			index.files = append(index.files, entry)
+		}
+	}
+	sort.Slice(index.files, func(i, j int) bool {
+		return index.files[i].RelativePath < index.files[j].RelativePath
+	})
+
+	var total int64
+	for _, entry := range index.files {
+		signature, err := engine.GenerateSignature(entry.AbsPath)
+		if err != nil {
+			return nil, err
+		}
+		if index.signature == nil {
+			index.signature = NewSignature(signature.BlockSize, 0)
+			index.signature.Chunker = signature.Chunker
+		}
+		for _, blocks := range signature.Blocks {
+			for _, block := range blocks {
+				block.Offset += total
+				index.signature.AddBlock(block)
+			}
+		}
+
+		index.offsets = append(index.offsets, total)
+		total += signature.FileSize
+	}
+	if index.signature != nil {
+		index.signature.FileSize = total
+	}
+
+	return index, nil
+}
+
+// empty 旧目录中没有可供匹配的文件
+func (idx *sourceIndex) empty() bool {
+	return len(idx.files) == 0
+}
+
+// offsetOf 返回指定路径的旧文件在拼接中的起始偏移量
+func (idx *sourceIndex) offsetOf(relativePath string) (int64, bool) {
+	i := sort.Search(len(idx.files), func(i int) bool {
+		return idx.files[i].RelativePath >= relativePath
+	})
+	if i < len(idx.files) && idx.files[i].RelativePath == relativePath {
+		return idx.offsets[i], true
+	}
+	return 0, false
+}
+
+// generateDelta 生成文件相对于所有旧文件的差异
+//
+// 基于签名的算法直接使用全局签名匹配；其他算法只与对应的旧文件比较，
+// 再将源偏移量换算到拼接中的位置。
+func (idx *sourceIndex) generateDelta(engine *Engine, diff *FileDiff) (*Delta, error) {
+	algorithm := engine.GetConfig().Algorithm
+	if diff.OldEntry != nil && algorithm != "" && algorithm != AlgorithmRollingHash {
+		delta, err := engine.GenerateDelta(diff.OldEntry.AbsPath, diff.NewEntry.AbsPath)
+		if err != nil {
+			return nil, err
+		}
+		base, _ := idx.offsetOf(diff.OldEntry.RelativePath)
+		for i := range delta.Operations {
+			delta.Operations[i].SrcOffset += base
+		}
+		delta.SourceSize = idx.signature.FileSize
+		return delta, nil
+	}
+
+	return engine.GenerateDeltaFromSignature(idx.signature, diff.NewEntry.AbsPath)
+}
+
+// primarySource 返回差异中引用字节数最多的源文件序号加1，没有引用时返回0
+func (idx *sourceIndex) primarySource(delta *Delta) uint32 {
+	referenced := make(map[int]int64)
+	for _, op := range delta.Operations {
+		if op.Type != OpCopy && op.Type != OpAdd {
+			continue
+		}
+		i := sort.Search(len(idx.offsets), func(i int) bool {
+			return idx.offsets[i] > op.SrcOffset
+		}) - 1
+		if i >= 0 {
+			referenced[i] += int64(op.Size)
+		}
+	}
+
+	best := -1
+	for i, size := range referenced {
+		if best < 0 || size > referenced[best] || (size == referenced[best] && i < best) {
+			best = i
+		}
+	}
+	return uint32(best + 1)
+}
+
+// hasSourceData 差异中是否包含引用源数据的操作
+func hasSourceData(delta *Delta) bool {
+	for _, op := range delta.Operations {
+		if op.Type == OpCopy || op.Type == OpAdd {
+			return true
+		}
+	}
+	return false
+}
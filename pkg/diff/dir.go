@@ -12,6 +12,8 @@ const (
 	StatusAdded                       // 新增
 	StatusDeleted                     // 删除
 	StatusModified                    // 修改
+	StatusRenamed                     // 重命名（移动）
+	StatusCopied                      // 复制
 )
 
 // String 返回文件状态的字符串表示
@@ -25,6 +27,33 @@ func (s FileStatus) String() string {
 		return "deleted"
 	case StatusModified:
 		return "modified"
+	case StatusRenamed:
+		return "renamed"
+	case StatusCopied:
+		return "copied"
+	default:
+		return "unknown"
+	}
+}
+
+// EntryKind 目录条目类型
+type EntryKind uint8
+
+const (
+	KindFile    EntryKind = iota // 普通文件
+	KindDir                      // 目录
+	KindSymlink                  // 符号链接
+)
+
+// String 返回条目类型的字符串表示
+func (k EntryKind) String() string {
+	switch k {
+	case KindFile:
+		return "file"
+	case KindDir:
+		return "dir"
+	case KindSymlink:
+		return "symlink"
 	default:
 		return "unknown"
 	}
@@ -40,6 +69,19 @@ type FileEntry struct {
 	MTime        time.Time   // 修改时间
 	IsDir        bool        // 是否是目录
 	IsSymlink    bool        // 是否是符号链接
+	LinkTarget   string      // 符号链接的目标
+}
+
+// Kind 返回条目类型
+func (e *FileEntry) Kind() EntryKind {
+	switch {
+	case e.IsDir:
+		return KindDir
+	case e.IsSymlink:
+		return KindSymlink
+	default:
+		return KindFile
+	}
 }
 
 // DirDiffResult 目录差异结果
@@ -50,9 +92,12 @@ type DirDiffResult struct {
 	AddedFiles     []*FileDiff          // 新增文件列表
 	DeletedFiles   []*FileDiff          // 删除文件列表
 	ModifiedFiles  []*FileDiff          // 修改文件列表
+	RenamedFiles   []*FileDiff          // 重命名文件列表
+	CopiedFiles    []*FileDiff          // 复制文件列表
 	UnchangedFiles []*FileDiff          // 未改变文件列表
 	TotalFiles     int                  // 总文件数
 	ChangedFiles   int                  // 改变的文件数
+	Sources        []*FileEntry         // 跨文件去重的源文件表，差异的源偏移量按此顺序拼接
 }
 
 // TotalBytesToProcess 计算需要处理的总字节数
@@ -74,17 +119,36 @@ func (r *DirDiffResult) TotalBytesToProcess() int64 {
 		}
 	}
 
+	for _, f := range r.SimilarFiles() {
+		total += f.OldEntry.Size + f.NewEntry.Size
+	}
+
 	return total
 }
 
+// SimilarFiles 返回内容不完全相同、需要生成差异的重命名和复制文件
+func (r *DirDiffResult) SimilarFiles() []*FileDiff {
+	var files []*FileDiff
+	for _, list := range [][]*FileDiff{r.RenamedFiles, r.CopiedFiles} {
+		for _, f := range list {
+			if f.Similarity < 1 {
+				files = append(files, f)
+			}
+		}
+	}
+	return files
+}
+
 // FileDiff 单个文件的差异
 type FileDiff struct {
 	RelativePath string     // 相对路径
 	Status       FileStatus // 文件状态
-	OldEntry     *FileEntry // 旧文件信息（删除/修改时有值）
+	OldEntry     *FileEntry // 旧文件信息（删除/修改/重命名/复制时有值）
 	NewEntry     *FileEntry // 新文件信息（新增/修改时有值）
-	Delta        *Delta     // 二进制差异（仅修改时有值）
+	Delta        *Delta     // 二进制差异（修改时有值，跨文件去重时新增文件也可能有值）
 	PatchData    []byte     // 补丁数据（新增文件时为完整内容）
+	SourceID     uint32     // 差异的主要来源在源文件表中的序号加1，为0时没有来源
+	Similarity   float64    // 重命名或复制时与旧文件的相似度，1表示内容相同
 }
 
 // DirDiffConfig 目录差异检测配置
@@ -97,6 +161,10 @@ type DirDiffConfig struct {
 	Compress       bool     // 是否压缩补丁
 	WorkerCount    int      // 并行工作协程数
 	BlockSize      int      // 块大小
+	CrossFileDedup bool     // 是否在所有旧文件中查找匹配（跨文件去重）
+
+	DetectRenames   bool    // 是否检测重命名和复制
+	RenameThreshold float64 // 内容不同的文件被视为重命名的最低相似度，为0时只检测内容相同的文件
 }
 
 // DefaultDirDiffConfig 默认目录差异检测配置
@@ -110,6 +178,10 @@ func DefaultDirDiffConfig() *DirDiffConfig {
 		Compress:       true,
 		WorkerCount:    4,
 		BlockSize:      DefaultBlockSize,
+		CrossFileDedup: true,
+
+		DetectRenames:   true,
+		RenameThreshold: 0.5,
 	}
 }
 
@@ -123,6 +195,9 @@ func (c *DirDiffConfig) Validate() error {
 	if c.BlockSize < MinBlockSize || c.BlockSize > MaxBlockSize {
 		return ErrInvalidBlockSize
 	}
+	if c.RenameThreshold < 0 || c.RenameThreshold > 1 {
+		return ErrInvalidRenameThreshold
+	}
 	return nil
 }
 
@@ -134,6 +209,13 @@ type DirPatch struct {
 	NewDir    string            // 新目录名
 	Files     []*DirPatchFile   // 文件补丁列表
 	Metadata  map[string]string // 元数据
+	Sources   []DirPatchSource  // 源文件表，不为空时差异的源偏移量指向所有源文件的拼接
+}
+
+// DirPatchSource 源文件表中的旧文件
+type DirPatchSource struct {
+	RelativePath string // 相对路径
+	Size         int64  // 文件大小
 }
 
 // DirPatchFile 单个文件的补丁信息
@@ -147,6 +229,10 @@ type DirPatchFile struct {
 	DeltaSize     int64       // 补丁数据大小
 	Delta         []byte      // 补丁数据（修改/新增时使用）
 	IsFullContent bool        // 是否为完整内容（新增文件）
+	SourceID      uint32      // 主要来源在源文件表中的序号加1，为0时没有来源
+	OldPath       string      // 重命名或复制的原路径
+	Kind          EntryKind   // 条目类型
+	LinkTarget    string      // 符号链接的目标
 }
 
 // NewDirDiffResult 创建新的目录差异结果
@@ -173,6 +259,10 @@ func (r *DirDiffResult) AddFileDiff(diff *FileDiff) {
 		r.DeletedFiles = append(r.DeletedFiles, diff)
 	case StatusModified:
 		r.ModifiedFiles = append(r.ModifiedFiles, diff)
+	case StatusRenamed:
+		r.RenamedFiles = append(r.RenamedFiles, diff)
+	case StatusCopied:
+		r.CopiedFiles = append(r.CopiedFiles, diff)
 	case StatusUnchanged:
 		r.UnchangedFiles = append(r.UnchangedFiles, diff)
 	}
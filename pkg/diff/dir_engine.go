@@ -32,6 +32,11 @@ func NewDirEngine(config *DiffConfig, dirConfig *DirDiffConfig) (*DirEngine, err
 }
 
 func (e *DirEngine) GenerateDirDiff(oldDir, newDir string, progress ProgressReporter) (*DirDiffResult, error) {
+	return e.GenerateDirDiffTo(oldDir, newDir, progress, nil)
+}
+
+// GenerateDirDiffTo 生成目录差异，sink不为nil时每个条目处理完成后立即写入sink，返回的结果中不包含差异数据
+func (e *DirEngine) GenerateDirDiffTo(oldDir, newDir string, progress ProgressReporter, sink DirDiffSink) (*DirDiffResult, error) {
 	oldDir = filepath.Clean(oldDir)
 	newDir = filepath.Clean(newDir)
 
@@ -60,7 +65,7 @@ func (e *DirEngine) GenerateDirDiff(oldDir, newDir string, progress ProgressRepo
 		return nil, err
 	}
 
-	err = ProcessDirDiff(result, diffEngine, e.dirConfig, progress)
+	err = ProcessDirDiffTo(result, diffEngine, e.dirConfig, progress, sink)
 	if err != nil {
 		return nil, err
 	}
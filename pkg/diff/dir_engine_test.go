@@ -1,6 +1,8 @@
 package diff
 
 import (
+	"bytes"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
@@ -215,3 +217,89 @@ func TestDirEngineGetDirConfig(t *testing.T) {
 		t.Error("IgnoreHidden should be true")
 	}
 }
+
+func TestDirEngineCrossFileDedup(t *testing.T) {
+	oldDir := t.TempDir()
+	newDir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(1))
+	first := make([]byte, 64*1024)
+	second := make([]byte, 32*1024)
+	rng.Read(first)
+	rng.Read(second)
+
+	os.WriteFile(filepath.Join(oldDir, "a.bin"), first, 0644)
+	os.WriteFile(filepath.Join(oldDir, "b.bin"), second, 0644)
+
+	// 移动后的文件，以及由两个旧文件拼接而成的新文件
+	os.MkdirAll(filepath.Join(newDir, "moved"), 0755)
+	os.WriteFile(filepath.Join(newDir, "moved", "a.bin"), first, 0644)
+	joined := append(append(append([]byte(nil), second...), "glue"...), first[:16*1024]...)
+	os.WriteFile(filepath.Join(newDir, "b.bin"), joined, 0644)
+
+	// 关闭重命名检测，使移动的文件作为新增文件处理
+	dirConfig := DefaultDirDiffConfig()
+	dirConfig.DetectRenames = false
+	engine, err := NewDirEngine(nil, dirConfig)
+	if err != nil {
+		t.Fatalf("NewDirEngine() error = %v", err)
+	}
+
+	result, err := engine.GenerateDirDiff(oldDir, newDir, nil)
+	if err != nil {
+		t.Fatalf("GenerateDirDiff() error = %v", err)
+	}
+	if len(result.Sources) != 2 {
+		t.Fatalf("len(Sources) = %d, want 2", len(result.Sources))
+	}
+
+	// 源偏移量指向按路径排序后拼接的旧文件
+	source := append(append([]byte(nil), first...), second...)
+
+	moved := result.Files["moved/a.bin"]
+	if moved == nil || moved.Delta == nil {
+		t.Fatal("expected added file to be stored as a delta")
+	}
+	if moved.PatchData != nil {
+		t.Error("added file should not store full content")
+	}
+	if moved.SourceID != 1 {
+		t.Errorf("SourceID = %d, want 1", moved.SourceID)
+	}
+	if got := rebuildFromSource(source, moved.Delta); !bytes.Equal(got, first) {
+		t.Error("moved file was not rebuilt from the old file")
+	}
+
+	modified := result.Files["b.bin"]
+	if modified == nil || modified.Delta == nil {
+		t.Fatal("expected modified file delta")
+	}
+	if modified.SourceID != 2 {
+		t.Errorf("SourceID = %d, want 2", modified.SourceID)
+	}
+	if got := rebuildFromSource(source, modified.Delta); !bytes.Equal(got, joined) {
+		t.Error("modified file was not rebuilt from the old files")
+	}
+	var inserted int
+	for _, op := range modified.Delta.Operations {
+		if op.Type == OpInsert {
+			inserted += op.Size
+		}
+	}
+	if inserted > len(joined)/4 {
+		t.Errorf("inserted %d bytes, expected most data to be copied from other files", inserted)
+	}
+}
+
+func rebuildFromSource(source []byte, delta *Delta) []byte {
+	var out []byte
+	for _, op := range delta.Operations {
+		switch op.Type {
+		case OpCopy:
+			out = append(out, source[op.SrcOffset:op.SrcOffset+int64(op.Size)]...)
+		case OpInsert:
+			out = append(out, op.Data...)
+		}
+	}
+	return out
+}
@@ -45,6 +45,9 @@ func (e *Engine) GenerateSignature(filePath string) (*Signature, error) {
 	}
 
 	fileSize := fileInfo.Size()
+	if e.config.Chunking == ChunkingContentDefined {
+		return e.generateChunkSignature(file, fileSize)
+	}
 	signature := NewSignature(e.config.BlockSize, fileSize)
 
 	// 创建SHA-256哈希器用于整个文件
@@ -107,12 +110,27 @@ func (e *Engine) GenerateSignature(filePath string) (*Signature, error) {
 	return signature, nil
 }
 
-// GenerateDelta 生成两个文件之间的差异
+// GenerateDelta 使用配置中选择的算法生成两个文件之间的差异
 func (e *Engine) GenerateDelta(oldFilePath, newFilePath string) (*Delta, error) {
-	// 首先为旧文件生成签名
-	signature, err := e.GenerateSignature(oldFilePath)
+	algorithm, err := NewAlgorithm(e.config)
 	if err != nil {
-		return nil, err
+		return nil, NewDiffError("generate delta", newFilePath, err)
+	}
+
+	return algorithm.Diff(oldFilePath, newFilePath)
+}
+
+// GenerateDeltaFromSignature 根据旧文件的签名生成差异（无需访问旧文件本身）
+func (e *Engine) GenerateDeltaFromSignature(signature *Signature, newFilePath string) (*Delta, error) {
+	if signature == nil {
+		return nil, NewDiffError("generate delta", newFilePath, ErrInvalidSignature)
+	}
+	if signature.Chunker != nil {
+		if err := validateChunkerConfig(signature.Chunker); err != nil {
+			return nil, NewDiffError("generate delta", newFilePath, err)
+		}
+	} else if signature.BlockSize < MinBlockSize || signature.BlockSize > MaxBlockSize {
+		return nil, NewDiffError("generate delta", newFilePath, ErrInvalidBlockSize)
 	}
 
 	// 打开新文件
@@ -130,8 +148,12 @@ func (e *Engine) GenerateDelta(oldFilePath, newFilePath string) (*Delta, error)
 
 	delta := NewDelta(signature.FileSize, newFileInfo.Size())
 
-	// 使用滚动哈希进行匹配
-	err = e.generateDeltaWithRollingHash(newFile, signature, delta)
+	// 基于内容分块的签名按分块匹配，否则使用滚动哈希进行匹配
+	if signature.Chunker != nil {
+		err = e.generateDeltaWithChunks(newFile, signature, delta)
+	} else {
+		err = e.generateDeltaWithRollingHash(newFile, signature, delta)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +167,8 @@ func (e *Engine) GenerateDelta(oldFilePath, newFilePath string) (*Delta, error)
 
 // generateDeltaWithRollingHash 使用滚动哈希生成差异
 func (e *Engine) generateDeltaWithRollingHash(newFile *os.File, signature *Signature, delta *Delta) error {
-	window := make([]byte, e.config.BlockSize)
+	blockSize := signature.BlockSize
+	window := make([]byte, blockSize)
 	n, err := io.ReadFull(newFile, window)
 	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return NewDiffError("read new file", "", err)
@@ -163,14 +186,14 @@ func (e *Engine) generateDeltaWithRollingHash(newFile *os.File, signature *Signa
 		fileHasher.Write(window[:n])
 	}
 
-	if n < e.config.BlockSize {
+	if n < blockSize {
 		e.processTailData(delta, signature, window[:n], 0, &unmatchedStart, &unmatchedData)
 		e.flushInsert(delta, unmatchedStart, unmatchedData)
 		e.setDeltaChecksum(delta, fileHasher)
 		return nil
 	}
 
-	basePow := calculateBasePow(e.config.BlockSize)
+	basePow := calculateBasePow(blockSize)
 	windowHash := hexhash.FastHash(window)
 	windowStart := int64(0)
 	windowIndex := 0
@@ -201,7 +224,7 @@ func (e *Engine) generateDeltaWithRollingHash(newFile *os.File, signature *Signa
 			if fileHasher != nil && n > 0 {
 				fileHasher.Write(window[:n])
 			}
-			if n < e.config.BlockSize {
+			if n < blockSize {
 				e.processTailData(delta, signature, window[:n], windowStart, &unmatchedStart, &unmatchedData)
 				break
 			}
@@ -226,7 +249,7 @@ func (e *Engine) generateDeltaWithRollingHash(newFile *os.File, signature *Signa
 
 		windowHash = rollBlockHash(windowHash, oldByte, oneByte[0], basePow)
 		window[windowIndex] = oneByte[0]
-		windowIndex = (windowIndex + 1) % e.config.BlockSize
+		windowIndex = (windowIndex + 1) % blockSize
 		windowStart++
 	}
 
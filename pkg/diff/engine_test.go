@@ -128,6 +128,10 @@ func assertDeltaRebuildsTarget(t *testing.T, oldData, newData []byte, delta *Del
 			copy(rebuilt[start:end], oldData[op.SrcOffset:op.SrcOffset+int64(op.Size)])
 		case OpInsert:
 			copy(rebuilt[start:end], op.Data)
+		case OpAdd:
+			for i := range op.Size {
+				rebuilt[start+i] = oldData[int(op.SrcOffset)+i] + op.Data[i]
+			}
 		}
 	}
 	if !bytes.Equal(rebuilt, newData) {
@@ -18,6 +18,10 @@ var (
 	ErrCorruptedData       = errors.New("corrupted data detected")
 	ErrDirectoryNotFound   = errors.New("directory not found")
 	ErrInvalidDirectory    = errors.New("invalid directory")
+	ErrUnknownAlgorithm    = errors.New("unknown diff algorithm")
+	ErrInvalidChunking     = errors.New("invalid chunking mode")
+
+	ErrInvalidRenameThreshold = errors.New("invalid rename threshold: must be between 0 and 1")
 )
 
 // DiffError 差异检测错误类型
@@ -0,0 +1,245 @@
+package diff
+
+import (
+	"io"
+	"os"
+	"sort"
+
+	hexhash "github.com/Sky-ey/HexDiff/pkg/hash"
+)
+
+// 计算相似度时使用的分块参数，较小的分块使少量修改只影响很少的数据
+var similarityChunker = &hexhash.ChunkerConfig{
+	MinSize: 256,
+	AvgSize: 1024,
+	MaxSize: 8 * 1024,
+}
+
+// detectRenames 将新增文件识别为重命名或复制
+//
+// 内容与某个删除的文件相同时视为重命名，与其他旧文件相同时视为复制；
+// 剩余的新增文件与删除的文件相似度不低于阈值时视为重命名，相似度按共同分块的字节数计算。
+func detectRenames(result *DirDiffResult, oldEntries map[string]*FileEntry, config *DirDiffConfig) error {
+	if !config.DetectRenames || len(result.AddedFiles) == 0 {
+		return nil
+	}
+
+	added := append([]*FileDiff(nil), result.AddedFiles...)
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].RelativePath < added[j].RelativePath
+	})
+
+	// 只检测普通文件
+	files := added[:0]
+	for _, f := range added {
+		if f.NewEntry.Kind() == KindFile {
+			files = append(files, f)
+		}
+	}
+	added = files
+
+	deleted := make(map[string]*FileDiff, len(result.DeletedFiles))
+	for _, f := range result.DeletedFiles {
+		if f.OldEntry.Kind() == KindFile {
+			deleted[f.RelativePath] = f
+		}
+	}
+
+	// 只需要计算与新增文件大小相同的旧文件的哈希
+	sizes := make(map[int64]bool)
+	for _, f := range added {
+		if f.NewEntry.Size > 0 {
+			sizes[f.NewEntry.Size] = true
+		}
+	}
+	var candidates []*FileEntry
+	for _, entry := range oldEntries {
+		if entry.Kind() == KindFile && sizes[entry.Size] {
+			candidates = append(candidates, entry)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].RelativePath < candidates[j].RelativePath
+	})
+
+	byHash := make(map[string][]*FileEntry)
+	for _, entry := range candidates {
+		hash, err := computeFileHash(entry.AbsPath)
+		if err != nil {
+			return NewDiffError("hash file", entry.AbsPath, err)
+		}
+		byHash[string(hash)] = append(byHash[string(hash)], entry)
+	}
+
+	var remaining []*FileDiff
+	for _, f := range added {
+		var matches []*FileEntry
+		if sizes[f.NewEntry.Size] {
+			hash, err := computeFileHash(f.NewEntry.AbsPath)
+			if err != nil {
+				return NewDiffError("hash file", f.NewEntry.AbsPath, err)
+			}
+			matches = byHash[string(hash)]
+		}
+		if len(matches) == 0 {
+			remaining = append(remaining, f)
+			continue
+		}
+
+		// 优先匹配尚未被重命名的删除文件
+		source := matches[0]
+		status := StatusCopied
+		for _, entry := range matches {
+			if _, ok := deleted[entry.RelativePath]; ok {
+				source, status = entry, StatusRenamed
+				delete(deleted, entry.RelativePath)
+				break
+			}
+		}
+		f.Status = status
+		f.OldEntry = source
+		f.Similarity = 1
+	}
+
+	if config.RenameThreshold > 0 && len(remaining) > 0 && len(deleted) > 0 {
+		if err := detectSimilarRenames(remaining, deleted, config.RenameThreshold); err != nil {
+			return err
+		}
+	}
+
+	rebuildFileLists(result)
+	return nil
+}
+
+// detectSimilarRenames 为内容不同的新增文件寻找相似度最高的删除文件
+func detectSimilarRenames(added []*FileDiff, deleted map[string]*FileDiff, threshold float64) error {
+	paths := make([]string, 0, len(deleted))
+	for path := range deleted {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	chunkSets := make(map[string]map[uint64]int64)
+	chunkSet := func(entry *FileEntry) (map[uint64]int64, error) {
+		if set, ok := chunkSets[entry.AbsPath]; ok {
+			return set, nil
+		}
+		set, err := fileChunkSet(entry.AbsPath)
+		if err != nil {
+			return nil, NewDiffError("read file", entry.AbsPath, err)
+		}
+		chunkSets[entry.AbsPath] = set
+		return set, nil
+	}
+
+	for _, f := range added {
+		newSize := f.NewEntry.Size
+		if newSize == 0 {
+			continue
+		}
+
+		var best *FileDiff
+		var bestScore float64
+		for _, path := range paths {
+			candidate, ok := deleted[path]
+			if !ok {
+				continue
+			}
+			oldSize := candidate.OldEntry.Size
+			if float64(min(oldSize, newSize)) < threshold*float64(max(oldSize, newSize)) {
+				continue
+			}
+
+			newSet, err := chunkSet(f.NewEntry)
+			if err != nil {
+				return err
+			}
+			oldSet, err := chunkSet(candidate.OldEntry)
+			if err != nil {
+				return err
+			}
+			if score := similarity(oldSet, newSet, oldSize, newSize); score >= threshold && score > bestScore {
+				best, bestScore = candidate, score
+			}
+		}
+
+		if best != nil {
+			f.Status = StatusRenamed
+			f.OldEntry = best.OldEntry
+			f.Similarity = min(bestScore, 0.99)
+			delete(deleted, best.RelativePath)
+		}
+	}
+	return nil
+}
+
+// fileChunkSet 返回文件中每个分块的哈希及其字节数
+func fileChunkSet(path string) (map[uint64]int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	set := make(map[uint64]int64)
+	chunker := hexhash.NewChunker(file, similarityChunker)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return set, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		set[hexhash.FastHash(chunk)] += int64(len(chunk))
+	}
+}
+
+// similarity 返回两个文件共同分块的字节数占较大文件的比例
+func similarity(oldSet, newSet map[uint64]int64, oldSize, newSize int64) float64 {
+	var common int64
+	for hash, size := range newSet {
+		common += min(size, oldSet[hash])
+	}
+	return float64(common) / float64(max(oldSize, newSize))
+}
+
+// rebuildFileLists 根据文件状态重新生成分类列表
+func rebuildFileLists(result *DirDiffResult) {
+	var files []*FileDiff
+	for _, list := range [][]*FileDiff{result.AddedFiles, result.DeletedFiles, result.ModifiedFiles} {
+		files = append(files, list...)
+	}
+
+	renamed := make(map[string]bool)
+	for _, f := range files {
+		if f.Status == StatusRenamed {
+			renamed[f.OldEntry.RelativePath] = true
+		}
+	}
+
+	result.AddedFiles = result.AddedFiles[:0]
+	result.DeletedFiles = result.DeletedFiles[:0]
+	result.ModifiedFiles = result.ModifiedFiles[:0]
+	for _, f := range files {
+		// 被重命名的文件不再单独记录删除
+		if f.Status == StatusDeleted && renamed[f.RelativePath] {
+			delete(result.Files, f.RelativePath)
+			result.TotalFiles--
+			result.ChangedFiles--
+			continue
+		}
+		switch f.Status {
+		case StatusAdded:
+			result.AddedFiles = append(result.AddedFiles, f)
+		case StatusDeleted:
+			result.DeletedFiles = append(result.DeletedFiles, f)
+		case StatusModified:
+			result.ModifiedFiles = append(result.ModifiedFiles, f)
+		case StatusRenamed:
+			result.RenamedFiles = append(result.RenamedFiles, f)
+		case StatusCopied:
+			result.CopiedFiles = append(result.CopiedFiles, f)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package diff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	hexhash "github.com/Sky-ey/HexDiff/pkg/hash"
+)
+
+// 签名文件格式常量
+const (
+	// SignatureMagic 签名文件魔数
+	SignatureMagic = 0x48585347 // "HXSG"
+	// SignatureVersion 签名文件版本
+	SignatureVersion = 1
+	// SignatureVersionCDC 基于内容分块的签名文件版本，文件头后附加分块参数
+	SignatureVersionCDC = 2
+	// SignatureHeaderSize 签名文件头大小 (4+2+2+4+4+8+32+8 = 64字节)
+	SignatureHeaderSize = 64
+	// SignatureHeaderCDCSize 基于内容分块的签名文件头大小 (64+4+4+8 = 80字节)
+	SignatureHeaderCDCSize = 80
+	// SignatureBlockSize 单个块记录的大小 (8+4+4 = 16字节)
+	SignatureBlockSize = 16
+)
+
+// 签名文件头标志位
+const (
+	SignatureFlagSHA256 uint16 = 1 << iota // 包含整个文件的SHA-256校验和
+	SignatureFlagCRC32                     // 块记录包含CRC32校验和
+)
+
+// SignatureHeader 签名文件头
+type SignatureHeader struct {
+	Magic      uint32   // 魔数 "HXSG"
+	Version    uint16   // 版本号
+	Flags      uint16   // 标志位
+	BlockSize  uint32   // 块大小
+	Reserved   uint32   // 保留字段
+	FileSize   int64    // 文件大小
+	Checksum   [32]byte // 文件SHA-256校验和
+	BlockCount uint64   // 块数量
+
+	// 以下字段仅用于基于内容分块的签名，BlockSize为平均分块大小
+	MinChunkSize uint32 // 最小分块大小
+	MaxChunkSize uint32 // 最大分块大小
+}
+
+// Size 返回文件头的序列化大小
+func (h *SignatureHeader) Size() int {
+	if h.Version == SignatureVersionCDC {
+		return SignatureHeaderCDCSize
+	}
+	return SignatureHeaderSize
+}
+
+// ChunkerConfig 返回基于内容分块的参数，固定大小分块时返回nil
+func (h *SignatureHeader) ChunkerConfig() *hexhash.ChunkerConfig {
+	if h.Version != SignatureVersionCDC {
+		return nil
+	}
+	return &hexhash.ChunkerConfig{
+		MinSize: int(h.MinChunkSize),
+		AvgSize: int(h.BlockSize),
+		MaxSize: int(h.MaxChunkSize),
+	}
+}
+
+// Validate 验证签名文件头
+func (h *SignatureHeader) Validate() error {
+	if h.Magic != SignatureMagic {
+		return fmt.Errorf("%w: invalid magic number: expected %x, got %x", ErrInvalidSignature, SignatureMagic, h.Magic)
+	}
+	switch h.Version {
+	case SignatureVersion:
+		if h.BlockSize < MinBlockSize || h.BlockSize > MaxBlockSize {
+			return fmt.Errorf("%w: invalid block size: %d", ErrInvalidSignature, h.BlockSize)
+		}
+	case SignatureVersionCDC:
+		if err := validateChunkerConfig(h.ChunkerConfig()); err != nil {
+			return fmt.Errorf("%w: invalid chunk sizes: min=%d, avg=%d, max=%d",
+				ErrInvalidSignature, h.MinChunkSize, h.BlockSize, h.MaxChunkSize)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported version: %d", ErrInvalidSignature, h.Version)
+	}
+	if h.FileSize < 0 {
+		return fmt.Errorf("%w: invalid file size: %d", ErrInvalidSignature, h.FileSize)
+	}
+	return nil
+}
+
+// Marshal 序列化签名文件头
+func (h *SignatureHeader) Marshal() []byte {
+	buf := make([]byte, h.Size())
+	binary.LittleEndian.PutUint32(buf[0:4], h.Magic)
+	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
+	binary.LittleEndian.PutUint16(buf[6:8], h.Flags)
+	binary.LittleEndian.PutUint32(buf[8:12], h.BlockSize)
+	binary.LittleEndian.PutUint32(buf[12:16], h.Reserved)
+	binary.LittleEndian.PutUint64(buf[16:24], uint64(h.FileSize))
+	copy(buf[24:56], h.Checksum[:])
+	binary.LittleEndian.PutUint64(buf[56:64], h.BlockCount)
+	if h.Version == SignatureVersionCDC {
+		binary.LittleEndian.PutUint32(buf[64:68], h.MinChunkSize)
+		binary.LittleEndian.PutUint32(buf[68:72], h.MaxChunkSize)
+	}
+	return buf
+}
+
+// Unmarshal 反序列化签名文件头
+func (h *SignatureHeader) Unmarshal(data []byte) error {
+	if len(data) < SignatureHeaderSize {
+		return fmt.Errorf("%w: insufficient data for header: need %d bytes, got %d",
+			ErrInvalidSignature, SignatureHeaderSize, len(data))
+	}
+	h.Magic = binary.LittleEndian.Uint32(data[0:4])
+	h.Version = binary.LittleEndian.Uint16(data[4:6])
+	h.Flags = binary.LittleEndian.Uint16(data[6:8])
+	h.BlockSize = binary.LittleEndian.Uint32(data[8:12])
+	h.Reserved = binary.LittleEndian.Uint32(data[12:16])
+	h.FileSize = int64(binary.LittleEndian.Uint64(data[16:24]))
+	copy(h.Checksum[:], data[24:56])
+	h.BlockCount = binary.LittleEndian.Uint64(data[56:64])
+	if h.Version == SignatureVersionCDC {
+		if len(data) < SignatureHeaderCDCSize {
+			return fmt.Errorf("%w: insufficient data for header: need %d bytes, got %d",
+				ErrInvalidSignature, SignatureHeaderCDCSize, len(data))
+		}
+		h.MinChunkSize = binary.LittleEndian.Uint32(data[64:68])
+		h.MaxChunkSize = binary.LittleEndian.Uint32(data[68:72])
+	}
+	return h.Validate()
+}
+
+// OrderedBlocks 按偏移量顺序返回签名中的所有块
+func (s *Signature) OrderedBlocks() []Block {
+	blocks := make([]Block, 0, s.BlockCount())
+	for _, bucket := range s.Blocks {
+		blocks = append(blocks, bucket...)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Offset < blocks[j].Offset
+	})
+	return blocks
+}
+
+// BlockCount 返回签名中的块数量
+func (s *Signature) BlockCount() int {
+	count := 0
+	for _, bucket := range s.Blocks {
+		count += len(bucket)
+	}
+	return count
+}
+
+// WriteTo 将签名以二进制格式写入writer
+func (s *Signature) WriteTo(w io.Writer) (int64, error) {
+	blocks := s.OrderedBlocks()
+
+	header := SignatureHeader{
+		Magic:      SignatureMagic,
+		Version:    SignatureVersion,
+		Flags:      SignatureFlagCRC32,
+		BlockSize:  uint32(s.BlockSize),
+		FileSize:   s.FileSize,
+		Checksum:   s.Checksum,
+		BlockCount: uint64(len(blocks)),
+	}
+	if s.Checksum != [32]byte{} {
+		header.Flags |= SignatureFlagSHA256
+	}
+	if s.Chunker != nil {
+		header.Version = SignatureVersionCDC
+		header.BlockSize = uint32(s.Chunker.AvgSize)
+		header.MinChunkSize = uint32(s.Chunker.MinSize)
+		header.MaxChunkSize = uint32(s.Chunker.MaxSize)
+	}
+
+	var written int64
+	n, err := w.Write(header.Marshal())
+	written += int64(n)
+	if err != nil {
+		return written, fmt.Errorf("write signature header: %w", err)
+	}
+
+	record := make([]byte, SignatureBlockSize)
+	var expectedOffset int64
+	for i, block := range blocks {
+		if block.Offset != expectedOffset {
+			return written, fmt.Errorf("%w: block %d is not contiguous: expected offset %d, got %d",
+				ErrInvalidSignature, i, expectedOffset, block.Offset)
+		}
+		binary.LittleEndian.PutUint64(record[0:8], block.Hash)
+		binary.LittleEndian.PutUint32(record[8:12], block.Checksum)
+		binary.LittleEndian.PutUint32(record[12:16], uint32(block.Size))
+
+		n, err := w.Write(record)
+		written += int64(n)
+		if err != nil {
+			return written, fmt.Errorf("write signature block %d: %w", i, err)
+		}
+		expectedOffset += int64(block.Size)
+	}
+
+	return written, nil
+}
+
+// ReadSignature 从reader中读取二进制签名
+func ReadSignature(r io.Reader) (*Signature, error) {
+	headerData := make([]byte, SignatureHeaderSize, SignatureHeaderCDCSize)
+	if _, err := io.ReadFull(r, headerData); err != nil {
+		return nil, fmt.Errorf("read signature header: %w", err)
+	}
+
+	// 基于内容分块的签名在文件头后附加分块参数
+	if binary.LittleEndian.Uint16(headerData[4:6]) == SignatureVersionCDC {
+		headerData = headerData[:SignatureHeaderCDCSize]
+		if _, err := io.ReadFull(r, headerData[SignatureHeaderSize:]); err != nil {
+			return nil, fmt.Errorf("read signature header: %w", err)
+		}
+	}
+
+	header := &SignatureHeader{}
+	if err := header.Unmarshal(headerData); err != nil {
+		return nil, err
+	}
+
+	signature := NewSignature(int(header.BlockSize), header.FileSize)
+	signature.Checksum = header.Checksum
+	signature.Chunker = header.ChunkerConfig()
+
+	record := make([]byte, SignatureBlockSize)
+	var offset int64
+	for i := uint64(0); i < header.BlockCount; i++ {
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, fmt.Errorf("read signature block %d: %w", i, err)
+		}
+
+		size := int(binary.LittleEndian.Uint32(record[12:16]))
+		if size <= 0 || offset+int64(size) > header.FileSize {
+			return nil, fmt.Errorf("%w: block %d out of range: offset=%d, size=%d, file size=%d",
+				ErrInvalidSignature, i, offset, size, header.FileSize)
+		}
+
+		signature.AddBlock(Block{
+			Offset:   offset,
+			Size:     size,
+			Hash:     binary.LittleEndian.Uint64(record[0:8]),
+			Checksum: binary.LittleEndian.Uint32(record[8:12]),
+		})
+		offset += int64(size)
+	}
+
+	if offset != header.FileSize {
+		return nil, fmt.Errorf("%w: blocks cover %d bytes, file size is %d",
+			ErrInvalidSignature, offset, header.FileSize)
+	}
+
+	return signature, nil
+}
+
+// SaveSignature 将签名保存到文件
+func SaveSignature(signature *Signature, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return NewDiffError("create signature file", path, err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if _, err := signature.WriteTo(writer); err != nil {
+		return NewDiffError("write signature file", path, err)
+	}
+	if err := writer.Flush(); err != nil {
+		return NewDiffError("flush signature file", path, err)
+	}
+
+	return nil
+}
+
+// LoadSignature 从文件加载签名
+func LoadSignature(path string) (*Signature, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, NewDiffError("open signature file", path, err)
+	}
+	defer file.Close()
+
+	signature, err := ReadSignature(bufio.NewReader(file))
+	if err != nil {
+		return nil, NewDiffError("read signature file", path, err)
+	}
+
+	return signature, nil
+}
@@ -0,0 +1,150 @@
+package diff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignatureRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := filepath.Join(tmpDir, "old.bin")
+	sigPath := filepath.Join(tmpDir, "old.sig")
+
+	// 末尾留一个不完整的块
+	oldData := buildPatternData(64*10 + 17)
+	if err := os.WriteFile(oldPath, oldData, 0644); err != nil {
+		t.Fatalf("write old file: %v", err)
+	}
+
+	engine, err := NewEngine(&DiffConfig{
+		BlockSize:    64,
+		WindowSize:   8,
+		EnableCRC32:  true,
+		EnableSHA256: true,
+		MaxMemory:    100 * 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	original, err := engine.GenerateSignature(oldPath)
+	if err != nil {
+		t.Fatalf("GenerateSignature() error = %v", err)
+	}
+	if err := SaveSignature(original, sigPath); err != nil {
+		t.Fatalf("SaveSignature() error = %v", err)
+	}
+
+	loaded, err := LoadSignature(sigPath)
+	if err != nil {
+		t.Fatalf("LoadSignature() error = %v", err)
+	}
+
+	if loaded.BlockSize != original.BlockSize {
+		t.Errorf("BlockSize = %d, want %d", loaded.BlockSize, original.BlockSize)
+	}
+	if loaded.FileSize != original.FileSize {
+		t.Errorf("FileSize = %d, want %d", loaded.FileSize, original.FileSize)
+	}
+	if loaded.Checksum != original.Checksum {
+		t.Errorf("Checksum = %x, want %x", loaded.Checksum, original.Checksum)
+	}
+
+	wantBlocks := original.OrderedBlocks()
+	gotBlocks := loaded.OrderedBlocks()
+	if len(gotBlocks) != len(wantBlocks) {
+		t.Fatalf("block count = %d, want %d", len(gotBlocks), len(wantBlocks))
+	}
+	for i := range wantBlocks {
+		got, want := gotBlocks[i], wantBlocks[i]
+		if got.Offset != want.Offset || got.Size != want.Size || got.Hash != want.Hash || got.Checksum != want.Checksum {
+			t.Errorf("block %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestReadSignatureRejectsCorruptData(t *testing.T) {
+	signature := NewSignature(64, 64)
+	signature.AddBlock(Block{Offset: 0, Size: 64, Hash: 1, Checksum: 2})
+
+	var buf bytes.Buffer
+	if _, err := signature.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	data := buf.Bytes()
+
+	// 截断块记录
+	if _, err := ReadSignature(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("expected error for truncated signature")
+	}
+
+	// 破坏魔数
+	corrupt := append([]byte(nil), data...)
+	corrupt[0] ^= 0xff
+	if _, err := ReadSignature(bytes.NewReader(corrupt)); err == nil {
+		t.Error("expected error for invalid magic")
+	}
+}
+
+func TestGenerateDeltaFromSignature(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := filepath.Join(tmpDir, "old.bin")
+	newPath := filepath.Join(tmpDir, "new.bin")
+	sigPath := filepath.Join(tmpDir, "old.sig")
+
+	oldData := buildPatternData(64 * 16)
+	newData := make([]byte, 0, len(oldData)+3)
+	newData = append(newData, oldData[:64*5]...)
+	newData = append(newData, 0x01, 0x02, 0x03)
+	newData = append(newData, oldData[64*5:]...)
+
+	if err := os.WriteFile(oldPath, oldData, 0644); err != nil {
+		t.Fatalf("write old file: %v", err)
+	}
+	if err := os.WriteFile(newPath, newData, 0644); err != nil {
+		t.Fatalf("write new file: %v", err)
+	}
+
+	// 签名由另一个块大小不同的引擎生成，差异应以签名的块大小为准
+	sigEngine, err := NewEngine(&DiffConfig{
+		BlockSize:    64,
+		WindowSize:   8,
+		EnableCRC32:  true,
+		EnableSHA256: true,
+		MaxMemory:    100 * 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	signature, err := sigEngine.GenerateSignature(oldPath)
+	if err != nil {
+		t.Fatalf("GenerateSignature() error = %v", err)
+	}
+	if err := SaveSignature(signature, sigPath); err != nil {
+		t.Fatalf("SaveSignature() error = %v", err)
+	}
+	loaded, err := LoadSignature(sigPath)
+	if err != nil {
+		t.Fatalf("LoadSignature() error = %v", err)
+	}
+
+	engine, err := NewEngine(nil)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	delta, err := engine.GenerateDeltaFromSignature(loaded, newPath)
+	if err != nil {
+		t.Fatalf("GenerateDeltaFromSignature() error = %v", err)
+	}
+
+	copyBytes, insertBytes := countOperationBytes(delta)
+	if copyBytes != len(oldData) {
+		t.Fatalf("copy bytes = %d, want %d", copyBytes, len(oldData))
+	}
+	if insertBytes != 3 {
+		t.Fatalf("insert bytes = %d, want 3", insertBytes)
+	}
+	assertDeltaRebuildsTarget(t, oldData, newData, delta)
+}
@@ -0,0 +1,353 @@
+package diff
+
+import (
+	"bytes"
+	"math"
+	"os"
+)
+
+// suffixArrayAlgorithm 基于后缀数组的差异算法
+//
+// 匹配策略与bsdiff相同：在旧文件的后缀数组中查找最长匹配，并将匹配向前后扩展为
+// 允许少量字节不同的近似匹配。近似匹配输出为相加操作，其差值数据大部分为零，
+// 压缩后远小于插入原始数据；无法匹配的部分输出为插入操作。
+// 旧文件和新文件会被完整读入内存，后缀数组额外占用约8倍旧文件大小的内存。
+type suffixArrayAlgorithm struct {
+	config *DiffConfig
+}
+
+func newSuffixArrayAlgorithm(config *DiffConfig) Algorithm {
+	return &suffixArrayAlgorithm{config: config}
+}
+
+func (a *suffixArrayAlgorithm) Name() string {
+	return AlgorithmSuffixArray
+}
+
+func (a *suffixArrayAlgorithm) Diff(oldFilePath, newFilePath string) (*Delta, error) {
+	oldData, err := os.ReadFile(oldFilePath)
+	if err != nil {
+		return nil, NewDiffError("read old file", oldFilePath, err)
+	}
+	if len(oldData) >= math.MaxInt32 {
+		return nil, NewDiffError("generate delta", oldFilePath, ErrMemoryLimitExceeded)
+	}
+
+	newData, err := os.ReadFile(newFilePath)
+	if err != nil {
+		return nil, NewDiffError("read new file", newFilePath, err)
+	}
+
+	delta := DiffBytes(oldData, newData)
+	if a.config.EnableSHA256 {
+		delta.SetChecksum(newData)
+	}
+	return delta, nil
+}
+
+// DiffBytes 使用后缀数组算法生成两段数据之间的差异，不计算校验和
+func DiffBytes(oldData, newData []byte) *Delta {
+	delta := NewDelta(int64(len(oldData)), int64(len(newData)))
+	index := buildSuffixArray(oldData)
+
+	oldSize, newSize := len(oldData), len(newData)
+	var scan, pos, length int
+	var lastScan, lastPos, lastOffset int
+
+	for scan < newSize {
+		oldScore := 0
+
+		// 查找下一个比沿用上一个匹配偏移量更好的匹配
+		scsc := scan + length
+		for scan = scsc; scan < newSize; scan++ {
+			length, pos = index.search(oldData, newData[scan:])
+
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastOffset < oldSize && oldData[scsc+lastOffset] == newData[scsc] {
+					oldScore++
+				}
+			}
+
+			if (length == oldScore && length != 0) || length > oldScore+8 {
+				break
+			}
+
+			if scan+lastOffset < oldSize && oldData[scan+lastOffset] == newData[scan] {
+				oldScore--
+			}
+		}
+
+		if length == oldScore && scan != newSize {
+			continue
+		}
+
+		// 将上一个匹配向后扩展
+		var lenForward int
+		for i, s, best := 0, 0, 0; lastScan+i < scan && lastPos+i < oldSize; {
+			if oldData[lastPos+i] == newData[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > best*2-lenForward {
+				best, lenForward = s, i
+			}
+		}
+
+		// 将当前匹配向前扩展
+		var lenBackward int
+		if scan < newSize {
+			for i, s, best := 1, 0, 0; scan >= lastScan+i && pos >= i; i++ {
+				if oldData[pos-i] == newData[scan-i] {
+					s++
+				}
+				if s*2-i > best*2-lenBackward {
+					best, lenBackward = s, i
+				}
+			}
+		}
+
+		// 两个扩展重叠时选择最佳分界点
+		if lastScan+lenForward > scan-lenBackward {
+			overlap := lastScan + lenForward - (scan - lenBackward)
+			s, best, lenSplit := 0, 0, 0
+			for i := 0; i < overlap; i++ {
+				if newData[lastScan+lenForward-overlap+i] == oldData[lastPos+lenForward-overlap+i] {
+					s++
+				}
+				if newData[scan-lenBackward+i] == oldData[pos-lenBackward+i] {
+					s--
+				}
+				if s > best {
+					best, lenSplit = s, i+1
+				}
+			}
+			lenForward += lenSplit - overlap
+			lenBackward -= lenSplit
+		}
+
+		addApproximateMatch(delta, oldData[lastPos:lastPos+lenForward], newData[lastScan:lastScan+lenForward], lastScan, lastPos)
+
+		extraStart := lastScan + lenForward
+		extraEnd := scan - lenBackward
+		if extraEnd > extraStart {
+			delta.AddOperation(Operation{
+				Type:   OpInsert,
+				Offset: int64(extraStart),
+				Size:   extraEnd - extraStart,
+				Data:   append([]byte(nil), newData[extraStart:extraEnd]...),
+			})
+		}
+
+		lastScan = scan - lenBackward
+		lastPos = pos - lenBackward
+		lastOffset = pos - scan
+	}
+
+	return delta
+}
+
+// addApproximateMatch 添加近似匹配，完全相同时使用复制操作
+func addApproximateMatch(delta *Delta, oldData, newData []byte, offset, srcOffset int) {
+	if len(newData) == 0 {
+		return
+	}
+	if bytes.Equal(oldData, newData) {
+		delta.AddOperation(Operation{
+			Type:      OpCopy,
+			Offset:    int64(offset),
+			Size:      len(newData),
+			SrcOffset: int64(srcOffset),
+		})
+		return
+	}
+
+	diffData := make([]byte, len(newData))
+	for i := range newData {
+		diffData[i] = newData[i] - oldData[i]
+	}
+	delta.AddOperation(Operation{
+		Type:      OpAdd,
+		Offset:    int64(offset),
+		Size:      len(newData),
+		Data:      diffData,
+		SrcOffset: int64(srcOffset),
+	})
+}
+
+// suffixArray 旧文件的后缀数组，包含空后缀
+type suffixArray []int32
+
+// buildSuffixArray 使用Larsson-Sadakane前缀倍增算法构建后缀数组
+func buildSuffixArray(data []byte) suffixArray {
+	n := len(data)
+	index := make([]int32, n+1)
+	rank := make([]int32, n+1)
+
+	var buckets [256]int
+	for _, b := range data {
+		buckets[b]++
+	}
+	for i := 1; i < 256; i++ {
+		buckets[i] += buckets[i-1]
+	}
+	for i := 255; i > 0; i-- {
+		buckets[i] = buckets[i-1]
+	}
+	buckets[0] = 0
+
+	for i, b := range data {
+		buckets[b]++
+		index[buckets[b]] = int32(i)
+	}
+	index[0] = int32(n)
+	for i, b := range data {
+		rank[i] = int32(buckets[b])
+	}
+	rank[n] = 0
+	for i := 1; i < 256; i++ {
+		if buckets[i] == buckets[i-1]+1 {
+			index[buckets[i]] = -1
+		}
+	}
+	index[0] = -1
+
+	// 负值表示已排序的分组及其长度
+	for h := 1; index[0] != -int32(n+1); h += h {
+		length := 0
+		i := 0
+		for i < n+1 {
+			if index[i] < 0 {
+				length -= int(index[i])
+				i -= int(index[i])
+				continue
+			}
+			if length > 0 {
+				index[i-length] = -int32(length)
+			}
+			length = int(rank[index[i]]) + 1 - i
+			splitGroup(index, rank, i, length, h)
+			i += length
+			length = 0
+		}
+		if length > 0 {
+			index[i-length] = -int32(length)
+		}
+	}
+
+	for i := 0; i < n+1; i++ {
+		index[rank[i]] = int32(i)
+	}
+	return index
+}
+
+// splitGroup 按第h个字符之后的排名对分组进行三路划分
+func splitGroup(index, rank []int32, start, length, h int) {
+	key := func(i int) int32 {
+		return rank[int(index[i])+h]
+	}
+
+	if length < 16 {
+		for k := start; k < start+length; {
+			j := 1
+			x := key(k)
+			for i := 1; k+i < start+length; i++ {
+				if key(k+i) < x {
+					x = key(k + i)
+					j = 0
+				}
+				if key(k+i) == x {
+					index[k+j], index[k+i] = index[k+i], index[k+j]
+					j++
+				}
+			}
+			for i := 0; i < j; i++ {
+				rank[index[k+i]] = int32(k + j - 1)
+			}
+			if j == 1 {
+				index[k] = -1
+			}
+			k += j
+		}
+		return
+	}
+
+	x := key(start + length/2)
+	jj, kk := 0, 0
+	for i := start; i < start+length; i++ {
+		if key(i) < x {
+			jj++
+		}
+		if key(i) == x {
+			kk++
+		}
+	}
+	jj += start
+	kk += jj
+
+	i, j, k := start, 0, 0
+	for i < jj {
+		switch v := key(i); {
+		case v < x:
+			i++
+		case v == x:
+			index[i], index[jj+j] = index[jj+j], index[i]
+			j++
+		default:
+			index[i], index[kk+k] = index[kk+k], index[i]
+			k++
+		}
+	}
+	for jj+j < kk {
+		if key(jj+j) == x {
+			j++
+		} else {
+			index[jj+j], index[kk+k] = index[kk+k], index[jj+j]
+			k++
+		}
+	}
+
+	if jj > start {
+		splitGroup(index, rank, start, jj-start, h)
+	}
+	for i := 0; i < kk-jj; i++ {
+		rank[index[jj+i]] = int32(kk - 1)
+	}
+	if jj == kk-1 {
+		index[jj] = -1
+	}
+	if start+length > kk {
+		splitGroup(index, rank, kk, start+length-kk, h)
+	}
+}
+
+// search 在后缀数组中二分查找与target前缀匹配最长的后缀，返回匹配长度和位置
+func (sa suffixArray) search(data, target []byte) (int, int) {
+	lo, hi := 0, len(sa)-1
+	for hi-lo >= 2 {
+		mid := lo + (hi-lo)/2
+		suffix := data[sa[mid]:]
+		if bytes.Compare(suffix[:min(len(suffix), len(target))], target[:min(len(suffix), len(target))]) < 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	x := matchLength(data[sa[lo]:], target)
+	y := matchLength(data[sa[hi]:], target)
+	if x > y {
+		return x, int(sa[lo])
+	}
+	return y, int(sa[hi])
+}
+
+// matchLength 返回两段数据的公共前缀长度
+func matchLength(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,130 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestBuildSuffixArray(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("banana"),
+		bytes.Repeat([]byte("ab"), 50),
+		bytes.Repeat([]byte{0}, 100),
+	}
+	random := make([]byte, 2000)
+	for i := range random {
+		random[i] = byte(rng.Intn(4))
+	}
+	inputs = append(inputs, random)
+
+	for _, data := range inputs {
+		want := make([]int32, len(data)+1)
+		for i := range want {
+			want[i] = int32(i)
+		}
+		sort.Slice(want, func(i, j int) bool {
+			return bytes.Compare(data[want[i]:], data[want[j]:]) < 0
+		})
+
+		got := buildSuffixArray(data)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("buildSuffixArray(%q)[%d] = %d, want %d", data[:min(len(data), 16)], i, got[i], want[i])
+			}
+		}
+	}
+}
+
+// buildExecutableData 模拟可执行文件：指令中嵌入的地址在新版本中整体偏移
+func buildExecutableData(rng *rand.Rand, records int, shift uint32) []byte {
+	data := make([]byte, 0, records*8)
+	for i := range records {
+		data = append(data, byte(0xe8+i%3), byte(rng.Intn(4)), 0x48, 0x89)
+		data = binary.LittleEndian.AppendUint32(data, uint32(0x401000+i*64)+shift)
+	}
+	return data
+}
+
+func TestSuffixArrayAlgorithmApproximateMatches(t *testing.T) {
+	oldData := buildExecutableData(rand.New(rand.NewSource(7)), 4096, 0)
+	newData := buildExecutableData(rand.New(rand.NewSource(7)), 4096, 0x40)
+	newData = append(newData[:1000:1000], append([]byte("new code"), newData[1000:]...)...)
+
+	tmpDir := t.TempDir()
+	oldPath := filepath.Join(tmpDir, "old.bin")
+	newPath := filepath.Join(tmpDir, "new.bin")
+	if err := os.WriteFile(oldPath, oldData, 0644); err != nil {
+		t.Fatalf("write old file: %v", err)
+	}
+	if err := os.WriteFile(newPath, newData, 0644); err != nil {
+		t.Fatalf("write new file: %v", err)
+	}
+
+	config := DefaultDiffConfig()
+	config.Algorithm = AlgorithmSuffixArray
+	engine, err := NewEngine(config)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	delta, err := engine.GenerateDelta(oldPath, newPath)
+	if err != nil {
+		t.Fatalf("GenerateDelta() error = %v", err)
+	}
+	assertDeltaRebuildsTarget(t, oldData, newData, delta)
+
+	// 地址变化应表现为相加操作，只有新增代码需要插入
+	addBytes := 0
+	_, insertBytes := countOperationBytes(delta)
+	for _, op := range delta.Operations {
+		if op.Type == OpAdd {
+			addBytes += op.Size
+		}
+	}
+	if addBytes == 0 {
+		t.Error("expected add operations for shifted addresses")
+	}
+	if insertBytes > 64 {
+		t.Errorf("insert bytes = %d, want at most 64", insertBytes)
+	}
+	if delta.Checksum == [32]byte{} {
+		t.Error("expected target checksum")
+	}
+}
+
+func TestDiffBytesEdgeCases(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldData []byte
+		newData []byte
+	}{
+		{"empty old", nil, []byte("hello")},
+		{"empty new", []byte("hello"), nil},
+		{"identical", []byte("hello world"), []byte("hello world")},
+		{"unrelated", []byte("aaaaaaaa"), []byte("zyxwvuts")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := DiffBytes(tt.oldData, tt.newData)
+			assertDeltaRebuildsTarget(t, tt.oldData, tt.newData, delta)
+		})
+	}
+}
+
+func TestNewEngineRejectsUnknownAlgorithm(t *testing.T) {
+	config := DefaultDiffConfig()
+	config.Algorithm = "unknown"
+	if _, err := NewEngine(config); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("NewEngine() error = %v, want ErrUnknownAlgorithm", err)
+	}
+}
@@ -3,6 +3,8 @@ package diff
 import (
 	"crypto/sha256"
 	"hash/crc32"
+
+	hexhash "github.com/Sky-ey/HexDiff/pkg/hash"
 )
 
 // BlockSize 默认块大小
@@ -10,6 +12,17 @@ const (
 	DefaultBlockSize = 4096  // 4KB
 	MinBlockSize     = 64    // 最小块大小
 	MaxBlockSize     = 65536 // 最大块大小 64KB
+
+	// MaxChunkSizeLimit 基于内容分块时最大分块大小的上限
+	MaxChunkSizeLimit = 16 * 1024 * 1024 // 16MB
+)
+
+// ChunkingMode 签名的分块方式
+type ChunkingMode uint8
+
+const (
+	ChunkingFixed          ChunkingMode = iota // 固定大小分块
+	ChunkingContentDefined                     // 基于内容分块 (FastCDC)
 )
 
 // OperationType 操作类型
@@ -19,6 +32,7 @@ const (
 	OpCopy   OperationType = iota // 复制操作
 	OpInsert                      // 插入操作
 	OpDelete                      // 删除操作
+	OpAdd                         // 相加操作：源数据与Data逐字节相加
 )
 
 // String 返回操作类型的字符串表示
@@ -30,6 +44,8 @@ func (op OperationType) String() string {
 		return "INSERT"
 	case OpDelete:
 		return "DELETE"
+	case OpAdd:
+		return "ADD"
 	default:
 		return "UNKNOWN"
 	}
@@ -49,16 +65,17 @@ type Operation struct {
 	Type      OperationType // 操作类型
 	Offset    int64         // 目标文件偏移量
 	Size      int           // 数据大小
-	Data      []byte        // 操作数据（插入时使用）
-	SrcOffset int64         // 源文件偏移量（复制时使用）
+	Data      []byte        // 操作数据（插入时为新数据，相加时为差值）
+	SrcOffset int64         // 源文件偏移量（复制和相加时使用）
 }
 
 // Signature 文件签名
 type Signature struct {
-	BlockSize int                // 块大小
-	Blocks    map[uint64][]Block // 哈希值到块的映射
-	FileSize  int64              // 文件大小
-	Checksum  [32]byte           // 文件SHA-256校验和
+	BlockSize int                    // 块大小，基于内容分块时为平均分块大小
+	Blocks    map[uint64][]Block     // 哈希值到块的映射
+	FileSize  int64                  // 文件大小
+	Checksum  [32]byte               // 文件SHA-256校验和
+	Chunker   *hexhash.ChunkerConfig // 基于内容分块的参数，为nil时为固定大小分块
 }
 
 // NewSignature 创建新的文件签名
@@ -132,11 +149,17 @@ type MatchResult struct {
 
 // DiffConfig 差异检测配置
 type DiffConfig struct {
-	BlockSize    int   // 块大小
-	WindowSize   int   // 滚动哈希窗口大小
-	EnableCRC32  bool  // 是否启用CRC32校验
-	EnableSHA256 bool  // 是否启用SHA256校验
-	MaxMemory    int64 // 最大内存使用量（字节）
+	BlockSize    int    // 块大小
+	WindowSize   int    // 滚动哈希窗口大小
+	EnableCRC32  bool   // 是否启用CRC32校验
+	EnableSHA256 bool   // 是否启用SHA256校验
+	MaxMemory    int64  // 最大内存使用量（字节）
+	Algorithm    string // 差异算法名称，为空时使用滚动哈希
+
+	Chunking     ChunkingMode // 签名的分块方式
+	MinChunkSize int          // 基于内容分块的最小分块大小
+	AvgChunkSize int          // 基于内容分块的平均分块大小
+	MaxChunkSize int          // 基于内容分块的最大分块大小
 }
 
 // DefaultDiffConfig 默认差异检测配置
@@ -147,6 +170,10 @@ func DefaultDiffConfig() *DiffConfig {
 		EnableCRC32:  true,
 		EnableSHA256: true,
 		MaxMemory:    100 * 1024 * 1024, // 100MB
+		Chunking:     ChunkingFixed,
+		MinChunkSize: hexhash.DefaultMinChunkSize,
+		AvgChunkSize: hexhash.DefaultAvgChunkSize,
+		MaxChunkSize: hexhash.DefaultMaxChunkSize,
 	}
 }
 
@@ -161,5 +188,35 @@ func (c *DiffConfig) Validate() error {
 	if c.MaxMemory < 1024*1024 { // 最小1MB
 		return ErrInvalidMaxMemory
 	}
+	if _, err := NewAlgorithm(c); err != nil {
+		return err
+	}
+	if c.Chunking == ChunkingContentDefined {
+		if err := validateChunkerConfig(c.ChunkerConfig()); err != nil {
+			return err
+		}
+	} else if c.Chunking != ChunkingFixed {
+		return ErrInvalidChunking
+	}
+	return nil
+}
+
+// ChunkerConfig 返回基于内容分块的参数
+func (c *DiffConfig) ChunkerConfig() *hexhash.ChunkerConfig {
+	return &hexhash.ChunkerConfig{
+		MinSize: c.MinChunkSize,
+		AvgSize: c.AvgChunkSize,
+		MaxSize: c.MaxChunkSize,
+	}
+}
+
+// validateChunkerConfig 验证分块参数在签名格式允许的范围内
+func validateChunkerConfig(config *hexhash.ChunkerConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.MinSize < MinBlockSize || config.MaxSize > MaxChunkSizeLimit {
+		return hexhash.ErrInvalidChunkSize
+	}
 	return nil
 }
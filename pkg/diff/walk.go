@@ -11,7 +11,7 @@ import (
 	"sync"
 )
 
-// WalkDirectory 遍历目录获取文件列表
+// WalkDirectory 遍历目录获取文件、目录和符号链接列表
 func WalkDirectory(dirPath string, config *DirDiffConfig) (map[string]*FileEntry, error) {
 	entries := make(map[string]*FileEntry)
 
@@ -20,12 +20,34 @@ func WalkDirectory(dirPath string, config *DirDiffConfig) (map[string]*FileEntry
 		return nil, NewDiffError("abs path", dirPath, err)
 	}
 
-	err = filepath.Walk(absDir, func(path string, info os.FileInfo, err error) error {
+	if err := walkDirectory(absDir, "", config, entries, make(map[string]bool)); err != nil {
+		return nil, NewDiffError("walk directory", dirPath, err)
+	}
+
+	return entries, nil
+}
+
+// walkDirectory 遍历root，条目的相对路径以prefix为前缀
+// 跟随符号链接时，visited记录已经遍历过的真实目录，避免链接形成循环
+func walkDirectory(root, prefix string, config *DirDiffConfig, entries map[string]*FileEntry, visited map[string]bool) error {
+	if config.FollowSymlinks {
+		realPath, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return err
+		}
+		if visited[realPath] {
+			return nil
+		}
+		visited[realPath] = true
+		root = realPath
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		relPath, err := filepath.Rel(absDir, path)
+		relPath, err := filepath.Rel(root, path)
 		if err != nil {
 			return err
 		}
@@ -33,6 +55,7 @@ func WalkDirectory(dirPath string, config *DirDiffConfig) (map[string]*FileEntry
 		if relPath == "." {
 			return nil
 		}
+		relPath = filepath.Join(prefix, relPath)
 
 		if config.IgnoreHidden && strings.HasPrefix(filepath.Base(path), ".") {
 			if info.IsDir() {
@@ -48,22 +71,10 @@ func WalkDirectory(dirPath string, config *DirDiffConfig) (map[string]*FileEntry
 			return nil
 		}
 
-		if !config.Recursive && info.IsDir() && relPath != "." {
+		if !config.Recursive && info.IsDir() {
 			return filepath.SkipDir
 		}
 
-		if info.IsDir() {
-			return nil
-		}
-
-		if config.FollowSymlinks {
-			return nil
-		}
-
-		if info.Mode()&os.ModeSymlink != 0 {
-			return nil
-		}
-
 		entry := &FileEntry{
 			Path:         relPath,
 			RelativePath: filepath.ToSlash(relPath),
@@ -71,18 +82,43 @@ func WalkDirectory(dirPath string, config *DirDiffConfig) (map[string]*FileEntry
 			Size:         info.Size(),
 			Mode:         info.Mode(),
 			MTime:        info.ModTime(),
-			IsDir:        info.IsDir(),
 		}
 
-		entries[filepath.ToSlash(relPath)] = entry
-		return nil
-	})
+		switch {
+		case info.IsDir():
+			entry.IsDir = true
+			entry.Size = 0
+
+		case info.Mode()&os.ModeSymlink != 0:
+			if config.FollowSymlinks {
+				if target, err := os.Stat(path); err == nil {
+					entry.Size, entry.Mode, entry.MTime = target.Size(), target.Mode(), target.ModTime()
+					if target.IsDir() {
+						if !config.Recursive {
+							return nil
+						}
+						entry.IsDir = true
+						entry.Size = 0
+						entries[entry.RelativePath] = entry
+						return walkDirectory(path, relPath, config, entries, visited)
+					}
+					break
+				}
+				// 目标不存在的链接按链接本身记录
+			}
 
-	if err != nil {
-		return nil, NewDiffError("walk directory", dirPath, err)
-	}
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			entry.IsSymlink = true
+			entry.LinkTarget = target
+			entry.Size = int64(len(target))
+		}
 
-	return entries, nil
+		entries[entry.RelativePath] = entry
+		return nil
+	})
 }
 
 // shouldIgnore 检查路径是否应该被忽略
@@ -159,25 +195,10 @@ func CompareDirectories(oldDir, newDir string, config *DirDiffConfig) (*DirDiffR
 				OldEntry:     oldEntry,
 			}
 		} else if oldExists && newExists {
-			if oldEntry.Size == newEntry.Size && oldEntry.MTime.Equal(newEntry.MTime) {
+			if !entryChanged(oldEntry, newEntry) {
 				continue
 			}
 
-			if oldEntry.Size != newEntry.Size {
-				hashOld, err := computeFileHash(oldEntry.AbsPath)
-				if err != nil {
-					continue
-				}
-				hashNew, err := computeFileHash(newEntry.AbsPath)
-				if err != nil {
-					continue
-				}
-
-				if bytes.Equal(hashOld, hashNew) {
-					continue
-				}
-			}
-
 			fileDiff = &FileDiff{
 				RelativePath: path,
 				Status:       StatusModified,
@@ -191,10 +212,50 @@ func CompareDirectories(oldDir, newDir string, config *DirDiffConfig) (*DirDiffR
 		}
 	}
 
+	if err := detectRenames(result, oldEntries, config); err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 
 // computeFileHash 计算文件SHA-256校验和
+// entryChanged 判断同一路径上的条目是否改变
+// 类型不同或权限不同时视为改变；目录只比较权限，符号链接还比较链接目标
+func entryChanged(oldEntry, newEntry *FileEntry) bool {
+	if oldEntry.Kind() != newEntry.Kind() || oldEntry.Mode.Perm() != newEntry.Mode.Perm() {
+		return true
+	}
+
+	switch newEntry.Kind() {
+	case KindDir:
+		return false
+	case KindSymlink:
+		return oldEntry.LinkTarget != newEntry.LinkTarget
+	}
+
+	if oldEntry.Size == newEntry.Size && oldEntry.MTime.Equal(newEntry.MTime) {
+		return false
+	}
+
+	if oldEntry.Size != newEntry.Size {
+		hashOld, err := computeFileHash(oldEntry.AbsPath)
+		if err != nil {
+			return false
+		}
+		hashNew, err := computeFileHash(newEntry.AbsPath)
+		if err != nil {
+			return false
+		}
+
+		if bytes.Equal(hashOld, hashNew) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func computeFileHash(filePath string) ([]byte, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -211,14 +272,73 @@ func computeFileHash(filePath string) ([]byte, error) {
 	return hasher.Sum(nil), nil
 }
 
+// DirDiffSink 接收流式生成的目录差异
+type DirDiffSink interface {
+	// Begin 在比较完成、源文件表确定之后，处理任何文件之前调用
+	Begin(result *DirDiffResult) error
+	// WriteFile 在每个改变的条目处理完成后调用，返回后该条目的差异数据会被释放
+	WriteFile(diff *FileDiff) error
+}
+
 // ProcessDirDiff 处理目录差异，为修改的文件生成补丁
 func ProcessDirDiff(result *DirDiffResult, diffEngine *Engine, config *DirDiffConfig, progress ProgressReporter) error {
+	return ProcessDirDiffTo(result, diffEngine, config, progress, nil)
+}
+
+// ProcessDirDiffTo 处理目录差异，sink不为nil时每个条目处理完成后立即交给sink，
+// 不在内存中保留差异数据，新增文件的内容也不会被读入内存
+func ProcessDirDiffTo(result *DirDiffResult, diffEngine *Engine, config *DirDiffConfig, progress ProgressReporter, sink DirDiffSink) error {
+	var index *sourceIndex
+	similar := result.SimilarFiles()
+	if config.CrossFileDedup && len(result.AddedFiles)+len(result.ModifiedFiles)+len(similar) > 0 {
+		if progress != nil {
+			progress.Message("正在索引旧文件...")
+		}
+		var err error
+		index, err = newSourceIndex(diffEngine, result.OldDir, config)
+		if err != nil {
+			return err
+		}
+		if index.empty() {
+			index = nil
+		} else {
+			result.Sources = index.files
+		}
+	}
+
+	if sink != nil {
+		if err := sink.Begin(result); err != nil {
+			return err
+		}
+	}
+
 	var wg sync.WaitGroup
 	fileChan := make(chan *FileDiff, config.WorkerCount*2)
 	errChan := make(chan error, 1)
 	doneChan := make(chan struct{})
 	progressChan := make(chan int64, config.WorkerCount*2)
 
+	// 只保留第一个错误
+	fail := func(err error) {
+		select {
+		case errChan <- err:
+		default:
+		}
+	}
+	// emit 将处理完成的条目交给sink并释放差异数据，sink不需要支持并发写入
+	var sinkMutex sync.Mutex
+	emit := func(diff *FileDiff) error {
+		if sink == nil {
+			return nil
+		}
+		sinkMutex.Lock()
+		err := sink.WriteFile(diff)
+		sinkMutex.Unlock()
+		diff.Delta = nil
+		diff.PatchData = nil
+		return err
+	}
+
 	if progress != nil {
 		totalBytes := result.TotalBytesToProcess()
 		if totalBytes > 0 {
@@ -252,7 +372,17 @@ func ProcessDirDiff(result *DirDiffResult, diffEngine *Engine, config *DirDiffCo
 		for diff := range fileChan {
 			var fileSize int64
 
-			if diff.Status == StatusModified {
+			// 目录和符号链接没有内容；旧条目不是普通文件时按新增处理
+			if diff.NewEntry != nil && diff.NewEntry.Kind() != KindFile {
+				if err := emit(diff); err != nil {
+					fail(err)
+				}
+				progressChan <- 0
+				wg.Done()
+				continue
+			}
+
+			if diff.Status != StatusAdded && diff.OldEntry.Kind() == KindFile {
 				if diff.OldEntry != nil {
 					fileSize += diff.OldEntry.Size
 				}
@@ -260,27 +390,56 @@ func ProcessDirDiff(result *DirDiffResult, diffEngine *Engine, config *DirDiffCo
 					fileSize += diff.NewEntry.Size
 				}
 
-				delta, err := diffEngine.GenerateDelta(diff.OldEntry.AbsPath, diff.NewEntry.AbsPath)
+				var delta *Delta
+				var err error
+				if index != nil {
+					delta, err = index.generateDelta(diffEngine, diff)
+				} else {
+					delta, err = diffEngine.GenerateDelta(diff.OldEntry.AbsPath, diff.NewEntry.AbsPath)
+				}
 				if err != nil {
-					errChan <- fmt.Errorf("generate delta for %s: %w", diff.RelativePath, err)
+					fail(fmt.Errorf("generate delta for %s: %w", diff.RelativePath, err))
 					wg.Done()
 					continue
 				}
 				diff.Delta = delta
-			} else if diff.Status == StatusAdded {
+				if index != nil {
+					diff.SourceID = index.primarySource(delta)
+				}
+			} else {
 				if diff.NewEntry != nil {
 					fileSize = diff.NewEntry.Size
 				}
 
-				data, err := os.ReadFile(diff.NewEntry.AbsPath)
-				if err != nil {
-					errChan <- fmt.Errorf("read new file %s: %w", diff.RelativePath, err)
-					wg.Done()
-					continue
+				// 新增文件的内容可能来自其他旧文件
+				if index != nil {
+					delta, err := index.generateDelta(diffEngine, diff)
+					if err != nil {
+						fail(fmt.Errorf("generate delta for %s: %w", diff.RelativePath, err))
+						wg.Done()
+						continue
+					}
+					if hasSourceData(delta) {
+						diff.Delta = delta
+						diff.SourceID = index.primarySource(delta)
+					}
+				}
+
+				// 流式处理时由sink直接读取新文件
+				if diff.Delta == nil && sink == nil {
+					data, err := os.ReadFile(diff.NewEntry.AbsPath)
+					if err != nil {
+						fail(fmt.Errorf("read new file %s: %w", diff.RelativePath, err))
+						wg.Done()
+						continue
+					}
+					diff.PatchData = data
 				}
-				diff.PatchData = data
 			}
 
+			if err := emit(diff); err != nil {
+				fail(err)
+			}
 			progressChan <- fileSize
 			wg.Done()
 		}
@@ -297,6 +456,12 @@ func ProcessDirDiff(result *DirDiffResult, diffEngine *Engine, config *DirDiffCo
 		fileChan <- diff
 	}
 
+	// 内容相同的重命名和复制不需要差异
+	for _, diff := range similar {
+		wg.Add(1)
+		fileChan <- diff
+	}
+
 	close(fileChan)
 	wg.Wait()
 	close(progressChan)
@@ -307,6 +472,20 @@ func ProcessDirDiff(result *DirDiffResult, diffEngine *Engine, config *DirDiffCo
 	default:
 	}
 
+	// 删除的条目以及内容相同的重命名和复制没有差异数据
+	if sink != nil {
+		for _, list := range [][]*FileDiff{result.DeletedFiles, result.RenamedFiles, result.CopiedFiles} {
+			for _, diff := range list {
+				if diff.Status != StatusDeleted && diff.Similarity < 1 {
+					continue
+				}
+				if err := emit(diff); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
 	if progress != nil {
 		progress.Message("完成")
 	}
@@ -1,6 +1,8 @@
 package diff
 
 import (
+	"bytes"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
@@ -35,8 +37,8 @@ func TestWalkDirectory(t *testing.T) {
 		t.Fatalf("WalkDirectory() error = %v", err)
 	}
 
-	if len(entries) != 3 {
-		t.Errorf("Expected 3 entries, got %d", len(entries))
+	if len(entries) != 4 {
+		t.Errorf("Expected 4 entries, got %d", len(entries))
 	}
 
 	if _, ok := entries["file1.txt"]; !ok {
@@ -45,6 +47,56 @@ func TestWalkDirectory(t *testing.T) {
 	if _, ok := entries["subdir/file3.txt"]; !ok {
 		t.Error("Expected subdir/file3.txt in entries")
 	}
+	if entry, ok := entries["subdir"]; !ok || entry.Kind() != KindDir {
+		t.Error("Expected subdir directory in entries")
+	}
+}
+
+func TestWalkDirectorySymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte("content"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "empty"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "real"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "real", "inner.txt"), []byte("inner"), 0644)
+	if err := os.Symlink("file.txt", filepath.Join(tmpDir, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	os.Symlink("real", filepath.Join(tmpDir, "dirlink"))
+	os.Symlink("missing", filepath.Join(tmpDir, "broken"))
+
+	entries, err := WalkDirectory(tmpDir, &DirDiffConfig{Recursive: true})
+	if err != nil {
+		t.Fatalf("WalkDirectory() error = %v", err)
+	}
+
+	if entry := entries["empty"]; entry == nil || entry.Kind() != KindDir {
+		t.Error("Expected empty directory in entries")
+	}
+	for path, target := range map[string]string{"link": "file.txt", "dirlink": "real", "broken": "missing"} {
+		entry := entries[path]
+		if entry == nil || entry.Kind() != KindSymlink || entry.LinkTarget != target {
+			t.Errorf("%s: expected symlink to %s, got %+v", path, target, entry)
+		}
+	}
+
+	// 跟随链接时链接按目标记录，目录链接的内容也会被遍历
+	entries, err = WalkDirectory(tmpDir, &DirDiffConfig{Recursive: true, FollowSymlinks: true})
+	if err != nil {
+		t.Fatalf("WalkDirectory() error = %v", err)
+	}
+	if entry := entries["link"]; entry == nil || entry.Kind() != KindFile || entry.Size != 7 {
+		t.Errorf("link: expected followed file, got %+v", entry)
+	}
+	if entry := entries["dirlink"]; entry == nil || entry.Kind() != KindDir {
+		t.Errorf("dirlink: expected followed directory, got %+v", entry)
+	}
+	if _, ok := entries["dirlink/inner.txt"]; !ok {
+		t.Error("Expected dirlink/inner.txt in entries")
+	}
+	if entry := entries["broken"]; entry == nil || entry.Kind() != KindSymlink {
+		t.Errorf("broken: expected symlink, got %+v", entry)
+	}
 }
 
 func TestWalkDirectoryNonRecursive(t *testing.T) {
@@ -323,3 +375,161 @@ func TestProcessDirDiff(t *testing.T) {
 		}
 	}
 }
+
+func TestCompareDirectoriesDetectsRenames(t *testing.T) {
+	oldDir := t.TempDir()
+	newDir := t.TempDir()
+
+	rng := rand.New(rand.NewSource(2))
+	moved := make([]byte, 8*1024)
+	edited := make([]byte, 32*1024)
+	rng.Read(moved)
+	rng.Read(edited)
+
+	os.WriteFile(filepath.Join(oldDir, "moved.bin"), moved, 0644)
+	os.WriteFile(filepath.Join(oldDir, "edited.bin"), edited, 0644)
+	os.WriteFile(filepath.Join(oldDir, "kept.txt"), []byte("shared content"), 0644)
+	os.WriteFile(filepath.Join(oldDir, "gone.txt"), []byte("deleted"), 0644)
+
+	os.MkdirAll(filepath.Join(newDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(newDir, "sub", "moved.bin"), moved, 0644)
+	changed := append(bytes.Clone(edited[:16*1024]), edited[16*1024+100:]...)
+	os.WriteFile(filepath.Join(newDir, "renamed.bin"), changed, 0644)
+	os.WriteFile(filepath.Join(newDir, "kept.txt"), []byte("shared content"), 0644)
+	os.WriteFile(filepath.Join(newDir, "kept-copy.txt"), []byte("shared content"), 0644)
+
+	config := DefaultDirDiffConfig()
+	result, err := CompareDirectories(oldDir, newDir, config)
+	if err != nil {
+		t.Fatalf("CompareDirectories() error = %v", err)
+	}
+
+	want := map[string]struct {
+		status  FileStatus
+		oldPath string
+	}{
+		"sub/moved.bin": {StatusRenamed, "moved.bin"},
+		"renamed.bin":   {StatusRenamed, "edited.bin"},
+		"kept-copy.txt": {StatusCopied, "kept.txt"},
+		"gone.txt":      {StatusDeleted, ""},
+	}
+	for path, w := range want {
+		f := result.Files[path]
+		if f == nil {
+			t.Errorf("%s: missing from result", path)
+			continue
+		}
+		if f.Status != w.status {
+			t.Errorf("%s: status = %v, want %v", path, f.Status, w.status)
+		}
+		if w.oldPath != "" && f.OldEntry.RelativePath != w.oldPath {
+			t.Errorf("%s: old path = %s, want %s", path, f.OldEntry.RelativePath, w.oldPath)
+		}
+	}
+	if _, ok := result.Files["moved.bin"]; ok {
+		t.Error("renamed file should not be reported as deleted")
+	}
+	// 只剩下新建的目录
+	if len(result.AddedFiles) != 1 || result.AddedFiles[0].RelativePath != "sub" {
+		t.Errorf("AddedFiles count = %d, want only sub", len(result.AddedFiles))
+	}
+	if f := result.Files["sub/moved.bin"]; f.Similarity != 1 {
+		t.Errorf("exact rename similarity = %v, want 1", f.Similarity)
+	}
+	if f := result.Files["renamed.bin"]; f.Similarity >= 1 || f.Similarity < config.RenameThreshold {
+		t.Errorf("similar rename similarity = %v", f.Similarity)
+	}
+
+	// 只有内容不同的重命名需要生成差异
+	diffEngine, err := NewEngine(DefaultDiffConfig())
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	if err := ProcessDirDiff(result, diffEngine, config, nil); err != nil {
+		t.Fatalf("ProcessDirDiff() error = %v", err)
+	}
+	if result.Files["sub/moved.bin"].Delta != nil {
+		t.Error("exact rename should not have a delta")
+	}
+	if result.Files["renamed.bin"].Delta == nil {
+		t.Error("similar rename should have a delta")
+	}
+
+	config.DetectRenames = false
+	result, err = CompareDirectories(oldDir, newDir, config)
+	if err != nil {
+		t.Fatalf("CompareDirectories() error = %v", err)
+	}
+	if len(result.RenamedFiles)+len(result.CopiedFiles) != 0 {
+		t.Error("rename detection should be disabled")
+	}
+}
+
+// collectSink 记录写入的条目及写入时是否带有差异数据
+type collectSink struct {
+	begun   bool
+	written map[string]bool
+}
+
+func (s *collectSink) Begin(result *DirDiffResult) error {
+	s.begun = true
+	s.written = make(map[string]bool)
+	return nil
+}
+
+func (s *collectSink) WriteFile(diff *FileDiff) error {
+	s.written[diff.RelativePath] = diff.Delta != nil || diff.PatchData != nil
+	return nil
+}
+
+func TestProcessDirDiffToSink(t *testing.T) {
+	oldDir := t.TempDir()
+	newDir := t.TempDir()
+
+	os.WriteFile(filepath.Join(oldDir, "modified.txt"), []byte("hello world"), 0644)
+	os.WriteFile(filepath.Join(oldDir, "deleted.txt"), []byte("deleted"), 0644)
+	os.WriteFile(filepath.Join(oldDir, "moved.txt"), []byte("moved content"), 0644)
+	os.WriteFile(filepath.Join(newDir, "modified.txt"), []byte("hello go, longer"), 0644)
+	os.WriteFile(filepath.Join(newDir, "added.txt"), []byte("new file"), 0644)
+	os.WriteFile(filepath.Join(newDir, "renamed.txt"), []byte("moved content"), 0644)
+
+	config := DefaultDirDiffConfig()
+	result, err := CompareDirectories(oldDir, newDir, config)
+	if err != nil {
+		t.Fatalf("CompareDirectories() error = %v", err)
+	}
+
+	diffEngine, err := NewEngine(DefaultDiffConfig())
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+
+	sink := &collectSink{}
+	if err := ProcessDirDiffTo(result, diffEngine, config, nil, sink); err != nil {
+		t.Fatalf("ProcessDirDiffTo() error = %v", err)
+	}
+
+	if !sink.begun {
+		t.Fatal("sink.Begin() was not called")
+	}
+	for path, hasData := range map[string]bool{
+		"modified.txt": true,
+		"added.txt":    false,
+		"deleted.txt":  false,
+		"renamed.txt":  false,
+	} {
+		got, ok := sink.written[path]
+		if !ok {
+			t.Errorf("%s: not written to sink", path)
+		} else if got != hasData {
+			t.Errorf("%s: written with data = %v, want %v", path, got, hasData)
+		}
+	}
+
+	// 写入sink后不再保留差异数据
+	for _, f := range result.ModifiedFiles {
+		if f.Delta != nil {
+			t.Errorf("%s: Delta kept after streaming", f.RelativePath)
+		}
+	}
+}
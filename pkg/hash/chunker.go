@@ -0,0 +1,161 @@
+package hash
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// 基于内容分块的默认参数
+const (
+	DefaultMinChunkSize = 2 * 1024  // 2KB
+	DefaultAvgChunkSize = 8 * 1024  // 8KB
+	DefaultMaxChunkSize = 64 * 1024 // 64KB
+)
+
+// ErrInvalidChunkSize 分块大小参数无效
+var ErrInvalidChunkSize = errors.New("invalid chunk size: require 0 < min <= avg <= max")
+
+// gearTable Gear哈希使用的随机表，由固定种子生成以保证不同机器上分块结果一致
+var gearTable = newGearTable()
+
+func newGearTable() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x4845584449464600) // "HEXDIFF"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+// ChunkerConfig 基于内容分块的参数
+type ChunkerConfig struct {
+	MinSize int // 最小分块大小
+	AvgSize int // 期望的平均分块大小
+	MaxSize int // 最大分块大小
+}
+
+// DefaultChunkerConfig 默认分块参数
+func DefaultChunkerConfig() *ChunkerConfig {
+	return &ChunkerConfig{
+		MinSize: DefaultMinChunkSize,
+		AvgSize: DefaultAvgChunkSize,
+		MaxSize: DefaultMaxChunkSize,
+	}
+}
+
+// Validate 验证分块参数
+func (c *ChunkerConfig) Validate() error {
+	if c.MinSize <= 0 || c.MinSize > c.AvgSize || c.AvgSize > c.MaxSize {
+		return ErrInvalidChunkSize
+	}
+	return nil
+}
+
+// Chunker FastCDC 分块器
+//
+// 使用Gear哈希寻找分块边界，并采用归一化分块：未达到平均大小前使用更严格的掩码，
+// 之后使用更宽松的掩码，使分块大小集中在平均值附近。
+// 分块边界只取决于分块内的数据，插入或删除数据只会影响附近的分块。
+type Chunker struct {
+	reader io.Reader
+	config ChunkerConfig
+	maskS  uint64 // 未达到平均大小时的掩码
+	maskL  uint64 // 超过平均大小后的掩码
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+// NewChunker 创建分块器，config为nil时使用默认参数
+func NewChunker(reader io.Reader, config *ChunkerConfig) *Chunker {
+	if config == nil {
+		config = DefaultChunkerConfig()
+	}
+
+	// 平均大小为2^n时，边界概率约为2^-n
+	n := bits.Len(uint(config.AvgSize)) - 1
+	return &Chunker{
+		reader: reader,
+		config: *config,
+		maskS:  highBitsMask(n + 2),
+		maskL:  highBitsMask(max(n-2, 1)),
+		buf:    make([]byte, 2*config.MaxSize),
+	}
+}
+
+// highBitsMask 返回高n位为1的掩码，Gear哈希的高位受更长的窗口影响
+func highBitsMask(n int) uint64 {
+	n = min(n, 63)
+	return ^uint64(0) << (64 - n)
+}
+
+// Next 返回下一个分块，数据读完时返回io.EOF
+// 返回的切片在下一次调用前有效
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.config.MaxSize && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.Boundary(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill 将剩余数据移到缓冲区开头并读满缓冲区
+func (c *Chunker) fill() error {
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Boundary 返回data中第一个分块的长度，data短于最大分块大小时视为数据末尾
+func (c *Chunker) Boundary(data []byte) int {
+	n := len(data)
+	if n <= c.config.MinSize {
+		return n
+	}
+	n = min(n, c.config.MaxSize)
+	normal := min(n, c.config.AvgSize)
+
+	var fp uint64
+	i := c.config.MinSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
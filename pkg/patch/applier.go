@@ -27,6 +27,9 @@ type ApplierConfig struct {
 	EnableRealtime  bool   // 是否启用实时验证
 	EnableRecovery  bool   // 是否启用恢复功能
 	BlockSize       int    // 完整性检查块大小
+
+	Resumable          bool  // 是否写入检查点，中断后再次应用时从检查点继续
+	CheckpointInterval int64 // 保存检查点的间隔字节数，0时使用DefaultCheckpointInterval
 }
 
 // DefaultApplierConfig 默认配置
@@ -80,7 +83,15 @@ func NewApplier(config *ApplierConfig) *Applier {
 	return applier
 }
 
+// SetResumable 设置是否以可恢复的方式应用单文件补丁，见ApplyPatch
+func (a *Applier) SetResumable(enabled bool) {
+	a.config.Resumable = enabled
+}
+
 // ApplyPatch 应用补丁到文件
+//
+// 启用Resumable时，输出先写入目标文件旁的部分文件并定期保存检查点，
+// 中断后再次调用会校验已写入的部分并从检查点继续。
 func (a *Applier) ApplyPatch(sourceFilePath, patchFilePath, targetFilePath string) (*ApplyResult, error) {
 	// 验证输入文件
 	if err := a.validateInputFiles(sourceFilePath, patchFilePath); err != nil {
@@ -99,6 +110,11 @@ func (a *Applier) ApplyPatch(sourceFilePath, patchFilePath, targetFilePath strin
 		return nil, fmt.Errorf("verify source file: %w", err)
 	}
 
+	// 按目标偏移量顺序写入的补丁可以从检查点继续
+	if a.config.Resumable && sequentialOperations(patchFile) {
+		return a.applyResumable(sourceFilePath, patchFilePath, patchFile, targetFilePath)
+	}
+
 	// 创建临时文件进行原子操作
 	tempFile, err := a.createTempFile(targetFilePath)
 	if err != nil {
@@ -223,6 +239,16 @@ func (a *Applier) applyOperations(sourceFilePath string, patchFile *PatchFile, t
 	}
 	defer sourceFile.Close()
 
+	result, err := a.applyOperationsFrom(sourceFile, patchFile, targetFilePath)
+	if err != nil {
+		return nil, err
+	}
+	result.SourceFilePath = sourceFilePath
+	return result, nil
+}
+
+// applyOperationsFrom 从任意可随机读取的源应用补丁操作
+func (a *Applier) applyOperationsFrom(source io.ReaderAt, patchFile *PatchFile, targetFilePath string) (*ApplyResult, error) {
 	// 创建目标文件
 	targetFile, err := os.Create(targetFilePath)
 	if err != nil {
@@ -231,7 +257,6 @@ func (a *Applier) applyOperations(sourceFilePath string, patchFile *PatchFile, t
 	defer targetFile.Close()
 
 	result := &ApplyResult{
-		SourceFilePath:    sourceFilePath,
 		PatchFilePath:     "",
 		OperationsApplied: 0,
 		BytesProcessed:    0,
@@ -239,7 +264,7 @@ func (a *Applier) applyOperations(sourceFilePath string, patchFile *PatchFile, t
 
 	// 按顺序应用每个操作
 	for i, op := range patchFile.Operations {
-		if err := a.applyOperation(sourceFile, targetFile, &op, patchFile.Data, result); err != nil {
+		if err := a.applyOperation(source, targetFile, &op, patchFile.Data, result); err != nil {
 			return nil, fmt.Errorf("apply operation %d: %w", i, err)
 		}
 		result.OperationsApplied++
@@ -249,38 +274,41 @@ func (a *Applier) applyOperations(sourceFilePath string, patchFile *PatchFile, t
 }
 
 // applyOperation 应用单个操作
-func (a *Applier) applyOperation(sourceFile, targetFile *os.File, op *PatchOperation, patchData []byte, result *ApplyResult) error {
+func (a *Applier) applyOperation(source io.ReaderAt, targetFile *os.File, op *PatchOperation, patchData []byte, result *ApplyResult) error {
 	// 定位到目标文件的指定偏移量
 	if _, err := targetFile.Seek(int64(op.Offset), 0); err != nil {
 		return fmt.Errorf("seek target file: %w", err)
 	}
 
+	return a.writeOperation(source, targetFile, op, patchData, result)
+}
+
+// writeOperation 在目标的当前位置写入单个操作的输出
+func (a *Applier) writeOperation(source io.ReaderAt, targetFile io.Writer, op *PatchOperation, patchData []byte, result *ApplyResult) error {
 	switch op.Type {
 	case 0: // Copy操作
-		return a.applyCopyOperation(sourceFile, targetFile, op, result)
+		return a.applyCopyOperation(source, targetFile, op, result)
 	case 1: // Insert操作
 		return a.applyInsertOperation(targetFile, op, patchData, result)
 	case 2: // Delete操作
 		return a.applyDeleteOperation(op, result)
+	case 3: // Add操作
+		return a.applyAddOperation(source, targetFile, op, patchData, result)
 	default:
 		return fmt.Errorf("unknown operation type: %d", op.Type)
 	}
 }
 
 // applyCopyOperation 应用复制操作
-func (a *Applier) applyCopyOperation(sourceFile, targetFile *os.File, op *PatchOperation, result *ApplyResult) error {
-	// 定位到源文件的指定位置
-	if _, err := sourceFile.Seek(int64(op.SrcOffset), 0); err != nil {
-		return fmt.Errorf("seek source file: %w", err)
-	}
-
-	// 复制指定大小的数据
-	buffer := make([]byte, min(int(op.Size), a.config.BufferSize))
-	remaining := int(op.Size)
+func (a *Applier) applyCopyOperation(source io.ReaderAt, targetFile io.Writer, op *PatchOperation, result *ApplyResult) error {
+	// 从源的指定位置开始复制指定大小的数据
+	buffer := make([]byte, min(op.Size, uint64(a.config.BufferSize)))
+	remaining := int64(op.Size)
+	srcOffset := int64(op.SrcOffset)
 
 	for remaining > 0 {
-		toRead := min(remaining, len(buffer))
-		n, err := sourceFile.Read(buffer[:toRead])
+		toRead := min(remaining, int64(len(buffer)))
+		n, err := source.ReadAt(buffer[:toRead], srcOffset)
 		if err != nil && err != io.EOF {
 			return fmt.Errorf("read from source: %w", err)
 		}
@@ -293,7 +321,8 @@ func (a *Applier) applyCopyOperation(sourceFile, targetFile *os.File, op *PatchO
 			return fmt.Errorf("write to target: %w", err)
 		}
 
-		remaining -= n
+		remaining -= int64(n)
+		srcOffset += int64(n)
 		result.BytesProcessed += int64(n)
 	}
 
@@ -301,9 +330,9 @@ func (a *Applier) applyCopyOperation(sourceFile, targetFile *os.File, op *PatchO
 }
 
 // applyInsertOperation 应用插入操作
-func (a *Applier) applyInsertOperation(targetFile *os.File, op *PatchOperation, patchData []byte, result *ApplyResult) error {
+func (a *Applier) applyInsertOperation(targetFile io.Writer, op *PatchOperation, patchData []byte, result *ApplyResult) error {
 	// 从补丁数据中获取要插入的数据
-	if op.DataOffset+op.Size > uint32(len(patchData)) {
+	if op.DataOffset > uint64(len(patchData)) || op.Size > uint64(len(patchData))-op.DataOffset {
 		return fmt.Errorf("insert data out of bounds: offset=%d, size=%d, total=%d",
 			op.DataOffset, op.Size, len(patchData))
 	}
@@ -319,6 +348,36 @@ func (a *Applier) applyInsertOperation(targetFile *os.File, op *PatchOperation,
 	return nil
 }
 
+// applyAddOperation 应用相加操作：源数据与补丁中的差值逐字节相加
+func (a *Applier) applyAddOperation(source io.ReaderAt, targetFile io.Writer, op *PatchOperation, patchData []byte, result *ApplyResult) error {
+	if op.DataOffset > uint64(len(patchData)) || op.Size > uint64(len(patchData))-op.DataOffset {
+		return fmt.Errorf("add data out of bounds: offset=%d, size=%d, total=%d",
+			op.DataOffset, op.Size, len(patchData))
+	}
+	diffData := patchData[op.DataOffset : op.DataOffset+op.Size]
+
+	buffer := make([]byte, min(op.Size, uint64(a.config.BufferSize)))
+	srcOffset := int64(op.SrcOffset)
+	for len(diffData) > 0 {
+		n := min(len(diffData), len(buffer))
+		if _, err := source.ReadAt(buffer[:n], srcOffset); err != nil {
+			return fmt.Errorf("read from source: %w", err)
+		}
+		for i := range n {
+			buffer[i] += diffData[i]
+		}
+		if _, err := targetFile.Write(buffer[:n]); err != nil {
+			return fmt.Errorf("write to target: %w", err)
+		}
+
+		diffData = diffData[n:]
+		srcOffset += int64(n)
+		result.BytesProcessed += int64(n)
+	}
+
+	return nil
+}
+
 // applyDeleteOperation 应用删除操作
 func (a *Applier) applyDeleteOperation(op *PatchOperation, result *ApplyResult) error {
 	// 删除操作在当前实现中是隐式的（不复制被删除的数据）
@@ -335,6 +394,7 @@ type ApplyResult struct {
 	Success           bool   // 是否成功
 	OperationsApplied int    // 已应用的操作数
 	BytesProcessed    int64  // 处理的字节数
+	ResumedBytes      int64  // 从检查点继续时已写入的字节数
 }
 
 // String 返回结果的字符串表示
@@ -400,19 +460,40 @@ func (a *Applier) ApplyDelta(sourceFilePath string, deltaData []byte, targetFile
 		}
 	}
 
+	sourceFile, err := os.Open(sourceFilePath)
+	if err != nil {
+		return fmt.Errorf("open source file: %w", err)
+	}
+	defer sourceFile.Close()
+
+	return a.applyDeltaFrom(sourceFile, patchFile, targetFilePath)
+}
+
+// ApplyDeltaFrom 从任意可随机读取的源应用内存中的补丁数据，不校验源数据
+func (a *Applier) ApplyDeltaFrom(source io.ReaderAt, deltaData []byte, targetFilePath string) error {
+	serializer := NewSerializer(CompressionNone)
+	patchFile, err := serializer.DeserializeFromData(deltaData)
+	if err != nil {
+		return fmt.Errorf("deserialize delta: %w", err)
+	}
+
+	return a.applyDeltaFrom(source, patchFile, targetFilePath)
+}
+
+func (a *Applier) applyDeltaFrom(source io.ReaderAt, patchFile *PatchFile, targetFilePath string) error {
 	tempFile, err := a.createTempFile(targetFilePath)
 	if err != nil {
 		return fmt.Errorf("create temp file: %w", err)
 	}
 	defer os.Remove(tempFile)
 
-	_, err = a.applyOperations(sourceFilePath, patchFile, tempFile)
+	_, err = a.applyOperationsFrom(source, patchFile, tempFile)
 	if err != nil {
 		return fmt.Errorf("apply operations: %w", err)
 	}
 
 	targetChecksum := patchFile.Header.TargetChecksum
-	isZeroChecksum = true
+	isZeroChecksum := true
 	for _, b := range targetChecksum {
 		if b != 0 {
 			isZeroChecksum = false
@@ -0,0 +1,275 @@
+package patch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	partialSuffix    = ".hexdiff-partial" // 可恢复应用的部分输出文件后缀
+	checkpointSuffix = ".ckpt"            // 检查点文件在部分输出文件名后的后缀
+
+	// DefaultCheckpointInterval 默认每写入64MB保存一次检查点
+	DefaultCheckpointInterval = 64 * 1024 * 1024
+)
+
+// applyCheckpoint 可恢复应用的检查点
+type applyCheckpoint struct {
+	PatchChecksum string `json:"patch_checksum"`
+	Operation     int    `json:"operation"` // 最后完成的操作索引，-1表示没有
+	Offset        int64  `json:"offset"`    // 已写入的输出字节数
+	Hash          string `json:"hash"`      // 已写入部分的SHA-256
+}
+
+// partialPath 返回目标文件对应的部分输出文件
+func partialPath(targetFilePath string) string {
+	return filepath.Join(filepath.Dir(targetFilePath), "."+filepath.Base(targetFilePath)+partialSuffix)
+}
+
+// CheckpointPath 返回目标文件对应的检查点文件
+func CheckpointPath(targetFilePath string) string {
+	return partialPath(targetFilePath) + checkpointSuffix
+}
+
+// HasCheckpoint 目标文件是否有未完成的可恢复应用
+func HasCheckpoint(targetFilePath string) bool {
+	_, err := os.Stat(CheckpointPath(targetFilePath))
+	return err == nil
+}
+
+// sequentialOperations 补丁的写入操作是否从零开始按目标偏移量连续排列
+func sequentialOperations(patchFile *PatchFile) bool {
+	var offset uint64
+	for _, op := range patchFile.Operations {
+		if op.Type == 2 {
+			continue
+		}
+		if op.Offset != offset {
+			return false
+		}
+		offset += op.Size
+	}
+	return true
+}
+
+// checkpointWriter 写入部分输出文件，同时计算已写入内容的哈希
+type checkpointWriter struct {
+	file   *os.File
+	hash   hash.Hash
+	offset int64
+}
+
+func (w *checkpointWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.hash.Write(p[:n])
+	w.offset += int64(n)
+	return n, err
+}
+
+// applyResumable 以可恢复的方式应用补丁
+func (a *Applier) applyResumable(sourceFilePath, patchFilePath string, patchFile *PatchFile, targetFilePath string) (*ApplyResult, error) {
+	checksum, err := calculateFileChecksum(patchFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("calculate patch checksum: %w", err)
+	}
+	patchChecksum := hex.EncodeToString(checksum[:])
+
+	sourceFile, err := os.Open(sourceFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("open source file: %w", err)
+	}
+	defer sourceFile.Close()
+
+	partial := partialPath(targetFilePath)
+	checkpointFile := CheckpointPath(targetFilePath)
+	file, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open partial output: %w", err)
+	}
+	defer file.Close()
+
+	w := &checkpointWriter{file: file, hash: sha256.New()}
+	checkpoint := loadCheckpoint(checkpointFile, patchChecksum)
+	if checkpoint == nil || checkpoint.Operation >= len(patchFile.Operations) ||
+		checkpoint.Offset > int64(patchFile.Header.TargetSize) || !w.resume(checkpoint) {
+		checkpoint = &applyCheckpoint{PatchChecksum: patchChecksum, Operation: -1}
+		w.hash.Reset()
+		w.offset = 0
+	}
+	if err := file.Truncate(w.offset); err != nil {
+		return nil, fmt.Errorf("truncate partial output: %w", err)
+	}
+	if _, err := file.Seek(w.offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek partial output: %w", err)
+	}
+
+	result := &ApplyResult{
+		SourceFilePath:    sourceFilePath,
+		PatchFilePath:     patchFilePath,
+		OperationsApplied: checkpoint.Operation + 1,
+		ResumedBytes:      w.offset,
+	}
+
+	interval := a.config.CheckpointInterval
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	next := w.offset + interval
+
+	for i := checkpoint.Operation + 1; i < len(patchFile.Operations); i++ {
+		op := patchFile.Operations[i]
+		if op.Type != 2 {
+			// 跳过检查点之前已写入的部分
+			if end := int64(op.Offset + op.Size); end <= w.offset {
+				result.OperationsApplied++
+				continue
+			}
+			if skip := w.offset - int64(op.Offset); skip > 0 {
+				op = sliceOperation(op, uint64(skip), op.Size-uint64(skip))
+			}
+		}
+
+		// 按检查点间隔分段写入，每段之后保存检查点
+		for op.Type != 2 && w.offset+int64(op.Size) > next {
+			n := uint64(next - w.offset)
+			head := sliceOperation(op, 0, n)
+			if err := a.writeOperation(sourceFile, w, &head, patchFile.Data, result); err != nil {
+				return nil, fmt.Errorf("apply operation %d: %w", i, err)
+			}
+			op = sliceOperation(op, n, op.Size-n)
+			if err := w.checkpoint(checkpointFile, patchChecksum, i-1); err != nil {
+				return nil, err
+			}
+			next = w.offset + interval
+		}
+		if err := a.writeOperation(sourceFile, w, &op, patchFile.Data, result); err != nil {
+			return nil, fmt.Errorf("apply operation %d: %w", i, err)
+		}
+		result.OperationsApplied++
+	}
+
+	// 已写入内容的哈希即为目标文件的校验和，不需要重新读取
+	if a.config.VerifyTarget && !emptyChecksum(patchFile.Header.TargetChecksum) {
+		var actual [32]byte
+		copy(actual[:], w.hash.Sum(nil))
+		if actual != patchFile.Header.TargetChecksum {
+			file.Close()
+			os.Remove(partial)
+			os.Remove(checkpointFile)
+			return nil, fmt.Errorf("verify target file: target file checksum mismatch: expected %x, got %x",
+				patchFile.Header.TargetChecksum, actual)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("sync partial output: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("close partial output: %w", err)
+	}
+
+	if a.config.BackupEnabled {
+		if err := a.createBackup(targetFilePath); err != nil {
+			return nil, fmt.Errorf("create backup: %w", err)
+		}
+	}
+	if err := a.atomicReplace(partial, targetFilePath); err != nil {
+		return nil, fmt.Errorf("atomic replace: %w", err)
+	}
+	os.Remove(checkpointFile)
+
+	result.TargetFilePath = targetFilePath
+	result.Success = true
+	return result, nil
+}
+
+// resume 校验部分输出文件中检查点之前的内容，一致时定位到检查点
+func (w *checkpointWriter) resume(checkpoint *applyCheckpoint) bool {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	if _, err := io.CopyN(w.hash, w.file, checkpoint.Offset); err != nil {
+		return false
+	}
+	if hex.EncodeToString(w.hash.Sum(nil)) != checkpoint.Hash {
+		return false
+	}
+	w.offset = checkpoint.Offset
+	return true
+}
+
+// checkpoint 将已写入的内容落盘后保存检查点
+func (w *checkpointWriter) checkpoint(path, patchChecksum string, operation int) error {
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("sync partial output: %w", err)
+	}
+	return writeFileSync(path, &applyCheckpoint{
+		PatchChecksum: patchChecksum,
+		Operation:     operation,
+		Offset:        w.offset,
+		Hash:          hex.EncodeToString(w.hash.Sum(nil)),
+	})
+}
+
+// sliceOperation 返回操作输出中从skip开始、长度为size的部分
+func sliceOperation(op PatchOperation, skip, size uint64) PatchOperation {
+	op.Offset += skip
+	op.Size = size
+	switch op.Type {
+	case 0: // Copy
+		op.SrcOffset += skip
+	case 1: // Insert
+		op.DataOffset += skip
+	case 3: // Add
+		op.SrcOffset += skip
+		op.DataOffset += skip
+	}
+	return op
+}
+
+// loadCheckpoint 读取检查点，不存在、损坏或属于其他补丁时返回nil
+func loadCheckpoint(path, patchChecksum string) *applyCheckpoint {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var checkpoint applyCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil
+	}
+	if checkpoint.PatchChecksum != patchChecksum || checkpoint.Operation < -1 || checkpoint.Offset < 0 {
+		return nil
+	}
+	return &checkpoint
+}
+
+// writeFileSync 将v编码为JSON，先写入临时文件并落盘再重命名，断电时不会留下写了一半的文件
+func writeFileSync(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode checkpoint: %w", err)
+	}
+
+	tempPath := path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("create checkpoint: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("sync checkpoint: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close checkpoint: %w", err)
+	}
+	return os.Rename(tempPath, path)
+}
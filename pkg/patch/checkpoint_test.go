@@ -0,0 +1,89 @@
+package patch
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// interruptApply 截断内存中的补丁数据后以可恢复方式应用，模拟写入插入数据时被中断
+func interruptApply(t *testing.T, applier *Applier, oldFile, patchPath, output string) {
+	t.Helper()
+	patchFile, err := NewSerializer(CompressionNone).DeserializePatch(patchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchFile.Data = patchFile.Data[:len(patchFile.Data)/2]
+	if _, err := applier.applyResumable(oldFile, patchPath, patchFile, output); err == nil {
+		t.Fatal("applyResumable() with truncated data succeeded")
+	}
+	if !HasCheckpoint(output) {
+		t.Fatal("no checkpoint left after interrupted apply")
+	}
+}
+
+func TestApplyPatchResumesFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	oldData := compressibleText(2000, "old")
+	tail := make([]byte, 256*1024)
+	rand.New(rand.NewSource(21)).Read(tail)
+	newData := append(append([]byte{}, oldData...), tail...)
+
+	oldFile := filepath.Join(dir, "old.bin")
+	newFile := filepath.Join(dir, "new.bin")
+	os.WriteFile(oldFile, oldData, 0644)
+	os.WriteFile(newFile, newData, 0644)
+
+	engine, err := diff.NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchPath := filepath.Join(dir, "update.patch")
+	if _, err := NewGenerator(engine, CompressionZstd).GeneratePatch(oldFile, newFile, patchPath); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultApplierConfig()
+	config.TempDir = dir
+	config.BackupEnabled = false
+	config.Resumable = true
+	config.CheckpointInterval = 16 * 1024
+	applier := NewApplier(config)
+	output := filepath.Join(dir, "out.bin")
+
+	for _, corrupt := range []bool{false, true} {
+		interruptApply(t, applier, oldFile, patchPath, output)
+		if corrupt {
+			f, err := os.OpenFile(partialPath(output), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteAt([]byte("corrupted"), 100)
+			f.Close()
+		}
+
+		result, err := applier.ApplyPatch(oldFile, patchPath, output)
+		if err != nil {
+			t.Fatalf("corrupt=%v: ApplyPatch() error = %v", corrupt, err)
+		}
+		if corrupt && result.ResumedBytes != 0 {
+			t.Errorf("corrupt=%v: ResumedBytes = %d, want 0", corrupt, result.ResumedBytes)
+		}
+		if !corrupt && result.ResumedBytes <= int64(len(oldData)) {
+			t.Errorf("corrupt=%v: ResumedBytes = %d, want > %d", corrupt, result.ResumedBytes, len(oldData))
+		}
+		if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+			t.Errorf("corrupt=%v: output mismatch", corrupt)
+		}
+		if HasCheckpoint(output) {
+			t.Errorf("corrupt=%v: checkpoint left behind", corrupt)
+		}
+		if _, err := os.Stat(partialPath(output)); !os.IsNotExist(err) {
+			t.Errorf("corrupt=%v: partial output left behind: %v", corrupt, err)
+		}
+	}
+}
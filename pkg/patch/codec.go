@@ -0,0 +1,334 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Sky-ey/HexDiff/pkg/compression"
+)
+
+// CompressionType 压缩类型，与compression包共用同一组取值，直接写入补丁文件头
+type CompressionType = compression.CompressionType
+
+// CompressionLevel 压缩级别，0表示使用算法的默认级别
+type CompressionLevel = compression.CompressionLevel
+
+const (
+	CompressionNone = compression.CompressionNone // 无压缩
+	CompressionGzip = compression.CompressionGzip // Gzip压缩
+	CompressionLZ4  = compression.CompressionLZ4  // LZ4压缩
+	CompressionZstd = compression.CompressionZstd // Zstandard压缩
+	CompressionAuto = compression.CompressionAuto // 按数据块自动选择
+)
+
+// AutoChunkSize 自动选择压缩算法时每个数据块的大小
+const AutoChunkSize = 128 * 1024
+
+// autoCandidates 自动选择时尝试的压缩算法，压缩后大小相同时优先解压更快的算法
+var autoCandidates = []CompressionType{CompressionLZ4, CompressionZstd, CompressionGzip}
+
+// ParseCompressionType 按名称解析压缩类型，不区分大小写
+func ParseCompressionType(name string) (CompressionType, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "none":
+		return CompressionNone, nil
+	case "gzip":
+		return CompressionGzip, nil
+	case "lz4":
+		return CompressionLZ4, nil
+	case "zstd":
+		return CompressionZstd, nil
+	case "auto":
+		return CompressionAuto, nil
+	default:
+		return CompressionNone, fmt.Errorf("unsupported compression type: %s", name)
+	}
+}
+
+// codec 按算法和级别压缩补丁数据
+//
+// 只使用compression包的流式接口，LZ4写入带长度信息的帧格式，解压时不需要预先知道原始大小。
+type codec struct {
+	compression CompressionType
+	manager     *compression.CompressionManager
+}
+
+// newCodec 创建指定算法和级别的编解码器，dict不为空时Zstd使用该字典压缩
+func newCodec(ct CompressionType, level CompressionLevel, dict []byte) (*codec, error) {
+	if ct > CompressionZstd && ct != CompressionAuto {
+		return nil, fmt.Errorf("unsupported compression type: %v", ct)
+	}
+	if level == 0 {
+		level = compression.LevelDefault
+	}
+
+	manager := compression.NewCompressionManager()
+	newConfig := func() *compression.CompressionConfig {
+		config := compression.DefaultCompressionConfig()
+		config.Level = level
+		return config
+	}
+	gzipConfig := newConfig()
+	if gzipConfig.Level > compression.LevelBest {
+		gzipConfig.Level = compression.LevelBest
+	}
+	manager.RegisterGzip(gzipConfig)
+	manager.RegisterLZ4(newConfig())
+	zstdConfig := newConfig()
+	zstdConfig.EnableDict = len(dict) > 0
+	zstdConfig.Dict = dict
+	manager.RegisterZstd(zstdConfig)
+
+	return &codec{compression: ct, manager: manager}, nil
+}
+
+// with 返回使用同一组压缩器、指定算法的编解码器
+func (c *codec) with(ct CompressionType) *codec {
+	return &codec{compression: ct, manager: c.manager}
+}
+
+// compressTo 压缩src中的全部数据并写入dst，自动选择时写入分块格式
+func (c *codec) compressTo(dst io.Writer, src io.Reader) error {
+	if c.compression == CompressionNone {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	if c.compression == CompressionAuto {
+		return c.compressChunksTo(dst, src)
+	}
+	if err := c.manager.CompressStream(src, dst, c.compression); err != nil {
+		return fmt.Errorf("%s compress: %w", c.compression, err)
+	}
+	return nil
+}
+
+// compress 压缩一段数据
+func (c *codec) compress(data []byte) ([]byte, error) {
+	if c.compression == CompressionNone {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := c.compressTo(&buf, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressBest 依次尝试候选算法，返回压缩后最小的结果；熵很高或压缩后没有变小时不压缩
+func (c *codec) compressBest(data []byte) (CompressionType, []byte, error) {
+	if len(data) == 0 || compression.IsIncompressible(data) {
+		return CompressionNone, data, nil
+	}
+
+	best, bestData := CompressionNone, data
+	for _, ct := range autoCandidates {
+		compressed, err := c.with(ct).compress(data)
+		if err != nil {
+			return CompressionNone, nil, err
+		}
+		if len(compressed) < len(bestData) {
+			best, bestData = ct, compressed
+		}
+	}
+	return best, bestData, nil
+}
+
+// compressChunksTo 将src按AutoChunkSize分块，每块单独选择压缩算法后写入dst
+//
+// 每个数据块依次为算法(1字节)、原始长度和压缩后长度(uvarint)以及压缩后的数据。
+func (c *codec) compressChunksTo(dst io.Writer, src io.Reader) error {
+	chunk := make([]byte, AutoChunkSize)
+	for {
+		n, err := io.ReadFull(src, chunk)
+		if n > 0 {
+			ct, payload, cerr := c.compressBest(chunk[:n])
+			if cerr != nil {
+				return cerr
+			}
+			header := []byte{uint8(ct)}
+			header = binary.AppendUvarint(header, uint64(n))
+			header = binary.AppendUvarint(header, uint64(len(payload)))
+			if _, werr := dst.Write(header); werr != nil {
+				return werr
+			}
+			if _, werr := dst.Write(payload); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decompressChunksTo 解压compressChunksTo写入的分块数据
+func decompressChunksTo(dst io.Writer, src io.Reader) error {
+	reader := bufio.NewReader(src)
+	for index := 0; ; index++ {
+		ct, err := reader.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read chunk %d: %w", index, err)
+		}
+		rawSize, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return fmt.Errorf("read chunk %d size: %w", index, err)
+		}
+		storedSize, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return fmt.Errorf("read chunk %d size: %w", index, err)
+		}
+		if CompressionType(ct) > CompressionZstd {
+			return fmt.Errorf("chunk %d: unsupported compression type: %v", index, CompressionType(ct))
+		}
+
+		counter := &countingWriter{w: dst}
+		payload := &io.LimitedReader{R: reader, N: int64(storedSize)}
+		if err := decompressTo(counter, payload, CompressionType(ct)); err != nil {
+			return fmt.Errorf("chunk %d: %w", index, err)
+		}
+		if payload.N != 0 || counter.n != int64(rawSize) {
+			return fmt.Errorf("chunk %d: size mismatch: expected %d bytes, got %d", index, rawSize, counter.n)
+		}
+	}
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// setCompression 在文件头中记录压缩方式，自动选择时数据区分块压缩，操作列表使用Zstd压缩
+func (h *PatchHeader) setCompression(ct CompressionType) {
+	h.Flags &^= FlagChunkedData
+	h.Compression = ct
+	if ct == CompressionAuto {
+		h.Compression = CompressionZstd
+		h.Flags |= FlagChunkedData
+	}
+}
+
+// dictionaryID 返回Zstd字典的ID，字典为空时返回0
+func dictionaryID(dict []byte) (uint32, error) {
+	if len(dict) == 0 {
+		return 0, nil
+	}
+	return compression.ZstdDictionaryID(dict)
+}
+
+// setDictionary 在文件头中记录压缩使用的字典，只有Zstd压缩使用字典
+func (h *PatchHeader) setDictionary(dictID uint32) {
+	h.Flags &^= FlagZstdDict
+	h.DictID = 0
+	if h.Compression == CompressionZstd && dictID != 0 {
+		h.Flags |= FlagZstdDict
+		h.DictID = dictID
+	}
+}
+
+// checkDictionary 检查补丁所需的字典是否已注册
+func checkDictionary(header *PatchHeader) error {
+	if header.Flags&FlagZstdDict == 0 {
+		return nil
+	}
+	if _, ok := compression.LookupZstdDictionary(header.DictID); !ok {
+		return fmt.Errorf("zstd dictionary %08x not loaded", header.DictID)
+	}
+	return nil
+}
+
+// decompressPatchData 按文件头记录的压缩类型和字典解压数据区
+func decompressPatchData(data []byte, header *PatchHeader) ([]byte, error) {
+	if err := checkDictionary(header); err != nil {
+		return nil, err
+	}
+	if header.Flags&FlagChunkedData != 0 {
+		var buf bytes.Buffer
+		if err := decompressChunksTo(&buf, bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return decompressData(data, header.Compression)
+}
+
+// DictionarySamples 返回补丁中会被压缩的内容，用作训练Zstd字典的样本
+//
+// 单文件补丁返回解压后的操作列表和插入数据，其他数据原样作为一个样本。
+func DictionarySamples(data []byte) ([][]byte, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint32(data) != MagicNumber {
+		return [][]byte{data}, nil
+	}
+	if version := binary.LittleEndian.Uint16(data[4:6]); version != Version && version != Version64 {
+		return [][]byte{data}, nil
+	}
+
+	patchFile, err := NewSerializer(CompressionNone).DeserializeFromData(data)
+	if err != nil {
+		return nil, err
+	}
+	var samples [][]byte
+	header := *patchFile.Header
+	if opData := header.layoutOperations(patchFile.Operations, uint64(len(patchFile.Data))); len(opData) > 0 {
+		samples = append(samples, opData)
+	}
+	if len(patchFile.Data) > 0 {
+		samples = append(samples, patchFile.Data)
+	}
+	return samples, nil
+}
+
+// decompressData 按补丁中记录的压缩类型解压数据
+func decompressData(data []byte, ct CompressionType) ([]byte, error) {
+	if ct == CompressionNone {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := decompressTo(&buf, bytes.NewReader(data), ct); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressTo 解压src中的全部数据并写入dst
+func decompressTo(dst io.Writer, src io.Reader, ct CompressionType) error {
+	if ct > CompressionZstd {
+		return fmt.Errorf("unsupported compression type: %v", ct)
+	}
+	if err := compression.NewCompressionManager().DecompressStream(src, dst, ct); err != nil {
+		return fmt.Errorf("%s decompress: %w", ct, err)
+	}
+	return nil
+}
+
+// newDecompressReader 返回按需解压src的读取器
+func newDecompressReader(src io.Reader, ct CompressionType) (io.ReadCloser, error) {
+	if ct > CompressionZstd {
+		return nil, fmt.Errorf("unsupported compression type: %v", ct)
+	}
+	if ct == CompressionNone {
+		return io.NopCloser(src), nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(decompressTo(pw, src, ct))
+	}()
+	return pr, nil
+}
@@ -0,0 +1,498 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sky-ey/HexDiff/pkg/compression"
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+var allCompressions = []CompressionType{CompressionNone, CompressionGzip, CompressionLZ4, CompressionZstd}
+
+// compressibleText 生成可压缩但每行不同的文本
+func compressibleText(lines int, seed string) []byte {
+	var buf bytes.Buffer
+	for i := range lines {
+		fmt.Fprintf(&buf, "%s line %d: the quick brown fox jumps over the lazy dog\n", seed, i)
+	}
+	return buf.Bytes()
+}
+
+func TestParseCompressionType(t *testing.T) {
+	for _, ct := range allCompressions {
+		got, err := ParseCompressionType(strings.ToUpper(ct.String()))
+		if err != nil || got != ct {
+			t.Errorf("ParseCompressionType(%q) = %v, %v, want %v", ct.String(), got, err, ct)
+		}
+	}
+	if got, err := ParseCompressionType("auto"); err != nil || got != CompressionAuto {
+		t.Errorf("ParseCompressionType(auto) = %v, %v", got, err)
+	}
+	if _, err := ParseCompressionType("brotli"); err == nil {
+		t.Error("ParseCompressionType() should reject unknown names")
+	}
+}
+
+func TestGeneratorCompressionRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old.txt")
+	newFile := filepath.Join(dir, "new.txt")
+	oldData := compressibleText(2000, "old")
+	newData := append(compressibleText(2000, "new"), oldData[:len(oldData)/2]...)
+	os.WriteFile(oldFile, oldData, 0644)
+	os.WriteFile(newFile, newData, 0644)
+
+	engine, err := hexdiff.NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := make(map[CompressionType]int64)
+	for _, ct := range allCompressions {
+		for _, streaming := range []bool{false, true} {
+			name := fmt.Sprintf("%s/streaming=%t", ct, streaming)
+			patchPath := filepath.Join(dir, fmt.Sprintf("%s-%t.patch", ct, streaming))
+
+			var info *PatchInfo
+			if streaming {
+				generator := NewStreamingPatchGenerator(engine, ct)
+				generator.SetLevel(9)
+				info, err = generator.GeneratePatchStreaming(oldFile, newFile, patchPath)
+			} else {
+				generator := NewGenerator(engine, ct)
+				generator.SetCompressionLevel(9)
+				generator.SetCompressOperations(true)
+				info, err = generator.GeneratePatch(oldFile, newFile, patchPath)
+			}
+			if err != nil {
+				t.Fatalf("%s: generate error = %v", name, err)
+			}
+			if info.Compression != ct {
+				t.Errorf("%s: header compression = %v", name, info.Compression)
+			}
+			if !streaming {
+				sizes[ct] = info.PatchFileSize
+			}
+
+			output := filepath.Join(dir, "out.txt")
+			if _, err := NewApplier(nil).ApplyPatch(oldFile, patchPath, output); err != nil {
+				t.Fatalf("%s: ApplyPatch() error = %v", name, err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+				t.Errorf("%s: applied output mismatch", name)
+			}
+		}
+	}
+
+	for _, ct := range allCompressions[1:] {
+		if sizes[ct] >= sizes[CompressionNone] {
+			t.Errorf("%s patch size = %d, want less than uncompressed %d", ct, sizes[ct], sizes[CompressionNone])
+		}
+	}
+}
+
+func TestCompressedOperationsRoundTrip(t *testing.T) {
+	for _, ct := range allCompressions[1:] {
+		patchFile := NewPatchFile()
+		patchFile.Header.Compression = ct
+		var position uint64
+		for i := range 500 {
+			// 重复的操作模式使操作列表容易压缩
+			patchFile.Operations = append(patchFile.Operations,
+				PatchOperation{Type: 0, Size: 4096, Offset: position, SrcOffset: uint64(i%4) * 4096},
+				PatchOperation{Type: 1, Size: 8, Offset: position + 4096, DataOffset: patchFile.AddInsertData([]byte("abcdefgh"))},
+			)
+			position += 4096 + 8
+		}
+
+		serializer := NewSerializer(ct)
+		serializer.SetCompressOperations(true)
+		var buf bytes.Buffer
+		if err := serializer.writePatch(&buf, patchFile); err != nil {
+			t.Fatalf("%s: writePatch() error = %v", ct, err)
+		}
+		if patchFile.Header.Flags&FlagCompressedOps == 0 {
+			t.Fatalf("%s: operation table not compressed", ct)
+		}
+
+		parsed, err := NewSerializer(CompressionNone).DeserializeFromData(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: DeserializeFromData() error = %v", ct, err)
+		}
+		if !reflect.DeepEqual(parsed.Operations, patchFile.Operations) {
+			t.Errorf("%s: operations mismatch after round trip", ct)
+		}
+		if !bytes.Equal(parsed.Data, patchFile.Data) {
+			t.Errorf("%s: insert data mismatch", ct)
+		}
+	}
+}
+
+func TestDirPatchCompressionRoundTrip(t *testing.T) {
+	oldFiles := map[string][]byte{
+		"doc.txt":  compressibleText(500, "doc"),
+		"keep.txt": []byte("unchanged"),
+	}
+	newFiles := map[string][]byte{
+		"doc.txt":   append(compressibleText(500, "doc"), compressibleText(100, "appended")...),
+		"keep.txt":  []byte("unchanged"),
+		"added.txt": compressibleText(800, "added"),
+		"empty.txt": {},
+	}
+
+	dir := t.TempDir()
+	oldDir := filepath.Join(dir, "old")
+	newDir := filepath.Join(dir, "new")
+	writeTree(t, oldDir, oldFiles)
+	writeTree(t, newDir, newFiles)
+
+	engine, err := hexdiff.NewDirEngine(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ct := range allCompressions {
+		patchFile := filepath.Join(dir, ct.String()+".patch")
+		serializer := NewDirPatchSerializer(ct)
+		serializer.SetLevel(3)
+		sink, err := serializer.NewSink(patchFile, "old", "new")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := engine.GenerateDirDiffTo(oldDir, newDir, nil, sink); err != nil {
+			t.Fatalf("%s: GenerateDirDiffTo() error = %v", ct, err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("%s: Close() error = %v", ct, err)
+		}
+
+		reader, err := OpenDirPatch(patchFile)
+		if err != nil {
+			t.Fatalf("%s: OpenDirPatch() error = %v", ct, err)
+		}
+		for i, f := range reader.Patch().Files {
+			want := CompressionNone
+			if f.IsFullContent && f.Kind == hexdiff.KindFile && f.Size > 0 {
+				want = ct
+			}
+			if got := reader.Compression(i); got != want {
+				t.Errorf("%s: %s compression = %v, want %v", ct, f.RelativePath, got, want)
+			}
+			if f.RelativePath == "added.txt" {
+				data, err := reader.ReadData(i)
+				if err != nil || !bytes.Equal(data, newFiles["added.txt"]) {
+					t.Errorf("%s: ReadData(added.txt) = %d bytes, %v", ct, len(data), err)
+				}
+			}
+			if f.RelativePath == "doc.txt" {
+				delta, err := reader.ReadData(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				header, err := ReadPatchHeader(bytes.NewReader(delta))
+				if err != nil || header.Compression != ct {
+					t.Errorf("%s: delta header compression = %v, %v", ct, header, err)
+				}
+			}
+		}
+		reader.Close()
+
+		target := filepath.Join(dir, "target-"+ct.String())
+		writeTree(t, target, oldFiles)
+		if _, err := NewDirApplier(nil).Apply(patchFile, target, nil); err != nil {
+			t.Fatalf("%s: Apply() error = %v", ct, err)
+		}
+		if got := readTree(t, target); !reflect.DeepEqual(got, newFiles) {
+			t.Errorf("%s: target files = %v, want %v", ct, keys(got), keys(newFiles))
+		}
+	}
+}
+
+func TestDirPatchWriterCompressesWithoutWriterAt(t *testing.T) {
+	content := compressibleText(300, "stream")
+	for _, ct := range allCompressions[1:] {
+		var buf bytes.Buffer
+		writer, err := NewDirPatchWriter(&buf, "old", "new", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.SetCompression(ct, 0); err != nil {
+			t.Fatal(err)
+		}
+		file := &hexdiff.DirPatchFile{RelativePath: "a.txt", Status: hexdiff.StatusAdded, Size: int64(len(content)), IsFullContent: true}
+		if err := writer.WriteFileFrom(file, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("%s: WriteFileFrom() error = %v", ct, err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), "stream.patch")
+		os.WriteFile(path, buf.Bytes(), 0644)
+		reader, err := OpenDirPatch(path)
+		if err != nil {
+			t.Fatalf("%s: OpenDirPatch() error = %v", ct, err)
+		}
+		if reader.Patch().Files[0].DeltaSize >= int64(len(content)) {
+			t.Errorf("%s: data not compressed", ct)
+		}
+		data, err := reader.ReadData(0)
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("%s: ReadData() = %d bytes, %v", ct, len(data), err)
+		}
+		reader.Close()
+	}
+}
+
+// devicePatch 生成每台设备配置略有不同的小补丁
+func devicePatch(t *testing.T, serializer *Serializer, device int) []byte {
+	t.Helper()
+	patchFile := NewPatchFile()
+	patchFile.Header.Compression = serializer.compression
+	config := fmt.Sprintf("[device]\nid = dev-%04d\nserial = SN%08d\nfirmware = 2.4.%d\nregion = eu-west\nendpoint = https://ota.example.com/v2/devices/dev-%04d\nupdate_channel = stable\nretry_interval = 300\n", device, device*7919, device%10, device)
+	patchFile.Operations = []PatchOperation{
+		{Type: 0, Size: 4096, Offset: 0, SrcOffset: 0},
+		{Type: 1, Size: uint64(len(config)), Offset: 4096, DataOffset: patchFile.AddInsertData([]byte(config))},
+	}
+
+	var buf bytes.Buffer
+	if err := serializer.writePatch(&buf, patchFile); err != nil {
+		t.Fatalf("writePatch() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestZstdDictionaryRoundTrip(t *testing.T) {
+	plain := NewSerializer(CompressionZstd)
+	var samples [][]byte
+	for device := range 200 {
+		patchSamples, err := DictionarySamples(devicePatch(t, plain, device))
+		if err != nil {
+			t.Fatalf("DictionarySamples() error = %v", err)
+		}
+		samples = append(samples, patchSamples...)
+	}
+
+	const dictID = 0x48440015
+	dict, err := compression.TrainZstdDictionary(samples, 4096, dictID)
+	if err != nil {
+		t.Fatalf("TrainZstdDictionary() error = %v", err)
+	}
+	if id, err := compression.ZstdDictionaryID(dict); err != nil || id != dictID {
+		t.Fatalf("ZstdDictionaryID() = %x, %v", id, err)
+	}
+
+	serializer := NewSerializer(CompressionZstd)
+	if err := serializer.SetDictionary(dict); err != nil {
+		t.Fatalf("SetDictionary() error = %v", err)
+	}
+	withDict := devicePatch(t, serializer, 1000)
+	withoutDict := devicePatch(t, plain, 1000)
+	if len(withDict) >= len(withoutDict) {
+		t.Errorf("patch with dictionary = %d bytes, want less than %d", len(withDict), len(withoutDict))
+	}
+
+	header, err := ReadPatchHeader(bytes.NewReader(withDict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Flags&FlagZstdDict == 0 || header.DictID != dictID {
+		t.Errorf("header dictionary = %x (flags %b)", header.DictID, header.Flags)
+	}
+
+	// 字典未注册时应给出明确的错误
+	if _, err := NewSerializer(CompressionNone).DeserializeFromData(withDict); err == nil || !strings.Contains(err.Error(), "not loaded") {
+		t.Errorf("DeserializeFromData() error = %v, want dictionary not loaded", err)
+	}
+
+	if _, err := compression.RegisterZstdDictionary(dict); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := NewSerializer(CompressionNone).DeserializeFromData(withDict)
+	if err != nil {
+		t.Fatalf("DeserializeFromData() error = %v", err)
+	}
+	want, _ := NewSerializer(CompressionNone).DeserializeFromData(withoutDict)
+	if !reflect.DeepEqual(parsed.Operations, want.Operations) || !bytes.Equal(parsed.Data, want.Data) {
+		t.Error("patch content mismatch after round trip with dictionary")
+	}
+}
+
+func TestZstdDictionaryIgnoredForOtherCompression(t *testing.T) {
+	dict, err := compression.TrainZstdDictionary([][]byte{compressibleText(200, "a"), compressibleText(200, "b"), compressibleText(200, "c")}, 2048, 0x48440016)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serializer := NewSerializer(CompressionGzip)
+	if err := serializer.SetDictionary(dict); err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadPatchHeader(bytes.NewReader(devicePatch(t, serializer, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Flags&FlagZstdDict != 0 || header.Size() != HeaderSize {
+		t.Errorf("gzip patch header records dictionary: flags %b, size %d", header.Flags, header.Size())
+	}
+
+	if err := serializer.SetDictionary([]byte("not a dictionary")); err == nil {
+		t.Error("SetDictionary() should reject invalid dictionaries")
+	}
+}
+
+// chunkCompressions 返回分块数据区中每个数据块的压缩算法
+func chunkCompressions(t *testing.T, patchData []byte) []CompressionType {
+	t.Helper()
+	header, err := ReadPatchHeader(bytes.NewReader(patchData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Flags&FlagChunkedData == 0 {
+		t.Fatalf("data region not chunked: flags %b", header.Flags)
+	}
+
+	var types []CompressionType
+	reader := bytes.NewReader(patchData[header.DataOffset:])
+	for reader.Len() > 0 {
+		ct, _ := reader.ReadByte()
+		binary.ReadUvarint(reader)
+		stored, err := binary.ReadUvarint(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.Seek(int64(stored), io.SeekCurrent)
+		types = append(types, CompressionType(ct))
+	}
+	return types
+}
+
+func TestAutoCompressionSelectsPerChunk(t *testing.T) {
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old.bin")
+	newFile := filepath.Join(dir, "new.bin")
+	random := make([]byte, 2*AutoChunkSize)
+	rand.New(rand.NewSource(16)).Read(random)
+	text := compressibleText(6000, "auto")[:2*AutoChunkSize]
+	newData := append(append([]byte{}, random...), text...)
+	os.WriteFile(oldFile, []byte("unrelated"), 0644)
+	os.WriteFile(newFile, newData, 0644)
+
+	engine, err := hexdiff.NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, streaming := range []bool{false, true} {
+		patchPath := filepath.Join(dir, fmt.Sprintf("auto-%t.patch", streaming))
+		if streaming {
+			_, err = NewStreamingPatchGenerator(engine, CompressionAuto).GeneratePatchStreaming(oldFile, newFile, patchPath)
+		} else {
+			_, err = NewGenerator(engine, CompressionAuto).GeneratePatch(oldFile, newFile, patchPath)
+		}
+		if err != nil {
+			t.Fatalf("streaming=%t: generate error = %v", streaming, err)
+		}
+
+		patchData, _ := os.ReadFile(patchPath)
+		types := chunkCompressions(t, patchData)
+		if len(types) != 4 {
+			t.Fatalf("streaming=%t: got %d chunks, want 4", streaming, len(types))
+		}
+		for i, ct := range types {
+			if compressed := ct != CompressionNone; compressed != (i >= 2) {
+				t.Errorf("streaming=%t: chunk %d compression = %v", streaming, i, ct)
+			}
+		}
+		if len(patchData) > len(random)+len(text)/4 {
+			t.Errorf("streaming=%t: patch size = %d, text chunks not compressed well", streaming, len(patchData))
+		}
+
+		output := filepath.Join(dir, "out.bin")
+		if _, err := NewApplier(nil).ApplyPatch(oldFile, patchPath, output); err != nil {
+			t.Fatalf("streaming=%t: ApplyPatch() error = %v", streaming, err)
+		}
+		if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+			t.Errorf("streaming=%t: applied output mismatch", streaming)
+		}
+	}
+}
+
+func TestAutoCompressionRejectsCorruptChunk(t *testing.T) {
+	var buf bytes.Buffer
+	codec, err := newCodec(CompressionAuto, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.compressTo(&buf, bytes.NewReader(compressibleText(100, "chunk"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改记录的原始长度
+	data := buf.Bytes()
+	data[1]++
+	if err := decompressChunksTo(io.Discard, bytes.NewReader(data)); err == nil {
+		t.Error("decompressChunksTo() should fail when the chunk size does not match")
+	}
+}
+
+func TestDirPatchAutoCompression(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(16)).Read(random)
+	files := map[string][]byte{
+		"photo.jpg": random,
+		"notes.txt": compressibleText(800, "notes"),
+	}
+
+	for _, streamed := range []bool{false, true} {
+		var buf bytes.Buffer
+		writer, err := NewDirPatchWriter(&buf, "old", "new", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.SetCompression(CompressionAuto, 0); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"photo.jpg", "notes.txt"} {
+			content := files[name]
+			file := &hexdiff.DirPatchFile{RelativePath: name, Status: hexdiff.StatusAdded, Size: int64(len(content)), IsFullContent: true}
+			if streamed {
+				err = writer.WriteFileFrom(file, bytes.NewReader(content), int64(len(content)))
+			} else {
+				file.Delta = content
+				err = writer.WriteFile(file)
+			}
+			if err != nil {
+				t.Fatalf("streamed=%t: write %s error = %v", streamed, name, err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), "auto.patch")
+		os.WriteFile(path, buf.Bytes(), 0644)
+		reader, err := OpenDirPatch(path)
+		if err != nil {
+			t.Fatalf("streamed=%t: OpenDirPatch() error = %v", streamed, err)
+		}
+		for i, f := range reader.Patch().Files {
+			compressed := reader.Compression(i) != CompressionNone
+			if compressed != (f.RelativePath == "notes.txt") {
+				t.Errorf("streamed=%t: %s compression = %v", streamed, f.RelativePath, reader.Compression(i))
+			}
+			if data, err := reader.ReadData(i); err != nil || !bytes.Equal(data, files[f.RelativePath]) {
+				t.Errorf("streamed=%t: ReadData(%s) = %d bytes, %v", streamed, f.RelativePath, len(data), err)
+			}
+		}
+		reader.Close()
+	}
+}
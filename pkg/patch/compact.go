@@ -0,0 +1,248 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 紧凑操作编码
+//
+// 每个操作以一个操作码字节开头：低2位为操作类型，高6位为1-63范围内的大小，
+// 为0时大小以uvarint紧随其后。目标偏移量由累计写入位置推导，不再存储：
+//   - COPY:   [大小] + varint(源偏移量 - 上一个COPY/ADD的源结束位置)
+//   - INSERT: [大小]，数据偏移量按插入顺序在数据区中累计
+//   - DELETE: [大小] + varint(偏移量 - 当前目标位置)
+//   - ADD:    与COPY相同，差值数据与INSERT一样在数据区中累计
+const (
+	compactTypeMask  = 0x03
+	compactSizeShift = 2
+	compactMaxInline = 0xff >> compactSizeShift
+)
+
+// errNotCompactable 操作列表无法使用紧凑编码表示
+var errNotCompactable = errors.New("operations cannot be compact encoded")
+
+// encodeCompactOperations 使用紧凑格式编码操作列表
+// 操作的目标偏移量或插入数据偏移量不连续时返回errNotCompactable
+func encodeCompactOperations(operations []PatchOperation) ([]byte, error) {
+	buf := make([]byte, 0, len(operations)*4)
+	var position, lastSrcEnd, dataCursor uint64
+
+	for i := range operations {
+		op := &operations[i]
+		if op.Reserved != 0 || op.Type > 3 {
+			return nil, errNotCompactable
+		}
+
+		if op.Size > 0 && op.Size <= compactMaxInline {
+			buf = append(buf, op.Type|byte(op.Size)<<compactSizeShift)
+		} else {
+			buf = append(buf, op.Type)
+			buf = binary.AppendUvarint(buf, op.Size)
+		}
+
+		switch op.Type {
+		case 0: // Copy
+			if op.Offset != position {
+				return nil, errNotCompactable
+			}
+			buf = binary.AppendVarint(buf, int64(op.SrcOffset-lastSrcEnd))
+			lastSrcEnd = op.SrcOffset + op.Size
+			position += op.Size
+		case 1: // Insert
+			if op.Offset != position || op.DataOffset != dataCursor {
+				return nil, errNotCompactable
+			}
+			dataCursor += op.Size
+			position += op.Size
+		case 2: // Delete
+			buf = binary.AppendVarint(buf, int64(op.Offset-position))
+		case 3: // Add
+			if op.Offset != position || op.DataOffset != dataCursor {
+				return nil, errNotCompactable
+			}
+			buf = binary.AppendVarint(buf, int64(op.SrcOffset-lastSrcEnd))
+			lastSrcEnd = op.SrcOffset + op.Size
+			dataCursor += op.Size
+			position += op.Size
+		}
+	}
+
+	return buf, nil
+}
+
+// decodeCompactOperations 解码紧凑格式的操作列表
+func decodeCompactOperations(reader io.ByteReader, count uint64) ([]PatchOperation, error) {
+	operations := make([]PatchOperation, 0, min(count, 1<<20))
+	var position, lastSrcEnd, dataCursor uint64
+
+	for i := uint64(0); i < count; i++ {
+		opcode, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read operation %d: %w", i, err)
+		}
+
+		op := PatchOperation{
+			Type: opcode & compactTypeMask,
+			Size: uint64(opcode >> compactSizeShift),
+		}
+		if op.Size == 0 {
+			if op.Size, err = binary.ReadUvarint(reader); err != nil {
+				return nil, fmt.Errorf("read operation %d size: %w", i, err)
+			}
+		}
+
+		switch op.Type {
+		case 0: // Copy
+			delta, err := binary.ReadVarint(reader)
+			if err != nil {
+				return nil, fmt.Errorf("read operation %d source offset: %w", i, err)
+			}
+			op.Offset = position
+			op.SrcOffset = lastSrcEnd + uint64(delta)
+			lastSrcEnd = op.SrcOffset + op.Size
+			position += op.Size
+		case 1: // Insert
+			op.Offset = position
+			op.DataOffset = dataCursor
+			dataCursor += op.Size
+			position += op.Size
+		case 2: // Delete
+			delta, err := binary.ReadVarint(reader)
+			if err != nil {
+				return nil, fmt.Errorf("read operation %d offset: %w", i, err)
+			}
+			op.Offset = position + uint64(delta)
+		case 3: // Add
+			delta, err := binary.ReadVarint(reader)
+			if err != nil {
+				return nil, fmt.Errorf("read operation %d source offset: %w", i, err)
+			}
+			op.Offset = position
+			op.SrcOffset = lastSrcEnd + uint64(delta)
+			op.DataOffset = dataCursor
+			lastSrcEnd = op.SrcOffset + op.Size
+			dataCursor += op.Size
+			position += op.Size
+		}
+
+		operations = append(operations, op)
+	}
+
+	return operations, nil
+}
+
+// layoutOperations 根据操作列表确定补丁版本、操作编码和数据区偏移量
+// 紧凑编码更小时使用紧凑编码，返回写入时使用的操作列表编码
+// 已显式选择64位版本时不会降级
+func (h *PatchHeader) layoutOperations(operations []PatchOperation, dataSize uint64) []byte {
+	if h.Version != Version64 {
+		h.Version = requiredVersion(operations, dataSize)
+	}
+	h.OperationCount = uint64(len(operations))
+	h.Flags &^= FlagCompactOps
+
+	fixedSize := uint64(len(operations)) * uint64(h.OperationSize())
+	compact, err := encodeCompactOperations(operations)
+	if err == nil && uint64(len(compact)) < fixedSize {
+		h.Flags |= FlagCompactOps
+		h.DataOffset = uint64(h.Size()) + uint64(len(compact))
+		return compact
+	}
+
+	h.DataOffset = uint64(h.Size()) + fixedSize
+	buf := make([]byte, 0, fixedSize)
+	for i := range operations {
+		buf = append(buf, operations[i].Marshal(h.Version)...)
+	}
+	return buf
+}
+
+// compressOperations 按压缩类型压缩操作列表，压缩后更小时设置FlagCompressedOps并更新数据区偏移量
+func (h *PatchHeader) compressOperations(opData []byte, c *codec) ([]byte, error) {
+	h.Flags &^= FlagCompressedOps
+	if c.compression == CompressionNone || len(opData) == 0 {
+		return opData, nil
+	}
+
+	compressed, err := c.compress(opData)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) >= len(opData) {
+		return opData, nil
+	}
+	h.Flags |= FlagCompressedOps
+	h.DataOffset = uint64(h.Size()) + uint64(len(compressed))
+	return compressed, nil
+}
+
+// ReadOperations 按文件头的版本和编码读取操作列表
+func ReadOperations(r io.Reader, header *PatchHeader) ([]PatchOperation, error) {
+	if header.Flags&FlagCompressedOps != 0 {
+		table, err := readOperationTable(r, header)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkDictionary(header); err != nil {
+			return nil, err
+		}
+		if table, err = decompressData(table, header.Compression); err != nil {
+			return nil, fmt.Errorf("decompress operations: %w", err)
+		}
+
+		// 按解压后的长度解析操作列表
+		plain := *header
+		plain.Flags &^= FlagCompressedOps
+		plain.DataOffset = uint64(header.Size()) + uint64(len(table))
+		return ReadOperations(bytes.NewReader(table), &plain)
+	}
+
+	if header.Flags&FlagCompactOps != 0 {
+		table, err := readOperationTable(r, header)
+		if err != nil {
+			return nil, err
+		}
+
+		reader := bytes.NewReader(table)
+		operations, err := decodeCompactOperations(reader, header.OperationCount)
+		if err != nil {
+			return nil, err
+		}
+		if reader.Len() != 0 {
+			return nil, fmt.Errorf("%d trailing bytes in operation table", reader.Len())
+		}
+		return operations, nil
+	}
+
+	operations := make([]PatchOperation, 0, min(header.OperationCount, 1<<20))
+	opData := make([]byte, header.OperationSize())
+	for i := uint64(0); i < header.OperationCount; i++ {
+		if _, err := io.ReadFull(r, opData); err != nil {
+			return nil, fmt.Errorf("read operation %d: %w", i, err)
+		}
+
+		var op PatchOperation
+		if err := op.Unmarshal(opData, header.Version); err != nil {
+			return nil, fmt.Errorf("parse operation %d: %w", i, err)
+		}
+		operations = append(operations, op)
+	}
+
+	return operations, nil
+}
+
+// readOperationTable 读取文件头和数据区之间的操作列表，长度由数据区偏移量确定
+func readOperationTable(r io.Reader, header *PatchHeader) ([]byte, error) {
+	if header.DataOffset < uint64(header.Size()) {
+		return nil, fmt.Errorf("invalid data offset: %d", header.DataOffset)
+	}
+	table := make([]byte, header.DataOffset-uint64(header.Size()))
+	if _, err := io.ReadFull(r, table); err != nil {
+		return nil, fmt.Errorf("read operations: %w", err)
+	}
+	return table, nil
+}
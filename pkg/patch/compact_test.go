@@ -0,0 +1,81 @@
+package patch
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompactOperationsRoundTrip(t *testing.T) {
+	patchFile := NewPatchFile()
+	var position uint64
+	for i := range 200 {
+		size := uint64(i%70 + 1)
+		if i%3 == 0 {
+			patchFile.Operations = append(patchFile.Operations, PatchOperation{
+				Type:       1,
+				Size:       size,
+				Offset:     position,
+				DataOffset: patchFile.AddInsertData(bytes.Repeat([]byte{byte(i)}, int(size))),
+			})
+		} else {
+			// 源偏移量有前有后，覆盖负的相对偏移
+			patchFile.Operations = append(patchFile.Operations, PatchOperation{
+				Type:      0,
+				Size:      size * 1000,
+				Offset:    position,
+				SrcOffset: uint64((i * 7919) % 100000),
+			})
+			size *= 1000
+		}
+		position += size
+	}
+	patchFile.Operations = append(patchFile.Operations, PatchOperation{Type: 2, Size: 5, Offset: position + 10})
+
+	var buf bytes.Buffer
+	if err := NewSerializer(CompressionNone).writePatch(&buf, patchFile); err != nil {
+		t.Fatalf("writePatch() error = %v", err)
+	}
+	if patchFile.Header.Flags&FlagCompactOps == 0 {
+		t.Fatal("expected compact operation encoding")
+	}
+	fixedSize := HeaderSize + len(patchFile.Operations)*OperationSize + len(patchFile.Data)
+	if buf.Len() >= fixedSize {
+		t.Errorf("compact patch size = %d, want less than %d", buf.Len(), fixedSize)
+	}
+
+	parsed, err := NewSerializer(CompressionNone).DeserializeFromData(buf.Bytes())
+	if err != nil {
+		t.Fatalf("DeserializeFromData() error = %v", err)
+	}
+	if len(parsed.Operations) != len(patchFile.Operations) {
+		t.Fatalf("operation count = %d, want %d", len(parsed.Operations), len(patchFile.Operations))
+	}
+	for i := range patchFile.Operations {
+		if parsed.Operations[i] != patchFile.Operations[i] {
+			t.Errorf("operation %d = %+v, want %+v", i, parsed.Operations[i], patchFile.Operations[i])
+		}
+	}
+	if !bytes.Equal(parsed.Data, patchFile.Data) {
+		t.Error("insert data mismatch")
+	}
+}
+
+func TestCompactOperationsFallback(t *testing.T) {
+	// 插入数据不按顺序存放，只能使用定长编码
+	operations := []PatchOperation{
+		{Type: 1, Size: 4, Offset: 0, DataOffset: 4},
+		{Type: 1, Size: 4, Offset: 4, DataOffset: 0},
+	}
+	if _, err := encodeCompactOperations(operations); err != errNotCompactable {
+		t.Fatalf("encodeCompactOperations() error = %v, want errNotCompactable", err)
+	}
+
+	header := NewPatchHeader()
+	header.layoutOperations(operations, 8)
+	if header.Flags&FlagCompactOps != 0 {
+		t.Error("unexpected compact flag")
+	}
+	if want := uint64(HeaderSize + 2*OperationSize); header.DataOffset != want {
+		t.Errorf("DataOffset = %d, want %d", header.DataOffset, want)
+	}
+}
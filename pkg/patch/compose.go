@@ -0,0 +1,207 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrPatchChainMismatch 第二个补丁的源文件不是第一个补丁的目标文件
+var ErrPatchChainMismatch = errors.New("patches do not form a chain")
+
+// Compose 将v1→v2的补丁p1与v2→v3的补丁p2合并为v1→v3的补丁
+//
+// p2中从v2复制和相加的操作按p1的操作映射改写为v1中的偏移量或字面数据，不需要生成中间文件。
+// 合并结果的操作按p2的顺序排列，相邻的同类操作会合并。
+func Compose(p1, p2 *PatchFile) (*PatchFile, error) {
+	if p1.Header.TargetSize != p2.Header.SourceSize {
+		return nil, fmt.Errorf("%w: target size %d, source size %d",
+			ErrPatchChainMismatch, p1.Header.TargetSize, p2.Header.SourceSize)
+	}
+	if !emptyChecksum(p1.Header.TargetChecksum) && !emptyChecksum(p2.Header.SourceChecksum) &&
+		p1.Header.TargetChecksum != p2.Header.SourceChecksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrPatchChainMismatch)
+	}
+
+	segments, err := targetSegments(p1)
+	if err != nil {
+		return nil, err
+	}
+	c := &composer{first: p1, segments: segments, result: NewPatchFile()}
+
+	for i := range p2.Operations {
+		op := &p2.Operations[i]
+		if op.Size == 0 {
+			continue
+		}
+		switch op.Type {
+		case 0: // Copy
+			err = c.mapRange(op, nil)
+		case 1: // Insert
+			var data []byte
+			if data, err = p2.GetInsertData(op.DataOffset, op.Size); err == nil {
+				c.insert(op.Offset, data)
+			}
+		case 2: // Delete
+		case 3: // Add
+			var delta []byte
+			if delta, err = p2.GetInsertData(op.DataOffset, op.Size); err == nil {
+				err = c.mapRange(op, delta)
+			}
+		default:
+			err = fmt.Errorf("unknown operation type: %d", op.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("compose operation %d: %w", i, err)
+		}
+	}
+
+	header := c.result.Header
+	header.SourceSize = p1.Header.SourceSize
+	header.SourceChecksum = p1.Header.SourceChecksum
+	header.TargetSize = p2.Header.TargetSize
+	header.TargetChecksum = p2.Header.TargetChecksum
+	c.result.UpdateHeader()
+	return c.result, nil
+}
+
+// targetSegments 返回按目标偏移量排序的写入操作，写入范围重叠时无法确定中间文件的内容
+func targetSegments(patchFile *PatchFile) ([]PatchOperation, error) {
+	segments := make([]PatchOperation, 0, len(patchFile.Operations))
+	for _, op := range patchFile.Operations {
+		if op.Type != 2 && op.Size > 0 {
+			segments = append(segments, op)
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].Offset < segments[j].Offset })
+
+	for i := 1; i < len(segments); i++ {
+		if prev := segments[i-1]; segments[i].Offset < prev.Offset+prev.Size {
+			return nil, fmt.Errorf("overlapping operations at target offset %d", segments[i].Offset)
+		}
+	}
+	return segments, nil
+}
+
+// composer 记录合并过程中的状态
+type composer struct {
+	first    *PatchFile
+	segments []PatchOperation
+	result   *PatchFile
+}
+
+// mapRange 将p2中引用v2的操作按p1改写，delta不为nil时为相加操作的差值
+func (c *composer) mapRange(op *PatchOperation, delta []byte) error {
+	pos, end := op.SrcOffset, op.SrcOffset+op.Size
+	if end < pos || end > uint64(c.first.Header.TargetSize) {
+		return fmt.Errorf("source range out of bounds: offset=%d, size=%d, total=%d",
+			op.SrcOffset, op.Size, c.first.Header.TargetSize)
+	}
+
+	i := sort.Search(len(c.segments), func(i int) bool {
+		return c.segments[i].Offset+c.segments[i].Size > pos
+	})
+	for pos < end {
+		target := op.Offset + (pos - op.SrcOffset)
+
+		// p1没有写入的区域在中间文件中为零字节
+		if i >= len(c.segments) || c.segments[i].Offset > pos {
+			n := end - pos
+			if i < len(c.segments) {
+				n = min(n, c.segments[i].Offset-pos)
+			}
+			data := make([]byte, n)
+			if delta != nil {
+				copy(data, delta[pos-op.SrcOffset:])
+			}
+			c.insert(target, data)
+			pos += n
+			continue
+		}
+
+		segment := &c.segments[i]
+		inner := pos - segment.Offset
+		n := min(end, segment.Offset+segment.Size) - pos
+		var diff []byte
+		if delta != nil {
+			diff = delta[pos-op.SrcOffset:][:n]
+		}
+
+		switch segment.Type {
+		case 0: // Copy
+			if diff == nil {
+				c.copy(target, segment.SrcOffset+inner, n)
+			} else {
+				c.add(target, segment.SrcOffset+inner, diff)
+			}
+		case 1: // Insert
+			data, err := c.first.GetInsertData(segment.DataOffset+inner, n)
+			if err != nil {
+				return err
+			}
+			c.insert(target, addBytes(data, diff))
+		case 3: // Add
+			data, err := c.first.GetInsertData(segment.DataOffset+inner, n)
+			if err != nil {
+				return err
+			}
+			c.add(target, segment.SrcOffset+inner, addBytes(data, diff))
+		default:
+			return fmt.Errorf("unknown operation type: %d", segment.Type)
+		}
+		pos += n
+		i++
+	}
+	return nil
+}
+
+// addBytes 返回两段数据逐字节相加的结果，diff为nil时直接返回data
+func addBytes(data, diff []byte) []byte {
+	if diff == nil {
+		return data
+	}
+	sum := make([]byte, len(data))
+	for i := range data {
+		sum[i] = data[i] + diff[i]
+	}
+	return sum
+}
+
+// last 返回可以与下一个操作合并的上一个操作
+func (c *composer) last(opType uint8, target uint64) *PatchOperation {
+	ops := c.result.Operations
+	if len(ops) == 0 {
+		return nil
+	}
+	op := &ops[len(ops)-1]
+	if op.Type != opType || op.Offset+op.Size != target {
+		return nil
+	}
+	return op
+}
+
+func (c *composer) copy(target, srcOffset, size uint64) {
+	if op := c.last(0, target); op != nil && op.SrcOffset+op.Size == srcOffset {
+		op.Size += size
+		return
+	}
+	c.result.Operations = append(c.result.Operations, PatchOperation{Type: 0, Offset: target, SrcOffset: srcOffset, Size: size})
+}
+
+func (c *composer) insert(target uint64, data []byte) {
+	dataOffset := c.result.AddInsertData(data)
+	if op := c.last(1, target); op != nil && op.DataOffset+op.Size == dataOffset {
+		op.Size += uint64(len(data))
+		return
+	}
+	c.result.Operations = append(c.result.Operations, PatchOperation{Type: 1, Offset: target, DataOffset: dataOffset, Size: uint64(len(data))})
+}
+
+func (c *composer) add(target, srcOffset uint64, diff []byte) {
+	dataOffset := c.result.AddInsertData(diff)
+	if op := c.last(3, target); op != nil && op.SrcOffset+op.Size == srcOffset && op.DataOffset+op.Size == dataOffset {
+		op.Size += uint64(len(diff))
+		return
+	}
+	c.result.Operations = append(c.result.Operations, PatchOperation{Type: 3, Offset: target, SrcOffset: srcOffset, DataOffset: dataOffset, Size: uint64(len(diff))})
+}
@@ -0,0 +1,189 @@
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+func TestComposeSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	v1 := compressibleText(3000, "v1")
+	v2 := append(append(compressibleText(500, "v2"), v1[:40000]...), v1[60000:]...)
+	v3 := append(append(v2[:20000:20000], compressibleText(800, "v3")...), v2[30000:]...)
+
+	paths := make([]string, 3)
+	for i, data := range [][]byte{v1, v2, v3} {
+		paths[i] = filepath.Join(dir, string(rune('1'+i))+".bin")
+		os.WriteFile(paths[i], data, 0644)
+	}
+
+	engine, err := hexdiff.NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := NewGenerator(engine, CompressionGzip)
+	serializer := NewSerializer(CompressionNone)
+	patches := make([]*PatchFile, 2)
+	for i := range patches {
+		patchPath := filepath.Join(dir, string(rune('a'+i))+".patch")
+		if _, err := generator.GeneratePatch(paths[i], paths[i+1], patchPath); err != nil {
+			t.Fatalf("GeneratePatch() error = %v", err)
+		}
+		if patches[i], err = serializer.DeserializePatch(patchPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	composed, err := Compose(patches[0], patches[1])
+	if err != nil {
+		t.Fatalf("Compose() error = %v", err)
+	}
+	if composed.Header.SourceChecksum != patches[0].Header.SourceChecksum ||
+		composed.Header.TargetChecksum != patches[1].Header.TargetChecksum {
+		t.Error("composed header checksums do not match the chain")
+	}
+
+	squashed := filepath.Join(dir, "ab.patch")
+	if err := NewSerializer(CompressionZstd).SerializePatch(composed, squashed); err != nil {
+		t.Fatalf("SerializePatch() error = %v", err)
+	}
+	output := filepath.Join(dir, "out.bin")
+	if _, err := NewApplier(nil).ApplyPatch(paths[0], squashed, output); err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, v3) {
+		t.Error("applied output mismatch")
+	}
+
+	if _, err := Compose(patches[1], patches[1]); !errors.Is(err, ErrPatchChainMismatch) {
+		t.Errorf("Compose() of unrelated patches error = %v, want ErrPatchChainMismatch", err)
+	}
+}
+
+func TestComposeAddOperations(t *testing.T) {
+	v1 := bytes.Repeat([]byte("call 0x00401000; mov rax, rbx; "), 64)
+	v2 := bytes.ReplaceAll(v1, []byte("0x00401000"), []byte("0x00401040"))
+	v3 := bytes.ReplaceAll(v2, []byte("0x00401040"), []byte("0x00402080"))
+	copy(v3[100:], "inserted literal")
+
+	patches := make([]*PatchFile, 2)
+	for i, pair := range [][2][]byte{{v1, v2}, {v2, v3}} {
+		delta := hexdiff.DiffBytes(pair[0], pair[1])
+		delta.SetChecksum(pair[1])
+		var err error
+		if patches[i], err = NewSerializer(CompressionNone).buildPatchFile(delta, [32]byte{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	composed, err := Compose(patches[0], patches[1])
+	if err != nil {
+		t.Fatalf("Compose() error = %v", err)
+	}
+	hasAdd := false
+	for _, op := range composed.Operations {
+		hasAdd = hasAdd || op.Type == 3
+	}
+	if !hasAdd {
+		t.Error("expected add operations in composed patch")
+	}
+
+	var buf bytes.Buffer
+	if err := NewSerializer(CompressionNone).SerializePatchTo(composed, &buf); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "out.bin")
+	if err := NewApplier(nil).ApplyDeltaFrom(bytes.NewReader(v1), buf.Bytes(), output); err != nil {
+		t.Fatalf("ApplyDeltaFrom() error = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, v3) {
+		t.Error("applied output mismatch")
+	}
+}
+
+func TestComposeDirPatches(t *testing.T) {
+	rng := rand.New(rand.NewSource(20))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rng.Read(data)
+		return data
+	}
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	shared, moved := random(32*1024), random(16*1024)
+	v1 := map[string][]byte{
+		"a.bin":       shared,
+		"b.txt":       []byte("unchanged"),
+		"sub/c.bin":   moved,
+		"obsolete.md": []byte("to be deleted"),
+		"keep.txt":    []byte("kept in every version"),
+	}
+	v2 := map[string][]byte{
+		"a.bin":     concat(shared[:16*1024], random(20*1024)),
+		"b.txt":     []byte("unchanged"),
+		"sub/d.bin": moved,
+		"added.txt": []byte("added in v2, removed in v3"),
+		"keep.txt":  []byte("kept in every version"),
+		"grow.bin":  concat(shared[8*1024:], random(4*1024)),
+	}
+	v3 := map[string][]byte{
+		"a.bin":       concat(v2["a.bin"][:24*1024], random(8*1024), v2["a.bin"][30*1024:]),
+		"b.txt":       []byte("changed in v3"),
+		"sub/e.bin":   concat(moved[:8*1024], []byte("patched"), moved[8*1024:]),
+		"obsolete.md": []byte("restored in v3"),
+		"keep.txt":    []byte("kept in every version"),
+		"grow.bin":    concat(v2["grow.bin"], random(1024)),
+	}
+
+	for _, dedup := range []bool{true, false} {
+		dir := t.TempDir()
+		patches := make([]string, 2)
+		trees := []map[string][]byte{v1, v2, v3}
+		for i := range patches {
+			oldDir := filepath.Join(dir, "old")
+			newDir := filepath.Join(dir, "new")
+			os.RemoveAll(oldDir)
+			os.RemoveAll(newDir)
+			writeTree(t, oldDir, trees[i])
+			writeTree(t, newDir, trees[i+1])
+
+			config := hexdiff.DefaultDirDiffConfig()
+			config.CrossFileDedup = dedup
+			engine, err := hexdiff.NewDirEngine(nil, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := engine.GenerateDirDiff(oldDir, newDir, nil)
+			if err != nil {
+				t.Fatalf("GenerateDirDiff() error = %v", err)
+			}
+			patches[i] = filepath.Join(dir, string(rune('a'+i))+".patch")
+			if err := NewDirPatchSerializer(CompressionGzip).SerializeDirPatch(result, "old", "new", patches[i]); err != nil {
+				t.Fatalf("SerializeDirPatch() error = %v", err)
+			}
+		}
+
+		squashed := filepath.Join(dir, "ab.patch")
+		if _, err := NewDirPatchSerializer(CompressionZstd).ComposeDirPatches(patches[0], patches[1], squashed); err != nil {
+			t.Fatalf("dedup=%v: ComposeDirPatches() error = %v", dedup, err)
+		}
+
+		target := filepath.Join(dir, "target")
+		writeTree(t, target, v1)
+		if _, err := NewDirApplier(nil).Apply(squashed, target, nil); err != nil {
+			t.Fatalf("dedup=%v: Apply() error = %v", dedup, err)
+		}
+		if got := readTree(t, target); !reflect.DeepEqual(got, v3) {
+			t.Errorf("dedup=%v: target files = %v, want %v", dedup, keys(got), keys(v3))
+		}
+	}
+}
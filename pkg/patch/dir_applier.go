@@ -0,0 +1,787 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+	"github.com/Sky-ey/HexDiff/pkg/integrity"
+)
+
+const (
+	dirTransactionSuffix = ".hexdiff-tx" // 事务目录后缀
+	dirJournalName       = "journal"     // 事务日志文件名
+	dirStagingName       = "staging"     // 暂存新文件的目录
+	dirBackupName        = "backup"      // 备份被替换和删除文件的目录
+)
+
+// 提交步骤的动作
+const (
+	commitDelete = "delete" // 删除文件、符号链接或空目录
+	commitMkdir  = "mkdir"  // 创建目录，同一路径上的其他条目会被替换
+	commitWrite  = "write"  // 将暂存的文件或符号链接移动到目标位置
+	commitChmod  = "chmod"  // 设置目录权限，放在最后以免只读目录阻止写入
+)
+
+// originalNone 表示执行步骤前目标路径不存在
+const originalNone = "none"
+
+// DirApplier 目录补丁应用器
+//
+// 应用分为两个阶段：先在目标目录旁的事务目录中生成所有新文件和符号链接并校验，
+// 全部成功后再按日志逐个删除、创建和替换目标目录中的条目，每个步骤执行前在日志中记录目标原来的状态，
+// 被覆盖的文件通过恢复管理器备份。
+// 提交过程被中断时，再次应用同一补丁会继续提交；应用其他补丁或调用Rollback会先回滚。
+//
+// 默认拒绝绝对路径或指向目标目录之外的符号链接，写入、替换和删除条目前检查其上级目录都不是符号链接。
+type DirApplier struct {
+	applier       *Applier
+	externalLinks bool // 允许符号链接指向目标目录之外
+}
+
+// NewDirApplier 创建目录补丁应用器，applier为nil时使用默认配置
+func NewDirApplier(applier *Applier) *DirApplier {
+	if applier == nil {
+		applier = NewApplier(nil)
+	}
+	return &DirApplier{applier: applier}
+}
+
+// SetAllowExternalSymlinks 设置是否允许补丁创建绝对路径或指向目标目录之外的符号链接
+func (a *DirApplier) SetAllowExternalSymlinks(allow bool) {
+	a.externalLinks = allow
+}
+
+// dirCommitStep 提交阶段的一个步骤
+type dirCommitStep struct {
+	Action string `json:"action"`           // 动作
+	Path   string `json:"path"`             // 目标目录中的相对路径
+	Staged string `json:"staged,omitempty"` // 事务目录中暂存文件的相对路径
+	Mode   uint32 `json:"mode,omitempty"`   // 目录的权限
+}
+
+// dirJournalHeader 事务日志头，写在日志的第一行
+type dirJournalHeader struct {
+	PatchChecksum string          `json:"patch_checksum"`
+	Filter        string          `json:"filter,omitempty"`  // 应用时使用的过滤器
+	Reverse       bool            `json:"reverse,omitempty"` // 是否应用嵌入的反向补丁
+	Steps         []dirCommitStep `json:"steps"`
+}
+
+// dirJournalRecord 步骤进度记录，每行一条，追加在日志头之后
+// 步骤开始前记录目标原来的状态，完成后记录Done
+type dirJournalRecord struct {
+	Step   int    `json:"step"`
+	Kind   string `json:"kind,omitempty"`   // 目标原来的类型，为originalNone时不存在
+	Backup string `json:"backup,omitempty"` // 备份文件相对事务目录的路径
+	Link   string `json:"link,omitempty"`   // 原来的符号链接目标
+	Mode   uint32 `json:"mode,omitempty"`   // 原来的权限
+	MTime  int64  `json:"mtime,omitempty"`  // 原来的修改时间
+	Done   bool   `json:"done,omitempty"`
+}
+
+// dirTransaction 正在进行的目录补丁事务
+type dirTransaction struct {
+	dir       string
+	targetDir string
+	header    dirJournalHeader
+	records   map[int]*dirJournalRecord
+	journal   *os.File
+	managers  map[string]*integrity.RecoveryManager
+}
+
+// DirTransactionPath 返回目标目录对应的事务目录
+func DirTransactionPath(targetDir string) string {
+	if abs, err := filepath.Abs(targetDir); err == nil {
+		targetDir = abs
+	}
+	return filepath.Join(filepath.Dir(targetDir), "."+filepath.Base(targetDir)+dirTransactionSuffix)
+}
+
+// HasPendingTransaction 目标目录是否有未完成的事务
+func HasPendingTransaction(targetDir string) bool {
+	_, err := os.Stat(filepath.Join(DirTransactionPath(targetDir), dirJournalName))
+	return err == nil
+}
+
+// Apply 以事务方式将目录补丁应用到目标目录
+func (a *DirApplier) Apply(patchFile, targetDir string, progress hexdiff.ProgressReporter) (*hexdiff.DirPatch, error) {
+	return a.ApplyFiltered(patchFile, targetDir, nil, progress)
+}
+
+// ApplyFiltered 以事务方式只应用路径匹配filter的条目，filter为nil时应用所有条目
+// 重命名条目的原路径不匹配时保留原文件，相当于复制
+func (a *DirApplier) ApplyFiltered(patchFile, targetDir string, filter *DirPatchFilter, progress hexdiff.ProgressReporter) (*hexdiff.DirPatch, error) {
+	return a.apply(patchFile, targetDir, filter, false, progress)
+}
+
+// ApplyReverse 以事务方式将补丁中嵌入的反向补丁应用到新目录，将其恢复为旧目录，filter为nil时应用所有条目
+func (a *DirApplier) ApplyReverse(patchFile, targetDir string, filter *DirPatchFilter, progress hexdiff.ProgressReporter) (*hexdiff.DirPatch, error) {
+	return a.apply(patchFile, targetDir, filter, true, progress)
+}
+
+func (a *DirApplier) apply(patchFile, targetDir string, filter *DirPatchFilter, reverse bool, progress hexdiff.ProgressReporter) (*hexdiff.DirPatch, error) {
+	checksum, err := calculateFileChecksum(patchFile)
+	if err != nil {
+		return nil, fmt.Errorf("hash patch file: %w", err)
+	}
+
+	reader, err := openDirPatch(patchFile, filter, reverse)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	dirPatch := reader.Patch()
+
+	txDir := DirTransactionPath(targetDir)
+	tx, err := openDirTransaction(txDir, targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx != nil {
+		if tx.header.PatchChecksum == hex.EncodeToString(checksum[:]) && tx.header.Filter == filter.String() &&
+			tx.header.Reverse == reverse {
+			reportMessage(progress, "继续提交上次中断的目录补丁...")
+			if err := tx.commit(progress); err != nil {
+				return nil, err
+			}
+			return dirPatch, tx.finish()
+		}
+
+		reportMessage(progress, "正在回滚上次中断的目录补丁...")
+		if err := tx.rollback(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 没有日志的事务目录是未完成的暂存，目标目录尚未被修改
+	if err := os.RemoveAll(txDir); err != nil {
+		return nil, fmt.Errorf("remove transaction directory: %w", err)
+	}
+
+	steps, err := a.stage(reader, targetDir, txDir, progress)
+	if err != nil {
+		os.RemoveAll(txDir)
+		return nil, err
+	}
+
+	tx, err = createDirTransaction(txDir, targetDir, dirJournalHeader{
+		PatchChecksum: hex.EncodeToString(checksum[:]),
+		Filter:        filter.String(),
+		Reverse:       reverse,
+		Steps:         steps,
+	})
+	if err != nil {
+		os.RemoveAll(txDir)
+		return nil, err
+	}
+
+	reportMessage(progress, "正在提交目录补丁...")
+	if err := tx.commit(progress); err != nil {
+		// 提交失败时立即回滚，回滚也失败时保留事务目录供下次处理
+		if rollbackErr := tx.rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return nil, err
+	}
+
+	return dirPatch, tx.finish()
+}
+
+// Rollback 回滚目标目录上未完成的事务，没有事务时不做任何操作
+func (a *DirApplier) Rollback(targetDir string) error {
+	txDir := DirTransactionPath(targetDir)
+	tx, err := openDirTransaction(txDir, targetDir)
+	if err != nil {
+		return err
+	}
+	if tx == nil {
+		return os.RemoveAll(txDir)
+	}
+	return tx.rollback()
+}
+
+// stage 在事务目录中生成所有新文件和符号链接并校验，返回提交步骤
+func (a *DirApplier) stage(reader *DirPatchReader, targetDir, txDir string, progress hexdiff.ProgressReporter) ([]dirCommitStep, error) {
+	dirPatch := reader.Patch()
+
+	// 差异可能引用源文件表中的任意旧文件，暂存期间目标目录保持不变
+	var sources *DirSourceReader
+	if len(dirPatch.Sources) > 0 {
+		sources = NewDirSourceReader(targetDir, dirPatch.Sources)
+		defer sources.Close()
+	}
+
+	var totalBytes, processedBytes int64
+	for _, filePatch := range dirPatch.Files {
+		if filePatch.Status != hexdiff.StatusDeleted {
+			totalBytes += filePatch.Size
+		}
+	}
+
+	var deletes, dirDeletes, mkdirs, writes, chmods []dirCommitStep
+	for i, filePatch := range dirPatch.Files {
+		targetPath := filepath.Join(targetDir, filepath.FromSlash(filePatch.RelativePath))
+
+		if filePatch.Status == hexdiff.StatusDeleted {
+			if _, err := os.Lstat(targetPath); err == nil {
+				step := dirCommitStep{Action: commitDelete, Path: filePatch.RelativePath}
+				if filePatch.Kind == hexdiff.KindDir {
+					dirDeletes = append(dirDeletes, step)
+				} else {
+					deletes = append(deletes, step)
+				}
+			}
+			continue
+		}
+
+		if filePatch.Kind == hexdiff.KindDir {
+			mode := uint32(filePatch.Mode.Perm())
+			mkdirs = append(mkdirs, dirCommitStep{Action: commitMkdir, Path: filePatch.RelativePath, Mode: mode})
+			chmods = append(chmods, dirCommitStep{Action: commitChmod, Path: filePatch.RelativePath, Mode: mode})
+			continue
+		}
+
+		// 没有差异数据的修改保留原内容
+		if filePatch.Kind == hexdiff.KindFile && filePatch.Status == hexdiff.StatusModified &&
+			!filePatch.IsFullContent && filePatch.DeltaSize == 0 {
+			continue
+		}
+
+		staged := filepath.ToSlash(filepath.Join(dirStagingName, filepath.FromSlash(filePatch.RelativePath)))
+		stagedPath := filepath.Join(txDir, filepath.FromSlash(staged))
+		// 暂存目录中已创建的符号链接不能被当作上级目录
+		if err := checkParents(txDir, staged); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", filePatch.RelativePath, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(stagedPath), 0755); err != nil {
+			return nil, fmt.Errorf("create staging directory: %w", err)
+		}
+
+		reportMessage(progress, "正在生成 "+filePatch.RelativePath)
+		if filePatch.Kind == hexdiff.KindSymlink {
+			if !a.externalLinks {
+				if err := checkLinkTarget(filePatch.RelativePath, filePatch.LinkTarget); err != nil {
+					return nil, fmt.Errorf("stage %s: %w", filePatch.RelativePath, err)
+				}
+			}
+			if err := os.Symlink(filePatch.LinkTarget, stagedPath); err != nil {
+				return nil, fmt.Errorf("stage %s: create symlink: %w", filePatch.RelativePath, err)
+			}
+		} else if err := a.stageFile(reader, i, targetDir, targetPath, stagedPath, sources); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", filePatch.RelativePath, err)
+		}
+		writes = append(writes, dirCommitStep{Action: commitWrite, Path: filePatch.RelativePath, Staged: staged})
+
+		if filePatch.Status == hexdiff.StatusRenamed && reader.filter.Match(filePatch.OldPath) {
+			deletes = append(deletes, dirCommitStep{Action: commitDelete, Path: filePatch.OldPath})
+		}
+
+		processedBytes += filePatch.Size
+		if progress != nil && totalBytes > 0 {
+			progress.SetProgress(int(float64(processedBytes) / float64(totalBytes) * 80))
+		}
+	}
+
+	// 所有新内容都已暂存，提交时不再读取旧文件，因此可以先删除：
+	// 先删除文件，再由深到浅删除目录，然后由浅到深创建目录并写入文件，最后设置目录权限
+	sort.Slice(dirDeletes, func(i, j int) bool { return dirDeletes[i].Path > dirDeletes[j].Path })
+	sort.Slice(mkdirs, func(i, j int) bool { return mkdirs[i].Path < mkdirs[j].Path })
+	sort.Slice(chmods, func(i, j int) bool { return chmods[i].Path > chmods[j].Path })
+
+	var steps []dirCommitStep
+	for _, list := range [][]dirCommitStep{deletes, dirDeletes, mkdirs, writes, chmods} {
+		steps = append(steps, list...)
+	}
+	return steps, nil
+}
+
+// stageFile 生成第i个条目的新内容并校验，完整内容从补丁中边读边解压，差异读入内存后应用
+func (a *DirApplier) stageFile(reader *DirPatchReader, i int, targetDir, targetPath, stagedPath string, sources *DirSourceReader) error {
+	filePatch := reader.Patch().Files[i]
+
+	var delta []byte
+	if !filePatch.IsFullContent && filePatch.DeltaSize > 0 {
+		var err error
+		if delta, err = reader.ReadData(i); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case filePatch.IsFullContent:
+		data, err := reader.Open(i)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+		if err := writeFileFrom(stagedPath, data, filePatch.Mode.Perm()); err != nil {
+			return fmt.Errorf("write file: %w", err)
+		}
+
+	case len(delta) > 0 && sources != nil:
+		if err := a.applier.ApplyDeltaFrom(sources, delta, stagedPath); err != nil {
+			return fmt.Errorf("apply delta: %w", err)
+		}
+
+	case filePatch.Status == hexdiff.StatusRenamed || filePatch.Status == hexdiff.StatusCopied:
+		oldPath := filepath.Join(targetDir, filepath.FromSlash(filePatch.OldPath))
+		if len(delta) == 0 {
+			if err := copyFile(oldPath, stagedPath); err != nil {
+				return fmt.Errorf("copy %s: %w", filePatch.OldPath, err)
+			}
+		} else if err := a.applier.ApplyDelta(oldPath, delta, stagedPath); err != nil {
+			return fmt.Errorf("apply delta: %w", err)
+		}
+
+	case filePatch.Status == hexdiff.StatusAdded:
+		if err := os.WriteFile(stagedPath, delta, filePatch.Mode.Perm()); err != nil {
+			return fmt.Errorf("write file: %w", err)
+		}
+
+	default:
+		if _, err := os.Stat(targetPath); err != nil {
+			return fmt.Errorf("source file does not exist: %w", err)
+		}
+		if err := a.applier.ApplyDelta(targetPath, delta, stagedPath); err != nil {
+			return fmt.Errorf("apply delta: %w", err)
+		}
+	}
+
+	if !emptyChecksum(filePatch.Checksum) {
+		checksum, err := calculateFileChecksum(stagedPath)
+		if err != nil {
+			return fmt.Errorf("hash staged file: %w", err)
+		}
+		if checksum != filePatch.Checksum {
+			return fmt.Errorf("checksum mismatch")
+		}
+	}
+
+	if err := os.Chmod(stagedPath, filePatch.Mode.Perm()); err != nil {
+		return fmt.Errorf("chmod: %w", err)
+	}
+	if err := os.Chtimes(stagedPath, filePatch.GetMTime(), filePatch.GetMTime()); err != nil {
+		return fmt.Errorf("set modification time: %w", err)
+	}
+	return nil
+}
+
+// createDirTransaction 写入日志头，开始提交阶段
+func createDirTransaction(txDir, targetDir string, header dirJournalHeader) (*dirTransaction, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("marshal journal: %w", err)
+	}
+
+	journal, err := os.OpenFile(filepath.Join(txDir, dirJournalName), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("create journal: %w", err)
+	}
+	if _, err := journal.Write(append(data, '\n')); err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("write journal: %w", err)
+	}
+	if err := journal.Sync(); err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("sync journal: %w", err)
+	}
+
+	return &dirTransaction{
+		dir:       txDir,
+		targetDir: targetDir,
+		header:    header,
+		records:   make(map[int]*dirJournalRecord),
+		journal:   journal,
+		managers:  make(map[string]*integrity.RecoveryManager),
+	}, nil
+}
+
+// openDirTransaction 读取已有的事务日志，没有日志时返回nil
+func openDirTransaction(txDir, targetDir string) (*dirTransaction, error) {
+	data, err := os.ReadFile(filepath.Join(txDir, dirJournalName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+
+	tx := &dirTransaction{
+		dir:       txDir,
+		targetDir: targetDir,
+		records:   make(map[int]*dirJournalRecord),
+		managers:  make(map[string]*integrity.RecoveryManager),
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, math.MaxInt32)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("read journal: empty journal")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &tx.header); err != nil {
+		return nil, fmt.Errorf("parse journal: %w", err)
+	}
+
+	for scanner.Scan() {
+		var record dirJournalRecord
+		// 最后一行可能因中断而不完整，忽略即可
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			break
+		}
+		if record.Step < 0 || record.Step >= len(tx.header.Steps) {
+			return nil, fmt.Errorf("parse journal: invalid step %d", record.Step)
+		}
+		merged := tx.record(record.Step)
+		if record.Kind != "" {
+			done := merged.Done
+			*merged = record
+			merged.Done = merged.Done || done
+		}
+		merged.Done = merged.Done || record.Done
+	}
+
+	journal, err := os.OpenFile(filepath.Join(txDir, dirJournalName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	tx.journal = journal
+	return tx, nil
+}
+
+// record 返回步骤的进度记录
+func (tx *dirTransaction) record(step int) *dirJournalRecord {
+	record, ok := tx.records[step]
+	if !ok {
+		record = &dirJournalRecord{Step: step}
+		tx.records[step] = record
+	}
+	return record
+}
+
+// appendRecord 追加进度记录并同步到磁盘
+func (tx *dirTransaction) appendRecord(record dirJournalRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal journal record: %w", err)
+	}
+	if _, err := tx.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	return tx.journal.Sync()
+}
+
+// recoveryManager 返回相对路径所在目录的恢复管理器，备份按目录存放以避免同名文件冲突
+func (tx *dirTransaction) recoveryManager(relativePath string) *integrity.RecoveryManager {
+	dir := filepath.Dir(filepath.FromSlash(relativePath))
+	manager, ok := tx.managers[dir]
+	if !ok {
+		manager = integrity.NewRecoveryManager(nil, &integrity.RecoveryConfig{
+			BackupDir:  filepath.Join(tx.dir, dirBackupName, dir),
+			MaxBackups: math.MaxInt32,
+		})
+		tx.managers[dir] = manager
+	}
+	return manager
+}
+
+// commit 按顺序执行尚未完成的提交步骤，可以重复调用
+func (tx *dirTransaction) commit(progress hexdiff.ProgressReporter) error {
+	for i, step := range tx.header.Steps {
+		record := tx.record(i)
+		if record.Done {
+			continue
+		}
+		// 日志在事务目录中，执行前再次检查路径，被篡改的日志不能修改目标目录之外的文件
+		if err := step.checkPaths(); err != nil {
+			return err
+		}
+		if err := checkParents(tx.targetDir, step.Path); err != nil {
+			return fmt.Errorf("%s %s: %w", step.Action, step.Path, err)
+		}
+		targetPath := filepath.Join(tx.targetDir, filepath.FromSlash(step.Path))
+
+		// 步骤第一次执行时记录目标原来的状态，中断后继续时目标可能已被修改
+		if record.Kind == "" {
+			if err := tx.saveOriginal(record, step.Path, targetPath); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.execute(step, targetPath); err != nil {
+			return err
+		}
+
+		record.Done = true
+		if err := tx.appendRecord(dirJournalRecord{Step: i, Done: true}); err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress.SetProgress(80 + (i+1)*20/len(tx.header.Steps))
+		}
+	}
+	return nil
+}
+
+// checkPaths 检查步骤的路径位于目标目录和事务目录之内
+func (step dirCommitStep) checkPaths() error {
+	if err := checkPatchPath(step.Path); err != nil {
+		return fmt.Errorf("%s: %w", step.Action, err)
+	}
+	if step.Staged != "" {
+		if err := checkPatchPath(step.Staged); err != nil {
+			return fmt.Errorf("%s %s: staged file: %w", step.Action, step.Path, err)
+		}
+	}
+	return nil
+}
+
+// checkParents 检查root下相对路径的各级上级目录都不是符号链接，避免通过链接修改root之外的文件
+// 不存在的上级目录会在之后创建，不需要检查
+func checkParents(root, relativePath string) error {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(filepath.FromSlash(relativePath))), "/")
+	for i := 1; i < len(parts); i++ {
+		parent := strings.Join(parts[:i], "/")
+		info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(parent)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: parent %s is a symlink", ErrUnsafePath, parent)
+		}
+	}
+	return nil
+}
+
+// checkLinkTarget 检查符号链接的目标是相对路径，且从链接所在目录解析后仍在目录之内
+func checkLinkTarget(relativePath, target string) error {
+	resolved := filepath.Join(filepath.Dir(filepath.FromSlash(relativePath)), filepath.FromSlash(target))
+	if filepath.IsAbs(filepath.FromSlash(target)) || !filepath.IsLocal(resolved) {
+		return fmt.Errorf("%w: symlink %s -> %s", ErrUnsafePath, relativePath, target)
+	}
+	return nil
+}
+
+// execute 执行单个提交步骤，重复执行的结果相同
+func (tx *dirTransaction) execute(step dirCommitStep, targetPath string) error {
+	switch step.Action {
+	case commitDelete:
+		if err := removeEntry(targetPath); err != nil {
+			return fmt.Errorf("delete %s: %w", step.Path, err)
+		}
+
+	case commitMkdir:
+		if info, err := os.Lstat(targetPath); err == nil && !info.IsDir() {
+			if err := os.Remove(targetPath); err != nil {
+				return fmt.Errorf("replace %s: %w", step.Path, err)
+			}
+		}
+		if err := os.MkdirAll(targetPath, 0755); err != nil {
+			return fmt.Errorf("create directory %s: %w", step.Path, err)
+		}
+
+	case commitWrite:
+		// 暂存文件不存在说明上次已经替换完成
+		stagedPath := filepath.Join(tx.dir, filepath.FromSlash(step.Staged))
+		if _, err := os.Lstat(stagedPath); err != nil {
+			return nil
+		}
+		// 原来的目录中的条目已在之前的步骤中删除
+		if info, err := os.Lstat(targetPath); err == nil && info.IsDir() {
+			if err := os.Remove(targetPath); err != nil {
+				return fmt.Errorf("replace %s: %w", step.Path, err)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("create directory for %s: %w", step.Path, err)
+		}
+		if err := os.Rename(stagedPath, targetPath); err != nil {
+			return fmt.Errorf("replace %s: %w", step.Path, err)
+		}
+
+	case commitChmod:
+		if err := os.Chmod(targetPath, os.FileMode(step.Mode)); err != nil {
+			return fmt.Errorf("chmod %s: %w", step.Path, err)
+		}
+	}
+	return nil
+}
+
+// saveOriginal 记录目标原来的状态，普通文件通过恢复管理器备份
+func (tx *dirTransaction) saveOriginal(record *dirJournalRecord, relativePath, targetPath string) error {
+	info, err := os.Lstat(targetPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		record.Kind = originalNone
+
+	case err != nil:
+		return fmt.Errorf("stat %s: %w", relativePath, err)
+
+	case info.IsDir():
+		record.Kind = hexdiff.KindDir.String()
+		record.Mode = uint32(info.Mode().Perm())
+
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(targetPath)
+		if err != nil {
+			return fmt.Errorf("read symlink %s: %w", relativePath, err)
+		}
+		record.Kind = hexdiff.KindSymlink.String()
+		record.Link = link
+
+	default:
+		backupPath, err := tx.recoveryManager(relativePath).CreateBackup(targetPath)
+		if err != nil {
+			return fmt.Errorf("backup %s: %w", relativePath, err)
+		}
+		rel, _ := filepath.Rel(tx.dir, backupPath)
+		record.Kind = hexdiff.KindFile.String()
+		record.Backup = filepath.ToSlash(rel)
+		record.Mode = uint32(info.Mode().Perm())
+		record.MTime = info.ModTime().UnixNano()
+	}
+
+	return tx.appendRecord(*record)
+}
+
+// rollback 按相反顺序将已开始的步骤恢复到原来的状态，并删除事务目录
+func (tx *dirTransaction) rollback() error {
+	for i := len(tx.header.Steps) - 1; i >= 0; i-- {
+		step := tx.header.Steps[i]
+		record := tx.record(i)
+		if record.Kind == "" {
+			continue
+		}
+		if err := step.checkPaths(); err != nil {
+			return err
+		}
+		if err := checkParents(tx.targetDir, step.Path); err != nil {
+			return fmt.Errorf("restore %s: %w", step.Path, err)
+		}
+		if record.Backup != "" {
+			if err := checkPatchPath(record.Backup); err != nil {
+				return fmt.Errorf("restore %s: backup: %w", step.Path, err)
+			}
+		}
+
+		if err := tx.restoreOriginal(record, step.Path); err != nil {
+			return fmt.Errorf("restore %s: %w", step.Path, err)
+		}
+	}
+
+	return tx.finish()
+}
+
+// restoreOriginal 将目标恢复为记录中的状态
+func (tx *dirTransaction) restoreOriginal(record *dirJournalRecord, relativePath string) error {
+	targetPath := filepath.Join(tx.targetDir, filepath.FromSlash(relativePath))
+
+	if info, err := os.Lstat(targetPath); err == nil {
+		if info.IsDir() && record.Kind == hexdiff.KindDir.String() {
+			return os.Chmod(targetPath, os.FileMode(record.Mode))
+		}
+		if err := removeEntry(targetPath); err != nil {
+			return err
+		}
+	}
+
+	switch record.Kind {
+	case hexdiff.KindDir.String():
+		if err := os.Mkdir(targetPath, 0755); err != nil {
+			return err
+		}
+		return os.Chmod(targetPath, os.FileMode(record.Mode))
+
+	case hexdiff.KindSymlink.String():
+		return os.Symlink(record.Link, targetPath)
+
+	case hexdiff.KindFile.String():
+		backupPath := filepath.Join(tx.dir, filepath.FromSlash(record.Backup))
+		if err := tx.recoveryManager(relativePath).RestoreFromBackup(targetPath, backupPath); err != nil {
+			return err
+		}
+		if err := os.Chmod(targetPath, os.FileMode(record.Mode)); err != nil {
+			return fmt.Errorf("chmod: %w", err)
+		}
+		mtime := time.Unix(0, record.MTime)
+		if err := os.Chtimes(targetPath, mtime, mtime); err != nil {
+			return fmt.Errorf("set modification time: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeFileFrom 将r的内容写入新文件
+func writeFileFrom(path string, r io.Reader, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// removeEntry 删除文件、符号链接或空目录
+// 目录中还有补丁未涉及的条目（例如被忽略的文件）时保留目录
+func removeEntry(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return nil
+		}
+	}
+	return os.Remove(path)
+}
+
+// finish 关闭日志并删除事务目录
+func (tx *dirTransaction) finish() error {
+	if tx.journal != nil {
+		tx.journal.Close()
+		tx.journal = nil
+	}
+	if err := os.RemoveAll(tx.dir); err != nil {
+		return fmt.Errorf("remove transaction directory: %w", err)
+	}
+	return nil
+}
+
+// emptyChecksum 校验和是否未设置
+func emptyChecksum(checksum [32]byte) bool {
+	return checksum == [32]byte{}
+}
+
+func reportMessage(progress hexdiff.ProgressReporter, message string) {
+	if progress != nil {
+		progress.Message(message)
+	}
+}
@@ -0,0 +1,480 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+func writeTree(t *testing.T, root string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTree(t *testing.T, root string) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		data, err := os.ReadFile(path)
+		files[filepath.ToSlash(rel)] = data
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// makeDirApplierFixture 创建旧目录、新目录和两者之间的目录补丁，返回旧目录内容、新目录内容和补丁路径
+func makeDirApplierFixture(t *testing.T) (map[string][]byte, map[string][]byte, string) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(10))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rng.Read(data)
+		return data
+	}
+
+	shared := random(32 * 1024)
+	oldFiles := map[string][]byte{
+		"a.bin":       shared,
+		"b.txt":       []byte("unchanged"),
+		"sub/c.bin":   random(16 * 1024),
+		"obsolete.md": []byte("to be deleted"),
+	}
+	newFiles := map[string][]byte{
+		"a.bin":     append(append([]byte{}, shared[:16*1024]...), random(20*1024)...),
+		"b.txt":     []byte("unchanged"),
+		"sub/d.bin": oldFiles["sub/c.bin"],
+		"added.txt": []byte("brand new file"),
+	}
+
+	dir := t.TempDir()
+	oldDir := filepath.Join(dir, "old")
+	newDir := filepath.Join(dir, "new")
+	writeTree(t, oldDir, oldFiles)
+	writeTree(t, newDir, newFiles)
+
+	engine, err := hexdiff.NewDirEngine(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.GenerateDirDiff(oldDir, newDir, nil)
+	if err != nil {
+		t.Fatalf("GenerateDirDiff() error = %v", err)
+	}
+
+	patchFile := filepath.Join(dir, "dir.patch")
+	if err := NewDirPatchSerializer(CompressionNone).SerializeDirPatch(result, "old", "new", patchFile); err != nil {
+		t.Fatalf("SerializeDirPatch() error = %v", err)
+	}
+	return oldFiles, newFiles, patchFile
+}
+
+func TestDirApplierApply(t *testing.T) {
+	oldFiles, newFiles, patchFile := makeDirApplierFixture(t)
+	target := filepath.Join(t.TempDir(), "target")
+	writeTree(t, target, oldFiles)
+
+	if _, err := NewDirApplier(nil).Apply(patchFile, target, nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if got := readTree(t, target); !reflect.DeepEqual(got, newFiles) {
+		t.Errorf("target files = %v, want %v", keys(got), keys(newFiles))
+	}
+	if _, err := os.Stat(DirTransactionPath(target)); !os.IsNotExist(err) {
+		t.Errorf("transaction directory left behind: %v", err)
+	}
+}
+
+func TestDirApplierFailureLeavesTargetUntouched(t *testing.T) {
+	oldFiles, _, patchFile := makeDirApplierFixture(t)
+	target := filepath.Join(t.TempDir(), "target")
+
+	// 源文件大小与补丁记录的不一致，生成阶段就会失败
+	oldFiles["sub/c.bin"] = bytes.Repeat([]byte("x"), 100)
+	writeTree(t, target, oldFiles)
+
+	if _, err := NewDirApplier(nil).Apply(patchFile, target, nil); err == nil {
+		t.Fatal("Apply() should fail when a source file does not match")
+	}
+
+	if got := readTree(t, target); !reflect.DeepEqual(got, oldFiles) {
+		t.Errorf("target was modified: %v", keys(got))
+	}
+	if _, err := os.Stat(DirTransactionPath(target)); !os.IsNotExist(err) {
+		t.Errorf("transaction directory left behind: %v", err)
+	}
+}
+
+// interruptDirApply 暂存补丁并只提交前n个步骤，模拟提交过程中被中断，n为负数时从末尾计算
+func interruptDirApply(t *testing.T, patchFile, target string, n int) {
+	t.Helper()
+	applier := NewDirApplier(nil)
+	reader, err := OpenDirPatch(patchFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	checksum, err := calculateFileChecksum(patchFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txDir := DirTransactionPath(target)
+	steps, err := applier.stage(reader, target, txDir, nil)
+	if err != nil {
+		t.Fatalf("stage() error = %v", err)
+	}
+	if n < 0 {
+		n += len(steps)
+	}
+	if n < 0 || n >= len(steps) {
+		t.Fatalf("patch has only %d steps", len(steps))
+	}
+
+	tx, err := createDirTransaction(txDir, target, dirJournalHeader{
+		PatchChecksum: hex.EncodeToString(checksum[:]),
+		Steps:         steps,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.header.Steps = steps[:n]
+	if err := tx.commit(nil); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+	tx.journal.Close()
+}
+
+func TestDirApplierResumesInterruptedApply(t *testing.T) {
+	oldFiles, newFiles, patchFile := makeDirApplierFixture(t)
+	target := filepath.Join(t.TempDir(), "target")
+	writeTree(t, target, oldFiles)
+
+	interruptDirApply(t, patchFile, target, 2)
+	if !HasPendingTransaction(target) {
+		t.Fatal("HasPendingTransaction() = false after interrupted apply")
+	}
+
+	if _, err := NewDirApplier(nil).Apply(patchFile, target, nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got := readTree(t, target); !reflect.DeepEqual(got, newFiles) {
+		t.Errorf("target files = %v, want %v", keys(got), keys(newFiles))
+	}
+	if HasPendingTransaction(target) {
+		t.Error("HasPendingTransaction() = true after resumed apply")
+	}
+}
+
+func TestDirApplierRollback(t *testing.T) {
+	oldFiles, _, patchFile := makeDirApplierFixture(t)
+	target := filepath.Join(t.TempDir(), "target")
+	writeTree(t, target, oldFiles)
+
+	interruptDirApply(t, patchFile, target, 3)
+
+	if err := NewDirApplier(nil).Rollback(target); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if got := readTree(t, target); !reflect.DeepEqual(got, oldFiles) {
+		t.Errorf("target files = %v, want %v", keys(got), keys(oldFiles))
+	}
+	if _, err := os.Stat(DirTransactionPath(target)); !os.IsNotExist(err) {
+		t.Errorf("transaction directory left behind: %v", err)
+	}
+}
+
+func TestDirTransactionRejectsUnsafeSteps(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	writeTree(t, target, map[string][]byte{"a.txt": []byte("a")})
+	victim := filepath.Join(dir, "victim")
+	if err := os.WriteFile(victim, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 日志被篡改，步骤指向目标目录之外
+	txDir := DirTransactionPath(target)
+	if err := os.MkdirAll(txDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := createDirTransaction(txDir, target, dirJournalHeader{
+		Steps: []dirCommitStep{{Action: commitDelete, Path: "../victim"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.commit(nil); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("commit() error = %v, want ErrUnsafePath", err)
+	}
+	tx.record(0).Kind = originalNone
+	if err := tx.rollback(); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("rollback() error = %v, want ErrUnsafePath", err)
+	}
+	tx.finish()
+
+	if data, err := os.ReadFile(victim); err != nil || string(data) != "keep" {
+		t.Errorf("file outside target = %q, %v", data, err)
+	}
+}
+
+func keys(files map[string][]byte) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	return names
+}
+
+// snapshotTree 返回目录中每个条目的类型、权限和内容
+func snapshotTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	snapshot := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		switch {
+		case info.IsDir():
+			snapshot[rel] = fmt.Sprintf("dir %o", info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			snapshot[rel] = "link " + target
+			return err
+		default:
+			data, err := os.ReadFile(path)
+			snapshot[rel] = fmt.Sprintf("file %o %s", info.Mode().Perm(), data)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshot
+}
+
+func TestDirApplierEntryKinds(t *testing.T) {
+	dir := t.TempDir()
+	oldDir := filepath.Join(dir, "old")
+	newDir := filepath.Join(dir, "new")
+	writeTree(t, oldDir, map[string][]byte{
+		"a.txt":      []byte("a"),
+		"gone/x.txt": []byte("removed with its directory"),
+		"perm/y.txt": []byte("y"),
+		"swap":       []byte("file becomes a directory"),
+	})
+	writeTree(t, newDir, map[string][]byte{
+		"a.txt":           []byte("a"),
+		"b.txt":           []byte("bb"),
+		"perm/y.txt":      []byte("y"),
+		"swap/child.txt":  []byte("child"),
+		"script.sh":       []byte("#!/bin/sh\n"),
+		"nested/deep/z.t": []byte("z"),
+	})
+	os.MkdirAll(filepath.Join(newDir, "empty"), 0755)
+	os.Chmod(filepath.Join(newDir, "perm"), 0750)
+	os.Chmod(filepath.Join(newDir, "script.sh"), 0755)
+	if err := os.Symlink("a.txt", filepath.Join(oldDir, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	os.Symlink("b.txt", filepath.Join(newDir, "link"))
+	os.Symlink("perm", filepath.Join(newDir, "dirlink"))
+
+	engine, err := hexdiff.NewDirEngine(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.GenerateDirDiff(oldDir, newDir, nil)
+	if err != nil {
+		t.Fatalf("GenerateDirDiff() error = %v", err)
+	}
+	patchFile := filepath.Join(dir, "dir.patch")
+	if err := NewDirPatchSerializer(CompressionNone).SerializeDirPatch(result, "old", "new", patchFile); err != nil {
+		t.Fatalf("SerializeDirPatch() error = %v", err)
+	}
+
+	dirPatch, err := NewDirPatchSerializer(CompressionNone).DeserializeDirPatch(patchFile)
+	if err != nil {
+		t.Fatalf("DeserializeDirPatch() error = %v", err)
+	}
+	kinds := make(map[string]hexdiff.EntryKind)
+	for _, f := range dirPatch.Files {
+		kinds[f.RelativePath] = f.Kind
+		if f.RelativePath == "link" && f.LinkTarget != "b.txt" {
+			t.Errorf("link target = %q, want b.txt", f.LinkTarget)
+		}
+	}
+	for path, want := range map[string]hexdiff.EntryKind{
+		"empty": hexdiff.KindDir, "gone": hexdiff.KindDir, "swap": hexdiff.KindDir,
+		"link": hexdiff.KindSymlink, "dirlink": hexdiff.KindSymlink, "b.txt": hexdiff.KindFile,
+	} {
+		if got, ok := kinds[path]; !ok || got != want {
+			t.Errorf("%s: kind = %v (present %v), want %v", path, got, ok, want)
+		}
+	}
+
+	want := snapshotTree(t, newDir)
+	before := snapshotTree(t, oldDir)
+
+	target := filepath.Join(dir, "target")
+	if err := os.Rename(oldDir, target); err != nil {
+		t.Fatal(err)
+	}
+
+	// 提交到最后一步前中断后回滚，所有条目应恢复原样
+	interruptDirApply(t, patchFile, target, -1)
+	if err := NewDirApplier(nil).Rollback(target); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if got := snapshotTree(t, target); !reflect.DeepEqual(got, before) {
+		t.Errorf("after rollback:\n got %v\nwant %v", got, before)
+	}
+
+	if _, err := NewDirApplier(nil).Apply(patchFile, target, nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got := snapshotTree(t, target); !reflect.DeepEqual(got, want) {
+		t.Errorf("after apply:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestDirApplierRejectsUnsafeSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	os.Mkdir(outside, 0755)
+	target := filepath.Join(dir, "target")
+	writeTree(t, target, map[string][]byte{"a.txt": []byte("a")})
+	if err := os.Symlink(outside, filepath.Join(target, "ext")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	before := snapshotTree(t, target)
+
+	link := func(path, linkTarget string) *hexdiff.DirPatchFile {
+		return &hexdiff.DirPatchFile{RelativePath: path, Status: hexdiff.StatusAdded, Mode: os.ModeSymlink | 0777,
+			Kind: hexdiff.KindSymlink, LinkTarget: linkTarget, IsFullContent: true}
+	}
+	file := func(path string) *hexdiff.DirPatchFile {
+		return &hexdiff.DirPatchFile{RelativePath: path, Status: hexdiff.StatusAdded, Mode: 0644,
+			Size: 4, Delta: []byte("evil"), IsFullContent: true}
+	}
+	tests := []struct {
+		name  string
+		allow bool
+		files []*hexdiff.DirPatchFile
+	}{
+		{"absolute target", false, []*hexdiff.DirPatchFile{link("abs", outside)}},
+		{"escaping target", false, []*hexdiff.DirPatchFile{link("sub/up", "../../outside")}},
+		{"write through new link", true, []*hexdiff.DirPatchFile{link("abs", outside), file("abs/evil.txt")}},
+		{"write through existing link", false, []*hexdiff.DirPatchFile{file("ext/evil.txt")}},
+	}
+	for _, tt := range tests {
+		patchFile := filepath.Join(dir, "links.patch")
+		writeDirPatchEntries(t, patchFile, nil, tt.files...)
+		applier := NewDirApplier(nil)
+		applier.SetAllowExternalSymlinks(tt.allow)
+		if _, err := applier.Apply(patchFile, target, nil); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("%s: Apply() error = %v, want ErrUnsafePath", tt.name, err)
+		}
+		if got := snapshotTree(t, target); !reflect.DeepEqual(got, before) {
+			t.Errorf("%s: target = %v, want %v", tt.name, got, before)
+		}
+		if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+			t.Errorf("%s: %d entries written outside the target", tt.name, len(entries))
+		}
+	}
+
+	// 明确允许时可以创建指向目录之外的链接
+	patchFile := filepath.Join(dir, "external.patch")
+	writeDirPatchEntries(t, patchFile, nil, link("abs", outside))
+	applier := NewDirApplier(nil)
+	applier.SetAllowExternalSymlinks(true)
+	if _, err := applier.Apply(patchFile, target, nil); err != nil {
+		t.Fatalf("Apply() with external symlinks allowed error = %v", err)
+	}
+	if got, _ := os.Readlink(filepath.Join(target, "abs")); got != outside {
+		t.Errorf("abs -> %q, want %q", got, outside)
+	}
+}
+
+func TestDirApplierApplyFiltered(t *testing.T) {
+	oldFiles, newFiles, patchFile := makeDirApplierFixture(t)
+
+	tests := []struct {
+		name   string
+		filter *DirPatchFilter
+		want   map[string][]byte
+	}{
+		{
+			name:   "only renamed directory",
+			filter: &DirPatchFilter{Only: []string{"sub"}},
+			want: map[string][]byte{
+				"a.bin":       oldFiles["a.bin"],
+				"b.txt":       oldFiles["b.txt"],
+				"sub/d.bin":   newFiles["sub/d.bin"],
+				"obsolete.md": oldFiles["obsolete.md"],
+			},
+		},
+		{
+			// 重命名的原路径不在过滤范围内时保留原文件
+			name:   "rename source excluded",
+			filter: &DirPatchFilter{Only: []string{"sub/d.bin"}},
+			want: map[string][]byte{
+				"a.bin":       oldFiles["a.bin"],
+				"b.txt":       oldFiles["b.txt"],
+				"sub/c.bin":   oldFiles["sub/c.bin"],
+				"sub/d.bin":   newFiles["sub/d.bin"],
+				"obsolete.md": oldFiles["obsolete.md"],
+			},
+		},
+		{
+			name:   "exclude",
+			filter: &DirPatchFilter{Exclude: []string{"*.md", "a.bin"}},
+			want: map[string][]byte{
+				"a.bin":       oldFiles["a.bin"],
+				"b.txt":       newFiles["b.txt"],
+				"sub/d.bin":   newFiles["sub/d.bin"],
+				"added.txt":   newFiles["added.txt"],
+				"obsolete.md": oldFiles["obsolete.md"],
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "target")
+			writeTree(t, target, oldFiles)
+
+			if _, err := NewDirApplier(nil).ApplyFiltered(patchFile, target, tt.filter, nil); err != nil {
+				t.Fatalf("ApplyFiltered() error = %v", err)
+			}
+			if got := readTree(t, target); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("target files = %v, want %v", keys(got), keys(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,505 @@
+package patch
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// ComposeDirPatches 将旧目录→中间目录的补丁first与中间目录→新目录的补丁second合并为旧目录→新目录的补丁
+//
+// 第二个补丁中以中间目录文件为源的差异按第一个补丁改写为以旧目录文件为源，不需要生成中间目录。
+// 合并后的差异都以源文件表为源，只由字面数据组成的文件保存完整内容。返回的补丁信息不包含条目数据。
+func (s *DirPatchSerializer) ComposeDirPatches(firstPath, secondPath, outputPath string) (*hexdiff.DirPatch, error) {
+	first, err := OpenDirPatch(firstPath)
+	if err != nil {
+		return nil, err
+	}
+	defer first.Close()
+
+	second, err := OpenDirPatch(secondPath)
+	if err != nil {
+		return nil, err
+	}
+	defer second.Close()
+
+	c := newDirComposer(first, second)
+	if err := c.compose(); err != nil {
+		return nil, err
+	}
+
+	result, err := s.writeComposed(c, outputPath)
+	if err != nil {
+		os.Remove(outputPath)
+		return nil, err
+	}
+	return result, nil
+}
+
+// dirComposer 合并两个目录补丁，记录合并后的源文件表和条目
+type dirComposer struct {
+	first  *DirPatchReader
+	second *DirPatchReader
+
+	firstFiles  map[string]int  // 第一个补丁中各路径的条目序号
+	renamedFrom map[string]bool // 第一个补丁中重命名和复制的原路径
+	secondFiles map[string]int  // 第二个补丁中各路径的条目序号
+	movedAway   map[string]bool // 第二个补丁中重命名的原路径
+
+	sources       []hexdiff.DirPatchSource
+	sourceIndex   map[string]int
+	sourceOffsets []int64
+	size          int64
+
+	intermediateSources *PatchFile // 第二个补丁的源文件表拼接，按需生成
+	entries             []composedEntry
+}
+
+// composedEntry 合并后的条目，delta不为nil时数据为以合并后源文件表为源的差异，否则从reader的第index个条目复制
+type composedEntry struct {
+	file   *hexdiff.DirPatchFile
+	delta  *PatchFile
+	reader *DirPatchReader
+	index  int
+}
+
+func newDirComposer(first, second *DirPatchReader) *dirComposer {
+	c := &dirComposer{
+		first:       first,
+		second:      second,
+		firstFiles:  make(map[string]int),
+		renamedFrom: make(map[string]bool),
+		secondFiles: make(map[string]int),
+		movedAway:   make(map[string]bool),
+		sourceIndex: make(map[string]int),
+	}
+	for i, file := range first.Patch().Files {
+		c.firstFiles[file.RelativePath] = i
+		if file.Status == hexdiff.StatusRenamed || file.Status == hexdiff.StatusCopied {
+			c.renamedFrom[file.OldPath] = true
+		}
+	}
+	for i, file := range second.Patch().Files {
+		c.secondFiles[file.RelativePath] = i
+		if file.Status == hexdiff.StatusRenamed {
+			c.movedAway[file.OldPath] = true
+		}
+	}
+
+	// 第一个补丁的源文件表放在最前面，其差异中的源偏移量不需要改写
+	for _, source := range first.Patch().Sources {
+		c.source(source.RelativePath, source.Size)
+	}
+	return c
+}
+
+// compose 先处理第一个补丁中没有被第二个补丁覆盖的条目，再改写第二个补丁的条目
+func (c *dirComposer) compose() error {
+	for i, file := range c.first.Patch().Files {
+		switch {
+		case file.Status == hexdiff.StatusDeleted:
+			if !c.recreated(file.RelativePath) {
+				c.emit(composedEntry{file: file})
+			}
+			continue
+		case file.Status == hexdiff.StatusRenamed && !c.recreated(file.OldPath):
+			c.emit(composedEntry{file: deletedEntry(file.OldPath)})
+		}
+
+		if c.replaced(file.RelativePath) {
+			continue
+		}
+		if err := c.keep(i); err != nil {
+			return fmt.Errorf("compose %s: %w", file.RelativePath, err)
+		}
+	}
+
+	for i, file := range c.second.Patch().Files {
+		if err := c.rewrite(i); err != nil {
+			return fmt.Errorf("compose %s: %w", file.RelativePath, err)
+		}
+	}
+	return nil
+}
+
+func (c *dirComposer) emit(entry composedEntry) {
+	c.entries = append(c.entries, entry)
+}
+
+// recreated 判断第二个补丁是否在该路径生成新条目
+func (c *dirComposer) recreated(path string) bool {
+	i, ok := c.secondFiles[path]
+	return ok && c.second.Patch().Files[i].Status != hexdiff.StatusDeleted
+}
+
+// replaced 判断中间目录中的该路径是否被第二个补丁删除、移走或修改
+func (c *dirComposer) replaced(path string) bool {
+	if i, ok := c.secondFiles[path]; ok && !unchangedEntry(c.second.Patch().Files[i]) {
+		return true
+	}
+	return c.movedAway[path]
+}
+
+// inOld 判断路径是否存在于旧目录，inIntermediate为路径是否存在于中间目录
+func (c *dirComposer) inOld(path string, inIntermediate bool) bool {
+	if i, ok := c.firstFiles[path]; ok {
+		status := c.first.Patch().Files[i].Status
+		return status == hexdiff.StatusDeleted || status == hexdiff.StatusModified
+	}
+	return c.renamedFrom[path] || inIntermediate
+}
+
+// status 返回合并后写入条目的状态
+func (c *dirComposer) status(path string, inIntermediate bool) hexdiff.FileStatus {
+	if c.inOld(path, inIntermediate) {
+		return hexdiff.StatusModified
+	}
+	return hexdiff.StatusAdded
+}
+
+// keep 保留第一个补丁的条目，差异改写为以合并后的源文件表为源
+func (c *dirComposer) keep(i int) error {
+	original := c.first.Patch().Files[i]
+	if original.Kind != hexdiff.KindFile || original.IsFullContent || unchangedEntry(original) {
+		c.emit(composedEntry{file: original, reader: c.first, index: i})
+		return nil
+	}
+
+	delta, err := c.intermediate(original.RelativePath, original.Size)
+	if err != nil {
+		return err
+	}
+	file := *original
+	file.Status = c.status(file.RelativePath, true)
+	file.OldPath = ""
+	c.emit(composedEntry{file: &file, delta: delta})
+	return nil
+}
+
+// rewrite 将第二个补丁的条目改写为以旧目录为源
+func (c *dirComposer) rewrite(i int) error {
+	original := c.second.Patch().Files[i]
+	switch {
+	case original.Status == hexdiff.StatusDeleted:
+		if c.inOld(original.RelativePath, true) {
+			c.emit(composedEntry{file: original})
+		}
+		return nil
+	case unchangedEntry(original):
+		// 内容与中间目录相同，由第一个补丁的条目决定
+		return nil
+	case original.Kind != hexdiff.KindFile || original.IsFullContent:
+		c.emit(composedEntry{file: original, reader: c.second, index: i})
+		return nil
+	}
+
+	if original.Status == hexdiff.StatusRenamed && c.inOld(original.OldPath, true) && !c.recreated(original.OldPath) {
+		c.emit(composedEntry{file: deletedEntry(original.OldPath)})
+	}
+
+	var data []byte
+	if original.DeltaSize > 0 {
+		var err error
+		if data, err = c.second.ReadData(i); err != nil {
+			return err
+		}
+	}
+
+	// 与DirApplier.stageFile的判断顺序一致
+	var delta *PatchFile
+	var err error
+	renamed := original.Status == hexdiff.StatusRenamed || original.Status == hexdiff.StatusCopied
+	switch {
+	case len(data) == 0 && renamed:
+		delta, err = c.intermediate(original.OldPath, original.Size)
+	case len(data) > 0 && len(c.second.Patch().Sources) > 0:
+		delta, err = c.composeDelta(data, func(int64) (*PatchFile, error) { return c.intermediateSourceTable() })
+	case renamed:
+		delta, err = c.composeDelta(data, func(size int64) (*PatchFile, error) { return c.intermediate(original.OldPath, size) })
+	case original.Status == hexdiff.StatusAdded:
+		delta = literalPatch(data)
+	default:
+		delta, err = c.composeDelta(data, func(size int64) (*PatchFile, error) { return c.intermediate(original.RelativePath, size) })
+	}
+	if err != nil {
+		return err
+	}
+
+	file := *original
+	file.Status = c.status(file.RelativePath, file.Status == hexdiff.StatusModified)
+	file.OldPath = ""
+	c.emit(composedEntry{file: &file, delta: delta})
+	return nil
+}
+
+// composeDelta 解析第二个补丁中的差异，并与其源在中间目录中的表示合并
+func (c *dirComposer) composeDelta(data []byte, base func(size int64) (*PatchFile, error)) (*PatchFile, error) {
+	delta, err := NewSerializer(CompressionNone).DeserializeFromData(data)
+	if err != nil {
+		return nil, fmt.Errorf("deserialize delta: %w", err)
+	}
+	first, err := base(delta.Header.SourceSize)
+	if err != nil {
+		return nil, err
+	}
+	return Compose(first, delta)
+}
+
+// intermediate 返回中间目录中的文件以合并后的源文件表为源的表示，size为该文件的大小
+func (c *dirComposer) intermediate(path string, size int64) (*PatchFile, error) {
+	i, ok := c.firstFiles[path]
+	if !ok || unchangedEntry(c.first.Patch().Files[i]) {
+		return c.identity(path, size)
+	}
+
+	file := c.first.Patch().Files[i]
+	if file.Status == hexdiff.StatusDeleted || file.Kind != hexdiff.KindFile {
+		return nil, fmt.Errorf("%s is not a regular file in the intermediate version", path)
+	}
+
+	var data []byte
+	if file.DeltaSize > 0 {
+		var err error
+		if data, err = c.first.ReadData(i); err != nil {
+			return nil, err
+		}
+	}
+	if file.IsFullContent || (file.Status == hexdiff.StatusAdded && (len(data) == 0 || len(c.first.Patch().Sources) == 0)) {
+		return literalPatch(data), nil
+	}
+	if len(data) == 0 {
+		return c.identity(file.OldPath, file.Size)
+	}
+
+	delta, err := NewSerializer(CompressionNone).DeserializeFromData(data)
+	if err != nil {
+		return nil, fmt.Errorf("deserialize delta: %w", err)
+	}
+	if len(c.first.Patch().Sources) > 0 {
+		return delta, nil
+	}
+
+	sourcePath := path
+	if file.Status == hexdiff.StatusRenamed || file.Status == hexdiff.StatusCopied {
+		sourcePath = file.OldPath
+	}
+	offset, err := c.source(sourcePath, delta.Header.SourceSize)
+	if err != nil {
+		return nil, err
+	}
+	for j := range delta.Operations {
+		if op := &delta.Operations[j]; op.Type == 0 || op.Type == 3 {
+			op.SrcOffset += uint64(offset)
+		}
+	}
+	return delta, nil
+}
+
+// intermediateSourceTable 返回第二个补丁源文件表中所有中间文件拼接后的表示
+func (c *dirComposer) intermediateSourceTable() (*PatchFile, error) {
+	if c.intermediateSources != nil {
+		return c.intermediateSources, nil
+	}
+
+	table := NewPatchFile()
+	for _, source := range c.second.Patch().Sources {
+		file, err := c.intermediate(source.RelativePath, source.Size)
+		if err != nil {
+			return nil, err
+		}
+		if file.Header.TargetSize != source.Size {
+			return nil, fmt.Errorf("%w: source %s size %d, intermediate size %d",
+				ErrPatchChainMismatch, source.RelativePath, source.Size, file.Header.TargetSize)
+		}
+
+		offset, dataOffset := uint64(table.Header.TargetSize), uint64(len(table.Data))
+		for _, op := range file.Operations {
+			op.Offset += offset
+			if op.Type == 1 || op.Type == 3 {
+				op.DataOffset += dataOffset
+			}
+			table.Operations = append(table.Operations, op)
+		}
+		table.Data = append(table.Data, file.Data...)
+		table.Header.TargetSize += source.Size
+	}
+	c.intermediateSources = table
+	return table, nil
+}
+
+// identity 返回旧目录中未改变的文件的表示
+func (c *dirComposer) identity(path string, size int64) (*PatchFile, error) {
+	offset, err := c.source(path, size)
+	if err != nil {
+		return nil, err
+	}
+	patchFile := NewPatchFile()
+	patchFile.Header.TargetSize = size
+	if size > 0 {
+		patchFile.Operations = append(patchFile.Operations, PatchOperation{Type: 0, SrcOffset: uint64(offset), Size: uint64(size)})
+	}
+	return patchFile, nil
+}
+
+// source 返回旧文件在合并后源文件表拼接中的偏移量，文件不在表中时追加到末尾
+func (c *dirComposer) source(path string, size int64) (int64, error) {
+	if i, ok := c.sourceIndex[path]; ok {
+		if c.sources[i].Size != size {
+			return 0, fmt.Errorf("%w: source %s size %d, expected %d", ErrPatchChainMismatch, path, c.sources[i].Size, size)
+		}
+		return c.sourceOffsets[i], nil
+	}
+
+	c.sourceIndex[path] = len(c.sources)
+	c.sources = append(c.sources, hexdiff.DirPatchSource{RelativePath: path, Size: size})
+	c.sourceOffsets = append(c.sourceOffsets, c.size)
+	c.size += size
+	return c.sourceOffsets[len(c.sourceOffsets)-1], nil
+}
+
+// primarySource 返回差异中复制字节最多的源文件序号加1，没有引用源文件时返回0
+func (c *dirComposer) primarySource(delta *PatchFile) uint32 {
+	counts := make(map[int]uint64)
+	for _, op := range delta.Operations {
+		if op.Type == 0 || op.Type == 3 {
+			i := sort.Search(len(c.sourceOffsets), func(i int) bool { return uint64(c.sourceOffsets[i]) > op.SrcOffset }) - 1
+			counts[i] += op.Size
+		}
+	}
+
+	best, bestCount := -1, uint64(0)
+	for i, count := range counts {
+		if count > bestCount || (count == bestCount && i < best) {
+			best, bestCount = i, count
+		}
+	}
+	return uint32(best + 1)
+}
+
+// writeComposed 写入合并后的补丁，源文件表确定后才能写入差异
+func (s *DirPatchSerializer) writeComposed(c *dirComposer, outputPath string) (*hexdiff.DirPatch, error) {
+	first, second := c.first.Patch(), c.second.Patch()
+	result := &hexdiff.DirPatch{
+		Version:   DirPatchVersion,
+		Timestamp: time.Now().Unix(),
+		OldDir:    first.OldDir,
+		NewDir:    second.NewDir,
+		Metadata:  second.Metadata,
+		Sources:   c.sources,
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("create patch file: %w", err)
+	}
+	defer file.Close()
+
+	writer, err := NewDirPatchWriter(file, result.OldDir, result.NewDir, c.sources, result.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.SetCompression(s.compression, s.level); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range c.entries {
+		patchFile, err := s.composedFile(c, entry)
+		if err != nil {
+			return nil, fmt.Errorf("encode %s: %w", entry.file.RelativePath, err)
+		}
+		if err := writer.WriteFile(patchFile); err != nil {
+			return nil, err
+		}
+		patchFile.DeltaSize = int64(len(patchFile.Delta))
+		patchFile.Delta = nil
+		result.Files = append(result.Files, patchFile)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("close patch file: %w", err)
+	}
+	return result, nil
+}
+
+// composedFile 返回带数据的条目，只由字面数据组成的差异保存为完整内容
+func (s *DirPatchSerializer) composedFile(c *dirComposer, entry composedEntry) (*hexdiff.DirPatchFile, error) {
+	file := *entry.file
+	file.SourceID = 0
+
+	switch {
+	case entry.delta != nil:
+		if data, ok := literalData(entry.delta); ok {
+			file.Delta = data
+			file.IsFullContent = true
+			return &file, nil
+		}
+
+		entry.delta.Header.SourceSize = c.size
+		entry.delta.Header.SourceChecksum = [32]byte{}
+		serializer := NewSerializer(s.compression)
+		serializer.SetLevel(s.level)
+		serializer.SetCompressOperations(true)
+
+		var buf bytes.Buffer
+		if err := serializer.SerializePatchTo(entry.delta, &buf); err != nil {
+			return nil, fmt.Errorf("serialize delta: %w", err)
+		}
+		file.Delta = buf.Bytes()
+		file.SourceID = c.primarySource(entry.delta)
+
+	case entry.reader != nil && file.Kind == hexdiff.KindFile && file.DeltaSize > 0:
+		data, err := entry.reader.ReadData(entry.index)
+		if err != nil {
+			return nil, err
+		}
+		file.Delta = data
+	}
+	return &file, nil
+}
+
+// unchangedEntry 判断条目是否为没有差异数据的修改，应用时保留原内容
+func unchangedEntry(file *hexdiff.DirPatchFile) bool {
+	return file.Kind == hexdiff.KindFile && file.Status == hexdiff.StatusModified &&
+		!file.IsFullContent && file.DeltaSize == 0
+}
+
+// deletedEntry 返回删除旧文件的条目
+func deletedEntry(path string) *hexdiff.DirPatchFile {
+	return &hexdiff.DirPatchFile{RelativePath: path, Status: hexdiff.StatusDeleted, Kind: hexdiff.KindFile}
+}
+
+// literalPatch 返回只插入data的补丁
+func literalPatch(data []byte) *PatchFile {
+	patchFile := NewPatchFile()
+	patchFile.Header.TargetSize = int64(len(data))
+	if len(data) > 0 {
+		patchFile.Operations = append(patchFile.Operations, PatchOperation{Type: 1, Size: uint64(len(data))})
+		patchFile.Data = data
+	}
+	return patchFile
+}
+
+// literalData 补丁只包含插入操作时返回生成的内容
+func literalData(patchFile *PatchFile) ([]byte, bool) {
+	data := make([]byte, patchFile.Header.TargetSize)
+	for _, op := range patchFile.Operations {
+		switch op.Type {
+		case 1:
+			if op.Offset+op.Size > uint64(len(data)) || op.DataOffset+op.Size > uint64(len(patchFile.Data)) {
+				return nil, false
+			}
+			copy(data[op.Offset:], patchFile.Data[op.DataOffset:op.DataOffset+op.Size])
+		case 2:
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
@@ -0,0 +1,65 @@
+package patch
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DirPatchFilter 按相对路径选择目录补丁中的条目
+//
+// 模式使用path.Match的语法，匹配条目路径本身或其任意上级目录，例如"lib"和"lib/*"都包含lib下的所有条目；
+// 不含"/"的模式还会匹配任意一级的名称，例如"*.log"匹配任意目录中的日志文件。
+type DirPatchFilter struct {
+	Only    []string // 只选择匹配的条目，为空时选择所有条目
+	Exclude []string // 排除匹配的条目，优先于Only
+}
+
+// NewDirPatchFilter 创建目录补丁过滤器并检查模式语法，only和exclude都为空时返回nil
+func NewDirPatchFilter(only, exclude []string) (*DirPatchFilter, error) {
+	if len(only) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range append(append([]string{}, only...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return &DirPatchFilter{Only: only, Exclude: exclude}, nil
+}
+
+// Match 判断相对路径是否被选择，过滤器为nil时选择所有条目
+func (f *DirPatchFilter) Match(relativePath string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Only) > 0 && !matchDirPatchPath(f.Only, relativePath) {
+		return false
+	}
+	return !matchDirPatchPath(f.Exclude, relativePath)
+}
+
+// String 返回过滤器的文本形式，记录在事务日志中用于判断能否继续上次的提交
+func (f *DirPatchFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return "only=" + strings.Join(f.Only, ",") + ";exclude=" + strings.Join(f.Exclude, ",")
+}
+
+// matchDirPatchPath 判断路径或其上级目录是否匹配任意模式
+func matchDirPatchPath(patterns []string, relativePath string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		matchName := !strings.Contains(pattern, "/")
+		for p := relativePath; p != "." && p != "/"; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(p)); ok && matchName {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package patch
+
+import "testing"
+
+func TestDirPatchFilterMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		only    []string
+		exclude []string
+		path    string
+		want    bool
+	}{
+		{"no patterns", nil, nil, "a/b.txt", true},
+		{"only directory", []string{"lib"}, nil, "lib/x/y.so", true},
+		{"only directory glob", []string{"lib/*"}, nil, "lib/x/y.so", true},
+		{"only other directory", []string{"lib"}, nil, "bin/app", false},
+		{"only nested path", []string{"lib/x"}, nil, "other/lib/x/y.so", false},
+		{"only name at any depth", []string{"*.so"}, nil, "lib/x/y.so", true},
+		{"exclude name", nil, []string{"*.log"}, "var/run.log", false},
+		{"exclude directory name", nil, []string{"cache"}, "a/cache/b", false},
+		{"exclude wins over only", []string{"lib"}, []string{"lib/x"}, "lib/x/y.so", false},
+		{"trailing slash", []string{"lib/"}, nil, "lib/y.so", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewDirPatchFilter(tt.only, tt.exclude)
+			if err != nil {
+				t.Fatalf("NewDirPatchFilter() error = %v", err)
+			}
+			if got := filter.Match(tt.path); got != tt.want {
+				t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDirPatchFilterInvalidPattern(t *testing.T) {
+	if _, err := NewDirPatchFilter([]string{"lib/["}, nil); err == nil {
+		t.Error("NewDirPatchFilter() should reject malformed patterns")
+	}
+}
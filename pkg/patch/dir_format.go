@@ -9,17 +9,58 @@ const (
 	DirPatchMagic      = 0x48455844 // "HEXD"
 	DirPatchVersion    = 2          // 版本2表示目录补丁
 	DirPatchHeaderSize = 64
+	DirPatchEntrySize  = 64
+
+	// DirPatchSourceEntrySize 源文件表条目大小（不含路径）
+	DirPatchSourceEntrySize = 12
+
+	// DirPatchSourceIDSize 源文件表不为空时，每个文件条目后紧跟4字节的源文件序号
+	DirPatchSourceIDSize = 4
+
+	// DirPatchKindSize 设置DirPatchFlagEntryKind时，每个文件条目后（源文件序号之后）紧跟1字节的条目类型
+	DirPatchKindSize = 1
+
+	// DirPatchCompressionSize 设置DirPatchFlagEntryCompression时，条目类型之后紧跟1字节的数据压缩类型
+	DirPatchCompressionSize = 1
+
+	// DirPatchIndexEntrySize 索引表条目大小
+	DirPatchIndexEntrySize = 24
+	// DirPatchTrailerSize 文件末尾指向索引表的尾部大小
+	DirPatchTrailerSize = 16
+	// DirPatchIndexMagic 尾部魔数
+	DirPatchIndexMagic = 0x48584449 // "HXDI"
 )
 
+// 目录补丁头部标志
+const (
+	// DirPatchFlagEntryKind 条目带有类型，目录没有数据，符号链接的数据为链接目标
+	DirPatchFlagEntryKind uint16 = 1 << 0
+	// DirPatchFlagIndexed 条目之后是索引表，文件以指向索引表的尾部结束
+	DirPatchFlagIndexed uint16 = 1 << 1
+	// DirPatchFlagPathIndex 索引表之后是路径表，依次为每个条目的2字节路径长度和相对路径，
+	// 读取时不需要解析条目即可按路径定位
+	DirPatchFlagPathIndex uint16 = 1 << 2
+	// DirPatchFlagEntryCompression 条目带有数据的压缩类型，只有完整内容的普通文件会被压缩，
+	// 差异数据在其自身的补丁头中记录压缩类型
+	DirPatchFlagEntryCompression uint16 = 1 << 3
+	// DirPatchFlagReverse 正向条目之后是状态带有DirPatchStatusReverse的反向条目，
+	// 反向条目的差异以新目录中的文件为源，不使用源文件表，补丁因此可以反向应用
+	DirPatchFlagReverse uint16 = 1 << 4
+)
+
+// DirPatchStatusReverse 设置DirPatchFlagReverse时，条目状态的最高位表示反向条目
+const DirPatchStatusReverse uint8 = 0x80
+
 type DirPatchHeader struct {
 	Magic         uint32
 	Version       uint16
-	Reserved      uint16
+	Flags         uint16 // 头部标志
 	Timestamp     int64
 	OldDirNameLen uint32
 	NewDirNameLen uint32
 	FileCount     uint32
 	MetadataLen   uint32
+	SourceCount   uint32 // 源文件表中的文件数，位于元数据之后
 	Reserved2     uint16
 }
 
@@ -37,12 +78,13 @@ func (h *DirPatchHeader) Marshal() []byte {
 	buf := make([]byte, DirPatchHeaderSize)
 	binary.LittleEndian.PutUint32(buf[0:4], h.Magic)
 	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
-	binary.LittleEndian.PutUint16(buf[6:8], h.Reserved)
+	binary.LittleEndian.PutUint16(buf[6:8], h.Flags)
 	binary.LittleEndian.PutUint64(buf[8:16], uint64(h.Timestamp))
 	binary.LittleEndian.PutUint32(buf[16:20], h.OldDirNameLen)
 	binary.LittleEndian.PutUint32(buf[20:24], h.NewDirNameLen)
 	binary.LittleEndian.PutUint32(buf[24:28], h.FileCount)
 	binary.LittleEndian.PutUint32(buf[28:32], h.MetadataLen)
+	binary.LittleEndian.PutUint32(buf[32:36], h.SourceCount)
 	binary.LittleEndian.PutUint16(buf[60:62], h.Reserved2)
 	return buf
 }
@@ -53,16 +95,20 @@ func (h *DirPatchHeader) Unmarshal(data []byte) error {
 	}
 	h.Magic = binary.LittleEndian.Uint32(data[0:4])
 	h.Version = binary.LittleEndian.Uint16(data[4:6])
-	h.Reserved = binary.LittleEndian.Uint16(data[6:8])
+	h.Flags = binary.LittleEndian.Uint16(data[6:8])
 	h.Timestamp = int64(binary.LittleEndian.Uint64(data[8:16]))
 	h.OldDirNameLen = binary.LittleEndian.Uint32(data[16:20])
 	h.NewDirNameLen = binary.LittleEndian.Uint32(data[20:24])
 	h.FileCount = binary.LittleEndian.Uint32(data[24:28])
 	h.MetadataLen = binary.LittleEndian.Uint32(data[28:32])
+	h.SourceCount = binary.LittleEndian.Uint32(data[32:36])
 	h.Reserved2 = binary.LittleEndian.Uint16(data[60:62])
 	return h.Validate()
 }
 
+// dirPatchDataLenOffset 条目中数据长度字段的偏移量，写入压缩数据后回填
+const dirPatchDataLenOffset = 57
+
 type DirPatchEntry struct {
 	PathLen       uint32
 	Status        uint8
@@ -72,25 +118,28 @@ type DirPatchEntry struct {
 	Checksum      [32]byte
 	DataLen       uint32
 	IsFullContent uint8
-	Reserved      uint16
+	OldPathLen    uint16 // 重命名或复制的原路径长度，原路径紧跟在路径之后
+	SourceID      uint32 // 主要来源的源文件序号加1，只在源文件表不为空时写入
+	Kind          uint8  // 条目类型，只在设置DirPatchFlagEntryKind时写入
+	Compression   uint8  // 数据的压缩类型，只在设置DirPatchFlagEntryCompression时写入
 }
 
 func (e *DirPatchEntry) Marshal() []byte {
-	buf := make([]byte, 64)
+	buf := make([]byte, DirPatchEntrySize)
 	binary.LittleEndian.PutUint32(buf[0:4], e.PathLen)
 	buf[4] = e.Status
 	binary.LittleEndian.PutUint32(buf[5:9], e.Mode)
 	binary.LittleEndian.PutUint64(buf[9:17], uint64(e.MTime))
 	binary.LittleEndian.PutUint64(buf[17:25], uint64(e.Size))
 	copy(buf[25:57], e.Checksum[:])
-	binary.LittleEndian.PutUint32(buf[57:61], e.DataLen)
+	binary.LittleEndian.PutUint32(buf[dirPatchDataLenOffset:], e.DataLen)
 	buf[61] = e.IsFullContent
-	binary.LittleEndian.PutUint16(buf[62:64], e.Reserved)
+	binary.LittleEndian.PutUint16(buf[62:64], e.OldPathLen)
 	return buf
 }
 
 func (e *DirPatchEntry) Unmarshal(data []byte) error {
-	if len(data) < 64 {
+	if len(data) < DirPatchEntrySize {
 		return fmt.Errorf("insufficient data for entry")
 	}
 	e.PathLen = binary.LittleEndian.Uint32(data[0:4])
@@ -101,6 +150,81 @@ func (e *DirPatchEntry) Unmarshal(data []byte) error {
 	copy(e.Checksum[:], data[25:57])
 	e.DataLen = binary.LittleEndian.Uint32(data[57:61])
 	e.IsFullContent = data[61]
-	e.Reserved = binary.LittleEndian.Uint16(data[62:64])
+	e.OldPathLen = binary.LittleEndian.Uint16(data[62:64])
+	return nil
+}
+
+// DirPatchSourceEntry 源文件表条目，后跟路径
+type DirPatchSourceEntry struct {
+	PathLen uint32
+	Size    int64
+}
+
+func (e *DirPatchSourceEntry) Marshal() []byte {
+	buf := make([]byte, DirPatchSourceEntrySize)
+	binary.LittleEndian.PutUint32(buf[0:4], e.PathLen)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(e.Size))
+	return buf
+}
+
+func (e *DirPatchSourceEntry) Unmarshal(data []byte) error {
+	if len(data) < DirPatchSourceEntrySize {
+		return fmt.Errorf("insufficient data for source entry")
+	}
+	e.PathLen = binary.LittleEndian.Uint32(data[0:4])
+	e.Size = int64(binary.LittleEndian.Uint64(data[4:12]))
+	return nil
+}
+
+// DirPatchIndexEntry 索引表条目，按条目顺序排列
+type DirPatchIndexEntry struct {
+	EntryOffset uint64 // 条目在补丁文件中的偏移量
+	DataOffset  uint64 // 条目数据的偏移量
+	DataLen     uint64 // 条目数据的长度，超过4GB的数据只能从索引中得到长度
+}
+
+func (e *DirPatchIndexEntry) Marshal() []byte {
+	buf := make([]byte, DirPatchIndexEntrySize)
+	binary.LittleEndian.PutUint64(buf[0:8], e.EntryOffset)
+	binary.LittleEndian.PutUint64(buf[8:16], e.DataOffset)
+	binary.LittleEndian.PutUint64(buf[16:24], e.DataLen)
+	return buf
+}
+
+func (e *DirPatchIndexEntry) Unmarshal(data []byte) error {
+	if len(data) < DirPatchIndexEntrySize {
+		return fmt.Errorf("insufficient data for index entry")
+	}
+	e.EntryOffset = binary.LittleEndian.Uint64(data[0:8])
+	e.DataOffset = binary.LittleEndian.Uint64(data[8:16])
+	e.DataLen = binary.LittleEndian.Uint64(data[16:24])
+	return nil
+}
+
+// DirPatchTrailer 补丁文件的尾部
+type DirPatchTrailer struct {
+	IndexOffset uint64 // 索引表的偏移量
+	EntryCount  uint32 // 条目数
+	Magic       uint32
+}
+
+func (t *DirPatchTrailer) Marshal() []byte {
+	buf := make([]byte, DirPatchTrailerSize)
+	binary.LittleEndian.PutUint64(buf[0:8], t.IndexOffset)
+	binary.LittleEndian.PutUint32(buf[8:12], t.EntryCount)
+	binary.LittleEndian.PutUint32(buf[12:16], t.Magic)
+	return buf
+}
+
+func (t *DirPatchTrailer) Unmarshal(data []byte) error {
+	if len(data) < DirPatchTrailerSize {
+		return fmt.Errorf("insufficient data for trailer")
+	}
+	t.IndexOffset = binary.LittleEndian.Uint64(data[0:8])
+	t.EntryCount = binary.LittleEndian.Uint32(data[8:12])
+	t.Magic = binary.LittleEndian.Uint32(data[12:16])
+	if t.Magic != DirPatchIndexMagic {
+		return fmt.Errorf("invalid trailer magic: %x", t.Magic)
+	}
 	return nil
 }
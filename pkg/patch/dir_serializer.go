@@ -1,19 +1,19 @@
 package patch
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
-	"time"
 
 	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
 )
 
 type DirPatchSerializer struct {
 	compression CompressionType
+	level       CompressionLevel
 }
 
 func NewDirPatchSerializer(compression CompressionType) *DirPatchSerializer {
@@ -22,156 +22,121 @@ func NewDirPatchSerializer(compression CompressionType) *DirPatchSerializer {
 	}
 }
 
+// SetLevel 设置压缩级别，0表示使用算法的默认级别
+func (s *DirPatchSerializer) SetLevel(level CompressionLevel) {
+	s.level = level
+}
+
+// SerializeDirPatch 将目录差异写入补丁文件
 func (s *DirPatchSerializer) SerializeDirPatch(result *hexdiff.DirDiffResult, oldDir, newDir, outputPath string) error {
-	dirPatch := hexdiff.NewDirPatch(oldDir, newDir)
+	sink, err := s.NewSink(outputPath, oldDir, newDir)
+	if err != nil {
+		return err
+	}
+	if err := sink.Begin(result); err != nil {
+		sink.Close()
+		return err
+	}
 
-	for _, diff := range result.AddedFiles {
-		entry := &hexdiff.DirPatchFile{
-			RelativePath:  diff.RelativePath,
-			Status:        diff.Status,
-			Mode:          diff.NewEntry.Mode,
-			MTime:         diff.NewEntry.MTime.Unix(),
-			Size:          diff.NewEntry.Size,
-			Delta:         diff.PatchData,
-			IsFullContent: true,
+	lists := [][]*hexdiff.FileDiff{
+		result.AddedFiles, result.RenamedFiles, result.CopiedFiles, result.DeletedFiles, result.ModifiedFiles,
+	}
+	for _, list := range lists {
+		for _, diff := range list {
+			if err := sink.WriteFile(diff); err != nil {
+				sink.Close()
+				return err
+			}
 		}
-		dirPatch.AddFile(entry)
 	}
 
-	for _, diff := range result.DeletedFiles {
-		entry := &hexdiff.DirPatchFile{
+	return sink.Close()
+}
+
+// patchFile 将文件差异转换为补丁条目，内容没有读入内存的新增文件Delta为nil
+func (s *DirPatchSerializer) patchFile(diff *hexdiff.FileDiff) (*hexdiff.DirPatchFile, error) {
+	if diff.Status == hexdiff.StatusDeleted {
+		return &hexdiff.DirPatchFile{
 			RelativePath: diff.RelativePath,
 			Status:       diff.Status,
 			Mode:         diff.OldEntry.Mode,
 			MTime:        diff.OldEntry.MTime.Unix(),
 			Size:         diff.OldEntry.Size,
-		}
-		dirPatch.AddFile(entry)
-	}
-
-	for _, diff := range result.ModifiedFiles {
-		entry := &hexdiff.DirPatchFile{
-			RelativePath:  diff.RelativePath,
-			Status:        diff.Status,
-			Mode:          diff.NewEntry.Mode,
-			MTime:         diff.NewEntry.MTime.Unix(),
-			Size:          diff.NewEntry.Size,
-			IsFullContent: false,
-		}
-
+			Kind:         diff.OldEntry.Kind(),
+		}, nil
+	}
+
+	entry := &hexdiff.DirPatchFile{
+		RelativePath: diff.RelativePath,
+		Status:       diff.Status,
+		Mode:         diff.NewEntry.Mode,
+		MTime:        diff.NewEntry.MTime.Unix(),
+		Size:         diff.NewEntry.Size,
+		SourceID:     diff.SourceID,
+		Checksum:     newFileChecksum(diff),
+		Kind:         diff.NewEntry.Kind(),
+		LinkTarget:   diff.NewEntry.LinkTarget,
+	}
+
+	switch diff.Status {
+	case hexdiff.StatusRenamed, hexdiff.StatusCopied:
+		// 内容相同时只记录原路径
+		entry.OldPath = diff.OldEntry.RelativePath
+		entry.Kind = hexdiff.KindFile
+		entry.LinkTarget = ""
 		if diff.Delta != nil {
-			entry.Delta = s.serializeDelta(diff.Delta)
+			var err error
+			if entry.Delta, err = s.serializeDelta(diff.Delta); err != nil {
+				return nil, err
+			}
 		}
-
-		dirPatch.AddFile(entry)
+		return entry, nil
 	}
 
-	return s.writeDirPatch(dirPatch, outputPath)
-}
-
-func (s *DirPatchSerializer) serializeDelta(delta *hexdiff.Delta) []byte {
-	buf := &bytes.Buffer{}
-
-	currentDataOffset := uint32(0)
-	dataBuf := &bytes.Buffer{}
-
-	operations := make([]PatchOperation, len(delta.Operations))
-	for i, op := range delta.Operations {
-		patchOp := PatchOperation{
-			Type:      uint8(op.Type),
-			Size:      uint32(op.Size),
-			Offset:    uint64(op.Offset),
-			SrcOffset: uint64(op.SrcOffset),
+	// 内容来自其他旧文件时保存差异，新增文件和旧条目不是普通文件的修改保存完整内容
+	fullContent := diff.Status == hexdiff.StatusAdded ||
+		(entry.Kind == hexdiff.KindFile && diff.OldEntry.Kind() != hexdiff.KindFile)
+	if diff.Delta != nil {
+		var err error
+		if entry.Delta, err = s.serializeDelta(diff.Delta); err != nil {
+			return nil, err
 		}
-
-		if op.Type == 1 {
-			patchOp.DataOffset = currentDataOffset
-			dataBuf.Write(op.Data)
-			currentDataOffset += uint32(len(op.Data))
-		}
-
-		operations[i] = patchOp
-	}
-
-	dataOffset := uint32(HeaderSize + uint32(len(delta.Operations))*OperationSize)
-
-	header := &PatchHeader{
-		Magic:          0x48455844,
-		Version:        1,
-		Compression:    CompressionNone,
-		SourceSize:     delta.SourceSize,
-		TargetSize:     delta.TargetSize,
-		TargetChecksum: delta.Checksum,
-		OperationCount: uint32(len(delta.Operations)),
-		DataOffset:     dataOffset,
-		Timestamp:      time.Now().Unix(),
+	} else if fullContent {
+		entry.Delta = diff.PatchData
+		entry.IsFullContent = true
 	}
+	return entry, nil
+}
 
-	buf.Write(header.Marshal())
+// serializeDelta 将单个文件的差异编码为HEXD补丁，插入数据和操作列表按设置的算法压缩
+func (s *DirPatchSerializer) serializeDelta(delta *hexdiff.Delta) ([]byte, error) {
+	serializer := NewSerializer(s.compression)
+	serializer.SetLevel(s.level)
+	serializer.SetCompressOperations(true)
 
-	for _, op := range operations {
-		buf.Write(op.Marshal())
+	var buf bytes.Buffer
+	if err := serializer.SerializeDeltaTo(delta, [32]byte{}, &buf); err != nil {
+		return nil, fmt.Errorf("serialize delta: %w", err)
 	}
-
-	buf.Write(dataBuf.Bytes())
-
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
-func (s *DirPatchSerializer) writeDirPatch(dirPatch *hexdiff.DirPatch, outputPath string) error {
-	file, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("create patch file: %w", err)
+// newFileChecksum 返回新文件的SHA-256校验和，应用时用于校验生成的文件，目录和符号链接没有校验和
+func newFileChecksum(diff *hexdiff.FileDiff) [32]byte {
+	if diff.NewEntry == nil || diff.NewEntry.Kind() != hexdiff.KindFile {
+		return [32]byte{}
 	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
-
-	oldDirName := dirPatch.OldDir
-	newDirName := dirPatch.NewDir
-
-	header := DirPatchHeader{
-		Magic:         DirPatchMagic,
-		Version:       DirPatchVersion,
-		Timestamp:     dirPatch.Timestamp,
-		OldDirNameLen: uint32(len(oldDirName)),
-		NewDirNameLen: uint32(len(newDirName)),
-		FileCount:     uint32(dirPatch.GetFileCount()),
+	if diff.Delta != nil && diff.Delta.Checksum != [32]byte{} {
+		return diff.Delta.Checksum
 	}
-
-	metadataJSON, _ := json.Marshal(dirPatch.Metadata)
-	header.MetadataLen = uint32(len(metadataJSON))
-
-	writer.Write(header.Marshal())
-	writer.WriteString(oldDirName)
-	writer.WriteString(newDirName)
-
-	if len(metadataJSON) > 0 {
-		writer.Write(metadataJSON)
+	if diff.Delta == nil && diff.PatchData != nil {
+		return sha256.Sum256(diff.PatchData)
 	}
-
-	for _, filePatch := range dirPatch.Files {
-		entry := DirPatchEntry{
-			PathLen:       uint32(len(filePatch.RelativePath)),
-			Status:        uint8(filePatch.Status),
-			Mode:          uint32(filePatch.Mode),
-			MTime:         filePatch.MTime,
-			Size:          filePatch.Size,
-			DataLen:       uint32(len(filePatch.Delta)),
-			IsFullContent: boolToUint8(filePatch.IsFullContent),
-		}
-		copy(entry.Checksum[:], filePatch.Checksum[:])
-
-		writer.Write(entry.Marshal())
-		writer.WriteString(filePatch.RelativePath)
-
-		if len(filePatch.Delta) > 0 {
-			writer.Write(filePatch.Delta)
-		}
+	checksum, err := calculateFileChecksum(diff.NewEntry.AbsPath)
+	if err != nil {
+		return [32]byte{}
 	}
-
-	return nil
+	return checksum
 }
 
 func boolToUint8(b bool) uint8 {
@@ -192,88 +157,22 @@ type DirPatchFileReader struct {
 	IsFullContent bool
 }
 
+// DeserializeDirPatch 读取整个目录补丁，所有条目的数据都会读入内存
 func (s *DirPatchSerializer) DeserializeDirPatch(inputPath string) (*hexdiff.DirPatch, error) {
-	file, err := os.Open(inputPath)
+	reader, err := OpenDirPatch(inputPath)
 	if err != nil {
-		return nil, fmt.Errorf("open patch file: %w", err)
-	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-
-	headerData := make([]byte, DirPatchHeaderSize)
-	if _, err := io.ReadFull(reader, headerData); err != nil {
-		return nil, fmt.Errorf("read header: %w", err)
-	}
-
-	header := &DirPatchHeader{}
-	if err := header.Unmarshal(headerData); err != nil {
-		return nil, fmt.Errorf("parse header: %w", err)
-	}
-
-	dirPatch := &hexdiff.DirPatch{
-		Version:   header.Version,
-		Timestamp: header.Timestamp,
-	}
-
-	oldDirName := make([]byte, header.OldDirNameLen)
-	newDirName := make([]byte, header.NewDirNameLen)
-
-	if _, err := io.ReadFull(reader, oldDirName); err != nil {
-		return nil, fmt.Errorf("read old dir name: %w", err)
+		return nil, err
 	}
-	if _, err := io.ReadFull(reader, newDirName); err != nil {
-		return nil, fmt.Errorf("read new dir name: %w", err)
-	}
-
-	dirPatch.OldDir = string(oldDirName)
-	dirPatch.NewDir = string(newDirName)
-
-	if header.MetadataLen > 0 {
-		metadataJSON := make([]byte, header.MetadataLen)
-		if _, err := io.ReadFull(reader, metadataJSON); err != nil {
-			return nil, fmt.Errorf("read metadata: %w", err)
-		}
-		json.Unmarshal(metadataJSON, &dirPatch.Metadata)
-	}
-
-	dirPatch.Files = make([]*hexdiff.DirPatchFile, 0, header.FileCount)
-
-	for i := uint32(0); i < header.FileCount; i++ {
-		entryData := make([]byte, 64)
-		if _, err := io.ReadFull(reader, entryData); err != nil {
-			return nil, fmt.Errorf("read entry %d: %w", i, err)
-		}
-
-		entry := &DirPatchEntry{}
-		if err := entry.Unmarshal(entryData); err != nil {
-			return nil, fmt.Errorf("parse entry %d: %w", i, err)
-		}
+	defer reader.Close()
 
-		pathBytes := make([]byte, entry.PathLen)
-		if _, err := io.ReadFull(reader, pathBytes); err != nil {
-			return nil, fmt.Errorf("read path %d: %w", i, err)
+	dirPatch := reader.Patch()
+	for i, filePatch := range dirPatch.Files {
+		if filePatch.Kind == hexdiff.KindSymlink || filePatch.DeltaSize == 0 {
+			continue
 		}
-
-		filePatch := &hexdiff.DirPatchFile{
-			RelativePath:  string(pathBytes),
-			Status:        hexdiff.FileStatus(entry.Status),
-			Mode:          os.FileMode(entry.Mode),
-			MTime:         entry.MTime,
-			Size:          entry.Size,
-			IsFullContent: entry.IsFullContent == 1,
+		if filePatch.Delta, err = reader.ReadData(i); err != nil {
+			return nil, err
 		}
-		copy(filePatch.Checksum[:], entry.Checksum[:])
-
-		if entry.DataLen > 0 {
-			delta := make([]byte, entry.DataLen)
-			if _, err := io.ReadFull(reader, delta); err != nil {
-				return nil, fmt.Errorf("read delta %d: %w", i, err)
-			}
-			filePatch.Delta = delta
-		}
-
-		dirPatch.Files = append(dirPatch.Files, filePatch)
 	}
 
 	return dirPatch, nil
@@ -300,9 +199,19 @@ func GetDirPatchInfo(patchPath string) (*DirPatchHeader, error) {
 }
 
 func IsDirPatch(patchPath string) (bool, error) {
-	header, err := GetDirPatchInfo(patchPath)
+	file, err := os.Open(patchPath)
 	if err != nil {
-		return false, err
+		return false, fmt.Errorf("open patch file: %w", err)
 	}
-	return header.Magic == DirPatchMagic && header.Version == DirPatchVersion, nil
+	defer file.Close()
+
+	// 单文件补丁与目录补丁共用魔数，只能通过版本号区分，因此这里不做完整的头部校验
+	prefix := make([]byte, 6)
+	if _, err := io.ReadFull(file, prefix); err != nil {
+		return false, fmt.Errorf("read header: %w", err)
+	}
+
+	magic := binary.LittleEndian.Uint32(prefix[0:4])
+	version := binary.LittleEndian.Uint16(prefix[4:6])
+	return magic == DirPatchMagic && version == DirPatchVersion, nil
 }
@@ -1,6 +1,7 @@
 package patch
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -336,3 +337,141 @@ func TestGetDirPatchInfo(t *testing.T) {
 		t.Errorf("NewDirNameLen = %d, want 6", header.NewDirNameLen)
 	}
 }
+
+func TestDirPatchSerializerSources(t *testing.T) {
+	patchFile := filepath.Join(t.TempDir(), "test.patch")
+
+	result := hexdiff.NewDirDiffResult("old", "new")
+	result.Sources = []*hexdiff.FileEntry{
+		{RelativePath: "a.txt", Size: 5},
+		{RelativePath: "sub/b.txt", Size: 7},
+	}
+	delta := hexdiff.NewDelta(12, 9)
+	delta.AddOperation(hexdiff.Operation{Type: hexdiff.OpCopy, Offset: 0, Size: 9, SrcOffset: 3})
+	result.AddFileDiff(&hexdiff.FileDiff{
+		RelativePath: "moved.txt",
+		Status:       hexdiff.StatusAdded,
+		NewEntry:     &hexdiff.FileEntry{RelativePath: "moved.txt", Size: 9, Mode: 0644, MTime: time.Now()},
+		Delta:        delta,
+		SourceID:     2,
+	})
+
+	serializer := NewDirPatchSerializer(CompressionNone)
+	if err := serializer.SerializeDirPatch(result, "old", "new", patchFile); err != nil {
+		t.Fatalf("SerializeDirPatch() error = %v", err)
+	}
+
+	dirPatch, err := serializer.DeserializeDirPatch(patchFile)
+	if err != nil {
+		t.Fatalf("DeserializeDirPatch() error = %v", err)
+	}
+	if len(dirPatch.Sources) != 2 || dirPatch.Sources[1].RelativePath != "sub/b.txt" || dirPatch.Sources[1].Size != 7 {
+		t.Fatalf("Sources = %+v", dirPatch.Sources)
+	}
+	if len(dirPatch.Files) != 1 {
+		t.Fatalf("len(Files) = %d, want 1", len(dirPatch.Files))
+	}
+	file := dirPatch.Files[0]
+	if file.IsFullContent {
+		t.Error("added file with delta should not be full content")
+	}
+	if file.SourceID != 2 {
+		t.Errorf("SourceID = %d, want 2", file.SourceID)
+	}
+
+	// 按源文件表从旧目录中读取并应用差异
+	oldDir := t.TempDir()
+	os.MkdirAll(filepath.Join(oldDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(oldDir, "a.txt"), []byte("01234"), 0644)
+	os.WriteFile(filepath.Join(oldDir, "sub", "b.txt"), []byte("5678abc"), 0644)
+
+	reader := NewDirSourceReader(oldDir, dirPatch.Sources)
+	defer reader.Close()
+
+	target := filepath.Join(t.TempDir(), "moved.txt")
+	if err := NewApplier(nil).ApplyDeltaFrom(reader, file.Delta, target); err != nil {
+		t.Fatalf("ApplyDeltaFrom() error = %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "345678abc" {
+		t.Errorf("target = %q, want %q", data, "345678abc")
+	}
+}
+
+func TestDirSourceReaderDetectsSizeMismatch(t *testing.T) {
+	oldDir := t.TempDir()
+	os.WriteFile(filepath.Join(oldDir, "a.txt"), []byte("changed"), 0644)
+
+	reader := NewDirSourceReader(oldDir, []hexdiff.DirPatchSource{{RelativePath: "a.txt", Size: 5}})
+	defer reader.Close()
+
+	if _, err := reader.ReadAt(make([]byte, 3), 0); err == nil {
+		t.Error("expected error for a source with a different size")
+	}
+}
+
+func TestDirPatchRejectsUnsafeSources(t *testing.T) {
+	dir := t.TempDir()
+	oldDir := filepath.Join(dir, "old")
+	os.Mkdir(oldDir, 0755)
+	secret := []byte("private key")
+	if err := os.WriteFile(filepath.Join(dir, "secret"), secret, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sources := []hexdiff.DirPatchSource{{RelativePath: "../secret", Size: int64(len(secret))}}
+
+	reader := NewDirSourceReader(oldDir, sources)
+	defer reader.Close()
+	if _, err := reader.ReadAt(make([]byte, len(secret)), 0); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("ReadAt() error = %v, want ErrUnsafePath", err)
+	}
+
+	patchPath := filepath.Join(dir, "sources.patch")
+	writeDirPatchEntries(t, patchPath, sources, &hexdiff.DirPatchFile{RelativePath: "copy.txt", Status: hexdiff.StatusAdded, Mode: 0644, SourceID: 1})
+	if _, err := OpenDirPatch(patchPath); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("OpenDirPatch() error = %v, want ErrUnsafePath", err)
+	}
+}
+
+func TestDirPatchSerializerRenames(t *testing.T) {
+	patchFile := filepath.Join(t.TempDir(), "test.patch")
+
+	result := hexdiff.NewDirDiffResult("old", "new")
+	result.AddFileDiff(&hexdiff.FileDiff{
+		RelativePath: "dir/new-name.txt",
+		Status:       hexdiff.StatusRenamed,
+		OldEntry:     &hexdiff.FileEntry{RelativePath: "old-name.txt", Size: 4},
+		NewEntry:     &hexdiff.FileEntry{RelativePath: "dir/new-name.txt", Size: 4, Mode: 0644, MTime: time.Now()},
+		Similarity:   1,
+	})
+	result.AddFileDiff(&hexdiff.FileDiff{
+		RelativePath: "copy.txt",
+		Status:       hexdiff.StatusCopied,
+		OldEntry:     &hexdiff.FileEntry{RelativePath: "kept.txt", Size: 4},
+		NewEntry:     &hexdiff.FileEntry{RelativePath: "copy.txt", Size: 4, Mode: 0644, MTime: time.Now()},
+		Similarity:   1,
+	})
+
+	serializer := NewDirPatchSerializer(CompressionNone)
+	if err := serializer.SerializeDirPatch(result, "old", "new", patchFile); err != nil {
+		t.Fatalf("SerializeDirPatch() error = %v", err)
+	}
+
+	dirPatch, err := serializer.DeserializeDirPatch(patchFile)
+	if err != nil {
+		t.Fatalf("DeserializeDirPatch() error = %v", err)
+	}
+	if len(dirPatch.Files) != 2 {
+		t.Fatalf("len(Files) = %d, want 2", len(dirPatch.Files))
+	}
+
+	renamed, copied := dirPatch.Files[0], dirPatch.Files[1]
+	if renamed.Status != hexdiff.StatusRenamed || renamed.RelativePath != "dir/new-name.txt" || renamed.OldPath != "old-name.txt" {
+		t.Errorf("renamed entry = %+v", renamed)
+	}
+	if copied.Status != hexdiff.StatusCopied || copied.RelativePath != "copy.txt" || copied.OldPath != "kept.txt" {
+		t.Errorf("copied entry = %+v", copied)
+	}
+	if len(renamed.Delta) != 0 {
+		t.Error("exact rename should not store any content")
+	}
+}
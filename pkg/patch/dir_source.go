@@ -0,0 +1,124 @@
+package patch
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// DirSourceReader 将目录补丁源文件表中的旧文件视为一个拼接的源进行随机读取
+//
+// 文件按需打开，同一时间只保持一个文件句柄。不支持并发读取。
+type DirSourceReader struct {
+	root    string
+	sources []hexdiff.DirPatchSource
+	offsets []int64
+	size    int64
+
+	current int
+	file    *os.File
+}
+
+// NewDirSourceReader 创建源文件读取器，root为旧目录
+func NewDirSourceReader(root string, sources []hexdiff.DirPatchSource) *DirSourceReader {
+	r := &DirSourceReader{
+		root:    root,
+		sources: sources,
+		offsets: make([]int64, len(sources)),
+		current: -1,
+	}
+	for i, source := range sources {
+		r.offsets[i] = r.size
+		r.size += source.Size
+	}
+	return r
+}
+
+// Size 返回所有源文件的总大小
+func (r *DirSourceReader) Size() int64 {
+	return r.size
+}
+
+// ReadAt 实现io.ReaderAt，读取可以跨越文件边界
+func (r *DirSourceReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+
+	read := 0
+	for read < len(p) {
+		if off >= r.size {
+			return read, io.EOF
+		}
+
+		i := sort.Search(len(r.offsets), func(i int) bool {
+			return r.offsets[i] > off
+		}) - 1
+		file, err := r.open(i)
+		if err != nil {
+			return read, err
+		}
+
+		local := off - r.offsets[i]
+		chunk := p[read:min(len(p), read+int(r.sources[i].Size-local))]
+		n, err := file.ReadAt(chunk, local)
+		read += n
+		off += int64(n)
+		if err == io.EOF && n == len(chunk) {
+			err = nil
+		}
+		if err != nil {
+			return read, fmt.Errorf("read source %s: %w", r.sources[i].RelativePath, err)
+		}
+	}
+	return read, nil
+}
+
+// open 打开源文件表中的第i个文件，并检查其大小与补丁记录的一致
+func (r *DirSourceReader) open(i int) (*os.File, error) {
+	if r.current == i {
+		return r.file, nil
+	}
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+		r.current = -1
+	}
+
+	source := r.sources[i]
+	if err := checkPatchPath(source.RelativePath); err != nil {
+		return nil, fmt.Errorf("open source: %w", err)
+	}
+	file, err := os.Open(filepath.Join(r.root, filepath.FromSlash(source.RelativePath)))
+	if err != nil {
+		return nil, fmt.Errorf("open source: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("stat source: %w", err)
+	}
+	if info.Size() != source.Size {
+		file.Close()
+		return nil, fmt.Errorf("source %s size mismatch: expected %d, got %d", source.RelativePath, source.Size, info.Size())
+	}
+
+	r.file = file
+	r.current = i
+	return file, nil
+}
+
+// Close 关闭当前打开的源文件
+func (r *DirSourceReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.current = -1
+	return err
+}
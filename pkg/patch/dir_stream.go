@@ -0,0 +1,797 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// DirPatchWriter 流式目录补丁写入器
+//
+// 每个条目写入后不再保留在内存中，所有条目写完后在末尾写入索引表、路径表和尾部。
+// 目标支持io.WriterAt时，关闭时会回填头部的条目数，压缩的文件内容也会边读边压缩后回填条目中的数据长度。
+type DirPatchWriter struct {
+	dst        io.Writer
+	w          *bufio.Writer
+	offset     int64
+	hasSources bool
+	flags      uint16
+	reverse    bool
+	codec      *codec
+	index      []DirPatchIndexEntry
+	paths      []string
+}
+
+// NewDirPatchWriter 创建写入器并写入头部、目录名、元数据和源文件表，dst应从头部开始写入
+func NewDirPatchWriter(dst io.Writer, oldDir, newDir string, sources []hexdiff.DirPatchSource, metadata map[string]string) (*DirPatchWriter, error) {
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	w := &DirPatchWriter{
+		dst:        dst,
+		w:          bufio.NewWriter(dst),
+		hasSources: len(sources) > 0,
+		flags:      DirPatchFlagEntryKind | DirPatchFlagIndexed | DirPatchFlagPathIndex | DirPatchFlagEntryCompression,
+	}
+
+	header := DirPatchHeader{
+		Magic:         DirPatchMagic,
+		Version:       DirPatchVersion,
+		Flags:         w.flags,
+		Timestamp:     time.Now().Unix(),
+		OldDirNameLen: uint32(len(oldDir)),
+		NewDirNameLen: uint32(len(newDir)),
+		MetadataLen:   uint32(len(metadataJSON)),
+		SourceCount:   uint32(len(sources)),
+	}
+
+	w.write(header.Marshal())
+	w.write([]byte(oldDir))
+	w.write([]byte(newDir))
+	w.write(metadataJSON)
+
+	for _, source := range sources {
+		sourceEntry := DirPatchSourceEntry{
+			PathLen: uint32(len(source.RelativePath)),
+			Size:    source.Size,
+		}
+		w.write(sourceEntry.Marshal())
+		if err := w.write([]byte(source.RelativePath)); err != nil {
+			return nil, fmt.Errorf("write header: %w", err)
+		}
+	}
+
+	return w, nil
+}
+
+// write 写入数据并记录偏移量，bufio.Writer出错后后续写入都会返回同一错误
+func (w *DirPatchWriter) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// SetCompression 设置完整内容的普通文件使用的压缩算法和级别，差异数据在生成时已按其补丁头压缩
+func (w *DirPatchWriter) SetCompression(compression CompressionType, level CompressionLevel) error {
+	codec, err := newCodec(compression, level, nil)
+	if err != nil {
+		return err
+	}
+	w.codec = codec
+	return nil
+}
+
+// BeginReverse 之后写入的条目都是反向条目，关闭时在头部设置DirPatchFlagReverse，dst需要支持io.WriterAt
+func (w *DirPatchWriter) BeginReverse() error {
+	if _, ok := w.dst.(io.WriterAt); !ok {
+		return fmt.Errorf("reverse entries require a seekable destination")
+	}
+	w.reverse = true
+	w.flags |= DirPatchFlagReverse
+	return nil
+}
+
+// compressible 判断条目的数据是否需要压缩
+func (w *DirPatchWriter) compressible(file *hexdiff.DirPatchFile) bool {
+	return w.codec != nil && w.codec.compression != CompressionNone &&
+		file.Kind == hexdiff.KindFile && file.IsFullContent
+}
+
+// WriteFile 写入条目，数据为Delta，符号链接的数据为链接目标
+func (w *DirPatchWriter) WriteFile(file *hexdiff.DirPatchFile) error {
+	data := file.Delta
+	if file.Kind == hexdiff.KindSymlink {
+		data = []byte(file.LinkTarget)
+	}
+
+	// 数据已在内存中，压缩后没有变小时按原样保存
+	if w.compressible(file) && len(data) > 0 {
+		ct, compressed, err := w.compressEntry(data)
+		if err != nil {
+			return fmt.Errorf("compress data for %s: %w", file.RelativePath, err)
+		}
+		if ct != CompressionNone {
+			return w.writeEntry(file, int64(len(compressed)), ct, copyData(bytes.NewReader(compressed), int64(len(compressed))))
+		}
+	}
+	return w.writeEntry(file, int64(len(data)), CompressionNone, copyData(bytes.NewReader(data), int64(len(data))))
+}
+
+// compressEntry 压缩条目数据，压缩后没有变小时返回CompressionNone
+func (w *DirPatchWriter) compressEntry(data []byte) (CompressionType, []byte, error) {
+	if w.codec.compression == CompressionAuto {
+		return w.codec.compressBest(data)
+	}
+	compressed, err := w.codec.compress(data)
+	if err != nil {
+		return CompressionNone, nil, err
+	}
+	if len(compressed) >= len(data) {
+		return CompressionNone, data, nil
+	}
+	return w.codec.compression, compressed, nil
+}
+
+// WriteFileFrom 写入条目，数据从data中读取size字节
+func (w *DirPatchWriter) WriteFileFrom(file *hexdiff.DirPatchFile, data io.Reader, size int64) error {
+	if !w.compressible(file) || size == 0 {
+		return w.writeEntry(file, size, CompressionNone, copyData(data, size))
+	}
+
+	// 自动选择时按开头的数据块为整个文件选择压缩算法
+	codec := w.codec
+	if codec.compression == CompressionAuto {
+		buffered := bufio.NewReaderSize(data, AutoChunkSize)
+		sample, err := buffered.Peek(int(min(size, AutoChunkSize)))
+		if err != nil {
+			return fmt.Errorf("read data for %s: %w", file.RelativePath, err)
+		}
+		ct, _, err := codec.compressBest(sample)
+		if err != nil {
+			return fmt.Errorf("compress data for %s: %w", file.RelativePath, err)
+		}
+		if ct == CompressionNone {
+			return w.writeEntry(file, size, CompressionNone, copyData(buffered, size))
+		}
+		codec, data = codec.with(ct), buffered
+	}
+
+	compress := func(dst io.Writer) error {
+		src := &io.LimitedReader{R: data, N: size}
+		if err := codec.compressTo(dst, src); err != nil {
+			return err
+		}
+		if src.N > 0 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	}
+	if _, ok := w.dst.(io.WriterAt); ok {
+		// 压缩后的长度在写入后回填
+		return w.writeEntry(file, 0, codec.compression, compress)
+	}
+
+	// 无法回填时先在内存中压缩
+	var buf bytes.Buffer
+	if err := compress(&buf); err != nil {
+		return fmt.Errorf("compress data for %s: %w", file.RelativePath, err)
+	}
+	return w.writeEntry(file, int64(buf.Len()), codec.compression, copyData(&buf, int64(buf.Len())))
+}
+
+// copyEntry 按原样复制reader中第i个条目，数据不解压
+func (w *DirPatchWriter) copyEntry(reader *DirPatchReader, i int) error {
+	section := reader.sections[i]
+	return w.writeEntry(reader.Patch().Files[i], section.size, section.compression, copyData(reader.Data(i), section.size))
+}
+
+// copyData 返回从data复制size字节的写入函数
+func copyData(data io.Reader, size int64) func(io.Writer) error {
+	return func(dst io.Writer) error {
+		_, err := io.CopyN(dst, data, size)
+		return err
+	}
+}
+
+// writeEntry 写入条目信息，再由writeData写入数据；实际写入的长度与size不同时回填条目中的数据长度
+func (w *DirPatchWriter) writeEntry(file *hexdiff.DirPatchFile, size int64, compression CompressionType, writeData func(io.Writer) error) error {
+	entryOffset := w.offset
+
+	status := uint8(file.Status)
+	if w.reverse {
+		status |= DirPatchStatusReverse
+	}
+
+	entry := DirPatchEntry{
+		PathLen:       uint32(len(file.RelativePath)),
+		Status:        status,
+		Mode:          uint32(file.Mode),
+		MTime:         file.MTime,
+		Size:          file.Size,
+		Checksum:      file.Checksum,
+		DataLen:       uint32(min(size, math.MaxUint32)),
+		IsFullContent: boolToUint8(file.IsFullContent),
+		OldPathLen:    uint16(len(file.OldPath)),
+		Kind:          uint8(file.Kind),
+		Compression:   uint8(compression),
+	}
+
+	w.write(entry.Marshal())
+	if w.hasSources {
+		w.write(binary.LittleEndian.AppendUint32(nil, file.SourceID))
+	}
+	w.write([]byte{entry.Kind, entry.Compression})
+	w.write([]byte(file.RelativePath))
+	if err := w.write([]byte(file.OldPath)); err != nil {
+		return fmt.Errorf("write entry %s: %w", file.RelativePath, err)
+	}
+
+	dataOffset := w.offset
+	if err := writeData(dirPatchDataWriter{w}); err != nil {
+		return fmt.Errorf("write data for %s: %w", file.RelativePath, err)
+	}
+	dataLen := w.offset - dataOffset
+
+	if dataLen != size {
+		writerAt, ok := w.dst.(io.WriterAt)
+		if !ok {
+			return fmt.Errorf("write data for %s: wrote %d bytes, want %d", file.RelativePath, dataLen, size)
+		}
+		if err := w.w.Flush(); err != nil {
+			return fmt.Errorf("write data for %s: %w", file.RelativePath, err)
+		}
+		length := binary.LittleEndian.AppendUint32(nil, uint32(min(dataLen, math.MaxUint32)))
+		if _, err := writerAt.WriteAt(length, entryOffset+dirPatchDataLenOffset); err != nil {
+			return fmt.Errorf("update entry %s: %w", file.RelativePath, err)
+		}
+	}
+
+	w.index = append(w.index, DirPatchIndexEntry{
+		EntryOffset: uint64(entryOffset),
+		DataOffset:  uint64(dataOffset),
+		DataLen:     uint64(dataLen),
+	})
+	w.paths = append(w.paths, file.RelativePath)
+	return nil
+}
+
+// dirPatchDataWriter 将条目数据写入补丁并记录偏移量
+type dirPatchDataWriter struct {
+	w *DirPatchWriter
+}
+
+func (d dirPatchDataWriter) Write(p []byte) (int, error) {
+	n, err := d.w.w.Write(p)
+	d.w.offset += int64(n)
+	return n, err
+}
+
+// Close 写入索引表、路径表和尾部，不关闭dst
+func (w *DirPatchWriter) Close() error {
+	indexOffset := w.offset
+	for _, entry := range w.index {
+		w.write(entry.Marshal())
+	}
+	for _, path := range w.paths {
+		w.write(binary.LittleEndian.AppendUint16(nil, uint16(len(path))))
+		w.write([]byte(path))
+	}
+
+	trailer := DirPatchTrailer{
+		IndexOffset: uint64(indexOffset),
+		EntryCount:  uint32(len(w.index)),
+		Magic:       DirPatchIndexMagic,
+	}
+	w.write(trailer.Marshal())
+
+	if err := w.w.Flush(); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+
+	// 头部的条目数只用于不读取索引的场合
+	if writerAt, ok := w.dst.(io.WriterAt); ok {
+		count := binary.LittleEndian.AppendUint32(nil, uint32(len(w.index)))
+		if _, err := writerAt.WriteAt(count, 24); err != nil {
+			return fmt.Errorf("update header: %w", err)
+		}
+		if w.reverse {
+			if _, err := writerAt.WriteAt(binary.LittleEndian.AppendUint16(nil, w.flags), 6); err != nil {
+				return fmt.Errorf("update header: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ErrUnsafePath 目录补丁中的路径是绝对路径或指向目录之外
+var ErrUnsafePath = errors.New("unsafe path in directory patch")
+
+// checkPatchPath 检查补丁中以/分隔的相对路径位于目录之内
+func checkPatchPath(path string) error {
+	if !filepath.IsLocal(filepath.FromSlash(path)) {
+		return fmt.Errorf("%w: %q", ErrUnsafePath, path)
+	}
+	return nil
+}
+
+// dirPatchSection 条目数据在补丁文件中的位置和压缩类型
+type dirPatchSection struct {
+	offset      int64
+	size        int64
+	compression CompressionType
+}
+
+// DirPatchReader 目录补丁读取器
+//
+// 打开时只读取头部、源文件表和条目信息，条目数据按需读取。
+// 带索引的补丁从索引中定位条目，带路径表时不匹配过滤器的条目不会被解析；
+// 没有索引的旧补丁依次跳过每个条目的数据。
+type DirPatchReader struct {
+	file     *os.File
+	filter   *DirPatchFilter
+	reverse  bool
+	header   DirPatchHeader
+	patch    *hexdiff.DirPatch
+	sections []dirPatchSection
+}
+
+// OpenDirPatch 打开目录补丁
+func OpenDirPatch(path string) (*DirPatchReader, error) {
+	return OpenDirPatchFiltered(path, nil)
+}
+
+// OpenDirPatchFiltered 打开目录补丁，只读取路径匹配filter的条目，filter为nil时读取所有条目
+func OpenDirPatchFiltered(path string, filter *DirPatchFilter) (*DirPatchReader, error) {
+	return openDirPatch(path, filter, false)
+}
+
+// OpenDirPatchReverse 打开目录补丁中嵌入的反向补丁，新旧目录互换，只读取路径匹配filter的反向条目。
+// 补丁没有反向条目时返回ErrNotReversible
+func OpenDirPatchReverse(path string, filter *DirPatchFilter) (*DirPatchReader, error) {
+	return openDirPatch(path, filter, true)
+}
+
+func openDirPatch(path string, filter *DirPatchFilter, reverse bool) (*DirPatchReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open patch file: %w", err)
+	}
+
+	r := &DirPatchReader{file: file, filter: filter, reverse: reverse}
+	if err := r.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// load 读取除条目数据以外的所有内容
+func (r *DirPatchReader) load() error {
+	info, err := r.file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat patch file: %w", err)
+	}
+	// 签名块不属于补丁内容
+	fileSize, err := contentSize(r.file, info.Size())
+	if err != nil {
+		return err
+	}
+
+	headerData := make([]byte, DirPatchHeaderSize)
+	if _, err := r.file.ReadAt(headerData, 0); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	header := &r.header
+	if err := header.Unmarshal(headerData); err != nil {
+		return fmt.Errorf("parse header: %w", err)
+	}
+
+	r.patch = &hexdiff.DirPatch{
+		Version:   header.Version,
+		Timestamp: header.Timestamp,
+	}
+
+	offset := int64(DirPatchHeaderSize)
+	reader := bufio.NewReader(io.NewSectionReader(r.file, offset, fileSize-offset))
+	readN := func(n int64) ([]byte, error) {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(reader, buf)
+		offset += n
+		return buf, err
+	}
+
+	oldDirName, err := readN(int64(header.OldDirNameLen))
+	if err != nil {
+		return fmt.Errorf("read old dir name: %w", err)
+	}
+	newDirName, err := readN(int64(header.NewDirNameLen))
+	if err != nil {
+		return fmt.Errorf("read new dir name: %w", err)
+	}
+	r.patch.OldDir = string(oldDirName)
+	r.patch.NewDir = string(newDirName)
+	if r.reverse {
+		if header.Flags&DirPatchFlagReverse == 0 {
+			return ErrNotReversible
+		}
+		r.patch.OldDir, r.patch.NewDir = r.patch.NewDir, r.patch.OldDir
+	}
+
+	if header.MetadataLen > 0 {
+		metadataJSON, err := readN(int64(header.MetadataLen))
+		if err != nil {
+			return fmt.Errorf("read metadata: %w", err)
+		}
+		json.Unmarshal(metadataJSON, &r.patch.Metadata)
+	}
+
+	for i := uint32(0); i < header.SourceCount; i++ {
+		sourceData, err := readN(DirPatchSourceEntrySize)
+		if err != nil {
+			return fmt.Errorf("read source %d: %w", i, err)
+		}
+
+		sourceEntry := &DirPatchSourceEntry{}
+		if err := sourceEntry.Unmarshal(sourceData); err != nil {
+			return fmt.Errorf("parse source %d: %w", i, err)
+		}
+
+		pathBytes, err := readN(int64(sourceEntry.PathLen))
+		if err != nil {
+			return fmt.Errorf("read source path %d: %w", i, err)
+		}
+		// 源文件从旧目录中读取，不能指向目录之外
+		if err := checkPatchPath(string(pathBytes)); err != nil {
+			return fmt.Errorf("source %d: %w", i, err)
+		}
+
+		if r.reverse {
+			// 反向条目不使用源文件表
+			continue
+		}
+		r.patch.Sources = append(r.patch.Sources, hexdiff.DirPatchSource{
+			RelativePath: string(pathBytes),
+			Size:         sourceEntry.Size,
+		})
+	}
+
+	count := int64(header.FileCount)
+	dataEnd := fileSize
+	var index []DirPatchIndexEntry
+	var paths []string
+	if header.Flags&DirPatchFlagIndexed != 0 {
+		if index, paths, dataEnd, err = r.readIndex(fileSize); err != nil {
+			return err
+		}
+		count = int64(len(index))
+	}
+
+	r.patch.Files = make([]*hexdiff.DirPatchFile, 0, count)
+	r.sections = make([]dirPatchSection, 0, count)
+	for i := int64(0); i < count; i++ {
+		// 路径表中不匹配的条目直接跳过
+		if paths != nil && !r.filter.Match(paths[i]) {
+			continue
+		}
+		if index != nil {
+			offset = int64(index[i].EntryOffset)
+		}
+
+		filePatch, section, reverse, err := r.readEntry(offset, i)
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			section.offset = int64(index[i].DataOffset)
+			section.size = int64(index[i].DataLen)
+		}
+		if section.offset < 0 || section.size < 0 || section.offset+section.size > dataEnd {
+			return fmt.Errorf("entry %d: data out of range", i)
+		}
+		if paths != nil && paths[i] != filePatch.RelativePath {
+			return fmt.Errorf("entry %d: path %q does not match index", i, filePatch.RelativePath)
+		}
+		offset = section.offset + section.size
+
+		if !r.filter.Match(filePatch.RelativePath) || reverse != r.reverse {
+			continue
+		}
+		filePatch.DeltaSize = section.size
+
+		if filePatch.Kind == hexdiff.KindSymlink {
+			target := make([]byte, section.size)
+			if _, err := r.file.ReadAt(target, section.offset); err != nil {
+				return fmt.Errorf("read link target %d: %w", i, err)
+			}
+			filePatch.LinkTarget = string(target)
+		}
+
+		r.patch.Files = append(r.patch.Files, filePatch)
+		r.sections = append(r.sections, section)
+	}
+
+	return nil
+}
+
+// readIndex 读取尾部、索引表和路径表，返回索引表的偏移量，即条目数据的结束位置
+func (r *DirPatchReader) readIndex(fileSize int64) ([]DirPatchIndexEntry, []string, int64, error) {
+	if fileSize < DirPatchHeaderSize+DirPatchTrailerSize {
+		return nil, nil, 0, fmt.Errorf("read trailer: file too small")
+	}
+	trailerData := make([]byte, DirPatchTrailerSize)
+	if _, err := r.file.ReadAt(trailerData, fileSize-DirPatchTrailerSize); err != nil {
+		return nil, nil, 0, fmt.Errorf("read trailer: %w", err)
+	}
+	trailer := &DirPatchTrailer{}
+	if err := trailer.Unmarshal(trailerData); err != nil {
+		return nil, nil, 0, fmt.Errorf("parse trailer: %w", err)
+	}
+
+	indexOffset := int64(trailer.IndexOffset)
+	indexEnd := fileSize - DirPatchTrailerSize
+	indexSize := int64(trailer.EntryCount) * DirPatchIndexEntrySize
+	hasPaths := r.header.Flags&DirPatchFlagPathIndex != 0
+	if indexOffset < DirPatchHeaderSize || indexOffset+indexSize > indexEnd ||
+		(!hasPaths && indexOffset+indexSize != indexEnd) {
+		return nil, nil, 0, fmt.Errorf("parse trailer: invalid index offset %d", trailer.IndexOffset)
+	}
+	indexData := make([]byte, indexEnd-indexOffset)
+	if _, err := r.file.ReadAt(indexData, indexOffset); err != nil {
+		return nil, nil, 0, fmt.Errorf("read index: %w", err)
+	}
+
+	index := make([]DirPatchIndexEntry, trailer.EntryCount)
+	for i := range index {
+		index[i].Unmarshal(indexData[i*DirPatchIndexEntrySize:])
+	}
+	if !hasPaths {
+		return index, nil, indexOffset, nil
+	}
+
+	pathData := indexData[indexSize:]
+	paths := make([]string, trailer.EntryCount)
+	for i := range paths {
+		if len(pathData) < 2 {
+			return nil, nil, 0, fmt.Errorf("read path index %d: truncated", i)
+		}
+		pathLen := int(binary.LittleEndian.Uint16(pathData))
+		if len(pathData) < 2+pathLen {
+			return nil, nil, 0, fmt.Errorf("read path index %d: truncated", i)
+		}
+		paths[i] = string(pathData[2 : 2+pathLen])
+		if err := checkPatchPath(paths[i]); err != nil {
+			return nil, nil, 0, fmt.Errorf("read path index %d: %w", i, err)
+		}
+		pathData = pathData[2+pathLen:]
+	}
+	return index, paths, indexOffset, nil
+}
+
+// readEntry 读取offset处的条目信息，返回条目、其数据的位置以及是否为反向条目
+func (r *DirPatchReader) readEntry(offset, i int64) (*hexdiff.DirPatchFile, dirPatchSection, bool, error) {
+	header := &r.header
+	entrySize := int64(DirPatchEntrySize)
+	if header.SourceCount > 0 {
+		entrySize += DirPatchSourceIDSize
+	}
+	if header.Flags&DirPatchFlagEntryKind != 0 {
+		entrySize += DirPatchKindSize
+	}
+	if header.Flags&DirPatchFlagEntryCompression != 0 {
+		entrySize += DirPatchCompressionSize
+	}
+
+	entryData := make([]byte, entrySize)
+	if _, err := r.file.ReadAt(entryData, offset); err != nil {
+		return nil, dirPatchSection{}, false, fmt.Errorf("read entry %d: %w", i, err)
+	}
+
+	entry := &DirPatchEntry{}
+	if err := entry.Unmarshal(entryData); err != nil {
+		return nil, dirPatchSection{}, false, fmt.Errorf("parse entry %d: %w", i, err)
+	}
+	extra := entryData[DirPatchEntrySize:]
+	if header.SourceCount > 0 {
+		entry.SourceID = binary.LittleEndian.Uint32(extra)
+		if entry.SourceID > header.SourceCount {
+			return nil, dirPatchSection{}, false, fmt.Errorf("entry %d: invalid source id %d", i, entry.SourceID)
+		}
+		extra = extra[DirPatchSourceIDSize:]
+	}
+	if header.Flags&DirPatchFlagEntryKind != 0 {
+		entry.Kind = extra[0]
+		if hexdiff.EntryKind(entry.Kind) > hexdiff.KindSymlink {
+			return nil, dirPatchSection{}, false, fmt.Errorf("entry %d: invalid kind %d", i, entry.Kind)
+		}
+		extra = extra[DirPatchKindSize:]
+	}
+	if header.Flags&DirPatchFlagEntryCompression != 0 {
+		entry.Compression = extra[0]
+		if CompressionType(entry.Compression) > CompressionZstd {
+			return nil, dirPatchSection{}, false, fmt.Errorf("entry %d: invalid compression %d", i, entry.Compression)
+		}
+	}
+
+	reverse := header.Flags&DirPatchFlagReverse != 0 && entry.Status&DirPatchStatusReverse != 0
+	if reverse {
+		entry.Status &^= DirPatchStatusReverse
+	}
+
+	paths := make([]byte, int64(entry.PathLen)+int64(entry.OldPathLen))
+	if _, err := r.file.ReadAt(paths, offset+entrySize); err != nil {
+		return nil, dirPatchSection{}, false, fmt.Errorf("read path %d: %w", i, err)
+	}
+
+	filePatch := &hexdiff.DirPatchFile{
+		RelativePath:  string(paths[:entry.PathLen]),
+		Status:        hexdiff.FileStatus(entry.Status),
+		Mode:          os.FileMode(entry.Mode),
+		MTime:         entry.MTime,
+		Size:          entry.Size,
+		Checksum:      entry.Checksum,
+		IsFullContent: entry.IsFullContent == 1,
+		SourceID:      entry.SourceID,
+		OldPath:       string(paths[entry.PathLen:]),
+		Kind:          hexdiff.EntryKind(entry.Kind),
+	}
+
+	// 条目的路径和原路径都在应用时拼接到目标目录下
+	if err := checkPatchPath(filePatch.RelativePath); err != nil {
+		return nil, dirPatchSection{}, false, fmt.Errorf("entry %d: %w", i, err)
+	}
+	if filePatch.OldPath != "" {
+		if err := checkPatchPath(filePatch.OldPath); err != nil {
+			return nil, dirPatchSection{}, false, fmt.Errorf("entry %d: old path: %w", i, err)
+		}
+	}
+
+	section := dirPatchSection{
+		offset:      offset + entrySize + int64(len(paths)),
+		size:        int64(entry.DataLen),
+		compression: CompressionType(entry.Compression),
+	}
+	return filePatch, section, reverse, nil
+}
+
+// Header 返回补丁头部
+func (r *DirPatchReader) Header() *DirPatchHeader {
+	return &r.header
+}
+
+// Patch 返回补丁信息，条目中不包含数据
+func (r *DirPatchReader) Patch() *hexdiff.DirPatch {
+	return r.patch
+}
+
+// Data 返回第i个条目在补丁中保存的数据，数据可能是压缩的，见Compression
+func (r *DirPatchReader) Data(i int) *io.SectionReader {
+	section := r.sections[i]
+	return io.NewSectionReader(r.file, section.offset, section.size)
+}
+
+// Compression 返回第i个条目数据的压缩类型
+func (r *DirPatchReader) Compression(i int) CompressionType {
+	return r.sections[i].compression
+}
+
+// Open 返回按需解压第i个条目数据的读取器
+func (r *DirPatchReader) Open(i int) (io.ReadCloser, error) {
+	reader, err := newDecompressReader(r.Data(i), r.sections[i].compression)
+	if err != nil {
+		return nil, fmt.Errorf("read data %d: %w", i, err)
+	}
+	return reader, nil
+}
+
+// ReadData 将第i个条目解压后的数据读入内存
+func (r *DirPatchReader) ReadData(i int) ([]byte, error) {
+	section := r.sections[i]
+	data := make([]byte, section.size)
+	if _, err := r.file.ReadAt(data, section.offset); err != nil {
+		return nil, fmt.Errorf("read data %d: %w", i, err)
+	}
+	data, err := decompressData(data, section.compression)
+	if err != nil {
+		return nil, fmt.Errorf("read data %d: %w", i, err)
+	}
+	return data, nil
+}
+
+// Close 关闭补丁文件
+func (r *DirPatchReader) Close() error {
+	return r.file.Close()
+}
+
+// DirPatchSink 将流式生成的目录差异直接写入补丁文件，实现hexdiff.DirDiffSink
+type DirPatchSink struct {
+	serializer *DirPatchSerializer
+	file       *os.File
+	oldDir     string
+	newDir     string
+	writer     *DirPatchWriter
+}
+
+// NewSink 创建写入outputPath的目录差异接收器，oldDir和newDir为记录在补丁中的目录名
+func (s *DirPatchSerializer) NewSink(outputPath, oldDir, newDir string) (*DirPatchSink, error) {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("create patch file: %w", err)
+	}
+	return &DirPatchSink{
+		serializer: s,
+		file:       file,
+		oldDir:     oldDir,
+		newDir:     newDir,
+	}, nil
+}
+
+// Begin 写入补丁头部和源文件表
+func (k *DirPatchSink) Begin(result *hexdiff.DirDiffResult) error {
+	var sources []hexdiff.DirPatchSource
+	for _, source := range result.Sources {
+		sources = append(sources, hexdiff.DirPatchSource{
+			RelativePath: source.RelativePath,
+			Size:         source.Size,
+		})
+	}
+
+	writer, err := NewDirPatchWriter(k.file, k.oldDir, k.newDir, sources, nil)
+	if err != nil {
+		return err
+	}
+	if err := writer.SetCompression(k.serializer.compression, k.serializer.level); err != nil {
+		return err
+	}
+	k.writer = writer
+	return nil
+}
+
+// WriteFile 写入一个条目，没有读入内存的新增文件直接从磁盘复制
+func (k *DirPatchSink) WriteFile(diff *hexdiff.FileDiff) error {
+	entry, err := k.serializer.patchFile(diff)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", diff.RelativePath, err)
+	}
+	if !entry.IsFullContent || entry.Delta != nil || entry.Kind != hexdiff.KindFile {
+		return k.writer.WriteFile(entry)
+	}
+
+	file, err := os.Open(diff.NewEntry.AbsPath)
+	if err != nil {
+		return fmt.Errorf("open new file %s: %w", diff.RelativePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat new file %s: %w", diff.RelativePath, err)
+	}
+	return k.writer.WriteFileFrom(entry, file, info.Size())
+}
+
+// Close 写入索引并关闭补丁文件
+func (k *DirPatchSink) Close() error {
+	var err error
+	if k.writer != nil {
+		err = k.writer.Close()
+	}
+	if closeErr := k.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close patch file: %w", closeErr)
+	}
+	return err
+}
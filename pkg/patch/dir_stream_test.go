@@ -0,0 +1,300 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+func writeStreamFixture(t *testing.T, path string) []*hexdiff.DirPatchFile {
+	t.Helper()
+	files := []*hexdiff.DirPatchFile{
+		{RelativePath: "a.txt", Status: hexdiff.StatusAdded, Mode: 0644, Size: 5, Delta: []byte("hello"), IsFullContent: true},
+		{RelativePath: "dir", Status: hexdiff.StatusAdded, Mode: os.ModeDir | 0755, Kind: hexdiff.KindDir, IsFullContent: true},
+		{RelativePath: "link", Status: hexdiff.StatusAdded, Mode: os.ModeSymlink | 0777, Kind: hexdiff.KindSymlink, LinkTarget: "a.txt", IsFullContent: true},
+		{RelativePath: "gone.txt", Status: hexdiff.StatusDeleted, Mode: 0644, Size: 3},
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer, err := NewDirPatchWriter(file, "old", "new", nil, nil)
+	if err != nil {
+		t.Fatalf("NewDirPatchWriter() error = %v", err)
+	}
+	for _, f := range files {
+		if err := writer.WriteFile(f); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return files
+}
+
+func checkStreamFixture(t *testing.T, path string, want []*hexdiff.DirPatchFile) {
+	t.Helper()
+	reader, err := OpenDirPatch(path)
+	if err != nil {
+		t.Fatalf("OpenDirPatch() error = %v", err)
+	}
+	defer reader.Close()
+
+	dirPatch := reader.Patch()
+	if dirPatch.OldDir != "old" || dirPatch.NewDir != "new" {
+		t.Errorf("dirs = %q, %q, want old, new", dirPatch.OldDir, dirPatch.NewDir)
+	}
+	if len(dirPatch.Files) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(dirPatch.Files), len(want))
+	}
+	for i, w := range want {
+		got := dirPatch.Files[i]
+		if got.RelativePath != w.RelativePath || got.Status != w.Status || got.Kind != w.Kind || got.Mode != w.Mode {
+			t.Errorf("entry %d = %+v, want %+v", i, got, w)
+		}
+		if got.Delta != nil {
+			t.Errorf("%s: data loaded before it was requested", got.RelativePath)
+		}
+		if got.LinkTarget != w.LinkTarget {
+			t.Errorf("%s: link target = %q, want %q", got.RelativePath, got.LinkTarget, w.LinkTarget)
+		}
+
+		data, err := reader.ReadData(i)
+		if err != nil {
+			t.Fatalf("ReadData(%d) error = %v", i, err)
+		}
+		wantData := w.Delta
+		if w.Kind == hexdiff.KindSymlink {
+			wantData = []byte(w.LinkTarget)
+		}
+		if !bytes.Equal(data, wantData) {
+			t.Errorf("%s: data = %q, want %q", got.RelativePath, data, wantData)
+		}
+	}
+}
+
+func TestDirPatchWriterReaderRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.patch")
+	files := writeStreamFixture(t, path)
+
+	header, err := GetDirPatchInfo(path)
+	if err != nil {
+		t.Fatalf("GetDirPatchInfo() error = %v", err)
+	}
+	if header.Flags&DirPatchFlagIndexed == 0 {
+		t.Error("indexed flag not set")
+	}
+	if header.FileCount != uint32(len(files)) {
+		t.Errorf("FileCount = %d, want %d", header.FileCount, len(files))
+	}
+
+	checkStreamFixture(t, path, files)
+}
+
+func TestDirPatchReaderWithoutIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.patch")
+	files := writeStreamFixture(t, path)
+
+	// 去掉索引和尾部并清除标志，得到与旧版本相同的顺序格式
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trailer := &DirPatchTrailer{}
+	if err := trailer.Unmarshal(data[len(data)-DirPatchTrailerSize:]); err != nil {
+		t.Fatal(err)
+	}
+	data = data[:trailer.IndexOffset]
+	flags := binary.LittleEndian.Uint16(data[6:8]) &^ (DirPatchFlagIndexed | DirPatchFlagPathIndex)
+	binary.LittleEndian.PutUint16(data[6:8], flags)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checkStreamFixture(t, path, files)
+}
+
+func TestDirPatchReaderRejectsCorruptIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.patch")
+	writeStreamFixture(t, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第一个条目的数据长度超出范围
+	trailer := &DirPatchTrailer{}
+	trailer.Unmarshal(data[len(data)-DirPatchTrailerSize:])
+	binary.LittleEndian.PutUint64(data[trailer.IndexOffset+16:], 1<<40)
+	os.WriteFile(path, data, 0644)
+
+	if _, err := OpenDirPatch(path); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("OpenDirPatch() error = %v, want out of range", err)
+	}
+}
+
+// writeDirPatchEntries 将条目原样写入目录补丁，用于构造生成器不会产生的补丁
+func writeDirPatchEntries(t *testing.T, path string, sources []hexdiff.DirPatchSource, files ...*hexdiff.DirPatchFile) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer, err := NewDirPatchWriter(file, "old", "new", sources, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := writer.WriteFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirPatchReaderRejectsUnsafePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, entry := range []*hexdiff.DirPatchFile{
+		{RelativePath: "../evil.txt", Status: hexdiff.StatusAdded, Mode: 0644, IsFullContent: true},
+		{RelativePath: "/etc/evil.txt", Status: hexdiff.StatusAdded, Mode: 0644, IsFullContent: true},
+		{RelativePath: "a/../../evil.txt", Status: hexdiff.StatusDeleted, Mode: 0644},
+		{RelativePath: "moved.txt", OldPath: "../../home/user/.ssh/id_ed25519", Status: hexdiff.StatusRenamed, Mode: 0644},
+	} {
+		path := filepath.Join(dir, "unsafe.patch")
+		writeDirPatchEntries(t, path, nil, entry)
+		if _, err := OpenDirPatch(path); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("OpenDirPatch(%q, %q) error = %v, want ErrUnsafePath", entry.RelativePath, entry.OldPath, err)
+		}
+
+		// 没有路径表时在读取条目时检查
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trailer := &DirPatchTrailer{}
+		trailer.Unmarshal(data[len(data)-DirPatchTrailerSize:])
+		data = data[:trailer.IndexOffset]
+		binary.LittleEndian.PutUint16(data[6:8], binary.LittleEndian.Uint16(data[6:8])&^(DirPatchFlagIndexed|DirPatchFlagPathIndex))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenDirPatch(path); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("OpenDirPatch(%q, %q) without index error = %v, want ErrUnsafePath", entry.RelativePath, entry.OldPath, err)
+		}
+	}
+}
+
+func TestDirPatchWriterShortData(t *testing.T) {
+	writer, err := NewDirPatchWriter(&bytes.Buffer{}, "old", "new", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &hexdiff.DirPatchFile{RelativePath: "a.txt", Status: hexdiff.StatusAdded, IsFullContent: true}
+	if err := writer.WriteFileFrom(file, strings.NewReader("abc"), 10); err == nil {
+		t.Error("WriteFileFrom() should fail when data is shorter than size")
+	}
+}
+
+func TestDirPatchSinkStreamsDirDiff(t *testing.T) {
+	oldFiles, newFiles, _ := makeDirApplierFixture(t)
+	dir := t.TempDir()
+	oldDir := filepath.Join(dir, "old")
+	newDir := filepath.Join(dir, "new")
+	writeTree(t, oldDir, oldFiles)
+	writeTree(t, newDir, newFiles)
+
+	engine, err := hexdiff.NewDirEngine(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchFile := filepath.Join(dir, "dir.patch")
+	sink, err := NewDirPatchSerializer(CompressionNone).NewSink(patchFile, "old", "new")
+	if err != nil {
+		t.Fatalf("NewSink() error = %v", err)
+	}
+	result, err := engine.GenerateDirDiffTo(oldDir, newDir, nil, sink)
+	if err != nil {
+		t.Fatalf("GenerateDirDiffTo() error = %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	for _, f := range result.AddedFiles {
+		if f.PatchData != nil || f.Delta != nil {
+			t.Errorf("%s: data kept in memory", f.RelativePath)
+		}
+	}
+
+	target := filepath.Join(dir, "target")
+	writeTree(t, target, oldFiles)
+	if _, err := NewDirApplier(nil).Apply(patchFile, target, nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got := readTree(t, target); !reflect.DeepEqual(got, newFiles) {
+		t.Errorf("target files = %v, want %v", keys(got), keys(newFiles))
+	}
+}
+
+func TestOpenDirPatchFiltered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.patch")
+	writeStreamFixture(t, path)
+	filter := &DirPatchFilter{Exclude: []string{"dir", "gone.txt"}}
+
+	check := func(name string) {
+		reader, err := OpenDirPatchFiltered(path, filter)
+		if err != nil {
+			t.Fatalf("%s: OpenDirPatchFiltered() error = %v", name, err)
+		}
+		defer reader.Close()
+
+		var paths []string
+		for i, f := range reader.Patch().Files {
+			paths = append(paths, f.RelativePath)
+			if f.RelativePath == "a.txt" {
+				if data, _ := reader.ReadData(i); string(data) != "hello" {
+					t.Errorf("%s: a.txt data = %q", name, data)
+				}
+			}
+		}
+		if want := []string{"a.txt", "link"}; !reflect.DeepEqual(paths, want) {
+			t.Errorf("%s: entries = %v, want %v", name, paths, want)
+		}
+	}
+	check("indexed")
+
+	// 路径表与条目不一致说明补丁已损坏
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := bytes.Replace(data, []byte("\x05\x00a.txt"), []byte("\x05\x00b.txt"), 1)
+	os.WriteFile(path, corrupt, 0644)
+	if _, err := OpenDirPatchFiltered(path, filter); err == nil {
+		t.Error("OpenDirPatchFiltered() should fail when the path index does not match the entries")
+	}
+
+	// 没有索引的补丁逐个解析条目后过滤
+	trailer := &DirPatchTrailer{}
+	trailer.Unmarshal(data[len(data)-DirPatchTrailerSize:])
+	data = data[:trailer.IndexOffset]
+	flags := binary.LittleEndian.Uint16(data[6:8]) &^ (DirPatchFlagIndexed | DirPatchFlagPathIndex)
+	binary.LittleEndian.PutUint16(data[6:8], flags)
+	os.WriteFile(path, data, 0644)
+	check("sequential")
+}
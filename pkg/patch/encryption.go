@@ -0,0 +1,587 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 加密块格式常量
+//
+// 设置FlagEncrypted时，文件头（和字典ID）之后紧跟加密块：
+//
+//	[密钥类型 1][保留 3][迭代次数 4][盐或临时公钥 32][密钥ID 8][随机数 12][加密的数据密钥 48]
+//
+// 每个补丁使用随机的数据密钥，以AES-256-GCM分块加密压缩后的操作列表和数据区，
+// 数据密钥由口令、原始密钥或X25519接收方公钥派生的密钥加密后保存在加密块中。
+// 文件头本身不加密，不需要密钥也能查看补丁信息。
+const (
+	// EncryptionHeaderSize 加密块大小
+	EncryptionHeaderSize = 108
+	// EncryptionChunkSize 加密分块的明文大小
+	EncryptionChunkSize = 64 * 1024
+	// encryptionKeySize AES-256密钥大小
+	encryptionKeySize = 32
+	// passphraseIterations 口令派生密钥时PBKDF2-SHA256的迭代次数
+	passphraseIterations = 600000
+)
+
+// EncryptionKind 加密密钥的类型
+type EncryptionKind uint8
+
+const (
+	EncryptionNone       EncryptionKind = iota // 不加密
+	EncryptionPassphrase                       // 口令，经PBKDF2-SHA256派生密钥
+	EncryptionRawKey                           // 32字节原始密钥
+	EncryptionRecipient                        // X25519接收方公钥
+)
+
+// String 返回密钥类型的名称
+func (k EncryptionKind) String() string {
+	switch k {
+	case EncryptionNone:
+		return "None"
+	case EncryptionPassphrase:
+		return "Passphrase"
+	case EncryptionRawKey:
+		return "RawKey"
+	case EncryptionRecipient:
+		return "X25519"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(k))
+	}
+}
+
+var (
+	// ErrDecryptionKeyMissing 没有注册能解密补丁的密钥
+	ErrDecryptionKeyMissing = errors.New("no decryption key for encrypted patch")
+	// ErrDecryptionFailed 密钥错误或加密数据被篡改
+	ErrDecryptionFailed = errors.New("patch decryption failed")
+)
+
+// EncryptionHeader 补丁文件头中的加密块
+type EncryptionHeader struct {
+	Kind       EncryptionKind // 密钥类型
+	Iterations uint32         // PBKDF2迭代次数（仅口令）
+	Param      [32]byte       // 口令和原始密钥的盐，或X25519临时公钥
+	KeyID      [8]byte        // 原始密钥或接收方公钥的标识
+	Nonce      [12]byte       // 加密数据密钥使用的随机数
+	WrappedKey [48]byte       // 加密的数据密钥
+}
+
+// KeyIDString 返回密钥标识的十六进制形式，口令加密时为空
+func (e *EncryptionHeader) KeyIDString() string {
+	if e.Kind == EncryptionPassphrase {
+		return ""
+	}
+	return hex.EncodeToString(e.KeyID[:])
+}
+
+// marshal 序列化加密块
+func (e *EncryptionHeader) marshal(buf []byte) {
+	buf[0] = uint8(e.Kind)
+	binary.LittleEndian.PutUint32(buf[4:8], e.Iterations)
+	copy(buf[8:40], e.Param[:])
+	copy(buf[40:48], e.KeyID[:])
+	copy(buf[48:60], e.Nonce[:])
+	copy(buf[60:108], e.WrappedKey[:])
+}
+
+// unmarshal 反序列化加密块
+func (e *EncryptionHeader) unmarshal(data []byte) {
+	e.Kind = EncryptionKind(data[0])
+	e.Iterations = binary.LittleEndian.Uint32(data[4:8])
+	copy(e.Param[:], data[8:40])
+	copy(e.KeyID[:], data[40:48])
+	copy(e.Nonce[:], data[48:60])
+	copy(e.WrappedKey[:], data[60:108])
+}
+
+// validate 验证加密块
+func (e *EncryptionHeader) validate() error {
+	switch e.Kind {
+	case EncryptionPassphrase:
+		if e.Iterations == 0 {
+			return fmt.Errorf("invalid passphrase iterations: 0")
+		}
+	case EncryptionRawKey, EncryptionRecipient:
+	default:
+		return fmt.Errorf("unsupported encryption kind: %s", e.Kind)
+	}
+	return nil
+}
+
+// EncryptionKey 补丁加密密钥
+//
+// 口令和原始密钥同时用于加密和解密；X25519公钥只能加密，解密需要对应的私钥。
+type EncryptionKey struct {
+	kind       EncryptionKind
+	passphrase string
+	key        []byte
+	recipient  *ecdh.PublicKey
+	identity   *ecdh.PrivateKey
+}
+
+// NewPassphraseKey 由口令创建密钥
+func NewPassphraseKey(passphrase string) (*EncryptionKey, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	return &EncryptionKey{kind: EncryptionPassphrase, passphrase: passphrase}, nil
+}
+
+// NewRawKey 由32字节原始密钥创建密钥
+func NewRawKey(key []byte) (*EncryptionKey, error) {
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("invalid raw key size: %d, want %d", len(key), encryptionKeySize)
+	}
+	return &EncryptionKey{kind: EncryptionRawKey, key: bytes.Clone(key)}, nil
+}
+
+// NewRecipientKey 由X25519接收方公钥创建加密密钥
+func NewRecipientKey(recipient *ecdh.PublicKey) (*EncryptionKey, error) {
+	if recipient == nil || recipient.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("recipient is not an x25519 public key")
+	}
+	return &EncryptionKey{kind: EncryptionRecipient, recipient: recipient}, nil
+}
+
+// NewIdentityKey 由X25519私钥创建密钥，可以解密发给对应公钥的补丁，也可以加密给该公钥
+func NewIdentityKey(identity *ecdh.PrivateKey) (*EncryptionKey, error) {
+	if identity == nil || identity.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("identity is not an x25519 private key")
+	}
+	return &EncryptionKey{kind: EncryptionRecipient, recipient: identity.PublicKey(), identity: identity}, nil
+}
+
+// Kind 返回密钥类型
+func (k *EncryptionKey) Kind() EncryptionKind {
+	return k.kind
+}
+
+// KeyID 返回原始密钥或接收方公钥的标识（SHA-256的前8字节），口令没有标识
+func (k *EncryptionKey) KeyID() string {
+	if k.kind == EncryptionPassphrase {
+		return ""
+	}
+	id := k.id()
+	return hex.EncodeToString(id[:])
+}
+
+// id 返回写入加密块的密钥标识
+func (k *EncryptionKey) id() [8]byte {
+	var id [8]byte
+	switch k.kind {
+	case EncryptionRawKey:
+		sum := sha256.Sum256(k.key)
+		copy(id[:], sum[:])
+	case EncryptionRecipient:
+		sum := sha256.Sum256(k.recipient.Bytes())
+		copy(id[:], sum[:])
+	}
+	return id
+}
+
+// ParseEncryptionKey 按说明解析密钥：
+//
+//	pass:<口令>   口令
+//	env:<变量名>  从环境变量读取的口令
+//	<文件路径>    PEM格式的X25519公钥或私钥，或32字节原始密钥（二进制或64位十六进制）
+func ParseEncryptionKey(spec string) (*EncryptionKey, error) {
+	if passphrase, ok := strings.CutPrefix(spec, "pass:"); ok {
+		return NewPassphraseKey(passphrase)
+	}
+	if name, ok := strings.CutPrefix(spec, "env:"); ok {
+		passphrase := os.Getenv(name)
+		if passphrase == "" {
+			return nil, fmt.Errorf("environment variable %s is empty", name)
+		}
+		return NewPassphraseKey(passphrase)
+	}
+
+	data, err := os.ReadFile(spec)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	return ParseKeyFile(data)
+}
+
+// ParseKeyFile 解析密钥文件内容：PEM格式（PKIX公钥或PKCS#8私钥）的X25519密钥，
+// 与 openssl genpkey -algorithm x25519 的输出兼容，或32字节原始密钥
+func ParseKeyFile(data []byte) (*EncryptionKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse public key: %w", err)
+			}
+			recipient, ok := key.(*ecdh.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("public key is not x25519")
+			}
+			return NewRecipientKey(recipient)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse private key: %w", err)
+			}
+			identity, ok := key.(*ecdh.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("private key is not x25519")
+			}
+			return NewIdentityKey(identity)
+		default:
+			return nil, fmt.Errorf("unsupported PEM block: %s", block.Type)
+		}
+	}
+
+	if text := strings.TrimSpace(string(data)); len(text) == hex.EncodedLen(encryptionKeySize) {
+		if key, err := hex.DecodeString(text); err == nil {
+			return NewRawKey(key)
+		}
+	}
+	return NewRawKey(data)
+}
+
+// GenerateEncryptionKey 生成X25519密钥对，私钥和公钥分别以PEM格式写入keyPath和pubPath
+func GenerateEncryptionKey(keyPath, pubPath string) (*EncryptionKey, error) {
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(identity)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(identity.PublicKey())
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key: %w", err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, fmt.Errorf("write private key: %w", err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		return nil, fmt.Errorf("write public key: %w", err)
+	}
+	return NewIdentityKey(identity)
+}
+
+// keyEncryptionKey 按加密块的参数派生加密数据密钥的密钥
+func (k *EncryptionKey) keyEncryptionKey(e *EncryptionHeader, encrypt bool) ([]byte, error) {
+	switch k.kind {
+	case EncryptionPassphrase:
+		return pbkdf2.Key(sha256.New, k.passphrase, e.Param[:], int(e.Iterations), encryptionKeySize)
+	case EncryptionRawKey:
+		return hkdf.Key(sha256.New, k.key, e.Param[:], "hexdiff patch key", encryptionKeySize)
+	case EncryptionRecipient:
+		var shared []byte
+		var err error
+		if encrypt {
+			// 加密时生成临时密钥对，临时公钥写入加密块
+			ephemeral, genErr := ecdh.X25519().GenerateKey(rand.Reader)
+			if genErr != nil {
+				return nil, fmt.Errorf("generate ephemeral key: %w", genErr)
+			}
+			copy(e.Param[:], ephemeral.PublicKey().Bytes())
+			shared, err = ephemeral.ECDH(k.recipient)
+		} else {
+			if k.identity == nil {
+				return nil, fmt.Errorf("x25519 public key cannot decrypt")
+			}
+			ephemeral, parseErr := ecdh.X25519().NewPublicKey(e.Param[:])
+			if parseErr != nil {
+				return nil, fmt.Errorf("parse ephemeral key: %w", parseErr)
+			}
+			shared, err = k.identity.ECDH(ephemeral)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("x25519: %w", err)
+		}
+		salt := append(bytes.Clone(e.Param[:]), k.recipient.Bytes()...)
+		return hkdf.Key(sha256.New, shared, salt, "hexdiff patch x25519", encryptionKeySize)
+	default:
+		return nil, fmt.Errorf("unsupported encryption kind: %s", k.kind)
+	}
+}
+
+// wrap 生成随机数据密钥，用本密钥加密后填写加密块，返回数据密钥
+func (k *EncryptionKey) wrap() (*EncryptionHeader, []byte, error) {
+	e := &EncryptionHeader{Kind: k.kind, KeyID: k.id()}
+	if k.kind == EncryptionPassphrase {
+		e.Iterations = passphraseIterations
+	}
+	if k.kind != EncryptionRecipient {
+		if _, err := rand.Read(e.Param[:]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err := rand.Read(e.Nonce[:]); err != nil {
+		return nil, nil, err
+	}
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	kek, err := k.keyEncryptionKey(e, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead.Seal(e.WrappedKey[:0], e.Nonce[:], dataKey, nil)
+	return e, dataKey, nil
+}
+
+// unwrap 解密加密块中的数据密钥，密钥类型或标识不符时返回nil
+func (k *EncryptionKey) unwrap(e *EncryptionHeader) ([]byte, error) {
+	if k.kind != e.Kind || (k.kind != EncryptionPassphrase && k.id() != e.KeyID) {
+		return nil, nil
+	}
+	if k.kind == EncryptionRecipient && k.identity == nil {
+		return nil, nil
+	}
+	kek, err := k.keyEncryptionKey(e, false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aead.Open(nil, e.Nonce[:], e.WrappedKey[:], nil)
+	if err != nil {
+		// 口令无法按标识匹配，错误的口令视为不匹配
+		return nil, nil
+	}
+	return dataKey, nil
+}
+
+// newAEAD 创建AES-256-GCM
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 已注册的解密密钥
+var (
+	decryptionKeysMu sync.RWMutex
+	decryptionKeys   []*EncryptionKey
+)
+
+// RegisterDecryptionKey 注册解密密钥，读取加密补丁时依次尝试已注册的密钥
+func RegisterDecryptionKey(key *EncryptionKey) {
+	decryptionKeysMu.Lock()
+	defer decryptionKeysMu.Unlock()
+	decryptionKeys = append(decryptionKeys, key)
+}
+
+// setEncryption 在文件头中记录加密块，返回加密数据使用的数据密钥，key为nil时不加密
+func (h *PatchHeader) setEncryption(key *EncryptionKey) ([]byte, error) {
+	h.Flags &^= FlagEncrypted
+	h.Encryption = EncryptionHeader{}
+	if key == nil {
+		return nil, nil
+	}
+	encryption, dataKey, err := key.wrap()
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	h.Flags |= FlagEncrypted
+	h.Encryption = *encryption
+	return dataKey, nil
+}
+
+// dataKey 用已注册的密钥解密补丁的数据密钥
+func (h *PatchHeader) dataKey() ([]byte, error) {
+	decryptionKeysMu.RLock()
+	keys := decryptionKeys
+	decryptionKeysMu.RUnlock()
+
+	for _, key := range keys {
+		dataKey, err := key.unwrap(&h.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		if dataKey != nil {
+			return dataKey, nil
+		}
+	}
+	if id := h.Encryption.KeyIDString(); id != "" {
+		return nil, fmt.Errorf("%w: %s key %s", ErrDecryptionKeyMissing, h.Encryption.Kind, id)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDecryptionKeyMissing, h.Encryption.Kind)
+}
+
+// encryptPayload 返回加密文件头之后内容的writer，未加密时直接写入w，Close写入最后一块
+func encryptPayload(w io.Writer, header *PatchHeader, dataKey []byte) (io.WriteCloser, error) {
+	if header.Flags&FlagEncrypted == 0 {
+		return nopWriteCloser{w}, nil
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:    w,
+		aead: aead,
+		aad:  header.Marshal(),
+		buf:  make([]byte, 0, EncryptionChunkSize),
+	}, nil
+}
+
+// OpenPayload 返回文件头之后内容（操作列表和数据区）的reader，加密的补丁用已注册的密钥解密
+func OpenPayload(r io.Reader, header *PatchHeader) (io.Reader, error) {
+	if header.Flags&FlagEncrypted == 0 {
+		return r, nil
+	}
+	dataKey, err := header.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead, aad: header.Marshal()}, nil
+}
+
+// chunkNonce 返回分块的随机数：8字节块序号和最后一块标志，数据密钥每个补丁不同，随机数不会重复
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[0:8], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// encryptWriter 分块加密写入的数据，最后一块在Close时写入并带有结束标志，防止截断
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	aad     []byte
+	buf     []byte
+	counter uint64
+	out     []byte
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// 缓冲区已满且还有数据时，当前块不是最后一块
+		if len(ew.buf) == EncryptionChunkSize {
+			if err := ew.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := min(len(p), EncryptionChunkSize-len(ew.buf))
+		ew.buf = append(ew.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (ew *encryptWriter) seal(last bool) error {
+	ew.out = ew.aead.Seal(ew.out[:0], chunkNonce(ew.counter, last), ew.buf, ew.aad)
+	ew.counter++
+	ew.buf = ew.buf[:0]
+	if _, err := ew.w.Write(ew.out); err != nil {
+		return fmt.Errorf("write encrypted chunk: %w", err)
+	}
+	return nil
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.seal(true)
+}
+
+// decryptReader 逐块解密，读到最后一块之前遇到结尾时报告截断
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	aad     []byte
+	counter uint64
+	in      []byte
+	plain   []byte
+	done    bool
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+	return n, nil
+}
+
+// next 读取并解密下一块，多读一个字节判断当前块是否为最后一块
+func (dr *decryptReader) next() error {
+	chunkSize := EncryptionChunkSize + dr.aead.Overhead()
+	if dr.in == nil {
+		dr.in = make([]byte, 0, chunkSize+1)
+	}
+	n, err := io.ReadFull(dr.r, dr.in[len(dr.in):chunkSize+1])
+	dr.in = dr.in[:len(dr.in)+n]
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return fmt.Errorf("read encrypted chunk: %w", err)
+	}
+
+	sealed := dr.in
+	if !last {
+		sealed = dr.in[:chunkSize]
+	}
+	plain, err := dr.aead.Open(nil, chunkNonce(dr.counter, last), sealed, dr.aad)
+	if err != nil {
+		return ErrDecryptionFailed
+	}
+	dr.counter++
+	dr.plain = plain
+	if last {
+		dr.done = true
+		dr.in = dr.in[:0]
+	} else {
+		dr.in = append(dr.in[:0], dr.in[chunkSize])
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// encryptedPatch 使用指定密钥生成加密补丁
+func encryptedPatch(t *testing.T, dir string, key *EncryptionKey) (oldFile, patchPath string, newData []byte) {
+	t.Helper()
+	oldFile = filepath.Join(dir, "old.bin")
+	newFile := filepath.Join(dir, "new.bin")
+	oldData := compressibleText(3000, "old")
+	newData = append(compressibleText(2000, "new"), oldData...)
+	if err := os.WriteFile(oldFile, oldData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newFile, newData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := diff.NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := NewGenerator(engine, CompressionZstd)
+	generator.SetCompressOperations(true)
+	generator.SetEncryption(key)
+	patchPath = filepath.Join(dir, "app.patch")
+	if _, err := generator.GeneratePatch(oldFile, newFile, patchPath); err != nil {
+		t.Fatalf("GeneratePatch() error = %v", err)
+	}
+	return oldFile, patchPath, newData
+}
+
+func rawKey(t *testing.T) *EncryptionKey {
+	t.Helper()
+	data := make([]byte, 32)
+	rand.Read(data)
+	key, err := NewRawKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptedPatchRoundTrip(t *testing.T) {
+	passphrase, err := NewPassphraseKey("firmware secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, _ := NewRecipientKey(identity.PublicKey())
+	decryptor, _ := NewIdentityKey(identity)
+
+	tests := []struct {
+		name    string
+		encrypt *EncryptionKey
+		decrypt *EncryptionKey
+	}{
+		{"passphrase", passphrase, passphrase},
+		{"raw key", rawKey(t), nil},
+		{"recipient", recipient, decryptor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			oldFile, patchPath, newData := encryptedPatch(t, dir, tt.encrypt)
+
+			// 文件头不加密，没有密钥也能读取补丁信息
+			header, err := GetPatchInfo(patchPath)
+			if err != nil {
+				t.Fatalf("GetPatchInfo() error = %v", err)
+			}
+			if header.Flags&FlagEncrypted == 0 || header.Encryption.Kind != tt.encrypt.Kind() {
+				t.Fatalf("header flags = %08b, encryption = %v", header.Flags, header.Encryption.Kind)
+			}
+			if header.TargetSize != int64(len(newData)) {
+				t.Errorf("TargetSize = %d, want %d", header.TargetSize, len(newData))
+			}
+			if header.Encryption.KeyIDString() != tt.encrypt.KeyID() {
+				t.Errorf("key ID = %s, want %s", header.Encryption.KeyIDString(), tt.encrypt.KeyID())
+			}
+
+			// 压缩后的内容不应出现在补丁中
+			data, _ := os.ReadFile(patchPath)
+			if bytes.Contains(data, []byte("new line")) {
+				t.Error("patch contains plaintext")
+			}
+
+			output := filepath.Join(dir, "out.bin")
+			if tt.encrypt.Kind() != EncryptionPassphrase {
+				if _, err := NewApplier(nil).ApplyPatch(oldFile, patchPath, output); !errors.Is(err, ErrDecryptionKeyMissing) {
+					t.Fatalf("ApplyPatch() without key error = %v, want ErrDecryptionKeyMissing", err)
+				}
+			}
+
+			decrypt := tt.decrypt
+			if decrypt == nil {
+				decrypt = tt.encrypt
+			}
+			RegisterDecryptionKey(decrypt)
+			if _, err := NewApplier(nil).ApplyPatch(oldFile, patchPath, output); err != nil {
+				t.Fatalf("ApplyPatch() error = %v", err)
+			}
+			if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+				t.Error("applied output mismatch")
+			}
+		})
+	}
+}
+
+func TestEncryptedPatchTampered(t *testing.T) {
+	key := rawKey(t)
+	RegisterDecryptionKey(key)
+	dir := t.TempDir()
+	_, patchPath, _ := encryptedPatch(t, dir, key)
+	original, _ := os.ReadFile(patchPath)
+
+	serializer := NewSerializer(CompressionNone)
+	if _, err := serializer.DeserializeFromData(original); err != nil {
+		t.Fatalf("DeserializeFromData() error = %v", err)
+	}
+
+	// 修改密文、修改明文文件头、截断末尾都应解密失败
+	tampered := bytes.Clone(original)
+	tampered[len(tampered)-20] ^= 0x01
+	headerChanged := bytes.Clone(original)
+	headerChanged[16]++ // SourceSize
+	truncated := original[:len(original)-1]
+
+	for name, data := range map[string][]byte{"ciphertext": tampered, "header": headerChanged, "truncated": truncated} {
+		if _, err := serializer.DeserializeFromData(data); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("%s: DeserializeFromData() error = %v, want ErrDecryptionFailed", name, err)
+		}
+	}
+}
+
+func TestEncryptedPayloadChunks(t *testing.T) {
+	header := NewPatchHeader()
+	dataKey, err := header.setEncryption(rawKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 覆盖空内容、整块和跨块的长度
+	for _, size := range []int{0, 1, EncryptionChunkSize, 3*EncryptionChunkSize + 7} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		var sealed bytes.Buffer
+		writer, err := encryptPayload(&sealed, header, dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(plain)
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		aead, _ := newAEAD(dataKey)
+		open := func(data []byte) ([]byte, error) {
+			return io.ReadAll(&decryptReader{r: bytes.NewReader(data), aead: aead, aad: header.Marshal()})
+		}
+		got, err := open(sealed.Bytes())
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: decrypt = %d bytes, %v", size, len(got), err)
+		}
+
+		// 在块边界截断时缺少最后一块，应当报错
+		if size > EncryptionChunkSize {
+			chunk := EncryptionChunkSize + aead.Overhead()
+			if _, err := open(sealed.Bytes()[:chunk]); !errors.Is(err, ErrDecryptionFailed) {
+				t.Errorf("size %d: truncated error = %v, want ErrDecryptionFailed", size, err)
+			}
+		}
+	}
+}
+
+func TestStreamingPatchEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old.bin")
+	newFile := filepath.Join(dir, "new.bin")
+	oldData := compressibleText(2000, "old")
+	newData := append(oldData[:1000:1000], compressibleText(2000, "new")...)
+	if err := os.WriteFile(oldFile, oldData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newFile, newData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := diff.NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := rawKey(t)
+	generator := NewStreamingPatchGenerator(engine, CompressionGzip)
+	generator.SetEncryption(key)
+	patchPath := filepath.Join(dir, "stream.patch")
+	if _, err := generator.GeneratePatchStreaming(oldFile, newFile, patchPath); err != nil {
+		t.Fatalf("GeneratePatchStreaming() error = %v", err)
+	}
+
+	RegisterDecryptionKey(key)
+	output := filepath.Join(dir, "out.bin")
+	if _, err := NewApplier(nil).ApplyPatch(oldFile, patchPath, output); err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+		t.Error("applied output mismatch")
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "fleet.key")
+	pubPath := filepath.Join(dir, "fleet.pub")
+	identity, err := GenerateEncryptionKey(keyPath, pubPath)
+	if err != nil {
+		t.Fatalf("GenerateEncryptionKey() error = %v", err)
+	}
+
+	recipient, err := ParseEncryptionKey(pubPath)
+	if err != nil || recipient.Kind() != EncryptionRecipient || recipient.KeyID() != identity.KeyID() {
+		t.Errorf("ParseEncryptionKey(pub) = %v, %v", recipient, err)
+	}
+	parsed, err := ParseEncryptionKey(keyPath)
+	if err != nil || parsed.KeyID() != identity.KeyID() {
+		t.Errorf("ParseEncryptionKey(key) = %v, %v", parsed, err)
+	}
+
+	rawPath := filepath.Join(dir, "raw.key")
+	if err := os.WriteFile(rawPath, []byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ParseEncryptionKey(rawPath)
+	if err != nil || raw.Kind() != EncryptionRawKey {
+		t.Errorf("ParseEncryptionKey(raw) = %v, %v", raw, err)
+	}
+
+	t.Setenv("HEXDIFF_TEST_PASSPHRASE", "secret")
+	if key, err := ParseEncryptionKey("env:HEXDIFF_TEST_PASSPHRASE"); err != nil || key.passphrase != "secret" {
+		t.Errorf("ParseEncryptionKey(env) = %v, %v", key, err)
+	}
+	if _, err := ParseEncryptionKey("pass:"); err == nil {
+		t.Error("ParseEncryptionKey() should reject an empty passphrase")
+	}
+}
@@ -0,0 +1,299 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Sky-ey/HexDiff/pkg/metadata"
+)
+
+// 增强补丁格式常量
+//
+// 增强补丁（HXDF）由128字节的文件头、完整的单文件补丁和JSON元数据依次组成，
+// 文件头中的摘要信息与内部补丁头一致，读取补丁时自动识别并跳过外层结构。
+const (
+	// EnhancedMagicNumber 增强补丁魔数
+	EnhancedMagicNumber = 0x48584446 // "HXDF"
+	// EnhancedVersion 增强补丁版本
+	EnhancedVersion = 1
+	// EnhancedHeaderSize 增强补丁文件头大小 (4+2+1+1+8+8+8+32+32+8+8+8+8 = 128字节)
+	EnhancedHeaderSize = 128
+)
+
+// EnhancedHeader 增强补丁文件头
+type EnhancedHeader struct {
+	Magic            uint32           // 魔数 "HXDF"
+	Version          uint16           // 版本号
+	Compression      CompressionType  // 内部补丁的压缩类型
+	CompressionLevel CompressionLevel // 压缩级别，0表示算法的默认级别
+	Timestamp        int64            // 创建时间戳
+	SourceSize       int64            // 源文件大小
+	TargetSize       int64            // 目标文件大小
+	SourceChecksum   [32]byte         // 源文件SHA-256校验和
+	TargetChecksum   [32]byte         // 目标文件SHA-256校验和
+	OperationCount   uint64           // 操作数量
+	DataOffset       uint64           // 内部补丁偏移量
+	MetadataOffset   uint64           // 元数据偏移量
+	MetadataSize     uint64           // 元数据大小
+}
+
+// Marshal 序列化增强补丁文件头
+func (h *EnhancedHeader) Marshal() []byte {
+	buf := make([]byte, EnhancedHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:4], h.Magic)
+	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
+	buf[6] = uint8(h.Compression)
+	buf[7] = uint8(h.CompressionLevel)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(h.Timestamp))
+	binary.LittleEndian.PutUint64(buf[16:24], uint64(h.SourceSize))
+	binary.LittleEndian.PutUint64(buf[24:32], uint64(h.TargetSize))
+	copy(buf[32:64], h.SourceChecksum[:])
+	copy(buf[64:96], h.TargetChecksum[:])
+	binary.LittleEndian.PutUint64(buf[96:104], h.OperationCount)
+	binary.LittleEndian.PutUint64(buf[104:112], h.DataOffset)
+	binary.LittleEndian.PutUint64(buf[112:120], h.MetadataOffset)
+	binary.LittleEndian.PutUint64(buf[120:128], h.MetadataSize)
+	return buf
+}
+
+// Unmarshal 反序列化增强补丁文件头
+func (h *EnhancedHeader) Unmarshal(data []byte) error {
+	if len(data) < EnhancedHeaderSize {
+		return fmt.Errorf("insufficient data for enhanced header: need %d bytes, got %d", EnhancedHeaderSize, len(data))
+	}
+	h.Magic = binary.LittleEndian.Uint32(data[0:4])
+	h.Version = binary.LittleEndian.Uint16(data[4:6])
+	h.Compression = CompressionType(data[6])
+	h.CompressionLevel = CompressionLevel(data[7])
+	h.Timestamp = int64(binary.LittleEndian.Uint64(data[8:16]))
+	h.SourceSize = int64(binary.LittleEndian.Uint64(data[16:24]))
+	h.TargetSize = int64(binary.LittleEndian.Uint64(data[24:32]))
+	copy(h.SourceChecksum[:], data[32:64])
+	copy(h.TargetChecksum[:], data[64:96])
+	h.OperationCount = binary.LittleEndian.Uint64(data[96:104])
+	h.DataOffset = binary.LittleEndian.Uint64(data[104:112])
+	h.MetadataOffset = binary.LittleEndian.Uint64(data[112:120])
+	h.MetadataSize = binary.LittleEndian.Uint64(data[120:128])
+	return h.Validate()
+}
+
+// Validate 验证增强补丁文件头
+func (h *EnhancedHeader) Validate() error {
+	if h.Magic != EnhancedMagicNumber {
+		return fmt.Errorf("invalid magic number: expected %x, got %x", EnhancedMagicNumber, h.Magic)
+	}
+	if h.Version != EnhancedVersion {
+		return fmt.Errorf("unsupported enhanced patch version: %d", h.Version)
+	}
+	if h.DataOffset < EnhancedHeaderSize || h.MetadataOffset < h.DataOffset {
+		return fmt.Errorf("invalid enhanced patch layout: data at %d, metadata at %d", h.DataOffset, h.MetadataOffset)
+	}
+	return nil
+}
+
+// EnhancedPatch 增强补丁的文件头和元数据
+type EnhancedPatch struct {
+	Header   *EnhancedHeader
+	Metadata *metadata.PatchMetadata
+}
+
+// isEnhancedMagic 判断数据是否以增强补丁魔数开头
+func isEnhancedMagic(prefix []byte) bool {
+	return len(prefix) >= 4 && binary.LittleEndian.Uint32(prefix) == EnhancedMagicNumber
+}
+
+// IsEnhancedPatch 判断文件是否为增强补丁
+func IsEnhancedPatch(patchPath string) (bool, error) {
+	file, err := os.Open(patchPath)
+	if err != nil {
+		return false, fmt.Errorf("open patch file: %w", err)
+	}
+	defer file.Close()
+
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(file, prefix); err != nil {
+		return false, fmt.Errorf("read header: %w", err)
+	}
+	return isEnhancedMagic(prefix), nil
+}
+
+// WrapEnhancedPatch 将单文件补丁封装为增强补丁
+//
+// 元数据中的补丁信息、文件大小和校验和按补丁头填写，其余字段由调用方设置。
+func WrapEnhancedPatch(patchPath, outputPath string, level CompressionLevel, meta *metadata.PatchMetadata) error {
+	input, err := os.Open(patchPath)
+	if err != nil {
+		return fmt.Errorf("open patch file: %w", err)
+	}
+	defer input.Close()
+
+	stat, err := input.Stat()
+	if err != nil {
+		return fmt.Errorf("stat patch file: %w", err)
+	}
+	inner, err := ReadPatchHeader(input)
+	if err != nil {
+		return err
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek patch file: %w", err)
+	}
+
+	compressionName := inner.Compression.String()
+	if inner.Flags&FlagChunkedData != 0 {
+		compressionName = CompressionAuto.String()
+	}
+	meta.SourceFile.Size = inner.SourceSize
+	meta.SourceFile.Checksum = hex.EncodeToString(inner.SourceChecksum[:])
+	meta.TargetFile.Size = inner.TargetSize
+	meta.TargetFile.Checksum = hex.EncodeToString(inner.TargetChecksum[:])
+	meta.PatchInfo.Size = stat.Size()
+	meta.PatchInfo.CompressionType = compressionName
+	meta.PatchInfo.OperationCount = int(inner.OperationCount)
+	if inner.TargetSize > 0 {
+		meta.PatchInfo.CompressionRatio = float64(stat.Size()) / float64(inner.TargetSize)
+	}
+
+	metadataData, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("marshal metadata: %w", err)
+	}
+
+	header := &EnhancedHeader{
+		Magic:            EnhancedMagicNumber,
+		Version:          EnhancedVersion,
+		Compression:      inner.Compression,
+		CompressionLevel: level,
+		Timestamp:        inner.Timestamp,
+		SourceSize:       inner.SourceSize,
+		TargetSize:       inner.TargetSize,
+		SourceChecksum:   inner.SourceChecksum,
+		TargetChecksum:   inner.TargetChecksum,
+		OperationCount:   inner.OperationCount,
+		DataOffset:       EnhancedHeaderSize,
+		MetadataOffset:   EnhancedHeaderSize + uint64(stat.Size()),
+		MetadataSize:     uint64(len(metadataData)),
+	}
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("create patch file: %w", err)
+	}
+	defer output.Close()
+
+	writer := bufio.NewWriter(output)
+	writer.Write(header.Marshal())
+	if _, err := io.Copy(writer, input); err != nil {
+		return fmt.Errorf("write patch data: %w", err)
+	}
+	writer.Write(metadataData)
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("write patch file: %w", err)
+	}
+	return output.Close()
+}
+
+// ReadEnhancedPatch 读取增强补丁的文件头和元数据
+func ReadEnhancedPatch(patchPath string) (*EnhancedPatch, error) {
+	file, err := os.Open(patchPath)
+	if err != nil {
+		return nil, fmt.Errorf("open patch file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readEnhancedHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataData := make([]byte, header.MetadataSize)
+	if _, err := file.ReadAt(metadataData, int64(header.MetadataOffset)); err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+	meta := &metadata.PatchMetadata{}
+	if err := json.Unmarshal(metadataData, meta); err != nil {
+		return nil, fmt.Errorf("parse metadata: %w", err)
+	}
+
+	// 签名块在元数据之后，签名信息按签名块填写，是否可信由VerifyPatch判断
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat patch file: %w", err)
+	}
+	signature, _, err := readSignature(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	if signature != nil {
+		meta.Verification.Signature = hex.EncodeToString(signature.Signature)
+		meta.Verification.SignedBy = signature.Signer
+		meta.Verification.SignedAt = time.Unix(signature.SignedAt, 0)
+	}
+
+	return &EnhancedPatch{Header: header, Metadata: meta}, nil
+}
+
+// readEnhancedHeader 从文件开头读取增强补丁文件头
+func readEnhancedHeader(r io.ReaderAt) (*EnhancedHeader, error) {
+	data := make([]byte, EnhancedHeaderSize)
+	if _, err := r.ReadAt(data, 0); err != nil {
+		return nil, fmt.Errorf("read enhanced header: %w", err)
+	}
+	header := &EnhancedHeader{}
+	if err := header.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("parse enhanced header: %w", err)
+	}
+	return header, nil
+}
+
+// openPatchBody 返回文件中单文件补丁的部分，增强补丁跳过外层文件头和元数据
+func openPatchBody(file *os.File) (*io.SectionReader, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat patch file: %w", err)
+	}
+	size, err := contentSize(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, 4)
+	if _, err := file.ReadAt(prefix, 0); err != nil || !isEnhancedMagic(prefix) {
+		return io.NewSectionReader(file, 0, size), nil
+	}
+
+	header, err := readEnhancedHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	if header.MetadataOffset > uint64(size) {
+		return nil, fmt.Errorf("enhanced patch truncated: metadata at %d, file size %d", header.MetadataOffset, size)
+	}
+	return io.NewSectionReader(file, int64(header.DataOffset), int64(header.MetadataOffset-header.DataOffset)), nil
+}
+
+// patchBody 返回内存中补丁数据的单文件补丁部分
+func patchBody(data []byte) ([]byte, error) {
+	data, err := stripSignature(data)
+	if err != nil {
+		return nil, err
+	}
+	if !isEnhancedMagic(data) {
+		return data, nil
+	}
+	header, err := readEnhancedHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if header.MetadataOffset > uint64(len(data)) {
+		return nil, fmt.Errorf("enhanced patch truncated: metadata at %d, data size %d", header.MetadataOffset, len(data))
+	}
+	return data[header.DataOffset:header.MetadataOffset], nil
+}
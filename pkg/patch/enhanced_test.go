@@ -0,0 +1,114 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+	"github.com/Sky-ey/HexDiff/pkg/metadata"
+)
+
+// enhancedPatch 生成单文件补丁并封装为增强补丁
+func enhancedPatch(t *testing.T, dir string) (oldFile, patchPath string, newData []byte) {
+	t.Helper()
+	oldFile = filepath.Join(dir, "old.txt")
+	newFile := filepath.Join(dir, "new.txt")
+	oldData := compressibleText(500, "old")
+	newData = append(compressibleText(200, "new"), oldData...)
+	os.WriteFile(oldFile, oldData, 0644)
+	os.WriteFile(newFile, newData, 0644)
+
+	engine, err := diff.NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	innerPath := filepath.Join(dir, "inner.patch")
+	if _, err := NewGenerator(engine, CompressionZstd).GeneratePatch(oldFile, newFile, innerPath); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := metadata.NewMetadataManager("").CreateMetadata(innerPath)
+	meta.Description = "nightly build"
+	meta.SourceFile.Name = "old.txt"
+	meta.TargetFile.Name = "new.txt"
+	meta.PatchInfo.Algorithm = diff.AlgorithmRollingHash
+	patchPath = filepath.Join(dir, "enhanced.patch")
+	if err := WrapEnhancedPatch(innerPath, patchPath, 9, meta); err != nil {
+		t.Fatalf("WrapEnhancedPatch() error = %v", err)
+	}
+	return oldFile, patchPath, newData
+}
+
+func TestEnhancedPatchRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	oldFile, patchPath, newData := enhancedPatch(t, dir)
+
+	if enhanced, err := IsEnhancedPatch(patchPath); err != nil || !enhanced {
+		t.Fatalf("IsEnhancedPatch() = %v, %v", enhanced, err)
+	}
+
+	enhanced, err := ReadEnhancedPatch(patchPath)
+	if err != nil {
+		t.Fatalf("ReadEnhancedPatch() error = %v", err)
+	}
+	if enhanced.Header.Compression != CompressionZstd || enhanced.Header.CompressionLevel != 9 {
+		t.Errorf("header compression = %v level %d", enhanced.Header.Compression, enhanced.Header.CompressionLevel)
+	}
+	if enhanced.Metadata.Description != "nightly build" || enhanced.Metadata.PatchInfo.CompressionType != "Zstd" {
+		t.Errorf("metadata = %+v", enhanced.Metadata)
+	}
+
+	header, err := GetPatchInfo(patchPath)
+	if err != nil {
+		t.Fatalf("GetPatchInfo() error = %v", err)
+	}
+	if header.Magic != MagicNumber || header.TargetSize != int64(len(newData)) {
+		t.Errorf("GetPatchInfo() = %+v", header)
+	}
+	if got := hex.EncodeToString(header.TargetChecksum[:]); got != enhanced.Metadata.TargetFile.Checksum {
+		t.Errorf("metadata target checksum = %s, want %s", enhanced.Metadata.TargetFile.Checksum, got)
+	}
+
+	output := filepath.Join(dir, "out.txt")
+	if _, err := NewApplier(nil).ApplyPatch(oldFile, patchPath, output); err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+		t.Error("applied output mismatch")
+	}
+
+	data, _ := os.ReadFile(patchPath)
+	patchFile, err := NewSerializer(CompressionNone).DeserializeFromData(data)
+	if err != nil {
+		t.Fatalf("DeserializeFromData() error = %v", err)
+	}
+	if patchFile.Header.OperationCount != enhanced.Header.OperationCount {
+		t.Errorf("operation count = %d, want %d", patchFile.Header.OperationCount, enhanced.Header.OperationCount)
+	}
+
+	result, err := NewValidator().ValidatePatchFile(patchPath)
+	if err != nil || !result.Valid {
+		t.Errorf("ValidatePatchFile() = %+v, %v", result, err)
+	}
+}
+
+func TestEnhancedPatchValidatorDetectsMismatch(t *testing.T) {
+	dir := t.TempDir()
+	_, patchPath, _ := enhancedPatch(t, dir)
+
+	// 篡改外层文件头中的目标文件大小
+	data, _ := os.ReadFile(patchPath)
+	data[24]++
+	os.WriteFile(patchPath, data, 0644)
+
+	result, err := NewValidator().ValidatePatchFile(patchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid {
+		t.Error("ValidatePatchFile() should reject a mismatched enhanced header")
+	}
+}
@@ -3,6 +3,8 @@ package patch
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
 	"time"
 )
 
@@ -10,48 +12,44 @@ import (
 const (
 	// MagicNumber 补丁文件魔数
 	MagicNumber = 0x48455844 // "HEXD"
-	// Version 补丁文件版本
+	// Version 补丁文件版本（32位大小和偏移量）
 	Version = 1
+	// Version64 64位补丁文件版本，用于超过4GiB的数据
+	// 版本2已被使用相同魔数的目录补丁占用
+	Version64 = 3
 	// HeaderSize 文件头大小 (4+2+1+1+8+8+8+32+32+4+4 = 104字节)
 	HeaderSize = 104
+	// Header64Size 64位文件头大小 (4+2+1+1+8+8+8+32+32+8+8+16 = 128字节，末尾16字节保留)
+	Header64Size = 128
+	// DictIDSize 设置FlagZstdDict时紧跟在文件头之后的字典ID大小
+	DictIDSize = 4
 )
 
-// CompressionType 压缩类型
-type CompressionType uint8
-
+// 补丁文件头标志位
 const (
-	CompressionNone CompressionType = iota // 无压缩
-	CompressionGzip                        // Gzip压缩
-	CompressionLZ4                         // LZ4压缩
+	FlagCompactOps    uint8 = 1 << iota // 操作列表使用紧凑编码
+	FlagCompressedOps                   // 操作列表按文件头的压缩类型压缩
+	FlagZstdDict                        // 使用Zstd字典压缩，文件头后附带字典ID
+	FlagChunkedData                     // 数据区分块，每块记录各自的压缩算法
+	FlagEncrypted                       // 文件头之后的内容已加密，文件头后附带加密块
+	FlagInPlace                         // 操作按依赖排序，可以直接在源文件上应用
 )
 
-// String 返回压缩类型的字符串表示
-func (c CompressionType) String() string {
-	switch c {
-	case CompressionNone:
-		return "None"
-	case CompressionGzip:
-		return "Gzip"
-	case CompressionLZ4:
-		return "LZ4"
-	default:
-		return "Unknown"
-	}
-}
-
 // PatchHeader 补丁文件头
 type PatchHeader struct {
-	Magic          uint32          // 魔数 "HEXD"
-	Version        uint16          // 版本号
-	Compression    CompressionType // 压缩类型
-	Reserved       uint8           // 保留字段
-	Timestamp      int64           // 创建时间戳
-	SourceSize     int64           // 源文件大小
-	TargetSize     int64           // 目标文件大小
-	SourceChecksum [32]byte        // 源文件SHA-256校验和
-	TargetChecksum [32]byte        // 目标文件SHA-256校验和
-	OperationCount uint32          // 操作数量
-	DataOffset     uint32          // 数据区偏移量
+	Magic          uint32           // 魔数 "HEXD"
+	Version        uint16           // 版本号
+	Compression    CompressionType  // 压缩类型
+	Flags          uint8            // 标志位
+	Timestamp      int64            // 创建时间戳
+	SourceSize     int64            // 源文件大小
+	TargetSize     int64            // 目标文件大小
+	SourceChecksum [32]byte         // 源文件SHA-256校验和
+	TargetChecksum [32]byte         // 目标文件SHA-256校验和
+	OperationCount uint64           // 操作数量
+	DataOffset     uint64           // 数据区偏移量
+	DictID         uint32           // Zstd字典ID（仅设置FlagZstdDict时有效）
+	Encryption     EncryptionHeader // 加密块（仅设置FlagEncrypted时有效）
 }
 
 // NewPatchHeader 创建新的补丁文件头
@@ -69,30 +67,84 @@ func (h *PatchHeader) Validate() error {
 	if h.Magic != MagicNumber {
 		return fmt.Errorf("invalid magic number: expected %x, got %x", MagicNumber, h.Magic)
 	}
-	if h.Version != Version {
+	if h.Version != Version && h.Version != Version64 {
 		return fmt.Errorf("unsupported version: %d", h.Version)
 	}
 	if h.SourceSize < 0 || h.TargetSize < 0 {
 		return fmt.Errorf("invalid file size: source=%d, target=%d", h.SourceSize, h.TargetSize)
 	}
+	if h.Flags&FlagZstdDict != 0 && h.Compression != CompressionZstd {
+		return fmt.Errorf("dictionary flag set for %s compression", h.Compression)
+	}
+	if h.Flags&FlagEncrypted != 0 {
+		if err := h.Encryption.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Size 返回当前版本文件头的序列化大小，包含字典ID和加密块
+func (h *PatchHeader) Size() int {
+	return headerSize(h.Version, h.Flags)
+}
+
+// headerSize 按版本和标志位计算文件头大小
+func headerSize(version uint16, flags uint8) int {
+	size := HeaderSize
+	if version == Version64 {
+		size = Header64Size
+	}
+	if flags&FlagZstdDict != 0 {
+		size += DictIDSize
+	}
+	if flags&FlagEncrypted != 0 {
+		size += EncryptionHeaderSize
+	}
+	return size
+}
+
+// baseSize 返回不含扩展字段的文件头大小
+func (h *PatchHeader) baseSize() int {
+	return headerSize(h.Version, 0)
+}
+
+// OperationSize 返回当前版本单个操作的序列化大小
+func (h *PatchHeader) OperationSize() int {
+	if h.Version == Version64 {
+		return Operation64Size
+	}
+	return OperationSize
+}
+
 // Marshal 序列化补丁文件头
 func (h *PatchHeader) Marshal() []byte {
-	buf := make([]byte, HeaderSize)
+	buf := make([]byte, h.Size())
 
 	binary.LittleEndian.PutUint32(buf[0:4], h.Magic)
 	binary.LittleEndian.PutUint16(buf[4:6], h.Version)
 	buf[6] = uint8(h.Compression)
-	buf[7] = h.Reserved
+	buf[7] = h.Flags
 	binary.LittleEndian.PutUint64(buf[8:16], uint64(h.Timestamp))
 	binary.LittleEndian.PutUint64(buf[16:24], uint64(h.SourceSize))
 	binary.LittleEndian.PutUint64(buf[24:32], uint64(h.TargetSize))
 	copy(buf[32:64], h.SourceChecksum[:])
 	copy(buf[64:96], h.TargetChecksum[:])
-	binary.LittleEndian.PutUint32(buf[96:100], h.OperationCount)
-	binary.LittleEndian.PutUint32(buf[100:104], h.DataOffset)
+	if h.Version == Version64 {
+		binary.LittleEndian.PutUint64(buf[96:104], h.OperationCount)
+		binary.LittleEndian.PutUint64(buf[104:112], h.DataOffset)
+	} else {
+		binary.LittleEndian.PutUint32(buf[96:100], uint32(h.OperationCount))
+		binary.LittleEndian.PutUint32(buf[100:104], uint32(h.DataOffset))
+	}
+	offset := h.baseSize()
+	if h.Flags&FlagZstdDict != 0 {
+		binary.LittleEndian.PutUint32(buf[offset:], h.DictID)
+		offset += DictIDSize
+	}
+	if h.Flags&FlagEncrypted != 0 {
+		h.Encryption.marshal(buf[offset:])
+	}
 
 	return buf
 }
@@ -105,58 +157,127 @@ func (h *PatchHeader) Unmarshal(data []byte) error {
 
 	h.Magic = binary.LittleEndian.Uint32(data[0:4])
 	h.Version = binary.LittleEndian.Uint16(data[4:6])
+	h.Flags = data[7]
+	if len(data) < h.Size() {
+		return fmt.Errorf("insufficient data for header: need %d bytes, got %d", h.Size(), len(data))
+	}
 	h.Compression = CompressionType(data[6])
-	h.Reserved = data[7]
 	h.Timestamp = int64(binary.LittleEndian.Uint64(data[8:16]))
 	h.SourceSize = int64(binary.LittleEndian.Uint64(data[16:24]))
 	h.TargetSize = int64(binary.LittleEndian.Uint64(data[24:32]))
 	copy(h.SourceChecksum[:], data[32:64])
 	copy(h.TargetChecksum[:], data[64:96])
-	h.OperationCount = binary.LittleEndian.Uint32(data[96:100])
-	h.DataOffset = binary.LittleEndian.Uint32(data[100:104])
+	if h.Version == Version64 {
+		h.OperationCount = binary.LittleEndian.Uint64(data[96:104])
+		h.DataOffset = binary.LittleEndian.Uint64(data[104:112])
+	} else {
+		h.OperationCount = uint64(binary.LittleEndian.Uint32(data[96:100]))
+		h.DataOffset = uint64(binary.LittleEndian.Uint32(data[100:104]))
+	}
+	offset := h.baseSize()
+	h.DictID = 0
+	if h.Flags&FlagZstdDict != 0 {
+		h.DictID = binary.LittleEndian.Uint32(data[offset:])
+		offset += DictIDSize
+	}
+	h.Encryption = EncryptionHeader{}
+	if h.Flags&FlagEncrypted != 0 {
+		h.Encryption.unmarshal(data[offset:])
+	}
 
 	return h.Validate()
 }
 
+// ReadPatchHeader 从reader读取补丁文件头，自动识别版本
+func ReadPatchHeader(r io.Reader) (*PatchHeader, error) {
+	data := make([]byte, HeaderSize, Header64Size+DictIDSize+EncryptionHeaderSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	// 64位版本的文件头更长，使用字典或加密时还附带字典ID和加密块，需要读取剩余部分
+	size := headerSize(binary.LittleEndian.Uint16(data[4:6]), data[7])
+	if size > HeaderSize {
+		data = data[:size]
+		if _, err := io.ReadFull(r, data[HeaderSize:]); err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+	}
+
+	header := &PatchHeader{}
+	if err := header.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("parse header: %w", err)
+	}
+	return header, nil
+}
+
 // PatchOperation 补丁操作（序列化格式）
 type PatchOperation struct {
-	Type       uint8  // 操作类型 (0=Copy, 1=Insert, 2=Delete)
+	Type       uint8  // 操作类型 (0=Copy, 1=Insert, 2=Delete, 3=Add)
 	Reserved   uint8  // 保留字段
-	Size       uint32 // 数据大小
+	Size       uint64 // 数据大小
 	Offset     uint64 // 目标偏移量
-	SrcOffset  uint64 // 源偏移量（仅Copy操作使用）
-	DataOffset uint32 // 数据在补丁文件中的偏移量（仅Insert操作使用）
+	SrcOffset  uint64 // 源偏移量（仅Copy和Add操作使用）
+	DataOffset uint64 // 数据在补丁文件中的偏移量（仅Insert和Add操作使用）
 }
 
-// OperationSize 单个操作的序列化大小
-const OperationSize = 26
+const (
+	// OperationSize 单个操作的序列化大小 (1+1+4+8+8+4 = 26字节)
+	OperationSize = 26
+	// Operation64Size 64位版本单个操作的序列化大小 (1+1+8+8+8+8 = 34字节)
+	Operation64Size = 34
+)
+
+// Marshal 按指定补丁版本序列化补丁操作
+func (op *PatchOperation) Marshal(version uint16) []byte {
+	if version == Version64 {
+		buf := make([]byte, Operation64Size)
+		buf[0] = op.Type
+		buf[1] = op.Reserved
+		binary.LittleEndian.PutUint64(buf[2:10], op.Size)
+		binary.LittleEndian.PutUint64(buf[10:18], op.Offset)
+		binary.LittleEndian.PutUint64(buf[18:26], op.SrcOffset)
+		binary.LittleEndian.PutUint64(buf[26:34], op.DataOffset)
+		return buf
+	}
 
-// Marshal 序列化补丁操作
-func (op *PatchOperation) Marshal() []byte {
 	buf := make([]byte, OperationSize)
 
 	buf[0] = op.Type
 	buf[1] = op.Reserved
-	binary.LittleEndian.PutUint32(buf[2:6], op.Size)
+	binary.LittleEndian.PutUint32(buf[2:6], uint32(op.Size))
 	binary.LittleEndian.PutUint64(buf[6:14], op.Offset)
 	binary.LittleEndian.PutUint64(buf[14:22], op.SrcOffset)
-	binary.LittleEndian.PutUint32(buf[22:26], op.DataOffset)
+	binary.LittleEndian.PutUint32(buf[22:26], uint32(op.DataOffset))
 
 	return buf
 }
 
-// Unmarshal 反序列化补丁操作
-func (op *PatchOperation) Unmarshal(data []byte) error {
+// Unmarshal 按指定补丁版本反序列化补丁操作
+func (op *PatchOperation) Unmarshal(data []byte, version uint16) error {
+	if version == Version64 {
+		if len(data) < Operation64Size {
+			return fmt.Errorf("insufficient data for operation: need %d bytes, got %d", Operation64Size, len(data))
+		}
+		op.Type = data[0]
+		op.Reserved = data[1]
+		op.Size = binary.LittleEndian.Uint64(data[2:10])
+		op.Offset = binary.LittleEndian.Uint64(data[10:18])
+		op.SrcOffset = binary.LittleEndian.Uint64(data[18:26])
+		op.DataOffset = binary.LittleEndian.Uint64(data[26:34])
+		return nil
+	}
+
 	if len(data) < OperationSize {
 		return fmt.Errorf("insufficient data for operation: need %d bytes, got %d", OperationSize, len(data))
 	}
 
 	op.Type = data[0]
 	op.Reserved = data[1]
-	op.Size = binary.LittleEndian.Uint32(data[2:6])
+	op.Size = uint64(binary.LittleEndian.Uint32(data[2:6]))
 	op.Offset = binary.LittleEndian.Uint64(data[6:14])
 	op.SrcOffset = binary.LittleEndian.Uint64(data[14:22])
-	op.DataOffset = binary.LittleEndian.Uint32(data[22:26])
+	op.DataOffset = uint64(binary.LittleEndian.Uint32(data[22:26]))
 
 	return nil
 }
@@ -178,31 +299,42 @@ func NewPatchFile() *PatchFile {
 }
 
 // AddInsertData 添加插入数据
-func (pf *PatchFile) AddInsertData(data []byte) uint32 {
-	offset := uint32(len(pf.Data))
+func (pf *PatchFile) AddInsertData(data []byte) uint64 {
+	offset := uint64(len(pf.Data))
 	pf.Data = append(pf.Data, data...)
 	return offset
 }
 
 // GetInsertData 获取插入数据
-func (pf *PatchFile) GetInsertData(offset, size uint32) ([]byte, error) {
-	if offset+size > uint32(len(pf.Data)) {
+func (pf *PatchFile) GetInsertData(offset, size uint64) ([]byte, error) {
+	if offset > uint64(len(pf.Data)) || size > uint64(len(pf.Data))-offset {
 		return nil, fmt.Errorf("data range out of bounds: offset=%d, size=%d, total=%d",
 			offset, size, len(pf.Data))
 	}
 	return pf.Data[offset : offset+size], nil
 }
 
-// CalculateSize 计算补丁文件总大小
+// CalculateSize 计算补丁文件总大小（基于UpdateHeader确定的布局）
 func (pf *PatchFile) CalculateSize() int64 {
-	size := int64(HeaderSize)                                // 文件头
-	size += int64(len(pf.Operations)) * int64(OperationSize) // 操作列表
-	size += int64(len(pf.Data))                              // 数据区
-	return size
+	return int64(pf.Header.DataOffset) + int64(len(pf.Data)) // 文件头、操作列表和数据区
 }
 
-// UpdateHeader 更新文件头信息
+// UpdateHeader 更新文件头信息，并根据数据规模选择补丁版本和操作编码
 func (pf *PatchFile) UpdateHeader() {
-	pf.Header.OperationCount = uint32(len(pf.Operations))
-	pf.Header.DataOffset = uint32(HeaderSize + len(pf.Operations)*OperationSize)
+	pf.Header.layoutOperations(pf.Operations, uint64(len(pf.Data)))
+}
+
+// requiredVersion 返回能表示给定操作和数据的最低补丁版本
+// 所有大小和偏移量都在32位范围内时使用版本1，以兼容旧版本的读取器
+func requiredVersion(operations []PatchOperation, dataSize uint64) uint16 {
+	dataOffset := uint64(HeaderSize) + uint64(len(operations))*OperationSize
+	if dataSize > math.MaxUint32 || dataOffset > math.MaxUint32 {
+		return Version64
+	}
+	for i := range operations {
+		if operations[i].Size > math.MaxUint32 || operations[i].DataOffset > math.MaxUint32 {
+			return Version64
+		}
+	}
+	return Version
 }
@@ -0,0 +1,184 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+func TestPatchHeaderVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint16
+		size    int
+		opCount uint64
+	}{
+		{"version 1", Version, HeaderSize, 12},
+		{"version 64", Version64, Header64Size, math.MaxUint32 + 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := NewPatchHeader()
+			original.Version = tt.version
+			original.SourceSize = 5 << 30
+			original.TargetSize = 6 << 30
+			original.OperationCount = tt.opCount
+			original.DataOffset = uint64(original.Size()) + tt.opCount*uint64(original.OperationSize())
+
+			data := original.Marshal()
+			if len(data) != tt.size {
+				t.Fatalf("Marshal() returned %d bytes, want %d", len(data), tt.size)
+			}
+
+			parsed, err := ReadPatchHeader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("ReadPatchHeader() error = %v", err)
+			}
+			if *parsed != *original {
+				t.Errorf("ReadPatchHeader() = %+v, want %+v", parsed, original)
+			}
+		})
+	}
+}
+
+func TestPatchHeaderValidateRejectsUnknownVersion(t *testing.T) {
+	header := NewPatchHeader()
+	header.Version = 2
+	if err := header.Validate(); err == nil {
+		t.Error("expected error for version 2")
+	}
+}
+
+func TestPatchOperationVersion64(t *testing.T) {
+	original := PatchOperation{
+		Type:       1,
+		Size:       math.MaxUint32 + 10,
+		Offset:     1 << 40,
+		DataOffset: math.MaxUint32 + 20,
+	}
+
+	data := original.Marshal(Version64)
+	if len(data) != Operation64Size {
+		t.Fatalf("Marshal() returned %d bytes, want %d", len(data), Operation64Size)
+	}
+
+	var parsed PatchOperation
+	if err := parsed.Unmarshal(data, Version64); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if parsed != original {
+		t.Errorf("Unmarshal() = %+v, want %+v", parsed, original)
+	}
+}
+
+func TestUpdateHeaderSelectsVersion(t *testing.T) {
+	// 目标偏移量不连续，无法使用紧凑编码，操作列表为定长格式
+	patchFile := NewPatchFile()
+	patchFile.Operations = append(patchFile.Operations, PatchOperation{Type: 0, Size: 1024, Offset: 1})
+	patchFile.UpdateHeader()
+	if patchFile.Header.Version != Version {
+		t.Errorf("Version = %d, want %d", patchFile.Header.Version, Version)
+	}
+
+	// 超过4GiB的复制操作需要64位格式
+	patchFile.Operations = append(patchFile.Operations, PatchOperation{Type: 0, Size: math.MaxUint32 + 1, Offset: 1})
+	patchFile.UpdateHeader()
+	if patchFile.Header.Version != Version64 {
+		t.Errorf("Version = %d, want %d", patchFile.Header.Version, Version64)
+	}
+	if want := uint64(Header64Size + 2*Operation64Size); patchFile.Header.DataOffset != want {
+		t.Errorf("DataOffset = %d, want %d", patchFile.Header.DataOffset, want)
+	}
+}
+
+func TestApplyVersion64Patch(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourcePath := filepath.Join(tmpDir, "source.bin")
+	targetPath := filepath.Join(tmpDir, "target.bin")
+
+	sourceData := []byte("0123456789abcdef")
+	if err := os.WriteFile(sourcePath, sourceData, 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	// 数据量很小，但强制使用64位格式写出
+	patchFile := NewPatchFile()
+	patchFile.Operations = append(patchFile.Operations,
+		PatchOperation{Type: 0, Size: 10, Offset: 0, SrcOffset: 0},
+		PatchOperation{Type: 1, Size: 3, Offset: 10, DataOffset: patchFile.AddInsertData([]byte("XYZ"))},
+		PatchOperation{Type: 0, Size: 6, Offset: 13, SrcOffset: 10},
+	)
+	patchFile.Header.SourceSize = int64(len(sourceData))
+	patchFile.Header.TargetSize = 19
+	patchFile.Header.Version = Version64
+	patchFile.Header.Compression = CompressionGzip
+
+	var buf bytes.Buffer
+	if err := NewSerializer(CompressionGzip).writePatch(&buf, patchFile); err != nil {
+		t.Fatalf("writePatch() error = %v", err)
+	}
+
+	if patchFile.Header.Version != Version64 {
+		t.Fatalf("Version = %d, want %d", patchFile.Header.Version, Version64)
+	}
+
+	applier := NewApplier(&ApplierConfig{BufferSize: 4, TempDir: tmpDir})
+	if err := applier.ApplyDelta(sourcePath, buf.Bytes(), targetPath); err != nil {
+		t.Fatalf("ApplyDelta() error = %v", err)
+	}
+
+	got, err := os.ReadFile(targetPath)
+	if err != nil {
+		t.Fatalf("read target: %v", err)
+	}
+	if want := "0123456789XYZabcdef"; string(got) != want {
+		t.Errorf("target = %q, want %q", got, want)
+	}
+}
+
+func TestApplyAddOperationPatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourcePath := filepath.Join(tmpDir, "source.bin")
+	targetPath := filepath.Join(tmpDir, "target.bin")
+
+	sourceData := bytes.Repeat([]byte("call 0x00401000; mov rax, rbx; "), 64)
+	targetData := bytes.ReplaceAll(sourceData, []byte("0x00401000"), []byte("0x00401040"))
+	if err := os.WriteFile(sourcePath, sourceData, 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	delta := diff.DiffBytes(sourceData, targetData)
+	delta.SetChecksum(targetData)
+
+	hasAdd := false
+	for _, op := range delta.Operations {
+		hasAdd = hasAdd || op.Type == diff.OpAdd
+	}
+	if !hasAdd {
+		t.Fatal("expected add operations in delta")
+	}
+
+	var buf bytes.Buffer
+	if err := NewSerializer(CompressionGzip).SerializeDeltaTo(delta, sha256.Sum256(sourceData), &buf); err != nil {
+		t.Fatalf("SerializeDeltaTo() error = %v", err)
+	}
+
+	applier := NewApplier(&ApplierConfig{BufferSize: 16, TempDir: tmpDir, VerifyTarget: true})
+	if err := applier.ApplyDelta(sourcePath, buf.Bytes(), targetPath); err != nil {
+		t.Fatalf("ApplyDelta() error = %v", err)
+	}
+
+	got, err := os.ReadFile(targetPath)
+	if err != nil {
+		t.Fatalf("read target: %v", err)
+	}
+	if !bytes.Equal(got, targetData) {
+		t.Error("target does not match")
+	}
+}
@@ -13,6 +13,7 @@ import (
 type Generator struct {
 	engine     *diff.Engine
 	serializer *Serializer
+	inPlace    bool
 }
 
 // NewGenerator 创建新的补丁生成器
@@ -23,6 +24,31 @@ func NewGenerator(engine *diff.Engine, compression CompressionType) *Generator {
 	}
 }
 
+// SetCompressionLevel 设置压缩级别，0表示使用算法的默认级别
+func (g *Generator) SetCompressionLevel(level CompressionLevel) {
+	g.serializer.SetLevel(level)
+}
+
+// SetCompressOperations 设置是否同时压缩操作列表
+func (g *Generator) SetCompressOperations(enabled bool) {
+	g.serializer.SetCompressOperations(enabled)
+}
+
+// SetDictionary 设置Zstd压缩使用的字典
+func (g *Generator) SetDictionary(dict []byte) error {
+	return g.serializer.SetDictionary(dict)
+}
+
+// SetEncryption 设置加密补丁使用的密钥，为nil时不加密
+func (g *Generator) SetEncryption(key *EncryptionKey) {
+	g.serializer.SetEncryption(key)
+}
+
+// SetInPlace 设置是否生成可以直接在源文件上应用的原地补丁，见MakeInPlace
+func (g *Generator) SetInPlace(enabled bool) {
+	g.inPlace = enabled
+}
+
 // GeneratePatch 生成补丁文件
 func (g *Generator) GeneratePatch(oldFilePath, newFilePath, patchPath string) (*PatchInfo, error) {
 	// 生成差异
@@ -38,7 +64,7 @@ func (g *Generator) GeneratePatch(oldFilePath, newFilePath, patchPath string) (*
 	}
 
 	// 序列化补丁
-	if err := g.serializer.SerializeDelta(delta, sourceChecksum, patchPath); err != nil {
+	if err := g.serialize(delta, sourceChecksum, oldFilePath, patchPath); err != nil {
 		return nil, fmt.Errorf("serialize patch: %w", err)
 	}
 
@@ -51,6 +77,45 @@ func (g *Generator) GeneratePatch(oldFilePath, newFilePath, patchPath string) (*
 	return patchInfo, nil
 }
 
+// GeneratePatchFromSignature 根据旧文件的签名生成补丁（旧文件可以不在本机）
+func (g *Generator) GeneratePatchFromSignature(signature *diff.Signature, newFilePath, patchPath string) (*PatchInfo, error) {
+	delta, err := g.engine.GenerateDeltaFromSignature(signature, newFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("generate delta: %w", err)
+	}
+
+	// 签名中记录的整个文件校验和即为源文件校验和
+	if err := g.serialize(delta, signature.Checksum, "", patchPath); err != nil {
+		return nil, fmt.Errorf("serialize patch: %w", err)
+	}
+
+	newStat, err := os.Stat(newFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("get patch info: %w", err)
+	}
+	patchStat, err := os.Stat(patchPath)
+	if err != nil {
+		return nil, fmt.Errorf("get patch info: %w", err)
+	}
+	header, err := GetPatchInfo(patchPath)
+	if err != nil {
+		return nil, fmt.Errorf("get patch info: %w", err)
+	}
+
+	return &PatchInfo{
+		PatchPath:      patchPath,
+		NewFilePath:    newFilePath,
+		OldFileSize:    signature.FileSize,
+		NewFileSize:    newStat.Size(),
+		PatchFileSize:  patchStat.Size(),
+		OperationCount: int(header.OperationCount),
+		Compression:    header.Compression,
+		CreatedAt:      header.Timestamp,
+		SourceChecksum: header.SourceChecksum,
+		TargetChecksum: header.TargetChecksum,
+	}, nil
+}
+
 // GeneratePatchWithMmap 使用内存映射生成补丁（适用于大文件）
 func (g *Generator) GeneratePatchWithMmap(oldFilePath, newFilePath, patchPath string) (*PatchInfo, error) {
 	// 使用内存映射打开文件
@@ -80,7 +145,7 @@ func (g *Generator) GeneratePatchWithMmap(oldFilePath, newFilePath, patchPath st
 	sourceChecksum := sha256.Sum256(oldFile.Data())
 
 	// 序列化补丁
-	if err := g.serializer.SerializeDelta(delta, sourceChecksum, patchPath); err != nil {
+	if err := g.serialize(delta, sourceChecksum, oldFilePath, patchPath); err != nil {
 		return nil, fmt.Errorf("serialize patch: %w", err)
 	}
 
@@ -93,6 +158,30 @@ func (g *Generator) GeneratePatchWithMmap(oldFilePath, newFilePath, patchPath st
 	return patchInfo, nil
 }
 
+// serialize 序列化补丁，生成原地补丁时从旧文件读取打破依赖环所需的数据
+func (g *Generator) serialize(delta *diff.Delta, sourceChecksum [32]byte, oldFilePath, patchPath string) error {
+	if !g.inPlace {
+		return g.serializer.SerializeDelta(delta, sourceChecksum, patchPath)
+	}
+	if oldFilePath == "" {
+		return fmt.Errorf("in-place patch requires the old file")
+	}
+
+	patchFile, err := g.serializer.buildPatchFile(delta, sourceChecksum)
+	if err != nil {
+		return err
+	}
+	source, err := os.Open(oldFilePath)
+	if err != nil {
+		return fmt.Errorf("open old file: %w", err)
+	}
+	defer source.Close()
+	if err := MakeInPlace(patchFile, source); err != nil {
+		return fmt.Errorf("make in-place: %w", err)
+	}
+	return g.serializer.SerializePatch(patchFile, patchPath)
+}
+
 // calculateFileChecksum 计算文件校验和
 func (g *Generator) calculateFileChecksum(filePath string) ([32]byte, error) {
 	file, err := os.Open(filePath)
@@ -0,0 +1,384 @@
+package patch
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	inPlaceJournalSuffix = ".hexdiff-inplace" // 原地应用日志文件后缀
+
+	// DefaultInPlaceInterval 原地应用时默认每写入8MB提交一次日志
+	DefaultInPlaceInterval = 8 * 1024 * 1024
+)
+
+// ErrNotInPlace 补丁没有按原地应用的依赖顺序生成
+var ErrNotInPlace = errors.New("patch is not in-place safe")
+
+// MakeInPlace 将补丁的操作改写为可以直接在源文件上应用的顺序，并设置FlagInPlace
+//
+// 读取某段源数据的操作排在覆盖这段数据的操作之前。依赖成环时，将环中最小的复制或相加操作
+// 改写为插入，其数据从source读取，补丁因此会变大。目标中没有操作写入的区域补充为零字节插入。
+func MakeInPlace(patchFile *PatchFile, source io.ReaderAt) error {
+	segments, err := targetSegments(patchFile)
+	if err != nil {
+		return err
+	}
+
+	// 源文件中未被覆盖的区域在原地应用后会残留旧数据，需要显式写入零字节
+	var ops []PatchOperation
+	var pos uint64
+	for _, op := range append(segments, PatchOperation{Offset: uint64(patchFile.Header.TargetSize)}) {
+		if op.Offset > pos {
+			gap := PatchOperation{Type: 1, Offset: pos, Size: op.Offset - pos}
+			gap.DataOffset = patchFile.AddInsertData(make([]byte, gap.Size))
+			ops = append(ops, gap)
+		}
+		if op.Size > 0 {
+			ops = append(ops, op)
+		}
+		pos = max(pos, op.Offset+op.Size)
+	}
+
+	// before[i]中的操作写入的区域被操作i读取，必须在操作i之后执行
+	before := make([][]int, len(ops))
+	pending := make([]int, len(ops))
+	for i, op := range ops {
+		if op.Type != 0 && op.Type != 3 {
+			continue
+		}
+		end := op.SrcOffset + op.Size
+		j := sort.Search(len(ops), func(j int) bool { return ops[j].Offset+ops[j].Size > op.SrcOffset })
+		for ; j < len(ops) && ops[j].Offset < end; j++ {
+			if j != i {
+				before[i] = append(before[i], j)
+				pending[j]++
+			}
+		}
+	}
+
+	ordered := make([]PatchOperation, 0, len(ops))
+	done := make([]bool, len(ops))
+	var ready []int
+	for i := range ops {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	release := func(i int) {
+		for _, j := range before[i] {
+			if pending[j]--; pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+		before[i] = nil
+	}
+
+	for len(ordered) < len(ops) {
+		if len(ready) == 0 {
+			// 剩余的操作相互依赖，改写最小的读取操作以打破环
+			victim := -1
+			for i := range ops {
+				if !done[i] && len(before[i]) > 0 && (victim < 0 || ops[i].Size < ops[victim].Size) {
+					victim = i
+				}
+			}
+			if err := literalizeOperation(patchFile, &ops[victim], source); err != nil {
+				return fmt.Errorf("break dependency cycle: %w", err)
+			}
+			release(victim)
+			continue
+		}
+
+		i := ready[0]
+		ready = ready[1:]
+		done[i] = true
+		ordered = append(ordered, ops[i])
+		release(i)
+	}
+
+	patchFile.Operations = ordered
+	patchFile.Header.Flags |= FlagInPlace
+	patchFile.UpdateHeader()
+	return nil
+}
+
+// literalizeOperation 将复制或相加操作改写为插入其结果的操作
+func literalizeOperation(patchFile *PatchFile, op *PatchOperation, source io.ReaderAt) error {
+	data := make([]byte, op.Size)
+	if _, err := source.ReadAt(data, int64(op.SrcOffset)); err != nil {
+		return fmt.Errorf("read from source: %w", err)
+	}
+	if op.Type == 3 {
+		diff, err := patchFile.GetInsertData(op.DataOffset, op.Size)
+		if err != nil {
+			return err
+		}
+		data = addBytes(data, diff)
+	}
+	*op = PatchOperation{Type: 1, Offset: op.Offset, Size: op.Size, DataOffset: patchFile.AddInsertData(data)}
+	return nil
+}
+
+// inPlaceUndo 提交日志之前被覆盖的原始数据
+type inPlaceUndo struct {
+	Offset int64  `json:"offset"`
+	Data   []byte `json:"data"`
+}
+
+// inPlaceJournal 原地应用日志
+//
+// 每批写入之前先保存这批写入将覆盖的原始数据，写入并落盘后再清空。
+// 中断后先用这些数据把文件恢复到这批写入之前的状态，再从记录的位置继续。
+type inPlaceJournal struct {
+	PatchChecksum string        `json:"patch_checksum"`
+	Operation     int           `json:"operation"` // 下一个要执行的操作索引
+	Done          uint64        `json:"done"`      // 该操作已完成的字节数
+	Size          int64         `json:"size"`      // 这批写入之前的文件大小
+	Undo          []inPlaceUndo `json:"undo,omitempty"`
+}
+
+// InPlaceJournalPath 返回文件对应的原地应用日志
+func InPlaceJournalPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+inPlaceJournalSuffix)
+}
+
+// HasInPlaceJournal 文件是否有未完成的原地应用
+func HasInPlaceJournal(filePath string) bool {
+	_, err := os.Stat(InPlaceJournalPath(filePath))
+	return err == nil
+}
+
+// ApplyInPlace 直接在文件上应用原地补丁，不创建完整的临时副本
+//
+// 应用过程按批提交日志，中断后再次调用会先撤销未提交的写入，再从日志记录的位置继续。
+// 原地应用不创建备份，目标校验失败时文件已被改写，需要从其他来源恢复。
+func (a *Applier) ApplyInPlace(filePath, patchFilePath string) (*ApplyResult, error) {
+	if err := a.validateInputFiles(filePath, patchFilePath); err != nil {
+		return nil, fmt.Errorf("validate input files: %w", err)
+	}
+
+	patchFile, err := NewSerializer(CompressionNone).DeserializePatch(patchFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("deserialize patch: %w", err)
+	}
+	if patchFile.Header.Flags&FlagInPlace == 0 {
+		return nil, ErrNotInPlace
+	}
+	checksum, err := calculateFileChecksum(patchFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("calculate patch checksum: %w", err)
+	}
+	patchChecksum := hex.EncodeToString(checksum[:])
+
+	journalPath := InPlaceJournalPath(filePath)
+	journal, err := loadInPlaceJournal(journalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	if journal == nil {
+		// 第一次应用时文件必须是补丁的源文件
+		if err := a.verifySourceFile(filePath, patchFile.Header.SourceChecksum); err != nil {
+			return nil, fmt.Errorf("verify source file: %w", err)
+		}
+		journal = &inPlaceJournal{PatchChecksum: patchChecksum, Size: patchFile.Header.SourceSize}
+		if err := writeFileSync(journalPath, journal); err != nil {
+			return nil, err
+		}
+	} else {
+		if journal.PatchChecksum != patchChecksum {
+			return nil, fmt.Errorf("unfinished in-place apply of another patch: %s", journalPath)
+		}
+		if err := journal.rollback(file); err != nil {
+			return nil, fmt.Errorf("roll back uncommitted writes: %w", err)
+		}
+	}
+
+	result := &ApplyResult{
+		SourceFilePath: filePath,
+		PatchFilePath:  patchFilePath,
+	}
+	interval := a.config.CheckpointInterval
+	if interval <= 0 {
+		interval = DefaultInPlaceInterval
+	}
+	for journal.Operation < len(patchFile.Operations) {
+		if err := a.applyInPlaceBatch(file, patchFile, journal, uint64(interval), journalPath, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := file.Truncate(patchFile.Header.TargetSize); err != nil {
+		return nil, fmt.Errorf("truncate file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("sync file: %w", err)
+	}
+
+	if a.config.VerifyTarget && !emptyChecksum(patchFile.Header.TargetChecksum) {
+		if err := a.verifyTargetFile(filePath, patchFile.Header.TargetChecksum); err != nil {
+			os.Remove(journalPath)
+			return nil, fmt.Errorf("verify target file: %w", err)
+		}
+	}
+	if err := os.Remove(journalPath); err != nil {
+		return nil, fmt.Errorf("remove journal: %w", err)
+	}
+
+	result.TargetFilePath = filePath
+	result.OperationsApplied = len(patchFile.Operations)
+	result.Success = true
+	return result, nil
+}
+
+// applyInPlaceBatch 执行从日志位置开始、约interval字节的一批写入，并提交日志
+func (a *Applier) applyInPlaceBatch(file *os.File, patchFile *PatchFile, journal *inPlaceJournal, interval uint64, journalPath string, result *ApplyResult) error {
+	chunks, opIndex, done, err := a.beginInPlaceBatch(file, patchFile, journal, interval, journalPath)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if err := a.writeInPlaceChunk(file, &chunk, patchFile.Data); err != nil {
+			return fmt.Errorf("apply operation %d: %w", journal.Operation, err)
+		}
+		result.BytesProcessed += int64(chunk.Size)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync file: %w", err)
+	}
+
+	journal.Operation, journal.Done, journal.Undo = opIndex, done, nil
+	return writeFileSync(journalPath, journal)
+}
+
+// beginInPlaceBatch 将下一批操作切分为不超过缓冲区大小的片段，并在日志中保存这些片段将覆盖的原始数据
+//
+// 返回这批片段以及这批写入完成后的操作索引和已完成字节数。
+func (a *Applier) beginInPlaceBatch(file *os.File, patchFile *PatchFile, journal *inPlaceJournal, interval uint64, journalPath string) ([]PatchOperation, int, uint64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("stat file: %w", err)
+	}
+	size := info.Size()
+
+	var chunks []PatchOperation
+	var total uint64
+	opIndex, done := journal.Operation, journal.Done
+	for opIndex < len(patchFile.Operations) && total < interval {
+		op := patchFile.Operations[opIndex]
+		if op.Type == 2 || op.Size == 0 {
+			opIndex++
+			continue
+		}
+		n := min(op.Size-done, uint64(a.config.BufferSize), interval-total)
+		chunks = append(chunks, inPlaceChunk(op, done, n))
+		total += n
+		if done += n; done == op.Size {
+			opIndex, done = opIndex+1, 0
+		}
+	}
+
+	journal.Size = size
+	journal.Undo = nil
+	for _, chunk := range chunks {
+		start := int64(chunk.Offset)
+		end := min(start+int64(chunk.Size), size)
+		if start >= end {
+			continue
+		}
+		data := make([]byte, end-start)
+		if _, err := file.ReadAt(data, start); err != nil {
+			return nil, 0, 0, fmt.Errorf("read original data: %w", err)
+		}
+		journal.Undo = append(journal.Undo, inPlaceUndo{Offset: start, Data: data})
+	}
+	if err := writeFileSync(journalPath, journal); err != nil {
+		return nil, 0, 0, err
+	}
+	return chunks, opIndex, done, nil
+}
+
+// inPlaceChunk 返回操作中已完成done字节之后的n字节
+//
+// 读取区域在写入区域之前且两者重叠时，从末尾向前处理，避免覆盖尚未读取的数据。
+func inPlaceChunk(op PatchOperation, done, n uint64) PatchOperation {
+	if (op.Type == 0 || op.Type == 3) && op.SrcOffset < op.Offset && op.Offset < op.SrcOffset+op.Size {
+		return sliceOperation(op, op.Size-done-n, n)
+	}
+	return sliceOperation(op, done, n)
+}
+
+// writeInPlaceChunk 读取片段的全部输入后再写入文件
+func (a *Applier) writeInPlaceChunk(file *os.File, op *PatchOperation, patchData []byte) error {
+	var data []byte
+	if op.Type == 1 || op.Type == 3 {
+		if op.DataOffset > uint64(len(patchData)) || op.Size > uint64(len(patchData))-op.DataOffset {
+			return fmt.Errorf("insert data out of bounds: offset=%d, size=%d, total=%d",
+				op.DataOffset, op.Size, len(patchData))
+		}
+		data = patchData[op.DataOffset : op.DataOffset+op.Size]
+	}
+
+	switch op.Type {
+	case 0, 3: // Copy, Add
+		buffer := make([]byte, op.Size)
+		if _, err := file.ReadAt(buffer, int64(op.SrcOffset)); err != nil {
+			return fmt.Errorf("read from source: %w", err)
+		}
+		data = addBytes(buffer, data)
+	case 1: // Insert
+	default:
+		return fmt.Errorf("unknown operation type: %d", op.Type)
+	}
+
+	if _, err := file.WriteAt(data, int64(op.Offset)); err != nil {
+		return fmt.Errorf("write to target: %w", err)
+	}
+	return nil
+}
+
+// rollback 撤销上次中断时未提交的写入
+func (j *inPlaceJournal) rollback(file *os.File) error {
+	if len(j.Undo) == 0 {
+		return nil
+	}
+	for _, undo := range j.Undo {
+		if _, err := file.WriteAt(undo.Data, undo.Offset); err != nil {
+			return err
+		}
+	}
+	if err := file.Truncate(j.Size); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// loadInPlaceJournal 读取原地应用日志，不存在时返回nil
+func loadInPlaceJournal(path string) (*inPlaceJournal, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	var journal inPlaceJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("parse journal %s: %w", path, err)
+	}
+	return &journal, nil
+}
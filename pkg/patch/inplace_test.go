@@ -0,0 +1,179 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// inPlacePatch 生成原地补丁，新文件在开头插入数据并交换了旧文件的前后两半，复制操作之间存在依赖环
+func inPlacePatch(t *testing.T, dir string) (oldData, newData []byte, patchPath string) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(22))
+	oldData = make([]byte, 192*1024)
+	rng.Read(oldData)
+	half := len(oldData) / 2
+	newData = bytes.Join([][]byte{[]byte("header inserted at the start"), oldData[half:], oldData[:half]}, nil)
+	newData = append(newData[:len(newData)-1000:len(newData)-1000], bytes.Repeat([]byte{0x5a}, 300)...)
+
+	oldFile := filepath.Join(dir, "old.bin")
+	newFile := filepath.Join(dir, "new.bin")
+	os.WriteFile(oldFile, oldData, 0644)
+	os.WriteFile(newFile, newData, 0644)
+
+	config := diff.DefaultDiffConfig()
+	config.BlockSize = 1024
+	engine, err := diff.NewEngine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := NewGenerator(engine, CompressionZstd)
+	generator.SetInPlace(true)
+	patchPath = filepath.Join(dir, "inplace.patch")
+	if _, err := generator.GeneratePatch(oldFile, newFile, patchPath); err != nil {
+		t.Fatalf("GeneratePatch() error = %v", err)
+	}
+	return oldData, newData, patchPath
+}
+
+func TestApplyInPlace(t *testing.T) {
+	dir := t.TempDir()
+	oldData, newData, patchPath := inPlacePatch(t, dir)
+
+	header, err := GetPatchInfo(patchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Flags&FlagInPlace == 0 {
+		t.Fatal("FlagInPlace not set")
+	}
+
+	// 原地补丁仍可以按普通方式应用
+	oldFile := filepath.Join(dir, "old.bin")
+	output := filepath.Join(dir, "out.bin")
+	if _, err := NewApplier(nil).ApplyPatch(oldFile, patchPath, output); err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+		t.Error("ApplyPatch() output mismatch")
+	}
+
+	config := DefaultApplierConfig()
+	config.BufferSize = 4 * 1024
+	config.CheckpointInterval = 16 * 1024
+	target := filepath.Join(dir, "device.bin")
+	os.WriteFile(target, oldData, 0644)
+	if _, err := NewApplier(config).ApplyInPlace(target, patchPath); err != nil {
+		t.Fatalf("ApplyInPlace() error = %v", err)
+	}
+	if got, _ := os.ReadFile(target); !bytes.Equal(got, newData) {
+		t.Error("ApplyInPlace() output mismatch")
+	}
+	if HasInPlaceJournal(target) {
+		t.Error("journal left behind")
+	}
+
+	// 普通补丁不能原地应用
+	plainPatch := filepath.Join(dir, "plain.patch")
+	engine, _ := diff.NewEngine(nil)
+	if _, err := NewGenerator(engine, CompressionNone).GeneratePatch(oldFile, output, plainPatch); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(target, oldData, 0644)
+	if _, err := NewApplier(nil).ApplyInPlace(target, plainPatch); !errors.Is(err, ErrNotInPlace) {
+		t.Errorf("ApplyInPlace() of plain patch error = %v, want ErrNotInPlace", err)
+	}
+}
+
+func TestMakeInPlaceOverlappingCopy(t *testing.T) {
+	dir := t.TempDir()
+	oldData := compressibleText(3000, "shift")
+	for _, newData := range [][]byte{
+		append([]byte("grown by a short prefix"), oldData...), // 读取区域在写入区域之前，需要从末尾向前复制
+		oldData[1000:], // 读取区域在写入区域之后
+	} {
+		delta := diff.DiffBytes(oldData, newData)
+		delta.SetChecksum(newData)
+		patchFile, err := NewSerializer(CompressionNone).buildPatchFile(delta, sha256.Sum256(oldData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := MakeInPlace(patchFile, bytes.NewReader(oldData)); err != nil {
+			t.Fatalf("MakeInPlace() error = %v", err)
+		}
+		patchPath := filepath.Join(dir, "shift.patch")
+		if err := NewSerializer(CompressionGzip).SerializePatch(patchFile, patchPath); err != nil {
+			t.Fatal(err)
+		}
+
+		config := DefaultApplierConfig()
+		config.BufferSize = 1024
+		target := filepath.Join(dir, "device.bin")
+		os.WriteFile(target, oldData, 0644)
+		if _, err := NewApplier(config).ApplyInPlace(target, patchPath); err != nil {
+			t.Fatalf("ApplyInPlace() error = %v", err)
+		}
+		if got, _ := os.ReadFile(target); !bytes.Equal(got, newData) {
+			t.Errorf("ApplyInPlace() output mismatch for target size %d", len(newData))
+		}
+	}
+}
+
+func TestApplyInPlaceRecoversFromCrash(t *testing.T) {
+	dir := t.TempDir()
+	oldData, newData, patchPath := inPlacePatch(t, dir)
+
+	config := DefaultApplierConfig()
+	config.BufferSize = 4 * 1024
+	config.CheckpointInterval = 16 * 1024
+	applier := NewApplier(config)
+
+	for _, batches := range []int{0, 3, 7} {
+		target := filepath.Join(dir, "device.bin")
+		os.WriteFile(target, oldData, 0644)
+
+		// 提交若干批后开始下一批，写入一部分后中断，中断的写入留下损坏的数据
+		patchFile, err := NewSerializer(CompressionNone).DeserializePatch(patchPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checksum, _ := calculateFileChecksum(patchPath)
+		journalPath := InPlaceJournalPath(target)
+		journal := &inPlaceJournal{PatchChecksum: hex.EncodeToString(checksum[:]), Size: int64(len(oldData))}
+		file, err := os.OpenFile(target, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range batches {
+			if err := applier.applyInPlaceBatch(file, patchFile, journal, 16*1024, journalPath, &ApplyResult{}); err != nil {
+				t.Fatalf("applyInPlaceBatch() error = %v", err)
+			}
+		}
+		chunks, _, _, err := applier.beginInPlaceBatch(file, patchFile, journal, 16*1024, journalPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, chunk := range chunks[:len(chunks)/2] {
+			applier.writeInPlaceChunk(file, &chunk, patchFile.Data)
+		}
+		file.WriteAt(bytes.Repeat([]byte{0xff}, 512), int64(chunks[len(chunks)/2].Offset))
+		file.Close()
+
+		if _, err := applier.ApplyInPlace(target, patchPath); err != nil {
+			t.Fatalf("batches=%d: ApplyInPlace() error = %v", batches, err)
+		}
+		if got, _ := os.ReadFile(target); !bytes.Equal(got, newData) {
+			t.Errorf("batches=%d: output mismatch after recovery", batches)
+		}
+		if HasInPlaceJournal(target) {
+			t.Errorf("batches=%d: journal left behind", batches)
+		}
+	}
+}
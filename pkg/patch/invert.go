@@ -0,0 +1,540 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// ErrNotReversible 目录补丁中没有嵌入反向条目
+var ErrNotReversible = errors.New("patch has no reverse data")
+
+// Invert 由旧文件→新文件的补丁和旧文件sourceFile求新文件→旧文件的补丁，不需要重新比较两个文件
+//
+// 被新文件复制或相加的旧数据改写为从新文件复制或相减，没有被新文件引用的旧数据保存为插入数据。
+// 补丁记录了源文件校验和时先校验sourceFile。
+func Invert(patchFile *PatchFile, sourceFile io.ReaderAt) (*PatchFile, error) {
+	header := patchFile.Header
+	if !emptyChecksum(header.SourceChecksum) {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(sourceFile, 0, header.SourceSize)); err != nil {
+			return nil, fmt.Errorf("hash source file: %w", err)
+		}
+		if checksum := [32]byte(hash.Sum(nil)); checksum != header.SourceChecksum {
+			return nil, fmt.Errorf("source file checksum mismatch: expected %x, got %x", header.SourceChecksum, checksum)
+		}
+	}
+
+	inverse, err := invertRange(patchFile, sourceFile, 0, uint64(header.SourceSize))
+	if err != nil {
+		return nil, err
+	}
+	inverse.Header.SourceChecksum = header.TargetChecksum
+	inverse.Header.TargetChecksum = header.SourceChecksum
+	return inverse, nil
+}
+
+// invertRange 求新文件→源文件[start, end)区间的补丁，生成的偏移量相对于start
+func invertRange(patchFile *PatchFile, source io.ReaderAt, start, end uint64) (*PatchFile, error) {
+	sourceSize, targetSize := uint64(patchFile.Header.SourceSize), uint64(patchFile.Header.TargetSize)
+	var segments []PatchOperation
+	for _, op := range patchFile.Operations {
+		if (op.Type != 0 && op.Type != 3) || op.Size == 0 {
+			continue
+		}
+		if op.SrcOffset+op.Size > sourceSize || op.Offset+op.Size > targetSize {
+			return nil, fmt.Errorf("operation out of bounds: offset=%d, source offset=%d, size=%d", op.Offset, op.SrcOffset, op.Size)
+		}
+		if op.SrcOffset < end && op.SrcOffset+op.Size > start {
+			segments = append(segments, op)
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].SrcOffset < segments[j].SrcOffset })
+
+	c := &composer{result: NewPatchFile()}
+	var best *PatchOperation
+	pos, j := start, 0
+	for pos < end {
+		// 同一段旧数据被多个操作引用时使用覆盖最远的一个
+		for ; j < len(segments) && segments[j].SrcOffset <= pos; j++ {
+			if best == nil || segments[j].SrcOffset+segments[j].Size > best.SrcOffset+best.Size {
+				best = &segments[j]
+			}
+		}
+
+		if best == nil || best.SrcOffset+best.Size <= pos {
+			next := end
+			if j < len(segments) {
+				next = min(next, segments[j].SrcOffset)
+			}
+			data := make([]byte, next-pos)
+			if _, err := io.ReadFull(io.NewSectionReader(source, int64(pos), int64(len(data))), data); err != nil {
+				return nil, fmt.Errorf("read source at %d: %w", pos, err)
+			}
+			c.insert(pos-start, data)
+			pos = next
+			continue
+		}
+
+		inner := pos - best.SrcOffset
+		n := min(end, best.SrcOffset+best.Size) - pos
+		if best.Type == 0 {
+			c.copy(pos-start, best.Offset+inner, n)
+		} else {
+			diff, err := patchFile.GetInsertData(best.DataOffset+inner, n)
+			if err != nil {
+				return nil, err
+			}
+			c.add(pos-start, best.Offset+inner, negateBytes(diff))
+		}
+		pos += n
+	}
+
+	header := c.result.Header
+	header.SourceSize = patchFile.Header.TargetSize
+	header.TargetSize = int64(end - start)
+	c.result.UpdateHeader()
+	return c.result, nil
+}
+
+// negateBytes 返回逐字节取反的差值，相加操作由新数据减去差值得到旧数据
+func negateBytes(diff []byte) []byte {
+	negated := make([]byte, len(diff))
+	for i, b := range diff {
+		negated[i] = -b
+	}
+	return negated
+}
+
+// InvertDirPatch 由旧目录→新目录的补丁和旧目录生成新目录→旧目录的补丁
+//
+// 补丁嵌入了反向条目时直接复制反向条目，不需要旧目录，oldDir可以为空。否则新增的条目改为删除，
+// 删除和被覆盖的条目从旧目录中读取完整内容，修改和重命名的文件由正向差异和旧文件求逆。
+// 返回的补丁信息不包含条目数据。
+func (s *DirPatchSerializer) InvertDirPatch(patchPath, oldDir, outputPath string) (*hexdiff.DirPatch, error) {
+	embedded, err := OpenDirPatchReverse(patchPath, nil)
+	if err == nil {
+		defer embedded.Close()
+		reverse := embedded.Patch()
+		return s.writeInverted(outputPath, reverse, func(w *DirPatchWriter) ([]*hexdiff.DirPatchFile, error) {
+			for i := range reverse.Files {
+				if err := w.copyEntry(embedded, i); err != nil {
+					return nil, err
+				}
+			}
+			return reverse.Files, nil
+		})
+	}
+	if !errors.Is(err, ErrNotReversible) {
+		return nil, err
+	}
+	if oldDir == "" {
+		return nil, fmt.Errorf("%w: old directory required", ErrNotReversible)
+	}
+
+	forward, err := OpenDirPatch(patchPath)
+	if err != nil {
+		return nil, err
+	}
+	defer forward.Close()
+
+	inverter := s.newDirInverter(forward, oldDir)
+	defer inverter.Close()
+	reverse := &hexdiff.DirPatch{
+		OldDir:   forward.Patch().NewDir,
+		NewDir:   forward.Patch().OldDir,
+		Metadata: forward.Patch().Metadata,
+	}
+	return s.writeInverted(outputPath, reverse, func(w *DirPatchWriter) ([]*hexdiff.DirPatchFile, error) {
+		if err := inverter.invert(w); err != nil {
+			return nil, err
+		}
+		return inverter.files, nil
+	})
+}
+
+// writeInverted 将write生成的条目写入反向补丁，失败时删除输出文件
+func (s *DirPatchSerializer) writeInverted(outputPath string, reverse *hexdiff.DirPatch,
+	write func(w *DirPatchWriter) ([]*hexdiff.DirPatchFile, error)) (*hexdiff.DirPatch, error) {
+	result := &hexdiff.DirPatch{
+		Version:   DirPatchVersion,
+		Timestamp: time.Now().Unix(),
+		OldDir:    reverse.OldDir,
+		NewDir:    reverse.NewDir,
+		Metadata:  reverse.Metadata,
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("create patch file: %w", err)
+	}
+	writer, err := NewDirPatchWriter(file, result.OldDir, result.NewDir, nil, result.Metadata)
+	if err == nil {
+		err = writer.SetCompression(s.compression, s.level)
+	}
+	if err == nil {
+		result.Files, err = write(writer)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close patch file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return nil, err
+	}
+	return result, nil
+}
+
+// AddReverse 在目录补丁中嵌入由旧目录求得的反向条目，补丁因此可以由DirApplier.ApplyReverse反向应用
+//
+// 补丁被重写，原有的签名会被移除。已经嵌入反向条目时不做任何操作。
+func (s *DirPatchSerializer) AddReverse(patchPath, oldDir string) error {
+	forward, err := OpenDirPatch(patchPath)
+	if err != nil {
+		return err
+	}
+	defer forward.Close()
+	if forward.Header().Flags&DirPatchFlagReverse != 0 {
+		return nil
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(patchPath), "."+filepath.Base(patchPath)+".*")
+	if err != nil {
+		return fmt.Errorf("create patch file: %w", err)
+	}
+	tempPath := file.Name()
+	defer os.Remove(tempPath)
+	if info, err := os.Stat(patchPath); err == nil {
+		file.Chmod(info.Mode().Perm())
+	}
+
+	err = s.writeReversible(file, forward, oldDir)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close patch file: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	forward.Close()
+	if err := os.Rename(tempPath, patchPath); err != nil {
+		return fmt.Errorf("replace patch file: %w", err)
+	}
+	return nil
+}
+
+// writeReversible 复制正向补丁的所有条目，再写入反向条目
+func (s *DirPatchSerializer) writeReversible(file *os.File, forward *DirPatchReader, oldDir string) error {
+	dirPatch := forward.Patch()
+	writer, err := NewDirPatchWriter(file, dirPatch.OldDir, dirPatch.NewDir, dirPatch.Sources, dirPatch.Metadata)
+	if err != nil {
+		return err
+	}
+	if err := writer.SetCompression(s.compression, s.level); err != nil {
+		return err
+	}
+	for i := range dirPatch.Files {
+		if err := writer.copyEntry(forward, i); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.BeginReverse(); err != nil {
+		return err
+	}
+	inverter := s.newDirInverter(forward, oldDir)
+	defer inverter.Close()
+	if err := inverter.invert(writer); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// dirInverter 由正向补丁和旧目录生成反向条目
+type dirInverter struct {
+	serializer    *DirPatchSerializer
+	forward       *DirPatchReader
+	oldDir        string
+	sources       *DirSourceReader // 正向补丁源文件表中旧文件的拼接，按需打开
+	sourceOffsets map[string]int64
+	restored      map[string]bool         // 已写入反向条目的路径
+	files         []*hexdiff.DirPatchFile // 已写入的反向条目，不包含数据
+}
+
+func (s *DirPatchSerializer) newDirInverter(forward *DirPatchReader, oldDir string) *dirInverter {
+	v := &dirInverter{
+		serializer:    s,
+		forward:       forward,
+		oldDir:        oldDir,
+		sourceOffsets: make(map[string]int64),
+		restored:      make(map[string]bool),
+	}
+	var offset int64
+	for _, source := range forward.Patch().Sources {
+		v.sourceOffsets[source.RelativePath] = offset
+		offset += source.Size
+	}
+	return v
+}
+
+// Close 关闭打开的旧文件
+func (v *dirInverter) Close() error {
+	if v.sources == nil {
+		return nil
+	}
+	return v.sources.Close()
+}
+
+// invert 依次写入正向补丁中每个条目的反向条目，同一路径只写入一次
+func (v *dirInverter) invert(w *DirPatchWriter) error {
+	for i, file := range v.forward.Patch().Files {
+		var err error
+		if file.Status == hexdiff.StatusRenamed {
+			err = v.restoreRenamed(w, i)
+		}
+		if err == nil {
+			err = v.restore(w, file.RelativePath, i)
+		}
+		if err != nil {
+			return fmt.Errorf("invert %s: %w", file.RelativePath, err)
+		}
+	}
+	return nil
+}
+
+// oldPath 返回路径在旧目录中的位置
+func (v *dirInverter) oldPath(path string) string {
+	return filepath.Join(v.oldDir, filepath.FromSlash(path))
+}
+
+// restore 写入将path恢复为旧目录中状态的反向条目，i为正向补丁中写入或删除path的条目
+func (v *dirInverter) restore(w *DirPatchWriter, path string, i int) error {
+	if v.restored[path] {
+		return nil
+	}
+	v.restored[path] = true
+	file := v.forward.Patch().Files[i]
+
+	info, err := os.Lstat(v.oldPath(path))
+	if os.IsNotExist(err) && file.Status != hexdiff.StatusDeleted {
+		// 旧目录中没有的条目在反向补丁中删除
+		return v.write(w, &hexdiff.DirPatchFile{RelativePath: path, Status: hexdiff.StatusDeleted, Kind: file.Kind})
+	}
+	if err != nil {
+		return fmt.Errorf("stat old file: %w", err)
+	}
+
+	entry, err := v.oldEntry(path, info)
+	if err != nil {
+		return err
+	}
+	entry.Status = hexdiff.StatusModified
+	if file.Status == hexdiff.StatusDeleted {
+		entry.Status = hexdiff.StatusAdded
+	}
+	if entry.Kind != hexdiff.KindFile {
+		return v.write(w, entry)
+	}
+
+	if file.Kind == hexdiff.KindFile && file.Status != hexdiff.StatusDeleted {
+		if unchangedEntry(file) {
+			return v.write(w, entry)
+		}
+		delta, err := v.inverseDelta(i, path, entry.Size)
+		if err != nil {
+			return err
+		}
+		if delta != nil {
+			return v.writeDelta(w, entry, delta)
+		}
+	}
+	return v.writeContent(w, entry)
+}
+
+// restoreRenamed 写入恢复重命名原路径的反向条目，内容由新路径的文件求得。
+// 新路径不在旧目录中时反向重命名，否则复制到原路径，新路径另外恢复
+func (v *dirInverter) restoreRenamed(w *DirPatchWriter, i int) error {
+	file := v.forward.Patch().Files[i]
+	path := file.OldPath
+	if v.restored[path] {
+		return nil
+	}
+	v.restored[path] = true
+
+	info, err := os.Lstat(v.oldPath(path))
+	if err != nil {
+		return fmt.Errorf("stat old file: %w", err)
+	}
+	entry, err := v.oldEntry(path, info)
+	if err != nil {
+		return err
+	}
+	entry.Status = hexdiff.StatusAdded
+	if entry.Kind != hexdiff.KindFile {
+		return v.write(w, entry)
+	}
+
+	var delta *PatchFile
+	if file.DeltaSize > 0 {
+		if delta, err = v.inverseDelta(i, path, entry.Size); err != nil {
+			return err
+		}
+		if delta == nil {
+			return v.writeContent(w, entry)
+		}
+	}
+
+	entry.Status = hexdiff.StatusCopied
+	entry.OldPath = file.RelativePath
+	if _, err := os.Lstat(v.oldPath(file.RelativePath)); os.IsNotExist(err) && !v.restored[file.RelativePath] {
+		entry.Status = hexdiff.StatusRenamed
+		v.restored[file.RelativePath] = true
+	}
+	if delta == nil {
+		return v.write(w, entry)
+	}
+	return v.writeDelta(w, entry, delta)
+}
+
+// oldEntry 返回旧目录中path的条目信息，普通文件带有校验和
+func (v *dirInverter) oldEntry(path string, info os.FileInfo) (*hexdiff.DirPatchFile, error) {
+	entry := &hexdiff.DirPatchFile{
+		RelativePath: path,
+		Mode:         info.Mode(),
+		MTime:        info.ModTime().Unix(),
+	}
+
+	switch {
+	case info.IsDir():
+		entry.Kind = hexdiff.KindDir
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(v.oldPath(path))
+		if err != nil {
+			return nil, fmt.Errorf("read old link: %w", err)
+		}
+		entry.Kind = hexdiff.KindSymlink
+		entry.LinkTarget = target
+		entry.Size = int64(len(target))
+	case info.Mode().IsRegular():
+		checksum, err := calculateFileChecksum(v.oldPath(path))
+		if err != nil {
+			return nil, fmt.Errorf("hash old file: %w", err)
+		}
+		entry.Kind = hexdiff.KindFile
+		entry.Size = info.Size()
+		entry.Checksum = checksum
+	default:
+		return nil, fmt.Errorf("unsupported file type: %s", info.Mode().Type())
+	}
+	return entry, nil
+}
+
+// inverseDelta 由正向补丁第i个条目的差异求新文件→旧目录中path的差异，size为旧文件大小。
+// 差异的源中不包含path时返回nil
+func (v *dirInverter) inverseDelta(i int, path string, size int64) (*PatchFile, error) {
+	file := v.forward.Patch().Files[i]
+	if file.IsFullContent || file.DeltaSize == 0 {
+		return nil, nil
+	}
+
+	sources := v.forward.Patch().Sources
+	start, ok := v.sourceOffsets[path]
+	if len(sources) == 0 {
+		source := file.RelativePath
+		if file.Status == hexdiff.StatusRenamed || file.Status == hexdiff.StatusCopied {
+			source = file.OldPath
+		}
+		start, ok = 0, file.Status != hexdiff.StatusAdded && source == path
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := v.forward.ReadData(i)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := NewSerializer(CompressionNone).DeserializeFromData(data)
+	if err != nil {
+		return nil, fmt.Errorf("deserialize delta: %w", err)
+	}
+
+	if len(sources) > 0 {
+		if v.sources == nil {
+			v.sources = NewDirSourceReader(v.oldDir, sources)
+		}
+		if delta.Header.SourceSize < start+size {
+			return nil, fmt.Errorf("delta source size %d does not contain %s", delta.Header.SourceSize, path)
+		}
+		return invertRange(delta, v.sources, uint64(start), uint64(start+size))
+	}
+
+	if delta.Header.SourceSize != size {
+		return nil, fmt.Errorf("old file size %d, patch expects %d", size, delta.Header.SourceSize)
+	}
+	old, err := os.Open(v.oldPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("open old file: %w", err)
+	}
+	defer old.Close()
+	return invertRange(delta, old, 0, uint64(size))
+}
+
+// write 写入没有数据或数据已在内存中的反向条目
+func (v *dirInverter) write(w *DirPatchWriter, entry *hexdiff.DirPatchFile) error {
+	if err := w.WriteFile(entry); err != nil {
+		return err
+	}
+	entry.DeltaSize = int64(len(entry.Delta))
+	entry.Delta = nil
+	v.files = append(v.files, entry)
+	return nil
+}
+
+// writeDelta 写入以差异恢复的文件条目，只由字面数据组成的差异保存为完整内容
+func (v *dirInverter) writeDelta(w *DirPatchWriter, entry *hexdiff.DirPatchFile, delta *PatchFile) error {
+	if data, ok := literalData(delta); ok && entry.OldPath == "" {
+		entry.Delta = data
+		entry.IsFullContent = true
+		return v.write(w, entry)
+	}
+
+	serializer := NewSerializer(v.serializer.compression)
+	serializer.SetLevel(v.serializer.level)
+	serializer.SetCompressOperations(true)
+
+	var buf bytes.Buffer
+	if err := serializer.SerializePatchTo(delta, &buf); err != nil {
+		return fmt.Errorf("serialize delta: %w", err)
+	}
+	entry.Delta = buf.Bytes()
+	return v.write(w, entry)
+}
+
+// writeContent 写入旧文件的完整内容，数据从磁盘边读边写入
+func (v *dirInverter) writeContent(w *DirPatchWriter, entry *hexdiff.DirPatchFile) error {
+	old, err := os.Open(v.oldPath(entry.RelativePath))
+	if err != nil {
+		return fmt.Errorf("open old file: %w", err)
+	}
+	defer old.Close()
+
+	entry.IsFullContent = true
+	if err := w.WriteFileFrom(entry, old, entry.Size); err != nil {
+		return err
+	}
+	entry.DeltaSize = entry.Size
+	v.files = append(v.files, entry)
+	return nil
+}
@@ -0,0 +1,168 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+func TestInvert(t *testing.T) {
+	rng := rand.New(rand.NewSource(23))
+	random := make([]byte, 64*1024)
+	rng.Read(random)
+	code := bytes.Repeat([]byte("call 0x00401000; mov rax, rbx; "), 64)
+
+	pairs := [][2][]byte{
+		{random, bytes.Join([][]byte{random[32*1024:], []byte("inserted"), random[:20*1024]}, nil)}, // 中间一段没有被引用
+		{code, bytes.ReplaceAll(code, []byte("0x00401000"), []byte("0x00401040"))},                  // 相加操作
+		{[]byte("old content"), nil},
+	}
+	for i, pair := range pairs {
+		oldData, newData := pair[0], pair[1]
+		delta := hexdiff.DiffBytes(oldData, newData)
+		delta.SetChecksum(newData)
+		patchFile, err := NewSerializer(CompressionNone).buildPatchFile(delta, sha256.Sum256(oldData))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inverse, err := Invert(patchFile, bytes.NewReader(oldData))
+		if err != nil {
+			t.Fatalf("pair %d: Invert() error = %v", i, err)
+		}
+		if inverse.Header.SourceChecksum != sha256.Sum256(newData) || inverse.Header.TargetChecksum != sha256.Sum256(oldData) {
+			t.Errorf("pair %d: inverse checksums not swapped", i)
+		}
+
+		var buf bytes.Buffer
+		if err := NewSerializer(CompressionZstd).SerializePatchTo(inverse, &buf); err != nil {
+			t.Fatal(err)
+		}
+		output := filepath.Join(t.TempDir(), "old.bin")
+		if err := NewApplier(nil).ApplyDeltaFrom(bytes.NewReader(newData), buf.Bytes(), output); err != nil {
+			t.Fatalf("pair %d: ApplyDeltaFrom() error = %v", i, err)
+		}
+		if got, _ := os.ReadFile(output); !bytes.Equal(got, oldData) {
+			t.Errorf("pair %d: inverse output mismatch", i)
+		}
+	}
+
+	delta := hexdiff.DiffBytes(code, random)
+	patchFile, _ := NewSerializer(CompressionNone).buildPatchFile(delta, sha256.Sum256(code))
+	if _, err := Invert(patchFile, bytes.NewReader(random[:len(code)])); err == nil {
+		t.Error("Invert() with wrong source file succeeded")
+	}
+}
+
+func TestInvertDirPatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(23))
+	random := func(n int) []byte {
+		data := make([]byte, n)
+		rng.Read(data)
+		return data
+	}
+
+	shared, moved, renamed := random(32*1024), random(16*1024), random(12*1024)
+	oldFiles := map[string][]byte{
+		"a.bin":          shared,
+		"b.txt":          []byte("unchanged"),
+		"sub/c.bin":      moved,
+		"sub/r.bin":      renamed,
+		"obsolete.md":    []byte("to be deleted"),
+		"gone/inner.txt": []byte("deleted with its directory"),
+	}
+	newFiles := map[string][]byte{
+		"a.bin":     append(append([]byte{}, shared[:16*1024]...), random(20*1024)...),
+		"b.txt":     []byte("unchanged"),
+		"sub/d.bin": moved,
+		"sub/s.bin": append(append([]byte{}, renamed...), []byte("appended")...),
+		"added.txt": []byte("brand new file"),
+		"new/x.bin": random(4 * 1024),
+	}
+
+	for _, dedup := range []bool{true, false} {
+		dir := t.TempDir()
+		oldDir := filepath.Join(dir, "old")
+		newDir := filepath.Join(dir, "new")
+		writeTree(t, oldDir, oldFiles)
+		writeTree(t, newDir, newFiles)
+
+		config := hexdiff.DefaultDirDiffConfig()
+		config.CrossFileDedup = dedup
+		engine, err := hexdiff.NewDirEngine(nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := engine.GenerateDirDiff(oldDir, newDir, nil)
+		if err != nil {
+			t.Fatalf("GenerateDirDiff() error = %v", err)
+		}
+		patchFile := filepath.Join(dir, "forward.patch")
+		serializer := NewDirPatchSerializer(CompressionGzip)
+		if err := serializer.SerializeDirPatch(result, "old", "new", patchFile); err != nil {
+			t.Fatal(err)
+		}
+
+		reversePatch := filepath.Join(dir, "reverse.patch")
+		if _, err := serializer.InvertDirPatch(patchFile, oldDir, reversePatch); err != nil {
+			t.Fatalf("dedup=%v: InvertDirPatch() error = %v", dedup, err)
+		}
+		target := filepath.Join(dir, "target")
+		writeTree(t, target, newFiles)
+		if _, err := NewDirApplier(nil).Apply(reversePatch, target, nil); err != nil {
+			t.Fatalf("dedup=%v: Apply() of reverse patch error = %v", dedup, err)
+		}
+		if got := readTree(t, target); !reflect.DeepEqual(got, oldFiles) {
+			t.Errorf("dedup=%v: reverse patch files = %v, want %v", dedup, keys(got), keys(oldFiles))
+		}
+
+		// 没有嵌入反向条目时需要旧目录
+		if _, err := serializer.InvertDirPatch(patchFile, "", reversePatch); !errors.Is(err, ErrNotReversible) {
+			t.Errorf("dedup=%v: InvertDirPatch() without old directory error = %v, want ErrNotReversible", dedup, err)
+		}
+
+		if err := serializer.AddReverse(patchFile, oldDir); err != nil {
+			t.Fatalf("dedup=%v: AddReverse() error = %v", dedup, err)
+		}
+		os.RemoveAll(target)
+		writeTree(t, target, oldFiles)
+		if _, err := NewDirApplier(nil).Apply(patchFile, target, nil); err != nil {
+			t.Fatalf("dedup=%v: Apply() of reversible patch error = %v", dedup, err)
+		}
+		if got := readTree(t, target); !reflect.DeepEqual(got, newFiles) {
+			t.Errorf("dedup=%v: forward files = %v, want %v", dedup, keys(got), keys(newFiles))
+		}
+		if _, err := NewDirApplier(nil).ApplyReverse(patchFile, target, nil, nil); err != nil {
+			t.Fatalf("dedup=%v: ApplyReverse() error = %v", dedup, err)
+		}
+		if got := readTree(t, target); !reflect.DeepEqual(got, oldFiles) {
+			t.Errorf("dedup=%v: reversed files = %v, want %v", dedup, keys(got), keys(oldFiles))
+		}
+		for _, name := range []string{"new", "new/x.bin"} {
+			if _, err := os.Lstat(filepath.Join(target, name)); !os.IsNotExist(err) {
+				t.Errorf("dedup=%v: %s left after ApplyReverse(): %v", dedup, name, err)
+			}
+		}
+
+		// 嵌入的反向条目可以直接提取
+		os.RemoveAll(oldDir)
+		if _, err := serializer.InvertDirPatch(patchFile, "", reversePatch); err != nil {
+			t.Fatalf("dedup=%v: InvertDirPatch() of reversible patch error = %v", dedup, err)
+		}
+		os.RemoveAll(target)
+		writeTree(t, target, newFiles)
+		if _, err := NewDirApplier(nil).Apply(reversePatch, target, nil); err != nil {
+			t.Fatalf("dedup=%v: Apply() of extracted reverse patch error = %v", dedup, err)
+		}
+		if got := readTree(t, target); !reflect.DeepEqual(got, oldFiles) {
+			t.Errorf("dedup=%v: extracted reverse files = %v, want %v", dedup, keys(got), keys(oldFiles))
+		}
+	}
+}
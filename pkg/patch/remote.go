@@ -0,0 +1,385 @@
+package patch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// 远程差异协议常量
+//
+// 协议流程：
+//  1. 接收方（持有旧文件）发送 HELLO，随后以数据帧发送旧文件签名，以 END 结束
+//  2. 发送方（持有新文件）根据签名生成差异，发送 HELLO 及补丁数据帧，以 END 结束
+//  3. 接收方应用补丁后回复 DONE；任一方在出错时可发送 ERROR 帧
+//
+// 每个帧由 1 字节类型、4 字节小端长度和负载组成。
+const (
+	// RemoteMagic 远程协议魔数
+	RemoteMagic = 0x48585250 // "HXRP"
+	// RemoteVersion 远程协议版本
+	RemoteVersion = 1
+	// RemoteChunkSize 单个数据帧的负载大小
+	RemoteChunkSize = 64 * 1024
+	// remoteMaxFrameSize 允许的最大帧负载
+	remoteMaxFrameSize = 1024 * 1024
+)
+
+// 帧类型
+const (
+	frameHello byte = iota + 1
+	frameData
+	frameEnd
+	frameError
+	frameDone
+)
+
+// 握手中的角色
+const (
+	roleReceiver byte = iota + 1
+	roleSender
+)
+
+// ErrRemoteProtocol 远程协议错误
+var ErrRemoteProtocol = errors.New("remote protocol error")
+
+// RemoteError 对端报告的错误
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "remote: " + e.Message
+}
+
+// RemoteResult 远程同步结果
+type RemoteResult struct {
+	SignatureBytes int64        // 传输的签名字节数
+	PatchBytes     int64        // 传输的补丁字节数
+	SourceSize     int64        // 旧文件大小
+	TargetSize     int64        // 新文件大小
+	Apply          *ApplyResult // 接收方的补丁应用结果
+}
+
+// RemoteSender 远程同步发送方，持有新文件
+type RemoteSender struct {
+	engine     *diff.Engine
+	serializer *Serializer
+}
+
+// NewRemoteSender 创建远程同步发送方
+func NewRemoteSender(engine *diff.Engine, compression CompressionType) *RemoteSender {
+	return &RemoteSender{
+		engine:     engine,
+		serializer: NewSerializer(compression),
+	}
+}
+
+// SetCompressionLevel 设置补丁数据的压缩级别
+func (s *RemoteSender) SetCompressionLevel(level CompressionLevel) {
+	s.serializer.SetLevel(level)
+}
+
+// Send 读取对端签名，返回针对新文件的补丁，并等待对端应用完成
+func (s *RemoteSender) Send(conn io.ReadWriter, newFilePath string) (*RemoteResult, error) {
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	if err := readHello(reader, roleReceiver); err != nil {
+		return nil, err
+	}
+
+	// 读取签名
+	sigReader := newFrameReader(reader)
+	signature, err := diff.ReadSignature(sigReader)
+	if err != nil {
+		return nil, fmt.Errorf("read signature: %w", err)
+	}
+	if n, err := io.Copy(io.Discard, sigReader); err != nil {
+		return nil, fmt.Errorf("read signature: %w", err)
+	} else if n != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes after signature", ErrRemoteProtocol, n)
+	}
+
+	result := &RemoteResult{
+		SignatureBytes: sigReader.n,
+		SourceSize:     signature.FileSize,
+	}
+
+	// 对端此时正在等待回复，失败时需要告知对端
+	delta, err := s.engine.GenerateDeltaFromSignature(signature, newFilePath)
+	if err != nil {
+		err = fmt.Errorf("generate delta: %w", err)
+		sendError(writer, err)
+		return nil, err
+	}
+	result.TargetSize = delta.TargetSize
+
+	if err := writeHello(writer, roleSender); err != nil {
+		return nil, err
+	}
+	patchWriter := newFrameWriter(writer)
+	if err := s.serializer.SerializeDeltaTo(delta, signature.Checksum, patchWriter); err != nil {
+		return nil, fmt.Errorf("send patch: %w", err)
+	}
+	if err := patchWriter.Close(); err != nil {
+		return nil, fmt.Errorf("send patch: %w", err)
+	}
+	result.PatchBytes = patchWriter.n
+
+	// 等待接收方确认
+	frameType, payload, err := readFrame(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read acknowledgement: %w", err)
+	}
+	switch frameType {
+	case frameDone:
+		return result, nil
+	case frameError:
+		return nil, &RemoteError{Message: string(payload)}
+	default:
+		return nil, fmt.Errorf("%w: unexpected frame type %d", ErrRemoteProtocol, frameType)
+	}
+}
+
+// RemoteReceiver 远程同步接收方，持有旧文件
+type RemoteReceiver struct {
+	engine  *diff.Engine
+	applier *Applier
+	tempDir string
+}
+
+// NewRemoteReceiver 创建远程同步接收方
+func NewRemoteReceiver(engine *diff.Engine, applier *Applier) *RemoteReceiver {
+	return &RemoteReceiver{
+		engine:  engine,
+		applier: applier,
+		tempDir: applier.config.TempDir,
+	}
+}
+
+// Receive 发送旧文件签名，接收补丁并应用到targetFilePath
+func (r *RemoteReceiver) Receive(conn io.ReadWriter, sourceFilePath, targetFilePath string) (*RemoteResult, error) {
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	signature, err := r.engine.GenerateSignature(sourceFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("generate signature: %w", err)
+	}
+
+	// 发送签名
+	if err := writeHello(writer, roleReceiver); err != nil {
+		return nil, err
+	}
+	sigWriter := newFrameWriter(writer)
+	if _, err := signature.WriteTo(sigWriter); err != nil {
+		return nil, fmt.Errorf("send signature: %w", err)
+	}
+	if err := sigWriter.Close(); err != nil {
+		return nil, fmt.Errorf("send signature: %w", err)
+	}
+
+	result := &RemoteResult{
+		SignatureBytes: sigWriter.n,
+		SourceSize:     signature.FileSize,
+	}
+
+	// 接收补丁到临时文件
+	if err := readHello(reader, roleSender); err != nil {
+		return nil, err
+	}
+	tempFile, err := os.CreateTemp(r.tempDir, "hexdiff_remote_*.patch")
+	if err != nil {
+		return nil, fmt.Errorf("create temp patch file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+
+	patchReader := newFrameReader(reader)
+	_, err = io.Copy(tempFile, patchReader)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("receive patch: %w", err)
+	}
+	result.PatchBytes = patchReader.n
+
+	header, err := GetPatchInfo(tempPath)
+	if err != nil {
+		err = fmt.Errorf("read patch header: %w", err)
+		sendError(writer, err)
+		return nil, err
+	}
+	result.TargetSize = header.TargetSize
+
+	// 应用补丁并告知发送方结果
+	applyResult, err := r.applier.ApplyPatch(sourceFilePath, tempPath, targetFilePath)
+	if err != nil {
+		err = fmt.Errorf("apply patch: %w", err)
+		sendError(writer, err)
+		return nil, err
+	}
+	result.Apply = applyResult
+
+	if err := writeFrame(writer, frameDone, nil); err != nil {
+		return nil, fmt.Errorf("send acknowledgement: %w", err)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, fmt.Errorf("send acknowledgement: %w", err)
+	}
+
+	return result, nil
+}
+
+// writeFrame 写入一个帧
+func writeFrame(w io.Writer, frameType byte, payload []byte) error {
+	var header [5]byte
+	header[0] = frameType
+	binary.LittleEndian.PutUint32(header[1:5], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame 读取一个帧
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[1:5])
+	if size > remoteMaxFrameSize {
+		return 0, nil, fmt.Errorf("%w: frame too large: %d bytes", ErrRemoteProtocol, size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// writeHello 发送握手帧
+func writeHello(w *bufio.Writer, role byte) error {
+	payload := make([]byte, 7)
+	binary.LittleEndian.PutUint32(payload[0:4], RemoteMagic)
+	binary.LittleEndian.PutUint16(payload[4:6], RemoteVersion)
+	payload[6] = role
+	if err := writeFrame(w, frameHello, payload); err != nil {
+		return fmt.Errorf("send hello: %w", err)
+	}
+	return nil
+}
+
+// readHello 读取并校验对端握手帧
+func readHello(r io.Reader, expectedRole byte) error {
+	frameType, payload, err := readFrame(r)
+	if err != nil {
+		return fmt.Errorf("read hello: %w", err)
+	}
+	if frameType == frameError {
+		return &RemoteError{Message: string(payload)}
+	}
+	if frameType != frameHello || len(payload) != 7 {
+		return fmt.Errorf("%w: expected hello frame", ErrRemoteProtocol)
+	}
+	if magic := binary.LittleEndian.Uint32(payload[0:4]); magic != RemoteMagic {
+		return fmt.Errorf("%w: invalid magic number: expected %x, got %x", ErrRemoteProtocol, RemoteMagic, magic)
+	}
+	if version := binary.LittleEndian.Uint16(payload[4:6]); version != RemoteVersion {
+		return fmt.Errorf("%w: unsupported version: %d", ErrRemoteProtocol, version)
+	}
+	if payload[6] != expectedRole {
+		return fmt.Errorf("%w: peer has the same role", ErrRemoteProtocol)
+	}
+	return nil
+}
+
+// sendError 尽力向对端发送错误帧
+func sendError(w *bufio.Writer, err error) {
+	if writeFrame(w, frameError, []byte(err.Error())) == nil {
+		w.Flush()
+	}
+}
+
+// frameWriter 将字节流切分为数据帧
+type frameWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func newFrameWriter(w *bufio.Writer) *frameWriter {
+	return &frameWriter{w: w}
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), RemoteChunkSize)]
+		if err := writeFrame(fw.w, frameData, chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		fw.n += int64(len(chunk))
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close 写入结束帧并刷新
+func (fw *frameWriter) Close() error {
+	if err := writeFrame(fw.w, frameEnd, nil); err != nil {
+		return err
+	}
+	return fw.w.Flush()
+}
+
+// frameReader 从数据帧中还原字节流，遇到结束帧时返回io.EOF
+type frameReader struct {
+	r       io.Reader
+	pending []byte
+	n       int64
+	done    bool
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: r}
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	for len(fr.pending) == 0 {
+		if fr.done {
+			return 0, io.EOF
+		}
+		frameType, payload, err := readFrame(fr.r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		switch frameType {
+		case frameData:
+			fr.pending = payload
+		case frameEnd:
+			fr.done = true
+		case frameError:
+			return 0, &RemoteError{Message: string(payload)}
+		default:
+			return 0, fmt.Errorf("%w: unexpected frame type %d", ErrRemoteProtocol, frameType)
+		}
+	}
+
+	n := copy(p, fr.pending)
+	fr.pending = fr.pending[n:]
+	fr.n += int64(n)
+	return n, nil
+}
@@ -0,0 +1,124 @@
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	hexdiff "github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+func newRemoteTestEngine(t *testing.T) *hexdiff.Engine {
+	t.Helper()
+	engine, err := hexdiff.NewEngine(&hexdiff.DiffConfig{
+		BlockSize:    256,
+		WindowSize:   32,
+		EnableCRC32:  true,
+		EnableSHA256: true,
+		MaxMemory:    100 * 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	return engine
+}
+
+func newRemoteTestApplier(tmpDir string) *Applier {
+	config := DefaultApplierConfig()
+	config.TempDir = tmpDir
+	config.BackupEnabled = false
+	return NewApplier(config)
+}
+
+func TestRemoteSync(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := filepath.Join(tmpDir, "old.bin")
+	newPath := filepath.Join(tmpDir, "new.bin")
+	outPath := filepath.Join(tmpDir, "out.bin")
+
+	oldData := make([]byte, 200*1024)
+	for i := range oldData {
+		oldData[i] = byte(i*7 + i/251)
+	}
+	newData := append([]byte(nil), oldData[:50*1024]...)
+	newData = append(newData, []byte("inserted on the build host")...)
+	newData = append(newData, oldData[50*1024:]...)
+
+	if err := os.WriteFile(oldPath, oldData, 0644); err != nil {
+		t.Fatalf("write old file: %v", err)
+	}
+	if err := os.WriteFile(newPath, newData, 0644); err != nil {
+		t.Fatalf("write new file: %v", err)
+	}
+
+	senderConn, receiverConn := net.Pipe()
+	defer senderConn.Close()
+	defer receiverConn.Close()
+
+	type sendOutcome struct {
+		result *RemoteResult
+		err    error
+	}
+	done := make(chan sendOutcome, 1)
+	go func() {
+		sender := NewRemoteSender(newRemoteTestEngine(t), CompressionGzip)
+		result, err := sender.Send(senderConn, newPath)
+		done <- sendOutcome{result, err}
+	}()
+
+	receiver := NewRemoteReceiver(newRemoteTestEngine(t), newRemoteTestApplier(tmpDir))
+	result, err := receiver.Receive(receiverConn, oldPath, outPath)
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	sent := <-done
+	if sent.err != nil {
+		t.Fatalf("Send() error = %v", sent.err)
+	}
+
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, newData) {
+		t.Fatal("output does not match new file")
+	}
+
+	if result.TargetSize != int64(len(newData)) {
+		t.Errorf("TargetSize = %d, want %d", result.TargetSize, len(newData))
+	}
+	if result.PatchBytes != sent.result.PatchBytes || result.SignatureBytes != sent.result.SignatureBytes {
+		t.Errorf("byte counts differ: receiver %+v, sender %+v", result, sent.result)
+	}
+	if result.PatchBytes >= int64(len(newData))/4 {
+		t.Errorf("PatchBytes = %d, expected far less than the new file", result.PatchBytes)
+	}
+}
+
+func TestRemoteSyncReportsSenderError(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldPath := filepath.Join(tmpDir, "old.bin")
+	if err := os.WriteFile(oldPath, bytes.Repeat([]byte("x"), 4096), 0644); err != nil {
+		t.Fatalf("write old file: %v", err)
+	}
+
+	senderConn, receiverConn := net.Pipe()
+	defer senderConn.Close()
+	defer receiverConn.Close()
+
+	go func() {
+		sender := NewRemoteSender(newRemoteTestEngine(t), CompressionNone)
+		sender.Send(senderConn, filepath.Join(tmpDir, "missing.bin"))
+	}()
+
+	receiver := NewRemoteReceiver(newRemoteTestEngine(t), newRemoteTestApplier(tmpDir))
+	_, err := receiver.Receive(receiverConn, oldPath, filepath.Join(tmpDir, "out.bin"))
+
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("Receive() error = %v, want RemoteError", err)
+	}
+}
@@ -3,7 +3,6 @@ package patch
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
@@ -14,6 +13,11 @@ import (
 // Serializer 补丁序列化器
 type Serializer struct {
 	compression CompressionType
+	level       CompressionLevel
+	compressOps bool
+	dict        []byte
+	dictID      uint32
+	encryption  *EncryptionKey
 }
 
 // NewSerializer 创建新的序列化器
@@ -23,11 +27,80 @@ func NewSerializer(compression CompressionType) *Serializer {
 	}
 }
 
+// SetLevel 设置压缩级别，0表示使用算法的默认级别
+func (s *Serializer) SetLevel(level CompressionLevel) {
+	s.level = level
+}
+
+// SetCompressOperations 设置是否同时压缩操作列表，压缩后更小时才会生效
+func (s *Serializer) SetCompressOperations(enabled bool) {
+	s.compressOps = enabled
+}
+
+// SetDictionary 设置Zstd压缩使用的字典，为空时不使用字典
+//
+// 字典ID写入补丁文件头，应用补丁前需要注册同一字典。其他压缩算法忽略字典。
+func (s *Serializer) SetDictionary(dict []byte) error {
+	id, err := dictionaryID(dict)
+	if err != nil {
+		return err
+	}
+	s.dict = dict
+	s.dictID = id
+	return nil
+}
+
+// SetEncryption 设置加密补丁使用的密钥，为nil时不加密
+//
+// 压缩后的操作列表和数据区分块加密，文件头保持明文，应用补丁前需要注册能解密的密钥。
+func (s *Serializer) SetEncryption(key *EncryptionKey) {
+	s.encryption = key
+}
+
 // SerializeDelta 将差异结果序列化为补丁文件
 func (s *Serializer) SerializeDelta(delta *diff.Delta, sourceChecksum [32]byte, outputPath string) error {
+	patchFile, err := s.buildPatchFile(delta, sourceChecksum)
+	if err != nil {
+		return err
+	}
+
+	// 写入文件
+	return s.writePatchFile(patchFile, outputPath)
+}
+
+// SerializeDeltaTo 将差异结果序列化后写入writer
+func (s *Serializer) SerializeDeltaTo(delta *diff.Delta, sourceChecksum [32]byte, w io.Writer) error {
+	patchFile, err := s.buildPatchFile(delta, sourceChecksum)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(w)
+	if err := s.writePatch(writer, patchFile); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// SerializePatch 按当前的压缩和加密设置写入已有的补丁文件结构，例如Compose的结果
+func (s *Serializer) SerializePatch(patchFile *PatchFile, outputPath string) error {
+	return s.writePatchFile(patchFile, outputPath)
+}
+
+// SerializePatchTo 将补丁文件结构序列化后写入writer
+func (s *Serializer) SerializePatchTo(patchFile *PatchFile, w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	if err := s.writePatch(writer, patchFile); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// buildPatchFile 由差异结果构建补丁文件结构
+func (s *Serializer) buildPatchFile(delta *diff.Delta, sourceChecksum [32]byte) (*PatchFile, error) {
 	// 创建补丁文件结构
 	patchFile := NewPatchFile()
-	patchFile.Header.Compression = s.compression
+	patchFile.Header.setCompression(s.compression)
 	patchFile.Header.SourceSize = delta.SourceSize
 	patchFile.Header.TargetSize = delta.TargetSize
 	patchFile.Header.SourceChecksum = sourceChecksum
@@ -41,7 +114,7 @@ func (s *Serializer) SerializeDelta(delta *diff.Delta, sourceChecksum [32]byte,
 
 		patchOp := PatchOperation{
 			Offset: uint64(op.Offset),
-			Size:   uint32(op.Size),
+			Size:   uint64(op.Size),
 		}
 
 		switch op.Type {
@@ -53,8 +126,12 @@ func (s *Serializer) SerializeDelta(delta *diff.Delta, sourceChecksum [32]byte,
 			patchOp.DataOffset = patchFile.AddInsertData(op.Data)
 		case diff.OpDelete:
 			patchOp.Type = 2
+		case diff.OpAdd:
+			patchOp.Type = 3
+			patchOp.SrcOffset = uint64(op.SrcOffset)
+			patchOp.DataOffset = patchFile.AddInsertData(op.Data)
 		default:
-			return fmt.Errorf("unknown operation type: %v", op.Type)
+			return nil, fmt.Errorf("unknown operation type: %v", op.Type)
 		}
 
 		patchFile.Operations = append(patchFile.Operations, patchOp)
@@ -63,8 +140,7 @@ func (s *Serializer) SerializeDelta(delta *diff.Delta, sourceChecksum [32]byte,
 	// 更新文件头
 	patchFile.UpdateHeader()
 
-	// 写入文件
-	return s.writePatchFile(patchFile, outputPath)
+	return patchFile, nil
 }
 
 // writePatchFile 写入补丁文件
@@ -76,7 +152,32 @@ func (s *Serializer) writePatchFile(patchFile *PatchFile, outputPath string) err
 	defer file.Close()
 
 	writer := bufio.NewWriter(file)
-	defer writer.Flush()
+	if err := s.writePatch(writer, patchFile); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// writePatch 将补丁内容写入writer
+func (s *Serializer) writePatch(writer io.Writer, patchFile *PatchFile) error {
+	codec, err := newCodec(s.compression, s.level, s.dict)
+	if err != nil {
+		return err
+	}
+
+	// 确定操作列表编码，字典ID影响文件头大小，需要先记录
+	patchFile.Header.setCompression(s.compression)
+	patchFile.Header.setDictionary(s.dictID)
+	dataKey, err := patchFile.Header.setEncryption(s.encryption)
+	if err != nil {
+		return err
+	}
+	opData := patchFile.Header.layoutOperations(patchFile.Operations, uint64(len(patchFile.Data)))
+	if s.compressOps {
+		if opData, err = patchFile.Header.compressOperations(opData, codec.with(patchFile.Header.Compression)); err != nil {
+			return fmt.Errorf("compress operations: %w", err)
+		}
+	}
 
 	// 写入文件头
 	headerData := patchFile.Header.Marshal()
@@ -84,36 +185,25 @@ func (s *Serializer) writePatchFile(patchFile *PatchFile, outputPath string) err
 		return fmt.Errorf("write header: %w", err)
 	}
 
+	// 文件头之后的内容在压缩后加密
+	payload, err := encryptPayload(writer, patchFile.Header, dataKey)
+	if err != nil {
+		return err
+	}
+
 	// 写入操作列表
-	for _, op := range patchFile.Operations {
-		opData := op.Marshal()
-		if _, err := writer.Write(opData); err != nil {
-			return fmt.Errorf("write operation: %w", err)
-		}
+	if _, err := payload.Write(opData); err != nil {
+		return fmt.Errorf("write operations: %w", err)
 	}
 
 	// 写入数据区（可能压缩）
-	if err := s.writeData(writer, patchFile.Data); err != nil {
-		return fmt.Errorf("write data: %w", err)
+	if len(patchFile.Data) > 0 {
+		if err := codec.compressTo(payload, bytes.NewReader(patchFile.Data)); err != nil {
+			return fmt.Errorf("write data: %w", err)
+		}
 	}
 
-	return nil
-}
-
-// writeData 写入数据（支持压缩）
-func (s *Serializer) writeData(writer io.Writer, data []byte) error {
-	switch s.compression {
-	case CompressionNone:
-		_, err := writer.Write(data)
-		return err
-	case CompressionGzip:
-		gzipWriter := gzip.NewWriter(writer)
-		defer gzipWriter.Close()
-		_, err := gzipWriter.Write(data)
-		return err
-	default:
-		return fmt.Errorf("unsupported compression type: %v", s.compression)
-	}
+	return payload.Close()
 }
 
 // DeserializePatch 反序列化补丁文件
@@ -124,45 +214,41 @@ func (s *Serializer) DeserializePatch(inputPath string) (*PatchFile, error) {
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
-
-	// 读取文件头
-	headerData := make([]byte, HeaderSize)
-	if _, err := io.ReadFull(reader, headerData); err != nil {
-		return nil, fmt.Errorf("read header: %w", err)
+	// 增强补丁只读取其中的单文件补丁
+	body, err := openPatchBody(file)
+	if err != nil {
+		return nil, err
 	}
+	reader := bufio.NewReader(body)
 
-	header := &PatchHeader{}
-	if err := header.Unmarshal(headerData); err != nil {
-		return nil, fmt.Errorf("parse header: %w", err)
+	// 读取文件头
+	header, err := ReadPatchHeader(reader)
+	if err != nil {
+		return nil, err
 	}
 
-	patchFile := &PatchFile{
-		Header:     header,
-		Operations: make([]PatchOperation, header.OperationCount),
+	// 加密的补丁先解密文件头之后的内容
+	payload, err := OpenPayload(reader, header)
+	if err != nil {
+		return nil, err
 	}
 
 	// 读取操作列表
-	for i := uint32(0); i < header.OperationCount; i++ {
-		opData := make([]byte, OperationSize)
-		if _, err := io.ReadFull(reader, opData); err != nil {
-			return nil, fmt.Errorf("read operation %d: %w", i, err)
-		}
-
-		if err := patchFile.Operations[i].Unmarshal(opData); err != nil {
-			return nil, fmt.Errorf("parse operation %d: %w", i, err)
-		}
+	operations, err := ReadOperations(payload, header)
+	if err != nil {
+		return nil, err
 	}
+	patchFile := &PatchFile{Header: header, Operations: operations}
 
 	// 读取剩余的数据区
-	remainingData, err := io.ReadAll(reader)
+	remainingData, err := io.ReadAll(payload)
 	if err != nil {
 		return nil, fmt.Errorf("read remaining data: %w", err)
 	}
 
 	if len(remainingData) > 0 {
 		// 解压数据
-		patchFile.Data, err = s.decompressData(remainingData, header.Compression)
+		patchFile.Data, err = decompressPatchData(remainingData, header)
 		if err != nil {
 			return nil, fmt.Errorf("decompress data: %w", err)
 		}
@@ -172,41 +258,35 @@ func (s *Serializer) DeserializePatch(inputPath string) (*PatchFile, error) {
 }
 
 func (s *Serializer) DeserializeFromData(data []byte) (*PatchFile, error) {
-	reader := bytes.NewReader(data)
-
-	headerData := make([]byte, HeaderSize)
-	if _, err := io.ReadFull(reader, headerData); err != nil {
-		return nil, fmt.Errorf("read header: %w", err)
+	data, err := patchBody(data)
+	if err != nil {
+		return nil, err
 	}
+	reader := bytes.NewReader(data)
 
-	header := &PatchHeader{}
-	if err := header.Unmarshal(headerData); err != nil {
-		return nil, fmt.Errorf("parse header: %w", err)
+	header, err := ReadPatchHeader(reader)
+	if err != nil {
+		return nil, err
 	}
 
-	patchFile := &PatchFile{
-		Header:     header,
-		Operations: make([]PatchOperation, header.OperationCount),
+	payload, err := OpenPayload(reader, header)
+	if err != nil {
+		return nil, err
 	}
 
-	for i := uint32(0); i < header.OperationCount; i++ {
-		opData := make([]byte, OperationSize)
-		if _, err := io.ReadFull(reader, opData); err != nil {
-			return nil, fmt.Errorf("read operation %d: %w", i, err)
-		}
-
-		if err := patchFile.Operations[i].Unmarshal(opData); err != nil {
-			return nil, fmt.Errorf("parse operation %d: %w", i, err)
-		}
+	operations, err := ReadOperations(payload, header)
+	if err != nil {
+		return nil, err
 	}
+	patchFile := &PatchFile{Header: header, Operations: operations}
 
-	remainingData, err := io.ReadAll(reader)
+	remainingData, err := io.ReadAll(payload)
 	if err != nil {
 		return nil, fmt.Errorf("read remaining data: %w", err)
 	}
 
 	if len(remainingData) > 0 {
-		patchFile.Data, err = s.decompressData(remainingData, header.Compression)
+		patchFile.Data, err = decompressPatchData(remainingData, header)
 		if err != nil {
 			return nil, fmt.Errorf("decompress data: %w", err)
 		}
@@ -215,24 +295,6 @@ func (s *Serializer) DeserializeFromData(data []byte) (*PatchFile, error) {
 	return patchFile, nil
 }
 
-// decompressData 解压数据
-func (s *Serializer) decompressData(compressedData []byte, compression CompressionType) ([]byte, error) {
-	switch compression {
-	case CompressionNone:
-		return compressedData, nil
-	case CompressionGzip:
-		reader, err := gzip.NewReader(bytes.NewReader(compressedData))
-		if err != nil {
-			return nil, fmt.Errorf("create gzip reader: %w", err)
-		}
-		defer reader.Close()
-
-		return io.ReadAll(reader)
-	default:
-		return nil, fmt.Errorf("unsupported compression type: %v", compression)
-	}
-}
-
 // GetPatchInfo 获取补丁文件信息
 func GetPatchInfo(patchPath string) (*PatchHeader, error) {
 	file, err := os.Open(patchPath)
@@ -241,15 +303,9 @@ func GetPatchInfo(patchPath string) (*PatchHeader, error) {
 	}
 	defer file.Close()
 
-	headerData := make([]byte, HeaderSize)
-	if _, err := io.ReadFull(file, headerData); err != nil {
-		return nil, fmt.Errorf("read header: %w", err)
-	}
-
-	header := &PatchHeader{}
-	if err := header.Unmarshal(headerData); err != nil {
-		return nil, fmt.Errorf("parse header: %w", err)
+	body, err := openPatchBody(file)
+	if err != nil {
+		return nil, err
 	}
-
-	return header, nil
+	return ReadPatchHeader(body)
 }
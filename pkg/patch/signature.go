@@ -0,0 +1,349 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// 签名块格式常量
+//
+// 签名块追加在补丁末尾：
+//
+//	[签名时间 8][公钥 32][签名 64][签名者长度 2][签名者][签名块大小 4][魔数 4]
+//
+// 签名覆盖补丁头、签名块之前全部内容的SHA-256、签名时间和签名者，
+// 读取补丁时按末尾的魔数识别并忽略签名块，因此单文件、增强和目录补丁都可以签名。
+const (
+	// SignatureMagic 签名块魔数
+	SignatureMagic = 0x48585053 // "HXPS"
+	// signatureFixedSize 签名块中固定部分的大小
+	signatureFixedSize = 8 + ed25519.PublicKeySize + ed25519.SignatureSize + 2 + 4 + 4
+	// maxSignerLen 签名者名称的最大长度
+	maxSignerLen = 1024
+)
+
+var (
+	// ErrPatchUnsigned 补丁没有签名
+	ErrPatchUnsigned = errors.New("patch is not signed")
+	// ErrSignatureInvalid 签名与补丁内容不符
+	ErrSignatureInvalid = errors.New("patch signature is invalid")
+	// ErrUntrustedKey 签名公钥不在信任列表中
+	ErrUntrustedKey = errors.New("patch is signed by an untrusted key")
+)
+
+// PatchSignature 补丁签名
+type PatchSignature struct {
+	Signer    string            // 签名者
+	SignedAt  int64             // 签名时间戳
+	PublicKey ed25519.PublicKey // 签名公钥
+	Signature []byte            // Ed25519签名
+}
+
+// KeyID 返回公钥的短标识（公钥SHA-256的前8字节）
+func (s *PatchSignature) KeyID() string {
+	return PublicKeyID(s.PublicKey)
+}
+
+// PublicKeyID 返回公钥的短标识（公钥SHA-256的前8字节）
+func PublicKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// marshal 序列化签名块
+func (s *PatchSignature) marshal() []byte {
+	size := signatureFixedSize + len(s.Signer)
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(s.SignedAt))
+	copy(buf[8:40], s.PublicKey)
+	copy(buf[40:104], s.Signature)
+	binary.LittleEndian.PutUint16(buf[104:106], uint16(len(s.Signer)))
+	copy(buf[106:], s.Signer)
+	binary.LittleEndian.PutUint32(buf[size-8:size-4], uint32(size))
+	binary.LittleEndian.PutUint32(buf[size-4:], SignatureMagic)
+	return buf
+}
+
+// unmarshal 反序列化签名块
+func (s *PatchSignature) unmarshal(data []byte) error {
+	if len(data) < signatureFixedSize {
+		return fmt.Errorf("signature block too small: %d bytes", len(data))
+	}
+	signerLen := int(binary.LittleEndian.Uint16(data[104:106]))
+	if len(data) != signatureFixedSize+signerLen {
+		return fmt.Errorf("signature block size %d does not match signer length %d", len(data), signerLen)
+	}
+	s.SignedAt = int64(binary.LittleEndian.Uint64(data[0:8]))
+	s.PublicKey = ed25519.PublicKey(bytes.Clone(data[8:40]))
+	s.Signature = bytes.Clone(data[40:104])
+	s.Signer = string(data[106 : 106+signerLen])
+	return nil
+}
+
+// readSignature 读取文件末尾的签名块，返回签名和签名块之前内容的大小，没有签名时返回nil
+func readSignature(r io.ReaderAt, size int64) (*PatchSignature, int64, error) {
+	if size < signatureFixedSize {
+		return nil, size, nil
+	}
+	footer := make([]byte, 8)
+	if _, err := r.ReadAt(footer, size-8); err != nil {
+		return nil, 0, fmt.Errorf("read signature footer: %w", err)
+	}
+	if binary.LittleEndian.Uint32(footer[4:]) != SignatureMagic {
+		return nil, size, nil
+	}
+
+	blockSize := int64(binary.LittleEndian.Uint32(footer[:4]))
+	if blockSize < signatureFixedSize || blockSize > signatureFixedSize+maxSignerLen || blockSize > size {
+		return nil, 0, fmt.Errorf("invalid signature block size: %d", blockSize)
+	}
+	block := make([]byte, blockSize)
+	if _, err := r.ReadAt(block, size-blockSize); err != nil {
+		return nil, 0, fmt.Errorf("read signature block: %w", err)
+	}
+	signature := &PatchSignature{}
+	if err := signature.unmarshal(block); err != nil {
+		return nil, 0, err
+	}
+	return signature, size - blockSize, nil
+}
+
+// contentSize 返回补丁中签名块之前内容的大小
+func contentSize(r io.ReaderAt, size int64) (int64, error) {
+	_, content, err := readSignature(r, size)
+	return content, err
+}
+
+// stripSignature 去掉内存中补丁数据末尾的签名块
+func stripSignature(data []byte) ([]byte, error) {
+	content, err := contentSize(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return data[:content], nil
+}
+
+// patchHeaderBytes 读取补丁头，按魔数识别单文件、增强和目录补丁
+func patchHeaderBytes(r io.ReaderAt, size int64) ([]byte, error) {
+	prefix := make([]byte, min(size, 256))
+	if _, err := r.ReadAt(prefix, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if len(prefix) < 4 {
+		return nil, fmt.Errorf("patch too small: %d bytes", size)
+	}
+
+	// 单文件补丁与目录补丁共用魔数，按版本号区分
+	headerSize := 0
+	magic := binary.LittleEndian.Uint32(prefix)
+	switch {
+	case magic == EnhancedMagicNumber:
+		headerSize = EnhancedHeaderSize
+	case magic == DirPatchMagic && len(prefix) >= 6 && binary.LittleEndian.Uint16(prefix[4:6]) == DirPatchVersion:
+		headerSize = DirPatchHeaderSize
+	case magic == MagicNumber:
+		header, err := ReadPatchHeader(bytes.NewReader(prefix))
+		if err != nil {
+			return nil, err
+		}
+		headerSize = header.Size()
+	default:
+		return nil, fmt.Errorf("unknown patch format: magic %x", magic)
+	}
+	if headerSize > len(prefix) {
+		return nil, fmt.Errorf("patch too small for header: %d bytes", size)
+	}
+	return prefix[:headerSize], nil
+}
+
+// signedMessage 计算签名覆盖的消息：补丁头、内容的SHA-256、签名时间和签名者
+func signedMessage(r io.ReaderAt, content int64, signedAt int64, signer string) ([]byte, error) {
+	header, err := patchHeaderBytes(r, content)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(r, 0, content)); err != nil {
+		return nil, fmt.Errorf("hash patch: %w", err)
+	}
+
+	var message bytes.Buffer
+	binary.Write(&message, binary.LittleEndian, uint32(SignatureMagic))
+	message.Write(header)
+	message.Write(hasher.Sum(nil))
+	binary.Write(&message, binary.LittleEndian, signedAt)
+	message.WriteString(signer)
+	return message.Bytes(), nil
+}
+
+// SignPatch 使用Ed25519私钥签名补丁文件，已有的签名会被替换
+func SignPatch(patchPath string, key ed25519.PrivateKey, signer string) (*PatchSignature, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid ed25519 private key size: %d", len(key))
+	}
+	if len(signer) > maxSignerLen {
+		return nil, fmt.Errorf("signer name too long: %d bytes", len(signer))
+	}
+
+	file, err := os.OpenFile(patchPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open patch file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat patch file: %w", err)
+	}
+	content, err := contentSize(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	signature := &PatchSignature{
+		Signer:    signer,
+		SignedAt:  time.Now().Unix(),
+		PublicKey: key.Public().(ed25519.PublicKey),
+	}
+	message, err := signedMessage(file, content, signature.SignedAt, signer)
+	if err != nil {
+		return nil, err
+	}
+	signature.Signature = ed25519.Sign(key, message)
+
+	if err := file.Truncate(content); err != nil {
+		return nil, fmt.Errorf("remove old signature: %w", err)
+	}
+	if _, err := file.WriteAt(signature.marshal(), content); err != nil {
+		return nil, fmt.Errorf("write signature: %w", err)
+	}
+	return signature, file.Close()
+}
+
+// ReadPatchSignature 读取补丁的签名但不验证，补丁没有签名时返回ErrPatchUnsigned
+func ReadPatchSignature(patchPath string) (*PatchSignature, error) {
+	file, err := os.Open(patchPath)
+	if err != nil {
+		return nil, fmt.Errorf("open patch file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat patch file: %w", err)
+	}
+	signature, _, err := readSignature(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	if signature == nil {
+		return nil, ErrPatchUnsigned
+	}
+	return signature, nil
+}
+
+// VerifyPatch 验证补丁签名，签名公钥必须在trusted中
+func VerifyPatch(patchPath string, trusted []ed25519.PublicKey) (*PatchSignature, error) {
+	file, err := os.Open(patchPath)
+	if err != nil {
+		return nil, fmt.Errorf("open patch file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat patch file: %w", err)
+	}
+	signature, content, err := readSignature(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	if signature == nil {
+		return nil, ErrPatchUnsigned
+	}
+
+	message, err := signedMessage(file, content, signature.SignedAt, signature.Signer)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(signature.PublicKey, message, signature.Signature) {
+		return signature, ErrSignatureInvalid
+	}
+	for _, key := range trusted {
+		if key.Equal(signature.PublicKey) {
+			return signature, nil
+		}
+	}
+	return signature, fmt.Errorf("%w: %s", ErrUntrustedKey, signature.KeyID())
+}
+
+// GenerateSigningKey 生成Ed25519密钥对，私钥和公钥分别以PEM格式写入keyPath和pubPath
+func GenerateSigningKey(keyPath, pubPath string) (ed25519.PublicKey, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key: %w", err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, fmt.Errorf("write private key: %w", err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		return nil, fmt.Errorf("write public key: %w", err)
+	}
+	return publicKey, nil
+}
+
+// ParseSigningKey 解析PEM格式（PKCS#8）的Ed25519私钥，与 openssl genpkey -algorithm ed25519 的输出兼容
+func ParseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not ed25519")
+	}
+	return privateKey, nil
+}
+
+// ParsePublicKey 解析PEM格式（PKIX）的Ed25519公钥
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not ed25519")
+	}
+	return publicKey, nil
+}
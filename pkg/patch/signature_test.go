@@ -0,0 +1,155 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+func signingKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey, privateKey
+}
+
+func TestSignPatchSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old.txt")
+	newFile := filepath.Join(dir, "new.txt")
+	oldData := compressibleText(300, "old")
+	newData := append(compressibleText(100, "new"), oldData...)
+	os.WriteFile(oldFile, oldData, 0644)
+	os.WriteFile(newFile, newData, 0644)
+
+	engine, err := diff.NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchPath := filepath.Join(dir, "app.patch")
+	if _, err := NewGenerator(engine, CompressionGzip).GeneratePatch(oldFile, newFile, patchPath); err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, privateKey := signingKey(t)
+	if _, err := VerifyPatch(patchPath, []ed25519.PublicKey{publicKey}); !errors.Is(err, ErrPatchUnsigned) {
+		t.Fatalf("VerifyPatch(unsigned) error = %v, want ErrPatchUnsigned", err)
+	}
+
+	if _, err := SignPatch(patchPath, privateKey, "release"); err != nil {
+		t.Fatalf("SignPatch() error = %v", err)
+	}
+	// 重新签名替换原有签名，而不是追加
+	signedSize := fileSize(t, patchPath)
+	if _, err := SignPatch(patchPath, privateKey, "release"); err != nil {
+		t.Fatalf("SignPatch() again error = %v", err)
+	}
+	if got := fileSize(t, patchPath); got != signedSize {
+		t.Errorf("size after re-signing = %d, want %d", got, signedSize)
+	}
+
+	signature, err := VerifyPatch(patchPath, []ed25519.PublicKey{publicKey})
+	if err != nil {
+		t.Fatalf("VerifyPatch() error = %v", err)
+	}
+	if signature.Signer != "release" || signature.KeyID() != PublicKeyID(publicKey) {
+		t.Errorf("signature = %+v", signature)
+	}
+
+	// 签名块不影响读取和应用补丁
+	output := filepath.Join(dir, "out.txt")
+	if _, err := NewApplier(nil).ApplyPatch(oldFile, patchPath, output); err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if got, _ := os.ReadFile(output); !bytes.Equal(got, newData) {
+		t.Error("applied output mismatch")
+	}
+
+	otherKey, _ := signingKey(t)
+	if _, err := VerifyPatch(patchPath, []ed25519.PublicKey{otherKey}); !errors.Is(err, ErrUntrustedKey) {
+		t.Errorf("VerifyPatch(other key) error = %v, want ErrUntrustedKey", err)
+	}
+
+	data, _ := os.ReadFile(patchPath)
+	data[len(data)/2] ^= 0xFF
+	os.WriteFile(patchPath, data, 0644)
+	if _, err := VerifyPatch(patchPath, []ed25519.PublicKey{publicKey}); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("VerifyPatch(tampered) error = %v, want ErrSignatureInvalid", err)
+	}
+}
+
+func TestSignPatchEnhancedAndDir(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, privateKey := signingKey(t)
+
+	_, enhancedPath, _ := enhancedPatch(t, dir)
+	dirPath := filepath.Join(dir, "dir.patch")
+	want := writeStreamFixture(t, dirPath)
+
+	for _, path := range []string{enhancedPath, dirPath} {
+		if _, err := SignPatch(path, privateKey, "ci"); err != nil {
+			t.Fatalf("SignPatch(%s) error = %v", path, err)
+		}
+		if _, err := VerifyPatch(path, []ed25519.PublicKey{publicKey}); err != nil {
+			t.Errorf("VerifyPatch(%s) error = %v", path, err)
+		}
+	}
+
+	enhanced, err := ReadEnhancedPatch(enhancedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enhanced.Metadata.Verification.SignedBy != "ci" || enhanced.Metadata.Verification.Signature == "" {
+		t.Errorf("metadata verification = %+v", enhanced.Metadata.Verification)
+	}
+	if result, err := NewValidator().ValidatePatchFile(enhancedPath); err != nil || !result.Valid {
+		t.Errorf("ValidatePatchFile() = %+v, %v", result, err)
+	}
+
+	checkStreamFixture(t, dirPath, want)
+}
+
+func TestSigningKeyPEM(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "fleet.key")
+	pubPath := filepath.Join(dir, "fleet.pub")
+
+	publicKey, err := GenerateSigningKey(keyPath, pubPath)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+
+	keyData, _ := os.ReadFile(keyPath)
+	privateKey, err := ParseSigningKey(keyData)
+	if err != nil {
+		t.Fatalf("ParseSigningKey() error = %v", err)
+	}
+	pubData, _ := os.ReadFile(pubPath)
+	parsed, err := ParsePublicKey(pubData)
+	if err != nil {
+		t.Fatalf("ParsePublicKey() error = %v", err)
+	}
+	if !parsed.Equal(publicKey) || !privateKey.Public().(ed25519.PublicKey).Equal(publicKey) {
+		t.Error("parsed keys do not match generated key pair")
+	}
+	if _, err := ParsePublicKey(keyData); err == nil {
+		t.Error("ParsePublicKey() should reject a private key")
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
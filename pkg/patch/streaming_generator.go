@@ -2,7 +2,6 @@ package patch
 
 import (
 	"bufio"
-	"compress/gzip"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -15,6 +14,11 @@ import (
 type StreamingPatchGenerator struct {
 	engine       *diff.Engine
 	compression  CompressionType
+	level        CompressionLevel
+	dict         []byte
+	dictID       uint32
+	encryption   *EncryptionKey
+	dataKey      []byte
 	patchFile    *os.File
 	writer       *bufio.Writer
 	dataWriter   io.Writer
@@ -22,7 +26,8 @@ type StreamingPatchGenerator struct {
 	dataFile     *os.File
 	header       *PatchHeader
 	operations   []PatchOperation
-	dataOffset   uint32
+	opData       []byte
+	dataOffset   uint64
 }
 
 // NewStreamingPatchGenerator 创建新的流式补丁生成器
@@ -35,6 +40,27 @@ func NewStreamingPatchGenerator(engine *diff.Engine, compression CompressionType
 	}
 }
 
+// SetLevel 设置压缩级别，0表示使用算法的默认级别
+func (spg *StreamingPatchGenerator) SetLevel(level CompressionLevel) {
+	spg.level = level
+}
+
+// SetDictionary 设置Zstd压缩使用的字典
+func (spg *StreamingPatchGenerator) SetDictionary(dict []byte) error {
+	id, err := dictionaryID(dict)
+	if err != nil {
+		return err
+	}
+	spg.dict = dict
+	spg.dictID = id
+	return nil
+}
+
+// SetEncryption 设置加密补丁使用的密钥，为nil时不加密
+func (spg *StreamingPatchGenerator) SetEncryption(key *EncryptionKey) {
+	spg.encryption = key
+}
+
 // GeneratePatchStreaming 流式生成补丁文件（适用于大文件）
 func (spg *StreamingPatchGenerator) GeneratePatchStreaming(oldFilePath, newFilePath, patchPath string) (*PatchInfo, error) {
 	var err error
@@ -54,17 +80,17 @@ func (spg *StreamingPatchGenerator) GeneratePatchStreaming(oldFilePath, newFileP
 		return nil, fmt.Errorf("create data file: %w", err)
 	}
 
-	// 设置数据写入器
-	if spg.compression == CompressionGzip {
-		gzipWriter := gzip.NewWriter(spg.dataFile)
-		spg.dataWriter = gzipWriter
-	} else {
-		spg.dataWriter = spg.dataFile
-	}
+	// 临时文件保存未压缩的数据，写入补丁时再整体压缩
+	spg.dataWriter = spg.dataFile
 
 	// 初始化补丁头
 	spg.header = NewPatchHeader()
-	spg.header.Compression = spg.compression
+	spg.header.setCompression(spg.compression)
+	spg.header.setDictionary(spg.dictID)
+	if spg.dataKey, err = spg.header.setEncryption(spg.encryption); err != nil {
+		spg.cleanup()
+		return nil, err
+	}
 
 	// 获取文件信息
 	oldStat, err := os.Stat(oldFilePath)
@@ -104,7 +130,7 @@ func (spg *StreamingPatchGenerator) GeneratePatchStreaming(oldFilePath, newFileP
 	for _, op := range delta.Operations {
 		patchOp := PatchOperation{
 			Offset: uint64(op.Offset),
-			Size:   uint32(op.Size),
+			Size:   uint64(op.Size),
 		}
 
 		switch op.Type {
@@ -121,6 +147,15 @@ func (spg *StreamingPatchGenerator) GeneratePatchStreaming(oldFilePath, newFileP
 			patchOp.DataOffset = dataOffset
 		case diff.OpDelete:
 			patchOp.Type = 2
+		case diff.OpAdd:
+			patchOp.Type = 3
+			patchOp.SrcOffset = uint64(op.SrcOffset)
+			dataOffset, err := spg.writeInsertDataStreaming(op.Data)
+			if err != nil {
+				spg.cleanup()
+				return nil, fmt.Errorf("write add data: %w", err)
+			}
+			patchOp.DataOffset = dataOffset
 		default:
 			spg.cleanup()
 			return nil, fmt.Errorf("unknown operation type: %v", op.Type)
@@ -136,8 +171,7 @@ func (spg *StreamingPatchGenerator) GeneratePatchStreaming(oldFilePath, newFileP
 	}
 
 	// 更新补丁头
-	spg.header.OperationCount = uint32(len(spg.operations))
-	spg.header.DataOffset = uint32(HeaderSize + len(spg.operations)*OperationSize)
+	spg.opData = spg.header.layoutOperations(spg.operations, spg.dataOffset)
 
 	// 写入补丁文件
 	if err := spg.writePatchFile(); err != nil {
@@ -160,7 +194,7 @@ func (spg *StreamingPatchGenerator) GeneratePatchStreaming(oldFilePath, newFileP
 }
 
 // writeInsertDataStreaming 流式写入插入数据
-func (spg *StreamingPatchGenerator) writeInsertDataStreaming(data []byte) (uint32, error) {
+func (spg *StreamingPatchGenerator) writeInsertDataStreaming(data []byte) (uint64, error) {
 	offset := spg.dataOffset
 
 	if len(data) == 0 {
@@ -181,37 +215,17 @@ func (spg *StreamingPatchGenerator) writeInsertDataStreaming(data []byte) (uint3
 		}
 	}
 
-	spg.dataOffset += uint32(len(data))
+	spg.dataOffset += uint64(len(data))
 	return offset, nil
 }
 
 // closeDataWriter 关闭数据写入器
 func (spg *StreamingPatchGenerator) closeDataWriter() error {
-	var err error
-
-	// 如果使用 Gzip 压缩，需要关闭 gzip writer
-	if spg.compression == CompressionGzip {
-		if gzipWriter, ok := spg.dataWriter.(*gzip.Writer); ok {
-			err = gzipWriter.Close()
-		}
-	} else if spg.dataFile != nil {
-		// 如果不使用压缩，需要 Sync 确保数据写入磁盘
-		if syncErr := spg.dataFile.Sync(); syncErr != nil {
-			if err == nil {
-				err = syncErr
-			}
-		}
+	if spg.dataFile == nil {
+		return nil
 	}
-
-	// 关闭数据文件
-	if spg.dataFile != nil {
-		closeErr := spg.dataFile.Close()
-		if err == nil {
-			err = closeErr
-		}
-		spg.dataFile = nil
-	}
-
+	err := spg.dataFile.Close()
+	spg.dataFile = nil
 	return err
 }
 
@@ -223,24 +237,35 @@ func (spg *StreamingPatchGenerator) writePatchFile() error {
 		return fmt.Errorf("write header: %w", err)
 	}
 
+	// 文件头之后的内容在压缩后加密
+	payload, err := encryptPayload(spg.writer, spg.header, spg.dataKey)
+	if err != nil {
+		return err
+	}
+
 	// 写入操作列表
-	for _, op := range spg.operations {
-		opData := op.Marshal()
-		if _, err := spg.writer.Write(opData); err != nil {
-			return fmt.Errorf("write operation: %w", err)
-		}
+	if _, err := payload.Write(spg.opData); err != nil {
+		return fmt.Errorf("write operations: %w", err)
 	}
 
-	// 将数据文件内容复制到补丁文件
+	// 将数据文件内容压缩后复制到补丁文件
+	codec, err := newCodec(spg.compression, spg.level, spg.dict)
+	if err != nil {
+		return err
+	}
 	dataFile, err := os.Open(spg.dataFilePath)
 	if err != nil {
 		return fmt.Errorf("open data file: %w", err)
 	}
 	defer dataFile.Close()
 
-	_, err = io.Copy(spg.writer, dataFile)
-	if err != nil {
-		return fmt.Errorf("copy data: %w", err)
+	if spg.dataOffset > 0 {
+		if err := codec.compressTo(payload, dataFile); err != nil {
+			return fmt.Errorf("copy data: %w", err)
+		}
+	}
+	if err := payload.Close(); err != nil {
+		return err
 	}
 
 	// 刷新缓冲区
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
+
+	"github.com/Sky-ey/HexDiff/pkg/metadata"
 )
 
 // Validator 补丁验证器
@@ -42,7 +44,7 @@ func (v *Validator) ValidatePatchFile(patchFilePath string) (*ValidationResult,
 	}
 
 	// 验证操作列表
-	if err := v.validateOperations(patchFile.Operations, patchFile.Data, result); err != nil {
+	if err := v.validateOperations(patchFile.Header, patchFile.Operations, patchFile.Data, result); err != nil {
 		return result, err
 	}
 
@@ -51,6 +53,11 @@ func (v *Validator) ValidatePatchFile(patchFilePath string) (*ValidationResult,
 		return result, err
 	}
 
+	// 验证增强补丁的文件头和元数据
+	if enhanced, err := IsEnhancedPatch(patchFilePath); err == nil && enhanced {
+		v.validateEnhanced(patchFilePath, patchFile.Header, result)
+	}
+
 	// 如果没有问题，标记为有效
 	if len(result.Issues) == 0 {
 		result.Valid = true
@@ -59,6 +66,26 @@ func (v *Validator) ValidatePatchFile(patchFilePath string) (*ValidationResult,
 	return result, nil
 }
 
+// validateEnhanced 验证增强补丁的文件头摘要与内部补丁一致，并检查元数据
+func (v *Validator) validateEnhanced(patchFilePath string, header *PatchHeader, result *ValidationResult) {
+	enhanced, err := ReadEnhancedPatch(patchFilePath)
+	if err != nil {
+		result.Issues = append(result.Issues, fmt.Sprintf("无法解析增强补丁元数据: %v", err))
+		return
+	}
+
+	summary := enhanced.Header
+	if summary.SourceSize != header.SourceSize || summary.TargetSize != header.TargetSize ||
+		summary.SourceChecksum != header.SourceChecksum || summary.TargetChecksum != header.TargetChecksum ||
+		summary.OperationCount != header.OperationCount {
+		result.Issues = append(result.Issues, "增强补丁文件头与内部补丁不一致")
+	}
+
+	for _, issue := range metadata.NewMetadataManager("").ValidateMetadata(enhanced.Metadata) {
+		result.Issues = append(result.Issues, "元数据: "+issue)
+	}
+}
+
 // validateHeader 验证文件头
 func (v *Validator) validateHeader(header *PatchHeader, result *ValidationResult) error {
 	// 验证魔数
@@ -67,7 +94,7 @@ func (v *Validator) validateHeader(header *PatchHeader, result *ValidationResult
 	}
 
 	// 验证版本
-	if header.Version != Version {
+	if header.Version != Version && header.Version != Version64 {
 		result.Issues = append(result.Issues, fmt.Sprintf("不支持的版本: %d", header.Version))
 	}
 
@@ -89,10 +116,10 @@ func (v *Validator) validateHeader(header *PatchHeader, result *ValidationResult
 }
 
 // validateOperations 验证操作列表
-func (v *Validator) validateOperations(operations []PatchOperation, data []byte, result *ValidationResult) error {
+func (v *Validator) validateOperations(header *PatchHeader, operations []PatchOperation, data []byte, result *ValidationResult) error {
 	for i, op := range operations {
 		// 验证操作类型
-		if op.Type > 2 {
+		if op.Type > 3 {
 			result.Issues = append(result.Issues, fmt.Sprintf("操作 %d: 无效的操作类型 %d", i, op.Type))
 		}
 
@@ -102,14 +129,15 @@ func (v *Validator) validateOperations(operations []PatchOperation, data []byte,
 		}
 
 		// 对于插入操作，验证数据偏移量
-		if op.Type == 1 { // Insert操作
-			if op.DataOffset+op.Size > uint32(len(data)) {
+		if op.Type == 1 || op.Type == 3 { // Insert和Add操作
+			if op.DataOffset > uint64(len(data)) || op.Size > uint64(len(data))-op.DataOffset {
 				result.Issues = append(result.Issues, fmt.Sprintf("操作 %d: 插入数据超出范围", i))
 			}
 		}
 
-		// 验证偏移量的合理性
-		if op.Type == 0 { // Copy操作
+		// 验证偏移量的合理性：复制的范围不能超出源文件
+		sourceSize := uint64(header.SourceSize)
+		if op.Type == 0 && (op.SrcOffset > sourceSize || op.Size > sourceSize-op.SrcOffset) { // Copy操作
 			result.Issues = append(result.Issues, fmt.Sprintf("操作 %d: 无效的源偏移量", i))
 		}
 	}
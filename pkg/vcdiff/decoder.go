@@ -0,0 +1,378 @@
+package vcdiff
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+	"sort"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// maxWindowEncodingSize 单个窗口编码数据的最大长度
+const maxWindowEncodingSize = 1 << 31
+
+// Decoder 将 VCDIFF 流解码为 HexDiff 差异
+type Decoder struct {
+	// Source 源文件，设置后校验窗口的 Adler-32 校验和并计算目标文件的SHA-256
+	Source io.ReaderAt
+}
+
+// NewDecoder 创建 VCDIFF 解码器，source可以为nil
+func NewDecoder(source io.ReaderAt) *Decoder {
+	return &Decoder{Source: source}
+}
+
+// Decode 解码 VCDIFF 流
+//
+// 从目标窗口自身复制的数据会被还原为对源文件的复制或插入操作，因此结果只引用源文件。
+// 未设置Source时，返回结果的SourceSize为引用到的源文件范围，Checksum为空。
+func (d *Decoder) Decode(r io.Reader) (*diff.Delta, error) {
+	reader := bufio.NewReader(r)
+	if err := readFileHeader(reader); err != nil {
+		return nil, err
+	}
+
+	delta := diff.NewDelta(0, 0)
+	var targetHash hash.Hash
+	if d.Source != nil {
+		targetHash = sha256.New()
+	}
+
+	for index := 0; ; index++ {
+		indicator, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read window %d: %w", index, err)
+		}
+
+		window, err := readWindow(reader, indicator)
+		if err != nil {
+			return nil, fmt.Errorf("read window %d: %w", index, err)
+		}
+
+		operations, err := window.decode()
+		if err != nil {
+			return nil, fmt.Errorf("decode window %d: %w", index, err)
+		}
+
+		if d.Source != nil {
+			if err := d.verifyWindow(window, operations, targetHash); err != nil {
+				return nil, fmt.Errorf("verify window %d: %w", index, err)
+			}
+		}
+
+		for _, op := range operations {
+			op.Offset += delta.TargetSize
+			if op.Type == diff.OpCopy {
+				delta.SourceSize = max(delta.SourceSize, op.SrcOffset+int64(op.Size))
+			}
+			delta.AddOperation(op)
+		}
+		delta.TargetSize += int64(window.targetLen)
+	}
+
+	if targetHash != nil {
+		copy(delta.Checksum[:], targetHash.Sum(nil))
+	}
+	return delta, nil
+}
+
+// Decode 使用默认解码器解码 VCDIFF 流
+func Decode(r io.Reader) (*diff.Delta, error) {
+	return NewDecoder(nil).Decode(r)
+}
+
+// readFileHeader 读取并校验文件头
+func readFileHeader(r *bufio.Reader) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if header[0] != magic0 || header[1] != magic1 || header[2] != magic2 {
+		return fmt.Errorf("%w: invalid magic number %x", ErrInvalidFormat, header[:3])
+	}
+	if header[3] != version {
+		return fmt.Errorf("%w: version %#x", ErrUnsupported, header[3])
+	}
+
+	indicator := header[4]
+	if indicator&hdrDecompress != 0 {
+		return fmt.Errorf("%w: secondary compression (use xdelta3 -S none)", ErrUnsupported)
+	}
+	if indicator&hdrCodeTable != 0 {
+		return fmt.Errorf("%w: custom code table", ErrUnsupported)
+	}
+	if indicator&^(hdrDecompress|hdrCodeTable|hdrAppHeader) != 0 {
+		return fmt.Errorf("%w: invalid header indicator %#x", ErrInvalidFormat, indicator)
+	}
+	if indicator&hdrAppHeader != 0 {
+		size, err := readInt(r)
+		if err != nil {
+			return fmt.Errorf("read application header: %w", err)
+		}
+		if _, err := r.Discard(int(min(size, maxWindowEncodingSize))); err != nil {
+			return fmt.Errorf("read application header: %w", err)
+		}
+	}
+	return nil
+}
+
+// window 一个已读取的目标窗口
+type window struct {
+	hasSource bool
+	segLen    uint64
+	segPos    uint64
+	targetLen uint64
+	checksum  []byte // 原始校验和字节，可能为空
+	data      []byte
+	inst      []byte
+	addr      []byte
+}
+
+// readWindow 读取窗口头和各数据段
+func readWindow(r *bufio.Reader, indicator byte) (*window, error) {
+	if indicator&winTarget != 0 {
+		return nil, fmt.Errorf("%w: VCD_TARGET windows", ErrUnsupported)
+	}
+	if indicator&^(winSource|winTarget|winAdler32) != 0 {
+		return nil, fmt.Errorf("%w: invalid window indicator %#x", ErrInvalidFormat, indicator)
+	}
+
+	w := &window{hasSource: indicator&winSource != 0}
+	var err error
+	if w.hasSource {
+		if w.segLen, err = readInt(r); err != nil {
+			return nil, err
+		}
+		if w.segPos, err = readInt(r); err != nil {
+			return nil, err
+		}
+	}
+
+	encodedLen, err := readInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if encodedLen > maxWindowEncodingSize {
+		return nil, fmt.Errorf("%w: window encoding too large: %d", ErrInvalidFormat, encodedLen)
+	}
+	encoded := make([]byte, encodedLen)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return nil, err
+	}
+
+	body := bytes.NewReader(encoded)
+	if w.targetLen, err = readInt(body); err != nil {
+		return nil, err
+	}
+	deltaIndicator, err := body.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if deltaIndicator != 0 {
+		return nil, fmt.Errorf("%w: secondary compression (use xdelta3 -S none)", ErrUnsupported)
+	}
+
+	var lengths [3]uint64
+	for i := range lengths {
+		if lengths[i], err = readInt(body); err != nil {
+			return nil, err
+		}
+	}
+	sectionsLen := lengths[0] + lengths[1] + lengths[2]
+	if sectionsLen > uint64(body.Len()) {
+		return nil, fmt.Errorf("%w: section lengths exceed window encoding", ErrInvalidFormat)
+	}
+
+	rest := encoded[len(encoded)-body.Len():]
+	checksumLen := uint64(len(rest)) - sectionsLen
+	if indicator&winAdler32 != 0 {
+		w.checksum = rest[:checksumLen]
+	} else if checksumLen != 0 {
+		return nil, fmt.Errorf("%w: %d unexpected bytes in window", ErrInvalidFormat, checksumLen)
+	}
+	rest = rest[checksumLen:]
+
+	w.data = rest[:lengths[0]]
+	w.inst = rest[lengths[0] : lengths[0]+lengths[1]]
+	w.addr = rest[lengths[0]+lengths[1]:]
+	return w, nil
+}
+
+// decode 执行窗口的指令，返回窗口内相对偏移的操作
+func (w *window) decode() ([]diff.Operation, error) {
+	builder := &windowBuilder{segPos: int64(w.segPos)}
+	var cache addressCache
+	inst := bytes.NewReader(w.inst)
+	addr := bytes.NewReader(w.addr)
+	data := w.data
+	var position uint64
+
+	for inst.Len() > 0 {
+		opcode, _ := inst.ReadByte()
+		for _, in := range defaultCodeTable[opcode] {
+			if in.typ == instNoop {
+				continue
+			}
+
+			size := uint64(in.size)
+			if size == 0 {
+				var err error
+				if size, err = readInt(inst); err != nil {
+					return nil, fmt.Errorf("read instruction size: %w", err)
+				}
+			}
+			if size > w.targetLen-position {
+				return nil, fmt.Errorf("%w: instructions exceed target window length %d", ErrInvalidFormat, w.targetLen)
+			}
+
+			switch in.typ {
+			case instAdd:
+				if size > uint64(len(data)) {
+					return nil, fmt.Errorf("%w: ADD exceeds data section", ErrInvalidFormat)
+				}
+				builder.insert(data[:size])
+				data = data[size:]
+			case instRun:
+				if len(data) == 0 {
+					return nil, fmt.Errorf("%w: RUN exceeds data section", ErrInvalidFormat)
+				}
+				builder.insert(bytes.Repeat(data[:1], int(size)))
+				data = data[1:]
+			case instCopy:
+				here := w.segLen + position
+				address, err := cache.decode(addr, in.mode, here)
+				if err != nil {
+					return nil, fmt.Errorf("read copy address: %w", err)
+				}
+
+				// 地址空间由源数据段和当前目标窗口拼接而成
+				remaining := size
+				if address < w.segLen {
+					n := min(remaining, w.segLen-address)
+					builder.copySource(int64(address), int64(n))
+					remaining -= n
+					address = w.segLen
+				}
+				if remaining > 0 {
+					builder.copyTarget(int64(address-w.segLen), int64(remaining), int64(position+size-remaining))
+				}
+			}
+			position += size
+		}
+	}
+
+	if position != w.targetLen {
+		return nil, fmt.Errorf("%w: decoded %d bytes, target window length is %d", ErrInvalidFormat, position, w.targetLen)
+	}
+	return builder.operations, nil
+}
+
+// verifyWindow 根据源文件还原窗口内容，校验 Adler-32 并更新目标文件哈希
+func (d *Decoder) verifyWindow(w *window, operations []diff.Operation, targetHash hash.Hash) error {
+	checksum := adler32.New()
+	out := io.MultiWriter(checksum, targetHash)
+	buf := make([]byte, 64*1024)
+	for _, op := range operations {
+		if op.Type == diff.OpInsert {
+			out.Write(op.Data)
+			continue
+		}
+		if err := copySourceRange(out, d.Source, op.SrcOffset, int64(op.Size), buf); err != nil {
+			return err
+		}
+	}
+
+	if len(w.checksum) == 0 {
+		return nil
+	}
+	sum := checksum.Sum32()
+
+	// xdelta3 写入4字节大端值，open-vcdiff 写入变长整数
+	if len(w.checksum) == 4 && binary.BigEndian.Uint32(w.checksum) == sum {
+		return nil
+	}
+	if v, err := readInt(bytes.NewReader(w.checksum)); err == nil && v == uint64(sum) {
+		return nil
+	}
+	return fmt.Errorf("adler32 checksum mismatch: computed %08x", sum)
+}
+
+// windowBuilder 构建窗口内的操作，合并相邻的同类操作
+type windowBuilder struct {
+	segPos     int64
+	position   int64
+	operations []diff.Operation
+}
+
+func (b *windowBuilder) insert(data []byte) {
+	if n := len(b.operations); n > 0 && b.operations[n-1].Type == diff.OpInsert {
+		last := &b.operations[n-1]
+		last.Data = append(last.Data, data...)
+		last.Size += len(data)
+	} else {
+		b.operations = append(b.operations, diff.Operation{
+			Type:   diff.OpInsert,
+			Offset: b.position,
+			Size:   len(data),
+			Data:   append([]byte(nil), data...),
+		})
+	}
+	b.position += int64(len(data))
+}
+
+// copySource 从源数据段复制，offset为相对源数据段的偏移
+func (b *windowBuilder) copySource(offset, size int64) {
+	srcOffset := b.segPos + offset
+	if n := len(b.operations); n > 0 && b.operations[n-1].Type == diff.OpCopy {
+		last := &b.operations[n-1]
+		if last.SrcOffset+int64(last.Size) == srcOffset {
+			last.Size += int(size)
+			b.position += size
+			return
+		}
+	}
+	b.operations = append(b.operations, diff.Operation{
+		Type:      diff.OpCopy,
+		Offset:    b.position,
+		Size:      int(size),
+		SrcOffset: srcOffset,
+	})
+	b.position += size
+}
+
+// copyTarget 从窗口中已生成的数据复制，start可能与当前位置重叠（周期性复制）
+func (b *windowBuilder) copyTarget(start, size, position int64) {
+	period := position - start
+	for size > 0 {
+		n := min(size, period)
+		b.replay(start, start+n)
+		size -= n
+	}
+}
+
+// replay 以已生成的操作重新产生窗口内[lo, hi)范围的数据
+func (b *windowBuilder) replay(lo, hi int64) {
+	i := sort.Search(len(b.operations), func(i int) bool {
+		op := b.operations[i]
+		return op.Offset+int64(op.Size) > lo
+	})
+	for ; i < len(b.operations) && b.operations[i].Offset < hi; i++ {
+		op := b.operations[i]
+		from := max(lo, op.Offset)
+		to := min(hi, op.Offset+int64(op.Size))
+		if op.Type == diff.OpInsert {
+			b.insert(op.Data[from-op.Offset : to-op.Offset])
+		} else {
+			b.copySource(op.SrcOffset-b.segPos+(from-op.Offset), to-from)
+		}
+	}
+}
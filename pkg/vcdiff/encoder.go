@@ -0,0 +1,284 @@
+package vcdiff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"sort"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+	"github.com/Sky-ey/HexDiff/pkg/patch"
+)
+
+// DefaultWindowSize 默认目标窗口大小
+const DefaultWindowSize = 8 * 1024 * 1024
+
+// Encoder 将 HexDiff 差异编码为 VCDIFF 流
+type Encoder struct {
+	// WindowSize 单个目标窗口的最大字节数
+	WindowSize int
+	// Source 源文件，设置后为每个窗口写入 Adler-32 校验和（xdelta3格式）
+	Source io.ReaderAt
+}
+
+// NewEncoder 创建 VCDIFF 编码器，source可以为nil
+func NewEncoder(source io.ReaderAt) *Encoder {
+	return &Encoder{
+		WindowSize: DefaultWindowSize,
+		Source:     source,
+	}
+}
+
+// Encode 将差异结果编码为 VCDIFF 流
+// VCDIFF 没有相加指令，包含相加操作时需要设置Source以还原目标数据
+func (e *Encoder) Encode(w io.Writer, delta *diff.Delta) error {
+	operations, err := targetOrder(delta.Operations)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(w)
+	if _, err := writer.Write([]byte{magic0, magic1, magic2, version, 0}); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	windowSize := int64(e.WindowSize)
+	if windowSize <= 0 {
+		windowSize = DefaultWindowSize
+	}
+
+	// 按窗口大小切分操作，跨越窗口边界的操作被拆分
+	var window []diff.Operation
+	var windowLen int64
+	for _, op := range operations {
+		for op.Size > 0 {
+			piece := op
+			piece.Size = int(min(int64(op.Size), windowSize-windowLen))
+			if op.Type == diff.OpInsert || op.Type == diff.OpAdd {
+				piece.Data = op.Data[:piece.Size]
+				op.Data = op.Data[piece.Size:]
+			}
+			window = append(window, piece)
+			windowLen += int64(piece.Size)
+
+			op.Offset += int64(piece.Size)
+			op.SrcOffset += int64(piece.Size)
+			op.Size -= piece.Size
+
+			if windowLen == windowSize {
+				if err := e.writeWindow(writer, window, windowLen); err != nil {
+					return err
+				}
+				window, windowLen = window[:0], 0
+			}
+		}
+	}
+	if windowLen > 0 {
+		if err := e.writeWindow(writer, window, windowLen); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// EncodePatch 将 HexDiff 补丁文件编码为 VCDIFF 流
+func (e *Encoder) EncodePatch(w io.Writer, patchFile *patch.PatchFile) error {
+	delta, err := DeltaFromPatch(patchFile)
+	if err != nil {
+		return err
+	}
+	return e.Encode(w, delta)
+}
+
+// DeltaFromPatch 将补丁文件中的操作转换为差异结果
+func DeltaFromPatch(patchFile *patch.PatchFile) (*diff.Delta, error) {
+	header := patchFile.Header
+	delta := diff.NewDelta(header.SourceSize, header.TargetSize)
+	delta.Checksum = header.TargetChecksum
+
+	for i, op := range patchFile.Operations {
+		operation := diff.Operation{
+			Type:      diff.OperationType(op.Type),
+			Offset:    int64(op.Offset),
+			Size:      int(op.Size),
+			SrcOffset: int64(op.SrcOffset),
+		}
+		if op.Type == 1 || op.Type == 3 {
+			data, err := patchFile.GetInsertData(op.DataOffset, op.Size)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			operation.Data = data
+		}
+		delta.AddOperation(operation)
+	}
+
+	return delta, nil
+}
+
+// targetOrder 返回按目标偏移量排序的复制和插入操作，并检查它们连续覆盖目标文件
+func targetOrder(operations []diff.Operation) ([]diff.Operation, error) {
+	result := make([]diff.Operation, 0, len(operations))
+	for _, op := range operations {
+		if op.Size == 0 || op.Type == diff.OpDelete {
+			continue
+		}
+		if op.Type != diff.OpCopy && op.Type != diff.OpInsert && op.Type != diff.OpAdd {
+			return nil, fmt.Errorf("unknown operation type: %v", op.Type)
+		}
+		if op.Type != diff.OpCopy && len(op.Data) < op.Size {
+			return nil, fmt.Errorf("%v at offset %d has %d bytes of data, want %d", op.Type, op.Offset, len(op.Data), op.Size)
+		}
+		result = append(result, op)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Offset < result[j].Offset
+	})
+
+	var position int64
+	for _, op := range result {
+		if op.Offset != position {
+			return nil, fmt.Errorf("operations are not contiguous at target offset %d", position)
+		}
+		position += int64(op.Size)
+	}
+
+	return result, nil
+}
+
+// writeWindow 编码并写入一个目标窗口
+func (e *Encoder) writeWindow(w io.Writer, window []diff.Operation, targetLen int64) error {
+	// 源数据段覆盖窗口内所有复制操作引用的范围
+	segPos, segEnd := int64(-1), int64(0)
+	for _, op := range window {
+		if op.Type != diff.OpCopy {
+			continue
+		}
+		if segPos < 0 || op.SrcOffset < segPos {
+			segPos = op.SrcOffset
+		}
+		segEnd = max(segEnd, op.SrcOffset+int64(op.Size))
+	}
+
+	var data, inst, addr []byte
+	for _, op := range window {
+		size := uint64(op.Size)
+		switch op.Type {
+		case diff.OpInsert, diff.OpAdd:
+			if op.Type == diff.OpAdd {
+				added, err := e.addedData(op)
+				if err != nil {
+					return err
+				}
+				data = append(data, added...)
+			} else {
+				data = append(data, op.Data[:op.Size]...)
+			}
+			if size <= 17 {
+				// 默认代码表中索引2-18为大小1-17的ADD
+				inst = append(inst, byte(1+size))
+			} else {
+				inst = appendInt(append(inst, 1), size)
+			}
+		case diff.OpCopy:
+			// 使用VCD_SELF模式，地址为相对源数据段的偏移
+			if size >= 4 && size <= 18 {
+				inst = append(inst, byte(19+size-3))
+			} else {
+				inst = appendInt(append(inst, 19), size)
+			}
+			addr = appendInt(addr, uint64(op.SrcOffset-segPos))
+		}
+	}
+
+	indicator := byte(0)
+	var header []byte
+	if segPos >= 0 {
+		indicator |= winSource
+		header = appendInt(header, uint64(segEnd-segPos))
+		header = appendInt(header, uint64(segPos))
+	}
+
+	var checksum []byte
+	if e.Source != nil {
+		sum, err := e.windowChecksum(window)
+		if err != nil {
+			return err
+		}
+		indicator |= winAdler32
+		checksum = binary.BigEndian.AppendUint32(nil, sum)
+	}
+
+	body := appendInt(nil, uint64(targetLen))
+	body = append(body, 0) // Delta_Indicator：不使用二级压缩
+	body = appendInt(body, uint64(len(data)))
+	body = appendInt(body, uint64(len(inst)))
+	body = appendInt(body, uint64(len(addr)))
+	body = append(body, checksum...)
+
+	encodedLen := uint64(len(body) + len(data) + len(inst) + len(addr))
+	header = appendInt(header, encodedLen)
+
+	for _, part := range [][]byte{{indicator}, header, body, data, inst, addr} {
+		if _, err := w.Write(part); err != nil {
+			return fmt.Errorf("write window: %w", err)
+		}
+	}
+	return nil
+}
+
+// windowChecksum 计算目标窗口内容的 Adler-32 校验和
+func (e *Encoder) windowChecksum(window []diff.Operation) (uint32, error) {
+	hasher := adler32.New()
+	buf := make([]byte, 64*1024)
+	for _, op := range window {
+		switch op.Type {
+		case diff.OpInsert:
+			hasher.Write(op.Data[:op.Size])
+		case diff.OpAdd:
+			added, err := e.addedData(op)
+			if err != nil {
+				return 0, err
+			}
+			hasher.Write(added)
+		default:
+			if err := copySourceRange(hasher, e.Source, op.SrcOffset, int64(op.Size), buf); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return hasher.Sum32(), nil
+}
+
+// addedData 还原相加操作产生的目标数据
+func (e *Encoder) addedData(op diff.Operation) ([]byte, error) {
+	if e.Source == nil {
+		return nil, fmt.Errorf("add operation at offset %d requires the source file", op.Offset)
+	}
+	data := make([]byte, op.Size)
+	if _, err := e.Source.ReadAt(data, op.SrcOffset); err != nil {
+		return nil, fmt.Errorf("read source at %d: %w", op.SrcOffset, err)
+	}
+	for i := range data {
+		data[i] += op.Data[i]
+	}
+	return data, nil
+}
+
+// copySourceRange 将源文件指定范围写入writer
+func copySourceRange(w io.Writer, source io.ReaderAt, offset, size int64, buf []byte) error {
+	for size > 0 {
+		n := int(min(size, int64(len(buf))))
+		if _, err := source.ReadAt(buf[:n], offset); err != nil {
+			return fmt.Errorf("read source at %d: %w", offset, err)
+		}
+		w.Write(buf[:n])
+		offset += int64(n)
+		size -= int64(n)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// Package vcdiff 实现 RFC 3284 (VCDIFF) 格式与 HexDiff 差异之间的转换
+package vcdiff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// VCDIFF 格式常量
+const (
+	// 文件头魔数 "VCD" 的高位形式及版本号
+	magic0  = 0xD6
+	magic1  = 0xC3
+	magic2  = 0xC4
+	version = 0x00
+
+	// Hdr_Indicator 标志位
+	hdrDecompress = 0x01 // 使用了二级压缩
+	hdrCodeTable  = 0x02 // 使用了自定义代码表
+	hdrAppHeader  = 0x04 // 包含应用头（xdelta3扩展）
+
+	// Win_Indicator 标志位
+	winSource   = 0x01 // 从源文件复制
+	winTarget   = 0x02 // 从之前的目标窗口复制
+	winAdler32  = 0x04 // 包含目标窗口的Adler-32校验和（xdelta3/open-vcdiff扩展）
+	maxIntBytes = 10   // 64位整数编码的最大字节数
+)
+
+// 指令类型
+const (
+	instNoop byte = iota
+	instAdd
+	instRun
+	instCopy
+)
+
+// 地址缓存参数（默认代码表）
+const (
+	nearSize = 4
+	sameSize = 3
+)
+
+// ErrInvalidFormat VCDIFF 数据格式错误
+var ErrInvalidFormat = errors.New("invalid vcdiff data")
+
+// ErrUnsupported 使用了不支持的 VCDIFF 特性
+var ErrUnsupported = errors.New("unsupported vcdiff feature")
+
+// instruction 代码表中的单条指令
+type instruction struct {
+	typ  byte
+	size byte
+	mode byte
+}
+
+// codeEntry 代码表条目，每个条目最多包含两条指令
+type codeEntry [2]instruction
+
+// defaultCodeTable RFC 3284 第5.6节定义的默认代码表
+var defaultCodeTable = buildDefaultCodeTable()
+
+func buildDefaultCodeTable() [256]codeEntry {
+	var table [256]codeEntry
+	index := 0
+	add := func(first, second instruction) {
+		table[index] = codeEntry{first, second}
+		index++
+	}
+
+	add(instruction{typ: instRun}, instruction{})
+	for size := 0; size <= 17; size++ {
+		add(instruction{typ: instAdd, size: byte(size)}, instruction{})
+	}
+	for mode := 0; mode <= 8; mode++ {
+		add(instruction{typ: instCopy, mode: byte(mode)}, instruction{})
+		for size := 4; size <= 18; size++ {
+			add(instruction{typ: instCopy, size: byte(size), mode: byte(mode)}, instruction{})
+		}
+	}
+	for mode := 0; mode <= 5; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			for copySize := 4; copySize <= 6; copySize++ {
+				add(instruction{typ: instAdd, size: byte(addSize)},
+					instruction{typ: instCopy, size: byte(copySize), mode: byte(mode)})
+			}
+		}
+	}
+	for mode := 6; mode <= 8; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			add(instruction{typ: instAdd, size: byte(addSize)},
+				instruction{typ: instCopy, size: 4, mode: byte(mode)})
+		}
+	}
+	for mode := 0; mode <= 8; mode++ {
+		add(instruction{typ: instCopy, size: 4, mode: byte(mode)},
+			instruction{typ: instAdd, size: 1})
+	}
+
+	return table
+}
+
+// addressCache 地址缓存（RFC 3284 第5.1节）
+type addressCache struct {
+	near     [nearSize]uint64
+	nextSlot int
+	same     [sameSize * 256]uint64
+}
+
+func (c *addressCache) update(addr uint64) {
+	c.near[c.nextSlot] = addr
+	c.nextSlot = (c.nextSlot + 1) % nearSize
+	c.same[addr%(sameSize*256)] = addr
+}
+
+// decode 按模式解码地址，here为当前在地址空间中的位置
+func (c *addressCache) decode(r io.ByteReader, mode byte, here uint64) (uint64, error) {
+	var addr uint64
+	switch {
+	case mode == 0: // VCD_SELF
+		v, err := readInt(r)
+		if err != nil {
+			return 0, err
+		}
+		addr = v
+	case mode == 1: // VCD_HERE
+		v, err := readInt(r)
+		if err != nil {
+			return 0, err
+		}
+		if v > here {
+			return 0, fmt.Errorf("%w: HERE address %d before start of window", ErrInvalidFormat, v)
+		}
+		addr = here - v
+	case int(mode) < 2+nearSize:
+		v, err := readInt(r)
+		if err != nil {
+			return 0, err
+		}
+		addr = c.near[mode-2] + v
+	case int(mode) < 2+nearSize+sameSize:
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		addr = c.same[int(mode-2-nearSize)*256+int(b)]
+	default:
+		return 0, fmt.Errorf("%w: invalid address mode %d", ErrInvalidFormat, mode)
+	}
+
+	if addr >= here {
+		return 0, fmt.Errorf("%w: address %d beyond current position %d", ErrInvalidFormat, addr, here)
+	}
+	c.update(addr)
+	return addr, nil
+}
+
+// appendInt 以 VCDIFF 变长整数格式（大端，每字节7位）追加整数
+func appendInt(buf []byte, v uint64) []byte {
+	var tmp [maxIntBytes]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7f) | 0x80
+	}
+	return append(buf, tmp[i:]...)
+}
+
+// readInt 读取 VCDIFF 变长整数
+func readInt(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for i := 0; i < maxIntBytes; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if v > (1<<64-1)>>7 {
+			return 0, fmt.Errorf("%w: integer overflow", ErrInvalidFormat)
+		}
+		v = v<<7 | uint64(b&0x7f)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: integer too long", ErrInvalidFormat)
+}
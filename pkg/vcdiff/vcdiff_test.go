@@ -0,0 +1,132 @@
+package vcdiff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/Sky-ey/HexDiff/pkg/diff"
+)
+
+// applyDelta 在内存中将差异应用到源数据
+func applyDelta(t *testing.T, source []byte, delta *diff.Delta) []byte {
+	t.Helper()
+	var out []byte
+	for _, op := range delta.Operations {
+		if int64(len(out)) != op.Offset {
+			t.Fatalf("operation at offset %d, want %d", op.Offset, len(out))
+		}
+		switch op.Type {
+		case diff.OpCopy:
+			out = append(out, source[op.SrcOffset:op.SrcOffset+int64(op.Size)]...)
+		case diff.OpInsert:
+			out = append(out, op.Data[:op.Size]...)
+		default:
+			t.Fatalf("unexpected operation type %v", op.Type)
+		}
+	}
+	return out
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	source := []byte("The quick brown fox jumps over the lazy dog")
+	target := []byte("The quick red fox jumps over the lazy dog!!")
+
+	delta := diff.NewDelta(int64(len(source)), int64(len(target)))
+	delta.AddOperation(diff.Operation{Type: diff.OpCopy, Offset: 0, Size: 10, SrcOffset: 0})
+	delta.AddOperation(diff.Operation{Type: diff.OpInsert, Offset: 10, Size: 3, Data: []byte("red")})
+	delta.AddOperation(diff.Operation{Type: diff.OpDelete, Offset: 13, Size: 5})
+	delta.AddOperation(diff.Operation{Type: diff.OpCopy, Offset: 13, Size: 28, SrcOffset: 15})
+	delta.AddOperation(diff.Operation{Type: diff.OpInsert, Offset: 41, Size: 2, Data: []byte("!!")})
+
+	for _, windowSize := range []int{DefaultWindowSize, 7} {
+		encoder := NewEncoder(bytes.NewReader(source))
+		encoder.WindowSize = windowSize
+
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, delta); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+
+		decoded, err := NewDecoder(bytes.NewReader(source)).Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("window %d: Decode() error = %v", windowSize, err)
+		}
+		if decoded.TargetSize != int64(len(target)) {
+			t.Errorf("window %d: TargetSize = %d, want %d", windowSize, decoded.TargetSize, len(target))
+		}
+		if got := applyDelta(t, source, decoded); !bytes.Equal(got, target) {
+			t.Errorf("window %d: target = %q, want %q", windowSize, got, target)
+		}
+		if decoded.Checksum != sha256.Sum256(target) {
+			t.Errorf("window %d: checksum mismatch", windowSize)
+		}
+	}
+}
+
+func TestDecodeDetectsChecksumMismatch(t *testing.T) {
+	source := []byte("0123456789")
+	delta := diff.NewDelta(10, 10)
+	delta.AddOperation(diff.Operation{Type: diff.OpCopy, Offset: 0, Size: 10})
+
+	var buf bytes.Buffer
+	if err := NewEncoder(bytes.NewReader(source)).Encode(&buf, delta); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	other := []byte("9876543210")
+	if _, err := NewDecoder(bytes.NewReader(other)).Decode(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("expected checksum error for a different source")
+	}
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Errorf("Decode() without source error = %v", err)
+	}
+}
+
+func TestDecodeInstructions(t *testing.T) {
+	source := []byte("ABCDEFGH")
+
+	// 组合指令、RUN、重叠的目标复制以及HERE/near/same地址模式
+	data := []byte("xz")
+	inst := []byte{
+		163,  // ADD 1 + COPY 4 (SELF)
+		0, 3, // RUN 3
+		36,  // COPY 4 (HERE)，与当前位置重叠
+		53,  // COPY 5 (near[0])，跨越源数据段与目标窗口
+		116, // COPY 4 (same)
+	}
+	addr := []byte{2, 2, 4, 14}
+
+	body := appendInt(nil, 21)
+	body = append(body, 0)
+	body = appendInt(body, uint64(len(data)))
+	body = appendInt(body, uint64(len(inst)))
+	body = appendInt(body, uint64(len(addr)))
+	body = append(append(append(body, data...), inst...), addr...)
+
+	stream := []byte{magic0, magic1, magic2, version, hdrAppHeader, 3, 'a', 'p', 'p'}
+	stream = append(stream, winSource, 8, 0)
+	stream = appendInt(stream, uint64(len(body)))
+	stream = append(stream, body...)
+
+	delta, err := Decode(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want := "xCDEFzzzzzzzGHxCDzzzz"
+	if got := applyDelta(t, source, delta); string(got) != want {
+		t.Errorf("target = %q, want %q", got, want)
+	}
+	if delta.SourceSize != 8 {
+		t.Errorf("SourceSize = %d, want 8", delta.SourceSize)
+	}
+}
+
+func TestDecodeRejectsSecondaryCompression(t *testing.T) {
+	stream := []byte{magic0, magic1, magic2, version, hdrDecompress, 1}
+	if _, err := Decode(bytes.NewReader(stream)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Decode() error = %v, want ErrUnsupported", err)
+	}
+}